@@ -0,0 +1,210 @@
+package jwkset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// CacheOptions are used to configure the behavior of NewCachingStorage.
+type CacheOptions struct {
+	// Clock returns the current time. It is used to expire cached keys.
+	//
+	// This defaults to time.Now.
+	Clock func() time.Time
+
+	// NegativeTTL is the duration a key ID is remembered as missing after KeyRead on the backing storage returned
+	// ErrKeyNotFound. Within this duration, KeyRead returns ErrKeyNotFound without consulting the backing storage.
+	//
+	// This defaults to 0, which means missing key IDs are not remembered.
+	NegativeTTL time.Duration
+
+	// TTL is the duration a key, or the snapshot of all keys, is served from the cache before it is read from the
+	// backing storage again.
+	//
+	// This defaults to time.Minute.
+	TTL time.Duration
+}
+
+var _ Storage = &cachingStorage{}
+
+type cachingStorage struct {
+	backing  Storage
+	options  CacheOptions
+	negative *negativeCache
+
+	mux        sync.Mutex
+	keys       map[string]cacheEntry
+	all        []JWK
+	allCached  bool
+	allExpires time.Time
+	generation uint64 // Incremented by each invalidation, so reads racing with a write are not cached.
+
+	snapshotStorage
+}
+
+type cacheEntry struct {
+	jwk     JWK
+	expires time.Time
+}
+
+// NewCachingStorage creates a new Storage implementation that caches reads from the backing storage in memory. KeyRead
+// caches each key by key ID. KeyReadAll caches a snapshot of all keys, which is also used by the other read methods
+// and the JSON and Marshal methods. Writes and deletes go to the backing storage, then invalidate the affected cache
+// entries.
+//
+// Changes made to the backing storage by other processes are observed after the TTL option expires. The returned
+// Storage implements io.Closer, which closes the backing storage if it implements io.Closer.
+func NewCachingStorage(backing Storage, options CacheOptions) Storage {
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+	if options.TTL == 0 {
+		options.TTL = time.Minute
+	}
+	c := &cachingStorage{
+		backing: backing,
+		options: options,
+		keys:    make(map[string]cacheEntry),
+	}
+	c.snapshotStorage = snapshotStorage{keyReadAll: c.KeyReadAll}
+	if options.NegativeTTL > 0 {
+		c.negative = &negativeCache{
+			clock:  options.Clock,
+			expiry: make(map[string]time.Time),
+			ttl:    options.NegativeTTL,
+		}
+	}
+	return c
+}
+
+// Close closes the backing storage if it implements io.Closer.
+func (c *cachingStorage) Close() error {
+	if closer, ok := c.backing.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *cachingStorage) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	ok, err = c.backing.KeyDelete(ctx, keyID)
+	c.invalidate(keyID)
+	return ok, err
+}
+func (c *cachingStorage) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	if c.negative != nil && c.negative.contains(keyID) {
+		return false, nil
+	}
+	now := c.options.Clock()
+	c.mux.Lock()
+	entry, ok := c.keys[keyID]
+	c.mux.Unlock()
+	if ok && now.Before(entry.expires) {
+		return true, nil
+	}
+	return c.backing.KeyExists(ctx, keyID)
+}
+func (c *cachingStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	if c.negative != nil && c.negative.contains(keyID) {
+		return JWK{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, keyID)
+	}
+	now := c.options.Clock()
+	c.mux.Lock()
+	entry, ok := c.keys[keyID]
+	generation := c.generation
+	c.mux.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.jwk.clone(), nil
+	}
+	jwk, err := c.backing.KeyRead(ctx, keyID)
+	if err != nil {
+		if c.negative != nil && errors.Is(err, ErrKeyNotFound) {
+			c.mux.Lock()
+			if generation == c.generation {
+				c.negative.add(keyID)
+			}
+			c.mux.Unlock()
+		}
+		return JWK{}, err
+	}
+	c.mux.Lock()
+	if generation == c.generation {
+		c.keys[keyID] = cacheEntry{
+			jwk:     jwk.clone(),
+			expires: now.Add(c.options.TTL),
+		}
+	}
+	c.mux.Unlock()
+	return jwk, nil
+}
+func (c *cachingStorage) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	jwks, err := c.KeyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterByAlg(jwks, alg, inferred), nil
+}
+func (c *cachingStorage) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	jwks, err := c.KeyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterByUse(jwks, use), nil
+}
+func (c *cachingStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	now := c.options.Clock()
+	c.mux.Lock()
+	if c.allCached && now.Before(c.allExpires) {
+		jwks := cloneJWKs(c.all)
+		c.mux.Unlock()
+		return jwks, nil
+	}
+	generation := c.generation
+	c.mux.Unlock()
+	jwks, err := c.backing.KeyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	if generation == c.generation {
+		c.all = cloneJWKs(jwks)
+		c.allCached = true
+		c.allExpires = now.Add(c.options.TTL)
+	}
+	c.mux.Unlock()
+	return jwks, nil
+}
+func (c *cachingStorage) KeyWrite(ctx context.Context, jwk JWK) error {
+	err := c.backing.KeyWrite(ctx, jwk)
+	c.invalidate(jwk.Marshal().KID)
+	return err
+}
+func (c *cachingStorage) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	err := c.backing.KeyWriteBatch(ctx, jwks)
+	keyIDs := make([]string, 0, len(jwks))
+	for _, jwk := range jwks {
+		keyIDs = append(keyIDs, jwk.Marshal().KID)
+	}
+	c.invalidate(keyIDs...)
+	return err
+}
+
+// invalidate removes the given key IDs and the snapshot of all keys from the cache.
+func (c *cachingStorage) invalidate(keyIDs ...string) {
+	c.mux.Lock()
+	for _, keyID := range keyIDs {
+		delete(c.keys, keyID)
+	}
+	c.all = nil
+	c.allCached = false
+	c.generation++
+	c.mux.Unlock()
+	if c.negative != nil {
+		for _, keyID := range keyIDs {
+			c.negative.remove(keyID)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingStorage struct {
+	Storage
+	reads    atomic.Int64
+	readAlls atomic.Int64
+}
+
+func (c *countingStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	c.reads.Add(1)
+	return c.Storage.KeyRead(ctx, keyID)
+}
+func (c *countingStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	c.readAlls.Add(1)
+	return c.Storage.KeyReadAll(ctx)
+}
+
+func TestCachingStorage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	backing := &countingStorage{Storage: NewMemoryStorage()}
+	writeKeys(ctx, t, backing, newStorageTestJWK(t, hmacKey1, kidWritten))
+	clock := newFakeClock()
+	store := NewCachingStorage(backing, CacheOptions{
+		Clock:       clock.Now,
+		NegativeTTL: time.Minute,
+		TTL:         time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := store.KeyRead(ctx, kidWritten)
+		if err != nil {
+			t.Fatalf("Failed to read key. %s", err)
+		}
+		_, err = store.KeyRead(ctx, kidMissing)
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("Expected ErrKeyNotFound, got %v.", err)
+		}
+		_, err = store.KeyReadAll(ctx)
+		if err != nil {
+			t.Fatalf("Failed to snapshot keys. %s", err)
+		}
+	}
+	if backing.reads.Load() != 2 || backing.readAlls.Load() != 1 {
+		t.Fatalf("Expected reads to be cached, got %d reads and %d snapshots.", backing.reads.Load(), backing.readAlls.Load())
+	}
+
+	err := store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey2, kidWritten))
+	if err != nil {
+		t.Fatalf("Failed to write key. %s", err)
+	}
+	key, err := store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey2) {
+		t.Fatalf("Expected a write to invalidate the cached key.")
+	}
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidMissing))
+	_, err = store.KeyRead(ctx, kidMissing)
+	if err != nil {
+		t.Fatalf("Expected a write to invalidate the negative cache. %s", err)
+	}
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected a write to invalidate the cached snapshot, got %d keys.", len(keys))
+	}
+
+	writeKeys(ctx, t, backing, newStorageTestJWK(t, hmacKey1, kidWritten2))
+	_, err = store.JSONPublic(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create JSON. %s", err)
+	}
+	keys, _ = store.KeyReadAll(ctx)
+	if len(keys) != 2 {
+		t.Fatalf("Expected the cached snapshot before the TTL expires.")
+	}
+	clock.Advance(time.Hour)
+	keys, _ = store.KeyReadAll(ctx)
+	if len(keys) != 3 {
+		t.Fatalf("Expected the snapshot to be read again after the TTL expires, got %d keys.", len(keys))
+	}
+}
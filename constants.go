@@ -101,6 +101,54 @@ func (alg ALG) IANARegistered() bool {
 	}
 	return false
 }
+
+var (
+	// signatureAlgs are the JWS algorithms, in the order returned by JWK.SupportedAlgs.
+	signatureAlgs = []ALG{
+		AlgHS256, AlgHS384, AlgHS512, AlgRS256, AlgRS384, AlgRS512, AlgES256, AlgES384, AlgES512, AlgPS256, AlgPS384,
+		AlgPS512, AlgEdDSA, AlgES256K,
+	}
+	// encryptionAlgs are the JWE algorithms, in the order returned by JWK.SupportedAlgs.
+	encryptionAlgs = []ALG{
+		AlgRSAOAEP256, AlgRSAOAEP384, AlgRSAOAEP512, AlgRSAOAEP, AlgRSA1_5, AlgA128KW, AlgA192KW, AlgA256KW, AlgDir,
+		AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW, AlgA128GCMKW, AlgA192GCMKW, AlgA256GCMKW,
+		AlgPBES2HS256A128KW, AlgPBES2HS384A192KW, AlgPBES2HS512A256KW, AlgA128CBCHS256, AlgA192CBCHS384, AlgA256CBCHS512,
+		AlgA128GCM, AlgA192GCM, AlgA256GCM,
+	}
+)
+
+// compatible reports whether a key with the given key type and curve can be used with the algorithm according to
+// https://www.rfc-editor.org/rfc/rfc7518, https://www.rfc-editor.org/rfc/rfc8037, and
+// https://www.rfc-editor.org/rfc/rfc8812.
+func (alg ALG) compatible(kty KTY, crv CRV) bool {
+	switch alg {
+	case AlgHS256, AlgHS384, AlgHS512, AlgA128KW, AlgA192KW, AlgA256KW, AlgDir, AlgA128GCMKW, AlgA192GCMKW,
+		AlgA256GCMKW, AlgPBES2HS256A128KW, AlgPBES2HS384A192KW, AlgPBES2HS512A256KW, AlgA128CBCHS256, AlgA192CBCHS384,
+		AlgA256CBCHS512, AlgA128GCM, AlgA192GCM, AlgA256GCM:
+		return kty == KtyOct
+	case AlgRS256, AlgRS384, AlgRS512, AlgPS256, AlgPS384, AlgPS512, AlgRSA1_5, AlgRSAOAEP, AlgRSAOAEP256,
+		AlgRSAOAEP384, AlgRSAOAEP512:
+		return kty == KtyRSA
+	case AlgES256:
+		return kty == KtyEC && crv == CrvP256
+	case AlgES384:
+		return kty == KtyEC && crv == CrvP384
+	case AlgES512:
+		return kty == KtyEC && crv == CrvP521
+	case AlgES256K:
+		return kty == KtyEC && crv == CrvSECP256K1
+	case AlgEdDSA:
+		return kty == KtyOKP && (crv == CrvEd25519 || crv == CrvEd448)
+	case AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW:
+		switch kty {
+		case KtyEC:
+			return crv == CrvP256 || crv == CrvP384 || crv == CrvP521
+		case KtyOKP:
+			return crv == CrvX25519 || crv == CrvX448
+		}
+	}
+	return curveCompatible(alg, kty, crv)
+}
 func (alg ALG) String() string {
 	return string(alg)
 }
@@ -135,6 +183,15 @@ func (keyopts KEYOPS) IANARegistered() bool {
 func (keyopts KEYOPS) String() string {
 	return string(keyopts)
 }
+func (keyopts KEYOPS) use() USE {
+	switch keyopts {
+	case KeyOpsSign, KeyOpsVerify:
+		return UseSig
+	case KeyOpsEncrypt, KeyOpsDecrypt, KeyOpsWrapKey, KeyOpsUnwrapKey, KeyOpsDeriveKey, KeyOpsDeriveBits:
+		return UseEnc
+	}
+	return ""
+}
 
 // KTY is a set of "JSON Web Key Types" from https://www.iana.org/assignments/jose/jose.xhtml as mentioned in
 // https://www.rfc-editor.org/rfc/rfc7517#section-4.1
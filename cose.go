@@ -0,0 +1,479 @@
+package jwkset
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	// ErrCOSE indicates that a key could not be converted to or from a COSE_Key.
+	ErrCOSE = errors.New("failed to convert COSE_Key")
+)
+
+// COSE_Key common parameter labels. https://www.rfc-editor.org/rfc/rfc9052#section-7.1
+const (
+	coseLabelKTY    = 1
+	coseLabelKID    = 2
+	coseLabelALG    = 3
+	coseLabelKeyOps = 4
+)
+
+// COSE_Key type parameter labels. https://www.rfc-editor.org/rfc/rfc9053#section-7 and
+// https://www.rfc-editor.org/rfc/rfc8230#section-4
+const (
+	coseLabelCRV = -1  // EC2 and OKP.
+	coseLabelX   = -2  // EC2 and OKP.
+	coseLabelY   = -3  // EC2.
+	coseLabelD   = -4  // EC2 and OKP.
+	coseLabelK   = -1  // Symmetric.
+	coseLabelN   = -1  // RSA.
+	coseLabelE   = -2  // RSA.
+	coseLabelRD  = -3  // RSA.
+	coseLabelP   = -4  // RSA.
+	coseLabelQ   = -5  // RSA.
+	coseLabelDP  = -6  // RSA.
+	coseLabelDQ  = -7  // RSA.
+	coseLabelQI  = -8  // RSA.
+	coseLabelOTH = -9  // RSA.
+	coseLabelRI  = -10 // RSA other prime.
+	coseLabelDI  = -11 // RSA other prime.
+	coseLabelTI  = -12 // RSA other prime.
+)
+
+var (
+	coseKTYs = map[KTY]int64{
+		KtyOKP: 1,
+		KtyEC:  2,
+		KtyRSA: 3,
+		KtyOct: 4,
+	}
+	coseCRVs = map[CRV]int64{
+		CrvP256:      1,
+		CrvP384:      2,
+		CrvP521:      3,
+		CrvX25519:    4,
+		CrvX448:      5,
+		CrvEd25519:   6,
+		CrvEd448:     7,
+		CrvSECP256K1: 8,
+	}
+	coseALGs = map[ALG]int64{
+		AlgA128GCM:    1,
+		AlgA192GCM:    2,
+		AlgA256GCM:    3,
+		AlgHS256:      5,
+		AlgHS384:      6,
+		AlgHS512:      7,
+		AlgA128KW:     -3,
+		AlgA192KW:     -4,
+		AlgA256KW:     -5,
+		AlgES256:      -7,
+		AlgEdDSA:      -8,
+		AlgES384:      -35,
+		AlgES512:      -36,
+		AlgPS256:      -37,
+		AlgPS384:      -38,
+		AlgPS512:      -39,
+		AlgRSAOAEP:    -40,
+		AlgRSAOAEP256: -41,
+		AlgRSAOAEP512: -42,
+		AlgES256K:     -47,
+		AlgRS256:      -257,
+		AlgRS384:      -258,
+		AlgRS512:      -259,
+	}
+	coseKeyOps = map[KEYOPS]int64{
+		KeyOpsSign:       1,
+		KeyOpsVerify:     2,
+		KeyOpsEncrypt:    3,
+		KeyOpsDecrypt:    4,
+		KeyOpsWrapKey:    5,
+		KeyOpsUnwrapKey:  6,
+		KeyOpsDeriveKey:  7,
+		KeyOpsDeriveBits: 8,
+	}
+)
+
+// coseParam maps a COSE_Key label to the base64url encoded JWK member holding its byte string.
+type coseParam struct {
+	label  int64
+	member *string
+}
+
+// coseParams returns the key type specific parameters of the JWKMarshal.
+func coseParams(m *JWKMarshal) []coseParam {
+	switch m.KTY {
+	case KtyEC:
+		return []coseParam{{coseLabelX, &m.X}, {coseLabelY, &m.Y}, {coseLabelD, &m.D}}
+	case KtyOKP:
+		return []coseParam{{coseLabelX, &m.X}, {coseLabelD, &m.D}}
+	case KtyRSA:
+		return []coseParam{{coseLabelN, &m.N}, {coseLabelE, &m.E}, {coseLabelRD, &m.D}, {coseLabelP, &m.P}, {coseLabelQ, &m.Q}, {coseLabelDP, &m.DP}, {coseLabelDQ, &m.DQ}, {coseLabelQI, &m.QI}}
+	case KtyOct:
+		return []coseParam{{coseLabelK, &m.K}}
+	}
+	return nil
+}
+
+// coseOtherParams returns the parameters of an additional RSA prime.
+func coseOtherParams(o *OtherPrimes) []coseParam {
+	return []coseParam{{coseLabelRI, &o.R}, {coseLabelDI, &o.D}, {coseLabelTI, &o.T}}
+}
+
+// coseEncodeParams adds the byte string parameters to the COSE_Key map.
+func coseEncodeParams(key map[int64]any, params []coseParam) error {
+	for _, param := range params {
+		if *param.member == "" {
+			continue
+		}
+		raw, err := base64urlTrailingPadding(*param.member)
+		if err != nil {
+			return fmt.Errorf("failed to decode key parameter for COSE_Key label %d: %w", param.label, err)
+		}
+		key[param.label] = raw
+	}
+	return nil
+}
+
+// coseDecodeParams sets the byte string parameters from the COSE_Key map.
+func coseDecodeParams(key map[any]any, params []coseParam) error {
+	for _, param := range params {
+		value, ok := key[param.label]
+		if !ok {
+			continue
+		}
+		b, ok := value.([]byte)
+		if !ok {
+			return fmt.Errorf("%w: COSE_Key label %d is not a byte string", ErrCOSE, param.label)
+		}
+		*param.member = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return nil
+}
+
+// COSE encodes the JWK as a CBOR COSE_Key. https://www.rfc-editor.org/rfc/rfc9052#section-7
+//
+// Private key material is only included if the Marshal.Private option is set. The kid is encoded as the UTF-8 bytes of
+// the key ID. An alg without a registered COSE value is encoded as a text string. JWK members without a COSE_Key
+// equivalent, such as use and the X.509 members, are not included. The map is encoded with the deterministic encoding
+// from https://www.rfc-editor.org/rfc/rfc8949#section-4.2.1.
+func (j JWK) COSE() ([]byte, error) {
+	m := j.marshal
+	kty, ok := coseKTYs[m.KTY]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported key type %q", errors.Join(ErrCOSE, ErrUnsupportedKey), m.KTY)
+	}
+	key := map[int64]any{
+		coseLabelKTY: kty,
+	}
+	if m.KID != "" {
+		key[coseLabelKID] = []byte(m.KID)
+	}
+	if m.ALG != "" {
+		if alg, ok := coseALGs[m.ALG]; ok {
+			key[coseLabelALG] = alg
+		} else {
+			key[coseLabelALG] = string(m.ALG)
+		}
+	}
+	if len(m.KEYOPS) != 0 {
+		ops := make([]any, 0, len(m.KEYOPS))
+		for _, op := range m.KEYOPS {
+			value, ok := coseKeyOps[op]
+			if !ok {
+				return nil, fmt.Errorf("%w: unsupported key operation %q", ErrCOSE, op)
+			}
+			ops = append(ops, value)
+		}
+		key[coseLabelKeyOps] = ops
+	}
+	if m.KTY == KtyEC || m.KTY == KtyOKP {
+		crv, ok := coseCRVs[m.CRV]
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported curve %q", ErrCOSE, m.CRV)
+		}
+		key[coseLabelCRV] = crv
+	}
+	err := coseEncodeParams(key, coseParams(&m))
+	if err != nil {
+		return nil, errors.Join(ErrCOSE, err)
+	}
+	if m.KTY == KtyRSA && len(m.OTH) != 0 {
+		others := make([]any, 0, len(m.OTH))
+		for _, o := range m.OTH {
+			other := make(map[int64]any)
+			err = coseEncodeParams(other, coseOtherParams(&o))
+			if err != nil {
+				return nil, errors.Join(ErrCOSE, err)
+			}
+			others = append(others, other)
+		}
+		key[coseLabelOTH] = others
+	}
+	return cborAppend(nil, key), nil
+}
+
+// JWKFromCOSE creates a JWK from a CBOR COSE_Key. https://www.rfc-editor.org/rfc/rfc9052#section-7
+//
+// Private key material in the COSE_Key is kept. Labels without a JWK equivalent are ignored.
+func JWKFromCOSE(raw []byte) (JWK, error) {
+	item, rest, err := cborDecode(raw, 0)
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to decode CBOR: %w", errors.Join(ErrCOSE, err))
+	}
+	if len(rest) != 0 {
+		return JWK{}, fmt.Errorf("%w: %d bytes of trailing data after COSE_Key", ErrCOSE, len(rest))
+	}
+	key, ok := item.(map[any]any)
+	if !ok {
+		return JWK{}, fmt.Errorf("%w: COSE_Key is not a CBOR map", ErrCOSE)
+	}
+
+	var m JWKMarshal
+	kty, _ := key[int64(coseLabelKTY)].(int64)
+	for name, value := range coseKTYs {
+		if value == kty {
+			m.KTY = name
+		}
+	}
+	if m.KTY == "" {
+		return JWK{}, fmt.Errorf("%w: unsupported COSE_Key type %v", errors.Join(ErrCOSE, ErrUnsupportedKey), key[int64(coseLabelKTY)])
+	}
+	switch kid := key[int64(coseLabelKID)].(type) {
+	case []byte:
+		m.KID = string(kid)
+	case string:
+		m.KID = kid
+	}
+	switch alg := key[int64(coseLabelALG)].(type) {
+	case int64:
+		for name, value := range coseALGs {
+			if value == alg {
+				m.ALG = name
+			}
+		}
+		if m.ALG == "" {
+			return JWK{}, fmt.Errorf("%w: unsupported COSE algorithm %d", ErrCOSE, alg)
+		}
+	case string:
+		m.ALG = ALG(alg)
+	}
+	if ops, ok := key[int64(coseLabelKeyOps)].([]any); ok {
+		for _, op := range ops {
+			found := false
+			for name, value := range coseKeyOps {
+				if value == op {
+					m.KEYOPS = append(m.KEYOPS, name)
+					found = true
+				}
+			}
+			if !found {
+				return JWK{}, fmt.Errorf("%w: unsupported COSE key operation %v", ErrCOSE, op)
+			}
+		}
+	}
+	if m.KTY == KtyEC || m.KTY == KtyOKP {
+		crv, _ := key[int64(coseLabelCRV)].(int64)
+		for name, value := range coseCRVs {
+			if value == crv {
+				m.CRV = name
+			}
+		}
+		if m.CRV == "" {
+			return JWK{}, fmt.Errorf("%w: unsupported COSE curve %v", ErrCOSE, key[int64(coseLabelCRV)])
+		}
+	}
+	err = coseDecodeParams(key, coseParams(&m))
+	if err != nil {
+		return JWK{}, err
+	}
+	if others, ok := key[int64(coseLabelOTH)].([]any); ok && m.KTY == KtyRSA {
+		for _, item := range others {
+			other, ok := item.(map[any]any)
+			if !ok {
+				return JWK{}, fmt.Errorf("%w: COSE_Key other prime is not a CBOR map", ErrCOSE)
+			}
+			var o OtherPrimes
+			err = coseDecodeParams(other, coseOtherParams(&o))
+			if err != nil {
+				return JWK{}, err
+			}
+			m.OTH = append(m.OTH, o)
+		}
+	}
+
+	marshalOptions := JWKMarshalOptions{
+		Private: true,
+	}
+	jwk, err := NewJWKFromMarshal(m, marshalOptions, JWKValidateOptions{})
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to create JWK from COSE_Key: %w", errors.Join(ErrCOSE, err))
+	}
+	return jwk, nil
+}
+
+// CBOR major types. https://www.rfc-editor.org/rfc/rfc8949#section-3.1
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+)
+
+// cborMaxDepth limits the nesting of decoded CBOR items.
+const cborMaxDepth = 16
+
+// cborAppendHead appends the initial byte and argument of a CBOR item with the shortest encoding.
+func cborAppendHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= 0xff:
+		return append(b, major|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+// cborAppend appends the CBOR encoding of an int64, []byte, string, []any, or map[int64]any. Map keys are sorted by
+// their encoding, as required for deterministic encoding.
+func cborAppend(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return cborAppendHead(b, cborNegative, uint64(-1-v))
+		}
+		return cborAppendHead(b, cborUnsigned, uint64(v))
+	case []byte:
+		return append(cborAppendHead(b, cborBytes, uint64(len(v))), v...)
+	case string:
+		return append(cborAppendHead(b, cborText, uint64(len(v))), v...)
+	case []any:
+		b = cborAppendHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			b = cborAppend(b, item)
+		}
+		return b
+	case map[int64]any:
+		type entry struct {
+			key   []byte
+			value any
+		}
+		entries := make([]entry, 0, len(v))
+		for key, value := range v {
+			entries = append(entries, entry{key: cborAppend(nil, key), value: value})
+		}
+		slices.SortFunc(entries, func(a, b entry) int {
+			if len(a.key) != len(b.key) {
+				return len(a.key) - len(b.key)
+			}
+			return slices.Compare(a.key, b.key)
+		})
+		b = cborAppendHead(b, cborMap, uint64(len(v)))
+		for _, e := range entries {
+			b = append(b, e.key...)
+			b = cborAppend(b, e.value)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("jwkset: unsupported CBOR type %T", v))
+}
+
+// cborDecode decodes one CBOR item and returns the remaining bytes. Integers are decoded as int64, byte strings as
+// []byte, text strings as string, arrays as []any, and maps as map[any]any. Other major types, indefinite lengths,
+// and tags are not supported, because they are not needed for a COSE_Key.
+func cborDecode(b []byte, depth int) (item any, rest []byte, err error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("CBOR nesting is too deep")
+	}
+	if len(b) == 0 {
+		return nil, nil, errors.New("unexpected end of CBOR data")
+	}
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(b) < size {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		for _, c := range b[:size] {
+			n = n<<8 | uint64(c)
+		}
+		b = b[size:]
+	default:
+		return nil, nil, fmt.Errorf("unsupported CBOR additional information %d", info)
+	}
+
+	switch major {
+	case cborUnsigned, cborNegative:
+		if n > 1<<63-1 {
+			return nil, nil, errors.New("CBOR integer overflows int64")
+		}
+		if major == cborNegative {
+			return -1 - int64(n), b, nil
+		}
+		return int64(n), b, nil
+	case cborBytes, cborText:
+		if n > uint64(len(b)) {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		if major == cborText {
+			return string(b[:n]), b[n:], nil
+		}
+		return slices.Clone(b[:n]), b[n:], nil
+	case cborArray:
+		if n > uint64(len(b)) {
+			return nil, nil, errors.New("CBOR array is longer than the data")
+		}
+		items := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, b, err = cborDecode(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, b, nil
+	case cborMap:
+		if n > uint64(len(b)) {
+			return nil, nil, errors.New("CBOR map is longer than the data")
+		}
+		m := make(map[any]any, n)
+		for i := uint64(0); i < n; i++ {
+			var key, value any
+			key, b, err = cborDecode(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("unsupported CBOR map key type %T", key)
+			}
+			if _, ok := m[key]; ok {
+				return nil, nil, fmt.Errorf("duplicate CBOR map key %v", key)
+			}
+			value, b, err = cborDecode(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, b, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported CBOR major type %d", major)
+}
@@ -0,0 +1,92 @@
+package jwkset
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCOSE(t *testing.T) {
+	// Public key from https://www.rfc-editor.org/rfc/rfc9052#appendix-C.7.1 in deterministic encoding.
+	kid := "meriadoc.brandybuck@buckland.example"
+	raw, err := hex.DecodeString("a5" +
+		"0102" +
+		"025824" + hex.EncodeToString([]byte(kid)) +
+		"2001" +
+		"215820" + "65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d" +
+		"225820" + "1e52ed75701163f7f9e40ddf9f341b3dc9ba860af7e0ca7ca7e9eecd0084d19c")
+	if err != nil {
+		t.Fatalf("Failed to decode hex. %s", err)
+	}
+	jwk, err := JWKFromCOSE(raw)
+	if err != nil {
+		t.Fatalf("Failed to create JWK from COSE_Key. %s", err)
+	}
+	if jwk.Marshal().KTY != KtyEC || jwk.Marshal().CRV != CrvP256 || jwk.Marshal().KID != kid {
+		t.Fatalf("Unexpected JWK from COSE_Key: %+v.", jwk.Marshal())
+	}
+	encoded, err := jwk.COSE()
+	if err != nil {
+		t.Fatalf("Failed to encode COSE_Key. %s", err)
+	}
+	if !bytes.Equal(encoded, raw) {
+		t.Fatalf("Expected the COSE_Key to round trip.\nExpected: %x\nActual:   %x", raw, encoded)
+	}
+
+	testCases := []struct {
+		name string
+		key  any
+		alg  ALG
+	}{
+		{name: "EC", key: makeECDSAP256(t), alg: AlgES256},
+		{name: "Ed25519", key: makeEdDSA(t), alg: AlgEdDSA},
+		{name: "RSA", key: makeRSA(t), alg: AlgPS256},
+		{name: "Oct", key: []byte(hmacKey1), alg: AlgHS256},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := JWKOptions{
+				Marshal: JWKMarshalOptions{
+					Private: true,
+				},
+				Metadata: JWKMetadataOptions{
+					ALG:    tc.alg,
+					KEYOPS: []KEYOPS{KeyOpsSign, KeyOpsVerify},
+					KID:    myKeyID,
+				},
+			}
+			jwk := newJWK(t, tc.key, options)
+			raw, err := jwk.COSE()
+			if err != nil {
+				t.Fatalf("Failed to encode COSE_Key. %s", err)
+			}
+			decoded, err := JWKFromCOSE(raw)
+			if err != nil {
+				t.Fatalf("Failed to create JWK from COSE_Key. %s", err)
+			}
+			expected, actual := jwk.Marshal(), decoded.Marshal()
+			if actual.KTY != expected.KTY || actual.CRV != expected.CRV || actual.ALG != expected.ALG || actual.KID != expected.KID ||
+				actual.X != expected.X || actual.Y != expected.Y || actual.D != expected.D || actual.N != expected.N ||
+				actual.E != expected.E || actual.K != expected.K || !slices.Equal(actual.KEYOPS, expected.KEYOPS) {
+				t.Fatalf("Expected the JWK to round trip.\nExpected: %+v\nActual:   %+v", expected, actual)
+			}
+		})
+	}
+
+	for _, raw := range [][]byte{
+		{},
+		{0x01},
+		{0xa1, 0x01, 0x09},
+		{0xa1, 0x01},
+		{0xbf},
+		{0xa2, 0x01, 0x04, 0x01, 0x04},
+		{0xa1, 0x01, 0x04, 0x00},
+	} {
+		_, err = JWKFromCOSE(raw)
+		if !errors.Is(err, ErrCOSE) {
+			t.Fatalf("Expected ErrCOSE for %x, got %v.", raw, err)
+		}
+	}
+}
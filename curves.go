@@ -0,0 +1,326 @@
+package jwkset
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"sync"
+)
+
+// CurveImpl implements the JWK parameters for keys on an elliptic curve, or a similar key family, identified by the
+// crv parameter. Register an implementation with RegisterCurve. Only EC and OKP keys are identified by a crv parameter,
+// so RSA and oct keys are always handled by this package and cannot be registered.
+type CurveImpl struct {
+	// ALGs are the algorithms keys on the curve can be used with. They are only used to check that an alg parameter
+	// is compatible with a key on the curve, such as when validating a JWK, inferring the algorithm of a key without
+	// the alg parameter, and by JWK.SupportedAlgs. Listing an algorithm does not add support for signing or
+	// verifying with it, such as with SignJWKS, which only supports the algorithms defined by this package.
+	ALGs []ALG
+
+	// Decode creates a key from the parameters of the JWKMarshal, such as x, y, and d. A private key should only be
+	// created if private is true. For EC keys, it should return an error wrapping ErrInvalidECPoint if the point is not
+	// on the curve. This is required.
+	Decode func(marshal JWKMarshal, private bool) (key any, err error)
+
+	// Encode returns a JWKMarshal with the parameters for the key, such as x, y, and d. The d parameter should only be
+	// set if private is true. It must return an error wrapping ErrUnsupportedKey for keys that are not on the curve.
+	// This is required.
+	Encode func(key any, private bool) (JWKMarshal, error)
+
+	// Generate generates a new private key on the curve. It is used by GenerateJWK and is optional.
+	Generate func() (key any, err error)
+
+	// KTY is the key type of keys on the curve, either EC or OKP. This is required.
+	KTY KTY
+
+	// Validate performs additional validation of a key on the curve, when the JWK is validated. It is optional.
+	Validate func(key any) error
+}
+
+var (
+	curvesMux sync.RWMutex
+	curves    = map[CRV]CurveImpl{
+		CrvP256:    ecdsaCurve(elliptic.P256(), AlgES256, AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW),
+		CrvP384:    ecdsaCurve(elliptic.P384(), AlgES384, AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW),
+		CrvP521:    ecdsaCurve(elliptic.P521(), AlgES512, AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW),
+		CrvEd25519: ed25519Curve(),
+		CrvX25519:  x25519Curve(),
+	}
+)
+
+// RegisterCurve registers the implementation of a curve for the given crv parameter, so keys on the curve can be
+// parsed, marshaled, validated, and generated. The curves supported by the Golang standard library are registered by
+// this package. The registry is limited to EC and OKP keys. RSA and oct keys have no crv parameter and are always
+// handled by this package. Algorithms cannot be registered, see CurveImpl.ALGs.
+//
+// RegisterCurve is intended to be called from an init function. It panics if the name is empty, if the implementation
+// is missing a required field, or if the name is already registered.
+func RegisterCurve(name string, impl CurveImpl) {
+	if name == "" || impl.Decode == nil || impl.Encode == nil || (impl.KTY != KtyEC && impl.KTY != KtyOKP) {
+		panic("jwkset: RegisterCurve requires a name, the Decode and Encode fields, and a KTY of EC or OKP")
+	}
+	curvesMux.Lock()
+	defer curvesMux.Unlock()
+	if _, ok := curves[CRV(name)]; ok {
+		panic(fmt.Sprintf("jwkset: RegisterCurve called twice for curve %q", name))
+	}
+	curves[CRV(name)] = impl
+}
+
+// lookupCurve returns the registered implementation of the given curve for the given key type.
+func lookupCurve(kty KTY, crv CRV) (CurveImpl, bool) {
+	curvesMux.RLock()
+	defer curvesMux.RUnlock()
+	impl, ok := curves[crv]
+	if !ok || impl.KTY != kty {
+		return CurveImpl{}, false
+	}
+	return impl, true
+}
+
+// encodeCurve uses the registered curve implementations to marshal the given key.
+func encodeCurve(key any, private bool) (JWKMarshal, error) {
+	curvesMux.RLock()
+	names := make([]CRV, 0, len(curves))
+	for name := range curves {
+		names = append(names, name)
+	}
+	curvesMux.RUnlock()
+	slices.Sort(names)
+	for _, name := range names {
+		curvesMux.RLock()
+		impl := curves[name]
+		curvesMux.RUnlock()
+		m, err := impl.Encode(key, private)
+		if errors.Is(err, ErrUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return JWKMarshal{}, fmt.Errorf("failed to marshal key on curve %q: %w", name, err)
+		}
+		m.CRV = name
+		m.KTY = impl.KTY
+		return m, nil
+	}
+	return JWKMarshal{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
+
+// curveCompatible reports whether a key on a registered curve can be used with the algorithm.
+func curveCompatible(alg ALG, kty KTY, crv CRV) bool {
+	impl, ok := lookupCurve(kty, crv)
+	return ok && slices.Contains(impl.ALGs, alg)
+}
+
+// ecdsaCurve implements an elliptic curve from crypto/elliptic for ECDSA keys.
+func ecdsaCurve(curve elliptic.Curve, algs ...ALG) CurveImpl {
+	name := curve.Params().Name
+	return CurveImpl{
+		ALGs: algs,
+		Decode: func(marshal JWKMarshal, private bool) (any, error) {
+			if marshal.X == "" || marshal.Y == "" {
+				return nil, fmt.Errorf(`%w: %s requires parameters "crv", "x", and "y"`, ErrKeyUnmarshalParameter, KtyEC)
+			}
+			x, err := base64urlTrailingPadding(marshal.X)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to decode %s key parameter "x": %w`, KtyEC, err)
+			}
+			y, err := base64urlTrailingPadding(marshal.Y)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to decode %s key parameter "y": %w`, KtyEC, err)
+			}
+			publicKey := &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+			if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+				return nil, fmt.Errorf("%w: point is not on curve %q", errors.Join(ErrKeyUnmarshalParameter, ErrInvalidECPoint), name)
+			}
+			if !private {
+				return publicKey, nil
+			}
+			d, err := base64urlTrailingPadding(marshal.D)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to decode %s key parameter "d": %w`, KtyEC, err)
+			}
+			return &ecdsa.PrivateKey{
+				PublicKey: *publicKey,
+				D:         new(big.Int).SetBytes(d),
+			}, nil
+		},
+		Encode: func(key any, private bool) (JWKMarshal, error) {
+			var pub *ecdsa.PublicKey
+			var priv *ecdsa.PrivateKey
+			switch key := key.(type) {
+			case *ecdsa.PrivateKey:
+				pub = &key.PublicKey
+				priv = key
+			case *ecdsa.PublicKey:
+				pub = key
+			}
+			if pub == nil || pub.Curve.Params().Name != name {
+				return JWKMarshal{}, ErrUnsupportedKey
+			}
+			params := pub.Curve.Params()
+			l := uint(params.BitSize / 8)
+			if params.BitSize%8 != 0 {
+				l++
+			}
+			m := JWKMarshal{
+				X: bigIntToBase64RawURL(pub.X, l),
+				Y: bigIntToBase64RawURL(pub.Y, l),
+			}
+			if private && priv != nil {
+				f, _ := params.N.Float64()
+				l = uint(math.Ceil(math.Log2(f) / 8))
+				m.D = bigIntToBase64RawURL(priv.D, l)
+			}
+			return m, nil
+		},
+		Generate: func() (any, error) {
+			return ecdsa.GenerateKey(curve, rand.Reader)
+		},
+		KTY: KtyEC,
+		Validate: func(key any) error {
+			pub, ok := publicKey(key).(*ecdsa.PublicKey)
+			if ok && (pub.X == nil || pub.Y == nil || !curve.IsOnCurve(pub.X, pub.Y)) {
+				return fmt.Errorf("%w: point is not on curve %q", ErrInvalidECPoint, name)
+			}
+			return nil
+		},
+	}
+}
+
+// ed25519Curve implements Ed25519 keys.
+func ed25519Curve() CurveImpl {
+	return CurveImpl{
+		ALGs: []ALG{AlgEdDSA},
+		Decode: func(marshal JWKMarshal, private bool) (any, error) {
+			public, err := decodeOKP(marshal)
+			if err != nil {
+				return nil, err
+			}
+			if len(public) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("%w: %s key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, ed25519.PublicKeySize)
+			}
+			if !private {
+				return ed25519.PublicKey(public), nil
+			}
+			priv, err := base64urlTrailingPadding(marshal.D)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to decode %s key parameter "d": %w`, KtyOKP, err)
+			}
+			priv = append(priv, public...)
+			if len(priv) != ed25519.PrivateKeySize {
+				return nil, fmt.Errorf("%w: %s key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, ed25519.PrivateKeySize)
+			}
+			return ed25519.PrivateKey(priv), nil
+		},
+		Encode: func(key any, private bool) (JWKMarshal, error) {
+			switch key := key.(type) {
+			case ed25519.PrivateKey:
+				m := JWKMarshal{
+					X: base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+				}
+				if private {
+					m.D = base64.RawURLEncoding.EncodeToString(key[:32])
+				}
+				return m, nil
+			case ed25519.PublicKey:
+				return JWKMarshal{
+					X: base64.RawURLEncoding.EncodeToString(key),
+				}, nil
+			}
+			return JWKMarshal{}, ErrUnsupportedKey
+		},
+		Generate: func() (any, error) {
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			return priv, err
+		},
+		KTY: KtyOKP,
+	}
+}
+
+// x25519Curve implements X25519 keys.
+func x25519Curve() CurveImpl {
+	return CurveImpl{
+		ALGs: []ALG{AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW},
+		Decode: func(marshal JWKMarshal, private bool) (any, error) {
+			public, err := decodeOKP(marshal)
+			if err != nil {
+				return nil, err
+			}
+			const x25519PublicKeySize = 32
+			if len(public) != x25519PublicKeySize {
+				return nil, fmt.Errorf("%w: %s with curve %s public key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, CrvX25519, x25519PublicKeySize)
+			}
+			if !private {
+				key, err := ecdh.X25519().NewPublicKey(public)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create X25519 public key: %w", err)
+				}
+				return key, nil
+			}
+			priv, err := base64urlTrailingPadding(marshal.D)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to decode %s key parameter "d": %w`, KtyOKP, err)
+			}
+			const x25519PrivateKeySize = 32
+			if len(priv) != x25519PrivateKeySize {
+				return nil, fmt.Errorf("%w: %s with curve %s private key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, CrvX25519, x25519PrivateKeySize)
+			}
+			key, err := ecdh.X25519().NewPrivateKey(priv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create X25519 private key: %w", err)
+			}
+			return key, nil
+		},
+		Encode: func(key any, private bool) (JWKMarshal, error) {
+			switch key := key.(type) {
+			case *ecdh.PrivateKey:
+				if key.Curve() != ecdh.X25519() {
+					break
+				}
+				m := JWKMarshal{
+					X: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+				}
+				if private {
+					m.D = base64.RawURLEncoding.EncodeToString(key.Bytes())
+				}
+				return m, nil
+			case *ecdh.PublicKey:
+				if key.Curve() != ecdh.X25519() {
+					break
+				}
+				return JWKMarshal{
+					X: base64.RawURLEncoding.EncodeToString(key.Bytes()),
+				}, nil
+			}
+			return JWKMarshal{}, ErrUnsupportedKey
+		},
+		Generate: func() (any, error) {
+			return ecdh.X25519().GenerateKey(rand.Reader)
+		},
+		KTY: KtyOKP,
+	}
+}
+
+// decodeOKP decodes the public key bytes of an OKP key.
+func decodeOKP(marshal JWKMarshal) ([]byte, error) {
+	if marshal.X == "" {
+		return nil, fmt.Errorf(`%w: %s requires parameters "crv" and "x"`, ErrKeyUnmarshalParameter, KtyOKP)
+	}
+	public, err := base64urlTrailingPadding(marshal.X)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to decode %s key parameter "x": %w`, KtyOKP, err)
+	}
+	return public, nil
+}
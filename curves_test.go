@@ -0,0 +1,110 @@
+package jwkset
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	crvTest = "test-curve"
+	algTest = "TEST"
+)
+
+var errTestCurveInvalid = errors.New("invalid test curve key")
+
+type testCurvePublicKey []byte
+
+func init() {
+	RegisterCurve(crvTest, CurveImpl{
+		ALGs: []ALG{algTest},
+		Decode: func(marshal JWKMarshal, private bool) (any, error) {
+			x, err := base64.RawURLEncoding.DecodeString(marshal.X)
+			if err != nil {
+				return nil, err
+			}
+			return testCurvePublicKey(x), nil
+		},
+		Encode: func(key any, private bool) (JWKMarshal, error) {
+			k, ok := key.(testCurvePublicKey)
+			if !ok {
+				return JWKMarshal{}, fmt.Errorf("%w: not a test curve key", ErrUnsupportedKey)
+			}
+			return JWKMarshal{X: base64.RawURLEncoding.EncodeToString(k)}, nil
+		},
+		KTY: KtyOKP,
+		Validate: func(key any) error {
+			if len(key.(testCurvePublicKey)) == 0 {
+				return errTestCurveInvalid
+			}
+			return nil
+		},
+	})
+}
+
+func TestRegisterCurve(t *testing.T) {
+	options := JWKOptions{
+		Metadata: JWKMetadataOptions{
+			KID: myKeyID,
+		},
+	}
+	jwk, err := NewJWKFromKey(testCurvePublicKey("public"), options)
+	if err != nil {
+		t.Fatalf("Failed to create JWK for registered curve. %s", err)
+	}
+	if jwk.Marshal().CRV != crvTest || jwk.Marshal().KTY != KtyOKP {
+		t.Fatalf("Unexpected crv %q or kty %q.", jwk.Marshal().CRV, jwk.Marshal().KTY)
+	}
+	raw, err := json.Marshal(jwk.Marshal())
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK. %s", err)
+	}
+	parsed, err := NewJWKFromRawJSON(raw, JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse JWK for registered curve. %s", err)
+	}
+	if !bytes.Equal(parsed.Key().(testCurvePublicKey), []byte("public")) {
+		t.Fatalf("Unexpected key after round trip.")
+	}
+	if !ALG(algTest).compatible(KtyOKP, crvTest) || ALG(algTest).compatible(KtyEC, crvTest) {
+		t.Fatalf("Expected the registered algorithm to be compatible with the registered curve only.")
+	}
+	for _, use := range []USE{"", UseSig, UseEnc} {
+		options := options
+		options.Metadata.USE = use
+		jwk, err = NewJWKFromKey(testCurvePublicKey("public"), options)
+		if err != nil {
+			t.Fatalf("Failed to create JWK for registered curve. %s", err)
+		}
+		if algs := jwk.SupportedAlgs(); len(algs) != 1 || algs[0] != algTest {
+			t.Fatalf("Expected the registered algorithm to be supported with use %q, got %v.", use, algs)
+		}
+	}
+
+	_, err = NewJWKFromKey(testCurvePublicKey{}, options)
+	if !errors.Is(err, errTestCurveInvalid) || !errors.Is(err, ErrJWKValidation) {
+		t.Fatalf("Expected the registered validation to fail, got %s.", err)
+	}
+	_, err = NewJWKFromRawJSON([]byte(`{"kty":"EC","crv":"test-curve","x":"AA","y":"AA"}`), JWKMarshalOptions{}, JWKValidateOptions{})
+	if !errors.Is(err, ErrKeyUnmarshalParameter) {
+		t.Fatalf("Expected an error for a curve with the wrong key type, got %s.", err)
+	}
+	_, err = NewJWKFromRawJSON([]byte(`{"kty":"OKP","crv":"unknown","x":"AA"}`), JWKMarshalOptions{}, JWKValidateOptions{})
+	if !errors.Is(err, ErrKeyUnmarshalParameter) {
+		t.Fatalf("Expected an error for an unregistered curve, got %s.", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected a panic when registering a curve twice.")
+		}
+	}()
+	RegisterCurve(string(CrvP256), CurveImpl{
+		Decode: func(JWKMarshal, bool) (any, error) { return nil, nil },
+		Encode: func(any, bool) (JWKMarshal, error) { return JWKMarshal{}, nil },
+		KTY:    KtyEC,
+	})
+}
@@ -0,0 +1,46 @@
+package jwkset
+
+import (
+	"strings"
+)
+
+// JWKSErrors is an error that aggregates multiple errors, such as when an operation partially fails. It implements
+// Unwrap() []error, so errors.Is and errors.As match any of the aggregated errors. Use Append to add errors and Err to
+// get an error that is nil when no errors were added.
+type JWKSErrors []error
+
+// Append adds the non-nil errors to the aggregate.
+func (e *JWKSErrors) Append(errs ...error) {
+	for _, err := range errs {
+		if err != nil {
+			*e = append(*e, err)
+		}
+	}
+}
+
+// Empty reports whether the aggregate has no errors.
+func (e JWKSErrors) Empty() bool {
+	return len(e) == 0
+}
+
+// Err returns the aggregate as an error, or nil if it is empty.
+func (e JWKSErrors) Err() error {
+	if e.Empty() {
+		return nil
+	}
+	return e
+}
+
+// Error implements the error interface. The messages of the aggregated errors are separated by newlines.
+func (e JWKSErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns the aggregated errors.
+func (e JWKSErrors) Unwrap() []error {
+	return e
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -17,15 +18,33 @@ type storageError struct{}
 func (s storageError) KeyDelete(_ context.Context, _ string) (ok bool, err error) {
 	return false, errStorage
 }
+func (s storageError) KeyExists(_ context.Context, _ string) (bool, error) {
+	return false, errStorage
+}
 func (s storageError) KeyRead(_ context.Context, _ string) (JWK, error) {
 	return JWK{}, errStorage
 }
+func (s storageError) KeyReadByAlg(_ context.Context, _ ALG, _ bool) ([]JWK, error) {
+	return nil, errStorage
+}
+func (s storageError) KeyReadByUse(_ context.Context, _ USE) ([]JWK, error) {
+	return nil, errStorage
+}
+func (s storageError) KeyReadFunc(_ context.Context, _ func(jwk JWK) bool) ([]JWK, error) {
+	return nil, errStorage
+}
 func (s storageError) KeyReadAll(_ context.Context) ([]JWK, error) {
 	return nil, errStorage
 }
+func (s storageError) KeyReadAllPublic(_ context.Context) ([]JWK, error) {
+	return nil, errStorage
+}
 func (s storageError) KeyWrite(_ context.Context, _ JWK) error {
 	return errStorage
 }
+func (s storageError) KeyWriteBatch(_ context.Context, _ []JWK) error {
+	return errStorage
+}
 
 func (s storageError) JSON(_ context.Context) (json.RawMessage, error) {
 	return nil, errStorage
@@ -57,3 +76,27 @@ func TestStorageError(t *testing.T) {
 		t.Fatalf("Expected error, but got none.")
 	}
 }
+
+func TestJWKSErrors(t *testing.T) {
+	var errs JWKSErrors
+	errs.Append(nil)
+	if !errs.Empty() || errs.Err() != nil {
+		t.Fatalf("Expected no errors after appending nil.")
+	}
+
+	errs.Append(fmt.Errorf("failed to write key: %w", ErrKeyNotFound), nil, errStorage)
+	if errs.Empty() || len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %d.", len(errs))
+	}
+	err := errs.Err()
+	if !errors.Is(err, ErrKeyNotFound) || !errors.Is(err, errStorage) {
+		t.Fatalf("Expected the aggregated errors to match with errors.Is.")
+	}
+	var target JWKSErrors
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &target) || len(target) != 2 {
+		t.Fatalf("Expected errors.As to find the aggregate.")
+	}
+	if err.Error() != "failed to write key: "+ErrKeyNotFound.Error()+"\n"+errStorage.Error() {
+		t.Fatalf("Unexpected error message %q.", err.Error())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/MicahParks/jwkset"
 )
@@ -47,18 +48,14 @@ func main() {
 		logger.Fatalf(logFmt, "Failed to store RSA key.", err)
 	}
 
-	http.HandleFunc("/jwks.json", func(writer http.ResponseWriter, request *http.Request) {
-		// TODO Cache the JWK Set so storage isn't called for every request.
-		response, err := jwkSet.JSONPublic(request.Context())
-		if err != nil {
+	// Serve the public keys of the JWK Set storage.
+	handlerOptions := jwkset.HandlerOptions{
+		CacheControlMaxAge: 5 * time.Minute,
+		ErrorHandler: func(ctx context.Context, err error) {
 			logger.Printf(logFmt, "Failed to get JWK Set JSON.", err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		writer.Header().Set("Content-Type", "application/json")
-		_, _ = writer.Write(response)
-	})
+		},
+	}
+	http.Handle("/jwks.json", jwkset.NewHTTPHandler(jwkSet, handlerOptions))
 
 	logger.Print("Visit: http://localhost:8080/jwks.json")
 	logger.Fatalf("Failed to listen and serve: %s", http.ListenAndServe(":8080", nil))
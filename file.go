@@ -0,0 +1,290 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStorageOptions are used to configure the behavior of NewStorageFromFile.
+type FileStorageOptions struct {
+	// Ctx is used to end the poll goroutine when it's no longer needed.
+	//
+	// This defaults to context.Background().
+	Ctx context.Context
+
+	// FileMode is the permission used when the JWK Set file is written.
+	//
+	// This defaults to 0600.
+	FileMode fs.FileMode
+
+	// PollErrorHandler is a function that consumes errors that happen while polling the JWK Set file. This is only
+	// effectual if PollInterval is set.
+	PollErrorHandler func(ctx context.Context, err error)
+
+	// PollInterval is the interval at which the modification time of the JWK Set file is checked. If the file was
+	// modified, it is parsed again and the in-memory cache is replaced. This option will launch a "poll goroutine".
+	//
+	// Without this option, changes made to the file outside of this Storage are not observed.
+	PollInterval time.Duration
+}
+
+var _ Storage = &fileStorage{}
+
+type fileStorage struct {
+	options FileStorageOptions
+	path    string
+
+	mux     sync.RWMutex
+	memory  Storage
+	modTime time.Time
+}
+
+// NewStorageFromFile creates a new Storage implementation that is backed by a JWK Set JSON file on disk. The file is
+// parsed once and cached in memory. KeyWrite, KeyWriteBatch, and KeyDelete atomically rewrite the file by writing to a
+// temporary file in the same directory, then renaming it. If the file does not exist, it is created on the first write.
+//
+// The file contains private key material, so it should be protected accordingly.
+func NewStorageFromFile(path string, options FileStorageOptions) (Storage, error) {
+	if options.Ctx == nil {
+		options.Ctx = context.Background()
+	}
+	if options.FileMode == 0 {
+		options.FileMode = 0600
+	}
+	s := &fileStorage{
+		options: options,
+		path:    path,
+		memory:  NewMemoryStorage(),
+	}
+	_, err := s.reload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWK Set file %q: %w", path, err)
+	}
+
+	if options.PollInterval != 0 {
+		go func() { // Poll goroutine.
+			ticker := time.NewTicker(options.PollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-options.Ctx.Done():
+					return
+				case <-ticker.C:
+					_, err := s.reload()
+					if err != nil && options.PollErrorHandler != nil {
+						options.PollErrorHandler(options.Ctx, err)
+					}
+				}
+			}
+		}()
+	}
+
+	return s, nil
+}
+
+func (s *fileStorage) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	keys, err := s.memory.KeyReadAll(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to snapshot keys from memory: %w", err)
+	}
+	for i, jwk := range keys {
+		if jwk.Marshal().KID == keyID {
+			err = s.write(ctx, append(keys[:i], keys[i+1:]...))
+			if err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (s *fileStorage) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	return s.snapshot().KeyExists(ctx, keyID)
+}
+func (s *fileStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	return s.snapshot().KeyRead(ctx, keyID)
+}
+func (s *fileStorage) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	return s.snapshot().KeyReadByAlg(ctx, alg, inferred)
+}
+func (s *fileStorage) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	return s.snapshot().KeyReadByUse(ctx, use)
+}
+func (s *fileStorage) KeyReadFunc(ctx context.Context, f func(jwk JWK) bool) ([]JWK, error) {
+	return s.snapshot().KeyReadFunc(ctx, f)
+}
+func (s *fileStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	return s.snapshot().KeyReadAll(ctx)
+}
+func (s *fileStorage) KeyReadAllPublic(ctx context.Context) ([]JWK, error) {
+	return s.snapshot().KeyReadAllPublic(ctx)
+}
+func (s *fileStorage) KeyWrite(ctx context.Context, jwk JWK) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	keys, err := s.memory.KeyReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot keys from memory: %w", err)
+	}
+	return s.write(ctx, replaceKey(keys, jwk))
+}
+func (s *fileStorage) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	keys, err := s.memory.KeyReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot keys from memory: %w", err)
+	}
+	for _, jwk := range jwks {
+		keys = replaceKey(keys, jwk)
+	}
+	return s.write(ctx, keys)
+}
+
+func (s *fileStorage) JSON(ctx context.Context) (json.RawMessage, error) {
+	return s.snapshot().JSON(ctx)
+}
+func (s *fileStorage) JSONPublic(ctx context.Context) (json.RawMessage, error) {
+	return s.snapshot().JSONPublic(ctx)
+}
+func (s *fileStorage) JSONPrivate(ctx context.Context) (json.RawMessage, error) {
+	return s.snapshot().JSONPrivate(ctx)
+}
+func (s *fileStorage) JSONWithOptions(ctx context.Context, marshalOptions JWKMarshalOptions, validationOptions JWKValidateOptions) (json.RawMessage, error) {
+	return s.snapshot().JSONWithOptions(ctx, marshalOptions, validationOptions)
+}
+func (s *fileStorage) Marshal(ctx context.Context) (JWKSMarshal, error) {
+	return s.snapshot().Marshal(ctx)
+}
+func (s *fileStorage) MarshalWithOptions(ctx context.Context, marshalOptions JWKMarshalOptions, validationOptions JWKValidateOptions) (JWKSMarshal, error) {
+	return s.snapshot().MarshalWithOptions(ctx, marshalOptions, validationOptions)
+}
+
+func (s *fileStorage) snapshot() Storage {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.memory
+}
+
+// reload parses the JWK Set file if its modification time changed since it was last parsed. It reports whether the
+// in-memory cache was replaced.
+func (s *fileStorage) reload() (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat JWK Set file: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return false, nil
+	}
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read JWK Set file: %w", err)
+	}
+	var jwks JWKSMarshal
+	err = json.Unmarshal(raw, &jwks)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode JWK Set file: %w", err)
+	}
+	m := NewMemoryStorage()
+	for _, marshal := range jwks.Keys {
+		marshalOptions := JWKMarshalOptions{
+			Private: true,
+		}
+		jwk, err := NewJWKFromMarshal(marshal, marshalOptions, JWKValidateOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to create JWK from JWK Marshal: %w", err)
+		}
+		err = m.KeyWrite(s.options.Ctx, jwk)
+		if err != nil {
+			return false, fmt.Errorf("failed to write JWK to memory storage: %w", err)
+		}
+	}
+	s.memory = m
+	s.modTime = info.ModTime()
+	return true, nil
+}
+
+// write atomically replaces the JWK Set file and the in-memory cache with the given keys. The caller must hold the
+// write lock.
+func (s *fileStorage) write(ctx context.Context, keys []JWK) error {
+	m := NewMemoryStorage()
+	for _, jwk := range keys {
+		err := m.KeyWrite(ctx, jwk)
+		if err != nil {
+			return fmt.Errorf("failed to write JWK to memory storage: %w", err)
+		}
+	}
+	raw, err := m.JSONPrivate(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JWK Set: %w", err)
+	}
+
+	err = writeFileAtomic(s.path, raw, s.options.FileMode)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat JWK Set file: %w", err)
+	}
+	s.memory = m
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// replaceKey replaces the key with the same key ID, or appends the key if there is none.
+func replaceKey(keys []JWK, jwk JWK) []JWK {
+	for i, j := range keys {
+		if j.Marshal().KID == jwk.Marshal().KID {
+			keys[i] = jwk
+			return keys
+		}
+	}
+	return append(keys, jwk)
+}
+
+// writeFileAtomic replaces the JWK Set file at the path by writing to a temporary file in the same directory, then
+// renaming it.
+func writeFileAtomic(path string, raw []byte, mode fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary JWK Set file: %w", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(raw)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write temporary JWK Set file: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close temporary JWK Set file: %w", closeErr)
+	}
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return fmt.Errorf("failed to set permissions on temporary JWK Set file: %w", err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to replace JWK Set file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	store, err := NewStorageFromFile(path, FileStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create file storage. %s", err)
+	}
+
+	err = store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey1, kidWritten))
+	if err != nil {
+		t.Fatalf("Failed to write key. %s", err)
+	}
+	err = store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	if err != nil {
+		t.Fatalf("Failed to write key. %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat JWK Set file. %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected file mode %s.", info.Mode().Perm())
+	}
+
+	other, err := NewStorageFromFile(path, FileStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create second file storage. %s", err)
+	}
+	key, err := other.KeyRead(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Failed to read key written by first storage. %s", err)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey2) {
+		t.Fatalf("Read key does not match written key.")
+	}
+
+	ok, err := store.KeyDelete(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to delete key. %s", err)
+	}
+	if !ok {
+		t.Fatalf("Expected key to be deleted.")
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Should have specific error when reading missing key.\n  Actual: %s\n  Expected: %s", err, ErrKeyNotFound)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Failed to read directory. %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the JWK Set file in the directory, but found %d entries.", len(entries))
+	}
+}
+
+func TestFileStorageKeyWriteBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	store, err := NewStorageFromFile(path, FileStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create file storage. %s", err)
+	}
+	err = store.KeyWriteBatch(ctx, []JWK{
+		newStorageTestJWK(t, hmacKey1, kidWritten),
+		newStorageTestJWK(t, hmacKey2, kidWritten2),
+	})
+	if err != nil {
+		t.Fatalf("Failed to write batch. %s", err)
+	}
+
+	other, err := NewStorageFromFile(path, FileStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create second file storage. %s", err)
+	}
+	keys, err := other.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 keys written by the batch, got %d.", len(keys))
+	}
+}
+
+func TestFileStoragePoll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	options := FileStorageOptions{
+		Ctx:          ctx,
+		PollInterval: 10 * time.Millisecond,
+	}
+	store, err := NewStorageFromFile(path, options)
+	if err != nil {
+		t.Fatalf("Failed to create file storage. %s", err)
+	}
+
+	err = os.WriteFile(path, newStorageTestRawJWKS(t), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write JWK Set file. %s", err)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		_, err = store.KeyRead(ctx, kidWritten)
+		if err == nil {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out waiting for external file change to be observed. %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package jwkset
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrGenerateJWK indicates that a JWK could not be generated.
+	ErrGenerateJWK = errors.New("failed to generate JWK")
+)
+
+// GenerateOptions are used to configure the behavior of GenerateJWK.
+type GenerateOptions struct {
+	// Bits is the size of the RSA modulus in bits. It must be at least 2048.
+	//
+	// This defaults to 2048.
+	Bits int
+
+	// CRV is the curve for EC and OKP keys. EC keys support P-256, P-384, and P-521. OKP keys support Ed25519 and
+	// X25519. Curves registered with RegisterCurve are supported if they implement Generate. Keys on the secp256k1
+	// curve are never generated, because its implementation is not constant time.
+	//
+	// This defaults to P-256 for EC keys and Ed25519 for OKP keys.
+	CRV CRV
+
+	// KTY is the key type to generate. This is required.
+	KTY KTY
+
+	// Length is the length in bytes of the random secret for oct keys. It must be at least 16.
+	//
+	// This defaults to 32.
+	Length int
+
+	// Options are used to create the JWK from the generated key. Use the Metadata field to set the alg, key_ops, and
+	// use parameters. If the key ID is empty, it is derived with the KIDStrategy field, which defaults to
+	// KIDThumbprintSHA256. The Marshal.Private field is always set for oct keys.
+	Options JWKOptions
+}
+
+// GenerateJWK generates a new key of the given type and creates a JWK from it.
+func GenerateJWK(options GenerateOptions) (JWK, error) {
+	var key any
+	var err error
+	switch options.KTY {
+	case KtyEC, KtyOKP:
+		crv := options.CRV
+		if crv == "" {
+			crv = CrvP256
+			if options.KTY == KtyOKP {
+				crv = CrvEd25519
+			}
+		}
+		impl, ok := lookupCurve(options.KTY, crv)
+		if !ok || impl.Generate == nil {
+			return JWK{}, fmt.Errorf("%w: unsupported curve %q for key type %q", errors.Join(ErrGenerateJWK, ErrOptions), options.CRV, options.KTY)
+		}
+		key, err = impl.Generate()
+	case KtyRSA:
+		bits := options.Bits
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < 2048 {
+			return JWK{}, fmt.Errorf("%w: RSA keys must be at least 2048 bits, got %d", errors.Join(ErrGenerateJWK, ErrOptions), bits)
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case KtyOct:
+		length := options.Length
+		if length == 0 {
+			length = 32
+		}
+		if length < 16 {
+			return JWK{}, fmt.Errorf("%w: oct keys must be at least 16 bytes, got %d", errors.Join(ErrGenerateJWK, ErrOptions), length)
+		}
+		secret := make([]byte, length)
+		_, err = rand.Read(secret)
+		key = secret
+		options.Options.Marshal.Private = true // Symmetric keys have no public representation.
+	default:
+		return JWK{}, fmt.Errorf("%w: unsupported key type %q", errors.Join(ErrGenerateJWK, ErrUnsupportedKey), options.KTY)
+	}
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to generate %q key: %w", options.KTY, errors.Join(ErrGenerateJWK, err))
+	}
+	if options.Options.KIDStrategy == nil {
+		options.Options.KIDStrategy = KIDThumbprintSHA256
+	}
+
+	jwk, err := NewJWKFromKey(key, options.Options)
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to create JWK from generated key: %w", errors.Join(ErrGenerateJWK, err))
+	}
+	return jwk, nil
+}
@@ -0,0 +1,236 @@
+package jwkset
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestGenerateJWK(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options GenerateOptions
+		check   func(t *testing.T, key any)
+	}{
+		{
+			name:    "EC",
+			options: GenerateOptions{KTY: KtyEC, CRV: CrvP384},
+			check: func(t *testing.T, key any) {
+				if key.(*ecdsa.PrivateKey).Curve.Params().Name != string(CrvP384) {
+					t.Fatalf("Unexpected curve.")
+				}
+			},
+		},
+		{
+			name:    "Ed25519",
+			options: GenerateOptions{KTY: KtyOKP},
+			check: func(t *testing.T, key any) {
+				_ = key.(ed25519.PrivateKey)
+			},
+		},
+		{
+			name:    "X25519",
+			options: GenerateOptions{KTY: KtyOKP, CRV: CrvX25519},
+			check: func(t *testing.T, key any) {
+				_ = key.(*ecdh.PrivateKey)
+			},
+		},
+		{
+			name:    "RSA",
+			options: GenerateOptions{KTY: KtyRSA},
+			check: func(t *testing.T, key any) {
+				if key.(*rsa.PrivateKey).N.BitLen() != 2048 {
+					t.Fatalf("Unexpected RSA modulus size.")
+				}
+			},
+		},
+		{
+			name:    "Oct",
+			options: GenerateOptions{KTY: KtyOct, Length: 64},
+			check: func(t *testing.T, key any) {
+				if len(key.([]byte)) != 64 {
+					t.Fatalf("Unexpected secret length.")
+				}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.options.Options.Metadata = JWKMetadataOptions{
+				KEYOPS: []KEYOPS{KeyOpsSign, KeyOpsVerify},
+				USE:    UseSig,
+			}
+			jwk, err := GenerateJWK(tc.options)
+			if err != nil {
+				t.Fatalf("Failed to generate JWK. %s", err)
+			}
+			tc.check(t, jwk.Key())
+			thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+			if err != nil {
+				t.Fatalf("Failed to compute thumbprint. %s", err)
+			}
+			if jwk.Marshal().KID != base64.RawURLEncoding.EncodeToString(thumbprint) {
+				t.Fatalf("Expected the key ID to be the thumbprint.")
+			}
+			if jwk.Marshal().USE != UseSig || !slices.Equal(jwk.Marshal().KEYOPS, []KEYOPS{KeyOpsSign, KeyOpsVerify}) {
+				t.Fatalf("Expected metadata to be set from options.")
+			}
+		})
+	}
+
+	jwk, err := GenerateJWK(GenerateOptions{KTY: KtyEC, Options: JWKOptions{Metadata: JWKMetadataOptions{KID: myKeyID}}})
+	if err != nil {
+		t.Fatalf("Failed to generate JWK. %s", err)
+	}
+	if jwk.Marshal().KID != myKeyID {
+		t.Fatalf("Expected the given key ID to be kept.")
+	}
+
+	for _, options := range []GenerateOptions{
+		{KTY: KtyEC, CRV: CrvEd25519},
+		{KTY: KtyOKP, CRV: CrvP256},
+		{KTY: KtyRSA, Bits: 1024},
+		{KTY: KtyOct, Length: 8},
+		{KTY: "unknown"},
+	} {
+		_, err = GenerateJWK(options)
+		if !errors.Is(err, ErrGenerateJWK) {
+			t.Fatalf("Expected an error for %+v, got %s.", options, err)
+		}
+	}
+}
+
+func TestGenerateJWKKeyOps(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  GenerateOptions
+		expected []KEYOPS
+	}{
+		{
+			name:     "Sig",
+			options:  GenerateOptions{KTY: KtyEC, Options: JWKOptions{Metadata: JWKMetadataOptions{USE: UseSig}}},
+			expected: []KEYOPS{KeyOpsSign, KeyOpsVerify},
+		},
+		{
+			name:     "SigALG",
+			options:  GenerateOptions{KTY: KtyOKP, Options: JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgEdDSA}}},
+			expected: []KEYOPS{KeyOpsSign, KeyOpsVerify},
+		},
+		{
+			name:     "KeyWrap",
+			options:  GenerateOptions{KTY: KtyRSA, Options: JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgRSAOAEP256}}},
+			expected: []KEYOPS{KeyOpsWrapKey, KeyOpsUnwrapKey},
+		},
+		{
+			name:     "KeyAgreement",
+			options:  GenerateOptions{KTY: KtyOKP, CRV: CrvX25519, Options: JWKOptions{Metadata: JWKMetadataOptions{USE: UseEnc}}},
+			expected: []KEYOPS{KeyOpsDeriveKey, KeyOpsDeriveBits},
+		},
+		{
+			name:     "Direct",
+			options:  GenerateOptions{KTY: KtyOct, Options: JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgDir}}},
+			expected: []KEYOPS{KeyOpsEncrypt, KeyOpsDecrypt},
+		},
+		{
+			name:     "Override",
+			options:  GenerateOptions{KTY: KtyEC, Options: JWKOptions{Metadata: JWKMetadataOptions{KEYOPS: []KEYOPS{KeyOpsVerify}, USE: UseSig}}},
+			expected: []KEYOPS{KeyOpsVerify},
+		},
+		{
+			name:    "Omitted",
+			options: GenerateOptions{KTY: KtyEC, Options: JWKOptions{Metadata: JWKMetadataOptions{KEYOPS: []KEYOPS{}, USE: UseSig}}},
+		},
+		{
+			name:    "NoMetadata",
+			options: GenerateOptions{KTY: KtyEC},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jwk, err := GenerateJWK(tc.options)
+			if err != nil {
+				t.Fatalf("Failed to generate JWK. %s", err)
+			}
+			if !slices.Equal(jwk.Marshal().KEYOPS, tc.expected) {
+				t.Fatalf("Expected key operations %v, got %v.", tc.expected, jwk.Marshal().KEYOPS)
+			}
+		})
+	}
+
+	jwk, err := GenerateJWK(GenerateOptions{KTY: KtyRSA})
+	if err != nil {
+		t.Fatalf("Failed to generate JWK. %s", err)
+	}
+	public, err := NewJWKFromKey(jwk.Key().(*rsa.PrivateKey).Public(), JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgRS256}})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from public key. %s", err)
+	}
+	if !slices.Equal(public.Marshal().KEYOPS, []KEYOPS{KeyOpsVerify}) {
+		t.Fatalf("Expected only the verify operation for a public key, got %v.", public.Marshal().KEYOPS)
+	}
+	public, err = NewJWKFromKey(jwk.Key().(*rsa.PrivateKey).Public(), JWKOptions{Metadata: JWKMetadataOptions{USE: UseEnc}})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from public key. %s", err)
+	}
+	if !slices.Equal(public.Marshal().KEYOPS, []KEYOPS{KeyOpsEncrypt}) {
+		t.Fatalf("Expected only the encrypt operation for a public key, got %v.", public.Marshal().KEYOPS)
+	}
+	signer, err := NewJWKFromSigner(jwk.Key().(*rsa.PrivateKey), JWKOptions{Metadata: JWKMetadataOptions{USE: UseSig}})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from signer. %s", err)
+	}
+	if !slices.Equal(signer.Marshal().KEYOPS, []KEYOPS{KeyOpsSign, KeyOpsVerify}) {
+		t.Fatalf("Expected the sign and verify operations for a signer, got %v.", signer.Marshal().KEYOPS)
+	}
+}
+
+func TestKIDStrategy(t *testing.T) {
+	jwk, err := GenerateJWK(GenerateOptions{KTY: KtyEC, Options: JWKOptions{KIDStrategy: KIDThumbprintSHA1}})
+	if err != nil {
+		t.Fatalf("Failed to generate JWK. %s", err)
+	}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA1)
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint. %s", err)
+	}
+	if jwk.Marshal().KID != base64.RawURLEncoding.EncodeToString(thumbprint) {
+		t.Fatalf("Expected the key ID to be the SHA-1 thumbprint.")
+	}
+
+	pemBytes, err := jwk.PEM()
+	if err != nil {
+		t.Fatalf("Failed to encode PEM. %s", err)
+	}
+	imported, err := JWKFromPEM(pemBytes, JWKOptions{KIDStrategy: KIDThumbprintSHA1})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from PEM. %s", err)
+	}
+	if imported.Marshal().KID != jwk.Marshal().KID {
+		t.Fatalf("Expected the imported key ID %q, got %q.", jwk.Marshal().KID, imported.Marshal().KID)
+	}
+
+	imported, err = JWKFromPEM(pemBytes, JWKOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from PEM. %s", err)
+	}
+	if imported.Marshal().KID != "" {
+		t.Fatalf("Expected no key ID without a KIDStrategy.")
+	}
+
+	custom := KIDStrategyFunc(func(jwk JWK) (string, error) {
+		return "provider-" + string(jwk.Marshal().KTY), nil
+	})
+	imported, err = JWKFromPEM(pemBytes, JWKOptions{KIDStrategy: custom})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from PEM. %s", err)
+	}
+	if imported.Marshal().KID != "provider-EC" {
+		t.Fatalf("Expected the custom key ID, got %q.", imported.Marshal().KID)
+	}
+}
@@ -0,0 +1,98 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentTypeJWKSet is the media type of a JWK Set.
+// https://www.iana.org/assignments/media-types/application/jwk-set+json
+const ContentTypeJWKSet = "application/jwk-set+json"
+
+// HandlerOptions are used to configure the behavior of NewHTTPHandler.
+type HandlerOptions struct {
+	// CacheControlMaxAge is the max-age directive of the Cache-Control header in each response. If zero, the
+	// Cache-Control header is not set.
+	CacheControlMaxAge time.Duration
+
+	// ErrorHandler is a function that consumes errors that happen while marshaling the JWK Set. The response has a
+	// status code of http.StatusInternalServerError when this happens.
+	ErrorHandler func(ctx context.Context, err error)
+}
+
+type httpHandler struct {
+	options HandlerOptions
+	store   Storage
+}
+
+// NewHTTPHandler creates an http.Handler that serves the public keys of the given Storage as a JWK Set. Only public
+// key material is ever served, using the JSONPublic method. The response has an ETag header computed over the JWK
+// Set, so conditional GET requests with the If-None-Match header are answered with http.StatusNotModified.
+//
+// The JWK Set is compact JSON unless the request has a "pretty" query parameter, such as "/jwks.json?pretty", in which
+// case it is indented for reading. HEAD requests are answered with the same headers as GET requests, including the
+// ETag and Content-Length headers, but without a body.
+func NewHTTPHandler(store Storage, options HandlerOptions) http.Handler {
+	return httpHandler{
+		options: options,
+		store:   store,
+	}
+}
+
+func (h httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	raw, err := h.store.JSONPublic(r.Context())
+	if err == nil && r.URL.Query().Has("pretty") {
+		buf := &bytes.Buffer{}
+		err = json.Indent(buf, raw, "", "  ")
+		raw = buf.Bytes()
+	}
+	if err != nil {
+		if h.options.ErrorHandler != nil {
+			h.options.ErrorHandler(r.Context(), err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(raw)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if h.options.CacheControlMaxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(h.options.CacheControlMaxAge/time.Second), 10))
+	}
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJWKSet)
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(raw)
+}
+
+// etagMatch reports whether the If-None-Match header matches the given ETag, using the weak comparison.
+// https://www.rfc-editor.org/rfc/rfc9110#section-13.1.2
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	writeKeys(ctx, t, store,
+		newStorageTestJWK(t, hmacKey1, kidWritten),
+		newStorageTestJWK(t, makeEdDSA(t), kidWritten2),
+	)
+	handler := NewHTTPHandler(store, HandlerOptions{
+		CacheControlMaxAge: time.Hour,
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jwks.json", nil))
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code %d.", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != ContentTypeJWKSet {
+		t.Fatalf("Unexpected Content-Type %q.", resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Cache-Control") != "public, max-age=3600" {
+		t.Fatalf("Unexpected Cache-Control %q.", resp.Header.Get("Cache-Control"))
+	}
+	var jwks JWKSMarshal
+	err := json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		t.Fatalf("Failed to decode JWK Set. %s", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KID != kidWritten2 || jwks.Keys[0].D != "" {
+		t.Fatalf("Expected only public key material to be served.")
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag header.")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/jwks.json", nil)
+	req.Header.Set("If-None-Match", `"other", W/`+etag)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotModified {
+		t.Fatalf("Expected a not modified response, got %d.", recorder.Code)
+	}
+
+	compactLength := resp.Header.Get("Content-Length")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, "/jwks.json", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+		t.Fatalf("Expected a HEAD response without a body, got %d with %d bytes.", recorder.Code, recorder.Body.Len())
+	}
+	if recorder.Header().Get("ETag") != etag || recorder.Header().Get("Content-Length") != compactLength {
+		t.Fatalf("Expected the HEAD response to have the same headers as the GET response.")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jwks.json?pretty", nil))
+	pretty := recorder.Body.String()
+	if !strings.Contains(pretty, "\n  ") || strconv.Itoa(len(pretty)) == compactLength {
+		t.Fatalf("Expected indented JSON, got %s", pretty)
+	}
+	if recorder.Header().Get("ETag") == etag {
+		t.Fatalf("Expected the indented JSON to have a different ETag.")
+	}
+	err = json.Unmarshal([]byte(pretty), &jwks)
+	if err != nil || len(jwks.Keys) != 1 {
+		t.Fatalf("Failed to decode indented JWK Set. %v", err)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/jwks.json", nil))
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("Expected method not allowed, got %d.", recorder.Code)
+	}
+
+	var handled error
+	handler = NewHTTPHandler(storageError{}, HandlerOptions{
+		ErrorHandler: func(ctx context.Context, err error) {
+			handled = err
+		},
+	})
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jwks.json", nil))
+	if recorder.Code != http.StatusInternalServerError || handled == nil {
+		t.Fatalf("Expected an internal server error to be handled, got %d.", recorder.Code)
+	}
+}
@@ -1,12 +1,19 @@
 package jwkset
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -15,36 +22,181 @@ import (
 var (
 	// ErrNewClient fails to create a new JWK Set client.
 	ErrNewClient = errors.New("failed to create new JWK Set client")
+	// ErrPartialKeyReadAll indicates that keys could not be read from some HTTP URLs, but the keys from the others were
+	// returned.
+	ErrPartialKeyReadAll = errors.New("failed to read keys from some HTTP URLs")
+	// ErrInitialRefresh indicates that no HTTP URL was successfully refreshed within the duration given to
+	// WithWaitForInitialRefresh.
+	ErrInitialRefresh = errors.New("no HTTP URL was successfully refreshed")
 )
 
 // HTTPClientOptions are options for creating a new JWK Set client.
 type HTTPClientOptions struct {
+	// Clock returns the current time. It is used for KeyValidityExtractor and UnknownKIDNegativeTTL. This defaults to
+	// time.Now.
+	Clock func() time.Time
+	// ConcurrentKeyReadAll is a flag that indicates the storage for each HTTP URL should be read concurrently when
+	// reading all keys. The combined result is in the same order as when read sequentially.
+	ConcurrentKeyReadAll bool
 	// Given contains keys known from outside HTTP URLs.
 	Given Storage
 	// HTTPURLs are a mapping of HTTP URLs to JWK Set endpoints to storage implementations for the keys located at the
 	// URL. If empty, HTTP will not be used.
+	//
+	// The HTTP URLs are consulted in a stable order. Storage created by NewStorageFromHTTP is ordered by the Priority
+	// option, highest first, and all other storage has a priority of 0. URLs with the same priority are ordered
+	// lexically.
 	HTTPURLs map[string]Storage
+	// KeyReadAllTimeout is the timeout for reading all keys from the storage for each HTTP URL. If zero, there is no
+	// timeout other than the one on the given context.
+	KeyReadAllTimeout time.Duration
+	// KeyValidityExtractor returns the validity window of a JWK, such as from non-standard members set by the JWK Set
+	// provider. If ok is true and the current time is before notBefore or after notAfter, KeyRead treats the key as not
+	// found. A zero notBefore or notAfter leaves that side of the window open. KeyValidityFromMembers can be used to
+	// read the window from JWK members. If nil, keys are not checked.
+	KeyValidityExtractor func(jwk JWK) (notBefore, notAfter time.Time, ok bool)
+	// PartialKeyReadAll is a flag that indicates a failure to read all keys from the storage for an HTTP URL is not
+	// fatal. The keys from the remaining storage are returned along with an error that wraps ErrPartialKeyReadAll and
+	// the error for each failed HTTP URL.
+	PartialKeyReadAll bool
 	// PrioritizeHTTP is a flag that indicates whether keys from the HTTP URL should be prioritized over keys from the
-	// given storage.
+	// given storage. It also decides which key KeyReadAll keeps when a key ID is in both the given storage and the
+	// storage for an HTTP URL. KeyReadAll keeps the key from the highest priority HTTP URL when a key ID is in the
+	// storage for more than one HTTP URL.
 	PrioritizeHTTP bool
 	// RateLimitWaitMax is the timeout for waiting for rate limiting to end.
 	RateLimitWaitMax time.Duration
+	// RefreshRateLimiter is set as the RefreshRateLimiter option of each storage for the HTTP URLs that was created by
+	// NewStorageFromHTTP without one, so scheduled and on-demand refreshes for all HTTP URLs share it. It can also be
+	// shared between clients. Requests made before NewHTTPClient, such as the first request for each HTTP URL, are not
+	// limited by it.
+	RefreshRateLimiter *rate.Limiter
 	// RefreshUnknownKID is non-nil to indicate that remote HTTP resources should be refreshed if a key with an unknown
 	// key ID is trying to be read. This makes reading methods block until the context is over, a key with the matching
-	// key ID is found in a refreshed remote resource, or all refreshes complete.
+	// key ID is found in a refreshed remote resource, or all refreshes complete. Concurrent reads that cause an
+	// on-demand refresh of the same HTTP URL share a single refresh.
+	//
+	// The on-demand refresh uses the context of the read, limited by the HTTPTimeout option of the HTTP storage, so
+	// values attached to it, such as trace IDs or a correlation ID from WithRefreshCorrelationID, are passed to
+	// RefreshErrorHandler and the hooks. When reads share a refresh, the context of the read that started it is used.
 	RefreshUnknownKID *rate.Limiter
+	// UnknownKIDNegativeTTL is the duration a key ID is remembered as unknown after an on-demand refresh caused by
+	// RefreshUnknownKID did not find it. Within this duration, reading the key ID returns ErrKeyNotFound without waiting
+	// for the RefreshUnknownKID rate limiter or refreshing. A key ID that later appears in storage is found as usual. If
+	// zero, unknown key IDs are not remembered.
+	UnknownKIDNegativeTTL time.Duration
 }
 
 // Client is a JWK Set client.
 type httpClient struct {
-	given             Storage
-	httpURLs          map[string]Storage
-	prioritizeHTTP    bool
-	rateLimitWaitMax  time.Duration
-	refreshUnknownKID *rate.Limiter
+	concurrentKeyReadAll bool
+	clock                func() time.Time
+	given                Storage
+	httpURLs             []httpURLStorage
+	keyReadAllTimeout    time.Duration
+	keyValidity          func(jwk JWK) (notBefore, notAfter time.Time, ok bool)
+	partialKeyReadAll    bool
+	prioritizeHTTP       bool
+	rateLimitWaitMax     time.Duration
+	refreshUnknownKID    *rate.Limiter
+	refreshes            *refreshGroup
+	unknownKIDs          *negativeCache
+	closed               *atomic.Bool
+}
+
+// refreshGroup coalesces concurrent on-demand refreshes of the same HTTP URL, so callers that arrive while a refresh is
+// in flight share its result instead of performing their own.
+//
+// It is a small version of golang.org/x/sync/singleflight, kept here on purpose so the module does not depend on
+// another package. Unlike singleflight, a waiting caller stops waiting when its context is done.
+type refreshGroup struct {
+	calls map[string]*refreshCall
+	mux   sync.Mutex
+}
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (g *refreshGroup) do(ctx context.Context, key string, refresh func() error) error {
+	g.mux.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mux.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &refreshCall{
+		done: make(chan struct{}),
+		err:  errors.New("refresh panicked"),
+	}
+	g.calls[key] = call
+	g.mux.Unlock()
+	defer func() {
+		g.mux.Lock()
+		delete(g.calls, key)
+		g.mux.Unlock()
+		close(call.done)
+	}()
+	call.err = refresh()
+	return call.err
+}
+
+// negativeCache remembers key IDs that were not found by an on-demand refresh. Expired key IDs are swept at most once
+// per TTL, so the cache holds no more than the key IDs added in the last two TTLs.
+type negativeCache struct {
+	clock  func() time.Time
+	expiry map[string]time.Time
+	mux    sync.Mutex
+	swept  time.Time
+	ttl    time.Duration
+}
+
+func (n *negativeCache) add(keyID string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	now := n.clock()
+	if now.Sub(n.swept) >= n.ttl {
+		for kid, expiry := range n.expiry {
+			if !now.Before(expiry) {
+				delete(n.expiry, kid)
+			}
+		}
+		n.swept = now
+	}
+	n.expiry[keyID] = now.Add(n.ttl)
+}
+
+func (n *negativeCache) contains(keyID string) bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	expiry, ok := n.expiry[keyID]
+	if !ok {
+		return false
+	}
+	if !n.clock().Before(expiry) {
+		delete(n.expiry, keyID)
+		return false
+	}
+	return true
+}
+
+func (n *negativeCache) remove(keyID string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	delete(n.expiry, keyID)
 }
 
 // NewHTTPClient creates a new JWK Set client from remote HTTP resources.
+//
+// The returned Storage implements RefreshStatusProvider and io.Closer. The refresh status includes each HTTP URL with
+// storage that implements RefreshStatusProvider. Closing it closes the storage for each HTTP URL that implements
+// io.Closer, such as storage created by NewStorageFromHTTP, and makes further Storage method calls return ErrClosed.
+// The given storage is not closed.
 func NewHTTPClient(options HTTPClientOptions) (Storage, error) {
 	if options.Given == nil && len(options.HTTPURLs) == 0 {
 		return nil, fmt.Errorf("%w: no given keys or HTTP URLs", ErrNewClient)
@@ -61,55 +213,181 @@ func NewHTTPClient(options HTTPClientOptions) (Storage, error) {
 			}
 		}
 	}
+	if options.RefreshRateLimiter != nil {
+		for _, store := range options.HTTPURLs {
+			if s, ok := asHTTPStorage(store); ok {
+				s.limiter.CompareAndSwap(nil, options.RefreshRateLimiter)
+			}
+		}
+	}
 	given := options.Given
 	if given == nil {
 		given = NewMemoryStorage()
 	}
+	clock := options.Clock
+	if clock == nil {
+		clock = time.Now
+	}
 	c := httpClient{
-		given:             given,
-		httpURLs:          options.HTTPURLs,
-		prioritizeHTTP:    options.PrioritizeHTTP,
-		rateLimitWaitMax:  options.RateLimitWaitMax,
-		refreshUnknownKID: options.RefreshUnknownKID,
+		clock:                clock,
+		concurrentKeyReadAll: options.ConcurrentKeyReadAll,
+		given:                given,
+		httpURLs:             orderHTTPURLs(options.HTTPURLs),
+		keyReadAllTimeout:    options.KeyReadAllTimeout,
+		keyValidity:          options.KeyValidityExtractor,
+		partialKeyReadAll:    options.PartialKeyReadAll,
+		prioritizeHTTP:       options.PrioritizeHTTP,
+		rateLimitWaitMax:     options.RateLimitWaitMax,
+		refreshUnknownKID:    options.RefreshUnknownKID,
+		refreshes: &refreshGroup{
+			calls: make(map[string]*refreshCall),
+		},
+		closed: &atomic.Bool{},
+	}
+	if options.UnknownKIDNegativeTTL > 0 {
+		c.unknownKIDs = &negativeCache{
+			clock:  clock,
+			expiry: make(map[string]time.Time),
+			ttl:    options.UnknownKIDNegativeTTL,
+		}
 	}
 	return c, nil
 }
 
+// DefaultHTTPClientOption changes one of the defaults of NewDefaultHTTPClient and NewDefaultHTTPClientCtx.
+type DefaultHTTPClientOption func(options *defaultHTTPClientOptions)
+
+type defaultHTTPClientOptions struct {
+	client                HTTPClientOptions
+	storage               HTTPClientStorageOptions
+	waitForInitialRefresh time.Duration
+}
+
+// WithLogger sets the Logger option of HTTPClientStorageOptions for each HTTP URL, which is also used to log refresh
+// failures unless WithRefreshErrorHandler is used. Use it to route the logs of the client elsewhere than
+// slog.Default(), such as to a logger with attributes for the component.
+func WithLogger(logger *slog.Logger) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.storage.Logger = logger
+	}
+}
+
+// WithPrioritizeGiven prioritizes keys from the given storage over keys from remote HTTP resources.
+func WithPrioritizeGiven() DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.client.PrioritizeHTTP = false
+	}
+}
+
+// WithRateLimitWaitMax sets the RateLimitWaitMax option of HTTPClientOptions.
+func WithRateLimitWaitMax(d time.Duration) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.client.RateLimitWaitMax = d
+	}
+}
+
+// WithRefreshErrorHandler sets the RefreshErrorHandler option of HTTPClientStorageOptions for each HTTP URL, replacing
+// the default of logging to the Logger option.
+func WithRefreshErrorHandler(handler func(ctx context.Context, err error)) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.storage.RefreshErrorHandler = handler
+	}
+}
+
+// WithRefreshInterval sets the RefreshInterval option of HTTPClientStorageOptions for each HTTP URL. A zero duration
+// disables the refresh goroutine.
+func WithRefreshInterval(d time.Duration) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.storage.RefreshInterval = d
+	}
+}
+
+// WithRefreshUnknownKIDLimiter sets the RefreshUnknownKID option of HTTPClientOptions. A nil limiter disables
+// refreshing remote HTTP resources for unknown key IDs.
+func WithRefreshUnknownKIDLimiter(limiter *rate.Limiter) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.client.RefreshUnknownKID = limiter
+	}
+}
+
+// WithSharedHTTPClient sets the Client option of HTTPClientStorageOptions for each HTTP URL, so requests to all HTTP
+// URLs share one connection pool. The client can also be shared between clients.
+func WithSharedHTTPClient(client *http.Client) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.storage.Client = client
+	}
+}
+
+// WithSharedRateLimiter sets the RefreshRateLimiter option of HTTPClientStorageOptions for each HTTP URL, so all
+// requests, including the first request, scheduled refreshes, and on-demand refreshes, are limited by one rate limiter
+// across all HTTP URLs. The rate limiter can also be shared between clients.
+func WithSharedRateLimiter(limiter *rate.Limiter) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.storage.RefreshRateLimiter = limiter
+	}
+}
+
+// WithWaitForInitialRefresh blocks the constructor until at least one HTTP URL has been successfully refreshed, so
+// keys are present before serving traffic. Failed HTTP URLs are retried until the given duration has passed. If no
+// HTTP URL was refreshed by then, the client is closed and an error that wraps ErrInitialRefresh and the last error for
+// each HTTP URL is returned.
+func WithWaitForInitialRefresh(d time.Duration) DefaultHTTPClientOption {
+	return func(options *defaultHTTPClientOptions) {
+		options.waitForInitialRefresh = d
+	}
+}
+
 // NewDefaultHTTPClient creates a new JWK Set client with default options from remote HTTP resources.
 //
 // The default behavior is to:
 // 1. Refresh remote HTTP resources every hour.
 // 2. Prioritize keys from remote HTTP resources over keys from the given storage.
 // 3. Refresh remote HTTP resources if a key with an unknown key ID is trying to be read, with a rate limit of 5 minutes.
-// 4. Log to slog.Default() if a refresh fails.
-func NewDefaultHTTPClient(urls []string) (Storage, error) {
-	return NewDefaultHTTPClientCtx(context.Background(), urls)
+// 4. Log to slog.Default() if a refresh fails. Use WithLogger to log elsewhere.
+//
+// The defaults can be changed with DefaultHTTPClientOption values, such as WithPrioritizeGiven.
+func NewDefaultHTTPClient(urls []string, opts ...DefaultHTTPClientOption) (Storage, error) {
+	return NewDefaultHTTPClientCtx(context.Background(), urls, opts...)
 }
 
 // NewDefaultHTTPClientCtx is the same as NewDefaultHTTPClient, but with a context that can end the refresh goroutine.
-func NewDefaultHTTPClientCtx(ctx context.Context, urls []string) (Storage, error) {
-	clientOptions := HTTPClientOptions{
-		HTTPURLs:          make(map[string]Storage),
-		RateLimitWaitMax:  time.Minute,
-		RefreshUnknownKID: rate.NewLimiter(rate.Every(5*time.Minute), 1),
+func NewDefaultHTTPClientCtx(ctx context.Context, urls []string, opts ...DefaultHTTPClientOption) (Storage, error) {
+	defaults := defaultHTTPClientOptions{
+		client: HTTPClientOptions{
+			PrioritizeHTTP:    true,
+			RateLimitWaitMax:  time.Minute,
+			RefreshUnknownKID: rate.NewLimiter(rate.Every(5*time.Minute), 1),
+		},
+		storage: HTTPClientStorageOptions{
+			Ctx:                       ctx,
+			NoErrorReturnFirstHTTPReq: true,
+			RefreshInterval:           time.Hour,
+		},
 	}
+	for _, opt := range opts {
+		opt(&defaults)
+	}
+	clientOptions := defaults.client
+	clientOptions.HTTPURLs = make(map[string]Storage)
 	for _, u := range urls {
 		parsed, err := url.ParseRequestURI(u)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse given URL %q: %w", u, errors.Join(err, ErrNewClient))
 		}
 		u = parsed.String()
-		refreshErrorHandler := func(ctx context.Context, err error) {
-			slog.Default().ErrorContext(ctx, "Failed to refresh HTTP JWK Set from remote HTTP resource.",
-				"error", err,
-				"url", u,
-			)
-		}
-		options := HTTPClientStorageOptions{
-			Ctx:                       ctx,
-			NoErrorReturnFirstHTTPReq: true,
-			RefreshErrorHandler:       refreshErrorHandler,
-			RefreshInterval:           time.Hour,
+		options := defaults.storage
+		if options.RefreshErrorHandler == nil {
+			logger := options.Logger
+			options.RefreshErrorHandler = func(ctx context.Context, err error) {
+				l := logger
+				if l == nil {
+					l = slog.Default()
+				}
+				l.ErrorContext(ctx, "Failed to refresh HTTP JWK Set from remote HTTP resource.",
+					"error", err,
+					"url", u,
+				)
+			}
 		}
 		c, err := NewStorageFromHTTP(parsed, options)
 		if err != nil {
@@ -117,10 +395,103 @@ func NewDefaultHTTPClientCtx(ctx context.Context, urls []string) (Storage, error
 		}
 		clientOptions.HTTPURLs[u] = c
 	}
-	return NewHTTPClient(clientOptions)
+	store, err := NewHTTPClient(clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	if defaults.waitForInitialRefresh > 0 {
+		c := store.(httpClient)
+		err = c.waitForInitialRefresh(ctx, defaults.waitForInitialRefresh)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return store, nil
 }
 
+// waitForInitialRefresh refreshes the HTTP URLs that have not been successfully refreshed until one succeeds or the
+// timeout has passed.
+func (c httpClient) waitForInitialRefresh(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errs := make([]error, len(c.httpURLs))
+	for {
+		var wg sync.WaitGroup
+		var mux sync.Mutex
+		succeeded := false
+		for i, h := range c.httpURLs {
+			s, ok := asHTTPStorage(h.store)
+			if !ok {
+				continue
+			}
+			if s.refreshed() {
+				return nil
+			}
+			wg.Add(1)
+			go func(i int, s *httpStorage) {
+				defer wg.Done()
+				err := s.refresh(ctx)
+				mux.Lock()
+				defer mux.Unlock()
+				if err != nil {
+					if errs[i] == nil || ctx.Err() == nil { // Keep the previous error over the timeout of the wait.
+						errs[i] = fmt.Errorf("failed to refresh %q: %w", s.u.String(), err)
+					}
+					return
+				}
+				succeeded = true
+			}(i, s)
+		}
+		wg.Wait()
+		if succeeded || slices.IndexFunc(errs, func(err error) bool { return err != nil }) == -1 {
+			return nil // Refreshed, or there is no storage created by NewStorageFromHTTP.
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to wait %s for the initial refresh: %w", timeout, errors.Join(append([]error{ErrInitialRefresh}, errs...)...))
+		case <-time.After(min(time.Second, timeout/10)):
+		}
+	}
+}
+
+// Close closes the storage for each HTTP URL that implements io.Closer. It is safe to call more than once.
+func (c httpClient) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	var errs JWKSErrors
+	for _, h := range c.httpURLs {
+		closer, ok := h.store.(io.Closer)
+		if !ok {
+			continue
+		}
+		err := closer.Close()
+		if err != nil {
+			errs.Append(fmt.Errorf("failed to close HTTP storage for %q: %w", h.url, err))
+		}
+	}
+	return errs.Err()
+}
+
+// RefreshStatus implements RefreshStatusProvider.
+func (c httpClient) RefreshStatus() map[string]RefreshInfo {
+	status := make(map[string]RefreshInfo, len(c.httpURLs))
+	for _, h := range c.httpURLs {
+		provider, ok := h.store.(RefreshStatusProvider)
+		if !ok {
+			continue
+		}
+		for u, info := range provider.RefreshStatus() {
+			status[u] = info
+		}
+	}
+	return status
+}
 func (c httpClient) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	if c.isClosed() {
+		return false, ErrClosed
+	}
 	ok, err = c.given.KeyDelete(ctx, keyID)
 	if err != nil && !errors.Is(err, ErrKeyNotFound) {
 		return false, fmt.Errorf("failed to delete key with ID %q from given storage due to error: %w", keyID, err)
@@ -128,8 +499,8 @@ func (c httpClient) KeyDelete(ctx context.Context, keyID string) (ok bool, err e
 	if ok {
 		return true, nil
 	}
-	for _, store := range c.httpURLs {
-		ok, err = store.KeyDelete(ctx, keyID)
+	for _, h := range c.httpURLs {
+		ok, err = h.store.KeyDelete(ctx, keyID)
 		if err != nil && !errors.Is(err, ErrKeyNotFound) {
 			return false, fmt.Errorf("failed to delete key with ID %q from HTTP storage due to error: %w", keyID, err)
 		}
@@ -139,7 +510,70 @@ func (c httpClient) KeyDelete(ctx context.Context, keyID string) (ok bool, err e
 	}
 	return false, nil
 }
-func (c httpClient) KeyRead(ctx context.Context, keyID string) (jwk JWK, err error) {
+func (c httpClient) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	if c.isClosed() {
+		return false, ErrClosed
+	}
+	err := ctx.Err()
+	if err != nil {
+		return false, fmt.Errorf("failed to check for key with ID %q: %w", keyID, err)
+	}
+	if c.keyValidity == nil {
+		ok, err := c.given.KeyExists(ctx, keyID)
+		if err != nil {
+			return false, fmt.Errorf("failed to check for key with ID %q in given storage due to error: %w", keyID, err)
+		}
+		if ok {
+			return true, nil
+		}
+		for _, h := range c.httpURLs {
+			ok, err = h.store.KeyExists(ctx, keyID)
+			if err != nil {
+				return false, fmt.Errorf("failed to check for key with ID %q in HTTP storage due to error: %w", keyID, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		if c.refreshUnknownKID == nil {
+			return false, nil
+		}
+	}
+	_, err = c.KeyRead(ctx, keyID)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+func (c httpClient) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	jwk, err := c.keyRead(ctx, keyID)
+	if err != nil || c.keyValidity == nil {
+		return jwk, err
+	}
+	notBefore, notAfter, ok := c.keyValidity(jwk)
+	if !ok {
+		return jwk, nil
+	}
+	now := c.clock()
+	if !notBefore.IsZero() && now.Before(notBefore) {
+		return JWK{}, fmt.Errorf("%w %q: key is not valid before %s", ErrKeyNotFound, keyID, notBefore)
+	}
+	if !notAfter.IsZero() && now.After(notAfter) {
+		return JWK{}, fmt.Errorf("%w %q: key is not valid after %s", ErrKeyNotFound, keyID, notAfter)
+	}
+	return jwk, nil
+}
+func (c httpClient) keyRead(ctx context.Context, keyID string) (jwk JWK, err error) {
+	if c.isClosed() {
+		return JWK{}, ErrClosed
+	}
+	err = ctx.Err()
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to read key with ID %q: %w", keyID, err)
+	}
 	if !c.prioritizeHTTP {
 		jwk, err = c.given.KeyRead(ctx, keyID)
 		switch {
@@ -151,8 +585,8 @@ func (c httpClient) KeyRead(ctx context.Context, keyID string) (jwk JWK, err err
 			return jwk, nil
 		}
 	}
-	for _, store := range c.httpURLs {
-		jwk, err = store.KeyRead(ctx, keyID)
+	for _, h := range c.httpURLs {
+		jwk, err = h.store.KeyRead(ctx, keyID)
 		switch {
 		case errors.Is(err, ErrKeyNotFound):
 			continue
@@ -174,6 +608,9 @@ func (c httpClient) KeyRead(ctx context.Context, keyID string) (jwk JWK, err err
 		}
 	}
 	if c.refreshUnknownKID != nil {
+		if c.unknownKIDs != nil && c.unknownKIDs.contains(keyID) {
+			return JWK{}, fmt.Errorf("%w %q: recently not found by refresh", ErrKeyNotFound, keyID)
+		}
 		var cancel context.CancelFunc = func() {}
 		if c.rateLimitWaitMax > 0 {
 			ctx, cancel = context.WithTimeout(ctx, c.rateLimitWaitMax)
@@ -183,48 +620,177 @@ func (c httpClient) KeyRead(ctx context.Context, keyID string) (jwk JWK, err err
 		if err != nil {
 			return JWK{}, fmt.Errorf("failed to wait for JWK Set refresh rate limiter due to error: %w", err)
 		}
-		for _, store := range c.httpURLs {
-			s, ok := store.(httpStorage)
+		for _, h := range c.httpURLs {
+			s, ok := asHTTPStorage(h.store)
 			if !ok {
 				continue
 			}
-			err = s.refresh(ctx)
-			if err != nil {
-				if s.options.RefreshErrorHandler != nil {
+			err = c.refreshes.do(ctx, h.url, func() error {
+				if s.options.RefreshUnknownKIDHook != nil {
+					runHook(ctx, s.options.Logger, "RefreshUnknownKIDHook", func() {
+						s.options.RefreshUnknownKIDHook(s.u.String(), keyID)
+					})
+				}
+				refreshCtx, cancel := context.WithTimeout(ctx, s.options.HTTPTimeout)
+				defer cancel()
+				err := s.refresh(refreshCtx)
+				if err != nil && s.options.RefreshErrorHandler != nil {
 					s.options.RefreshErrorHandler(ctx, err)
 				}
+				return err
+			})
+			if err != nil {
 				continue
 			}
-			jwk, err = store.KeyRead(ctx, keyID)
+			jwk, err = h.store.KeyRead(ctx, keyID)
 			switch {
 			case errors.Is(err, ErrKeyNotFound):
 				// Do nothing.
 			case err != nil:
 				return JWK{}, fmt.Errorf("failed to find JWT key with ID %q in HTTP storage due to error: %w", keyID, err)
 			default:
+				if c.unknownKIDs != nil {
+					c.unknownKIDs.remove(keyID)
+				}
 				return jwk, nil
 			}
 		}
+		if c.unknownKIDs != nil {
+			c.unknownKIDs.add(keyID)
+		}
 	}
 	return JWK{}, fmt.Errorf("%w %q", ErrKeyNotFound, keyID)
 }
+func (c httpClient) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	given, err := c.given.KeyReadByAlg(ctx, alg, inferred)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read given keys by alg due to error: %w", err)
+	}
+	var fromHTTP []JWK
+	for _, h := range c.httpURLs {
+		j, err := h.store.KeyReadByAlg(ctx, alg, inferred)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTTP keys by alg from %q due to error: %w", h.url, err)
+		}
+		fromHTTP = append(fromHTTP, j...)
+	}
+	if c.prioritizeHTTP {
+		return dedupeByKID(fromHTTP, given), nil
+	}
+	return dedupeByKID(given, fromHTTP), nil
+}
+func (c httpClient) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	given, err := c.given.KeyReadByUse(ctx, use)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read given keys by use due to error: %w", err)
+	}
+	var fromHTTP []JWK
+	for _, h := range c.httpURLs {
+		j, err := h.store.KeyReadByUse(ctx, use)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTTP keys by use from %q due to error: %w", h.url, err)
+		}
+		fromHTTP = append(fromHTTP, j...)
+	}
+	if c.prioritizeHTTP {
+		return dedupeByKID(fromHTTP, given), nil
+	}
+	return dedupeByKID(given, fromHTTP), nil
+}
+func (c httpClient) KeyReadFunc(ctx context.Context, f func(jwk JWK) bool) ([]JWK, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	given, err := c.given.KeyReadFunc(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read given keys with function due to error: %w", err)
+	}
+	var fromHTTP []JWK
+	for _, h := range c.httpURLs {
+		j, err := h.store.KeyReadFunc(ctx, f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTTP keys with function from %q due to error: %w", h.url, err)
+		}
+		fromHTTP = append(fromHTTP, j...)
+	}
+	if c.prioritizeHTTP {
+		return dedupeByKID(fromHTTP, given), nil
+	}
+	return dedupeByKID(given, fromHTTP), nil
+}
 func (c httpClient) KeyReadAll(ctx context.Context) ([]JWK, error) {
-	jwks, err := c.given.KeyReadAll(ctx)
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	err := ctx.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot keys: %w", err)
+	}
+	given, err := c.given.KeyReadAll(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to snapshot given keys due to error: %w", err)
 	}
-	for u, store := range c.httpURLs {
-		j, err := store.KeyReadAll(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to snapshot HTTP keys from %q due to error: %w", u, err)
+	results := make([][]JWK, len(c.httpURLs))
+	errs := make([]error, len(c.httpURLs))
+	if c.concurrentKeyReadAll {
+		var wg sync.WaitGroup
+		for i := range c.httpURLs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = c.keyReadAllHTTP(ctx, c.httpURLs[i])
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range c.httpURLs {
+			results[i], errs[i] = c.keyReadAllHTTP(ctx, c.httpURLs[i])
+			if errs[i] != nil && !c.partialKeyReadAll {
+				break
+			}
+		}
+	}
+	var fromHTTP []JWK
+	for i, j := range results {
+		if errs[i] != nil && !c.partialKeyReadAll {
+			return nil, errs[i]
 		}
-		jwks = append(jwks, j...)
+		fromHTTP = append(fromHTTP, j...)
+	}
+	first, second := given, fromHTTP
+	if c.prioritizeHTTP {
+		first, second = second, first
+	}
+	jwks := dedupeByKID(first, second)
+	var failures JWKSErrors
+	failures.Append(errs...)
+	if !failures.Empty() {
+		return jwks, fmt.Errorf("failed to snapshot HTTP keys from some URLs: %w", errors.Join(ErrPartialKeyReadAll, failures))
 	}
 	return jwks, nil
 }
+func (c httpClient) KeyReadAllPublic(ctx context.Context) ([]JWK, error) {
+	jwks, err := c.KeyReadAll(ctx)
+	return publicJWKs(jwks), err
+}
 func (c httpClient) KeyWrite(ctx context.Context, jwk JWK) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
 	return c.given.KeyWrite(ctx, jwk)
 }
+func (c httpClient) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	return c.given.KeyWriteBatch(ctx, jwks)
+}
 
 func (c httpClient) JSON(ctx context.Context) (json.RawMessage, error) {
 	m, err := c.combineStorage(ctx)
@@ -269,17 +835,102 @@ func (c httpClient) MarshalWithOptions(ctx context.Context, marshalOptions JWKMa
 	return m.MarshalWithOptions(ctx, marshalOptions, validationOptions)
 }
 
+func (c httpClient) isClosed() bool {
+	return c.closed != nil && c.closed.Load()
+}
+
+// combineStorage snapshots the keys into a new MemoryStorage. KeyReadAll orders the keys by precedence, following the
+// PrioritizeHTTP option and the priority of each HTTP URL, so when keys have the same key ID, the first one is kept
+// and the others are dropped instead of overwriting it.
 func (c httpClient) combineStorage(ctx context.Context) (Storage, error) {
 	jwks, err := c.KeyReadAll(ctx)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrPartialKeyReadAll) {
 		return nil, fmt.Errorf("failed to snapshot keys due to error: %w", err)
 	}
 	m := NewMemoryStorage()
-	for _, jwk := range jwks {
-		err = m.KeyWrite(ctx, jwk)
-		if err != nil {
-			return nil, fmt.Errorf("failed to write key to memory storage due to error: %w", err)
+	err = m.KeyWriteBatch(ctx, dedupeByKID(jwks))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write keys to memory storage due to error: %w", err)
+	}
+	return NewReadOnlyStorage(m), nil
+}
+
+// keyReadAllHTTP reads all keys from the storage for an HTTP URL, honoring the KeyReadAllTimeout option.
+func (c httpClient) keyReadAllHTTP(ctx context.Context, h httpURLStorage) ([]JWK, error) {
+	if c.keyReadAllTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.keyReadAllTimeout)
+		defer cancel()
+	}
+	jwks, err := h.store.KeyReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot HTTP keys from %q due to error: %w", h.url, err)
+	}
+	return jwks, nil
+}
+
+// httpStorageWrapper is implemented by Storage that wraps the Storage returned by NewStorageFromHTTP, such as the
+// Storage returned by NewObservedStorage, so the HTTP client can still refresh, order, and rate limit it.
+type httpStorageWrapper interface {
+	httpStorage() (*httpStorage, bool)
+}
+
+// asHTTPStorage returns the Storage created by NewStorageFromHTTP, if the given storage is one or wraps one.
+func asHTTPStorage(store Storage) (*httpStorage, bool) {
+	switch s := store.(type) {
+	case *httpStorage:
+		return s, true
+	case httpStorageWrapper:
+		return s.httpStorage()
+	}
+	return nil, false
+}
+
+// httpURLStorage is the storage for the keys located at an HTTP URL.
+type httpURLStorage struct {
+	url   string
+	store Storage
+}
+
+// orderHTTPURLs sorts the given HTTP URLs by the priority of their storage, highest first, then lexically.
+func orderHTTPURLs(httpURLs map[string]Storage) []httpURLStorage {
+	ordered := make([]httpURLStorage, 0, len(httpURLs))
+	for u, store := range httpURLs {
+		ordered = append(ordered, httpURLStorage{
+			url:   u,
+			store: store,
+		})
+	}
+	priority := func(store Storage) int {
+		s, ok := asHTTPStorage(store)
+		if !ok {
+			return 0
+		}
+		return s.options.Priority
+	}
+	slices.SortFunc(ordered, func(a, b httpURLStorage) int {
+		if c := cmp.Compare(priority(b.store), priority(a.store)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.url, b.url)
+	})
+	return ordered
+}
+
+// dedupeByKID concatenates the given slices of keys. When more than one key has the same key ID, only the first one is
+// kept.
+func dedupeByKID(sets ...[]JWK) []JWK {
+	var deduped []JWK
+	seen := make(map[string]struct{})
+	for _, jwks := range sets {
+		for _, jwk := range jwks {
+			kid := jwk.Marshal().KID
+			if _, ok := seen[kid]; ok {
+				continue
+			}
+			seen[kid] = struct{}{}
+			deduped = append(deduped, jwk)
 		}
 	}
-	return m, nil
+	return deduped
 }
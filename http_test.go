@@ -3,12 +3,21 @@ package jwkset
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 func TestClient(t *testing.T) {
@@ -144,6 +153,1057 @@ func TestClient(t *testing.T) {
 	}
 }
 
+func TestDefaultHTTPClientOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+
+	for _, prioritizeGiven := range []bool{false, true} {
+		opts := []DefaultHTTPClientOption{WithRateLimitWaitMax(time.Second), WithRefreshUnknownKIDLimiter(nil)}
+		if prioritizeGiven {
+			opts = append(opts, WithPrioritizeGiven())
+		}
+		requests.Store(0)
+		store, err := NewDefaultHTTPClientCtx(ctx, []string{server.URL}, opts...)
+		if err != nil {
+			t.Fatalf("Failed to create client. %s", err)
+		}
+		c := store.(httpClient)
+		if c.rateLimitWaitMax != time.Second || c.refreshUnknownKID != nil {
+			t.Fatalf("Expected the client options to be changed.")
+		}
+		writeKeys(ctx, t, c.given, newStorageTestJWK(t, hmacKey2, kidWritten))
+		jwk, err := store.KeyRead(ctx, kidWritten)
+		if err != nil {
+			t.Fatalf("Failed to read key. %s", err)
+		}
+		expected := hmacKey1
+		if prioritizeGiven {
+			expected = hmacKey2
+		}
+		if !bytes.Equal(jwk.Key().([]byte), expected) {
+			t.Fatalf("Unexpected key read with prioritizeGiven %t.", prioritizeGiven)
+		}
+		_, err = store.KeyRead(ctx, kidMissing)
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("Expected key not found, got %v.", err)
+		}
+		if requests.Load() != 1 {
+			t.Fatalf("Expected no refresh for an unknown key ID, got %d requests.", requests.Load())
+		}
+	}
+}
+
+func TestDefaultHTTPClientLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil)).With("component", "jwks")
+	store, err := NewDefaultHTTPClientCtx(ctx, []string{server.URL}, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	defer store.(io.Closer).Close()
+	logged := buf.String()
+	if !strings.Contains(logged, "Failed to refresh HTTP JWK Set") || !strings.Contains(logged, "component=jwks") {
+		t.Fatalf("Expected the refresh failure to be logged to the given logger, got %q.", logged)
+	}
+}
+
+func TestDefaultHTTPClientRefreshOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var handled atomic.Int64
+	store, err := NewDefaultHTTPClientCtx(ctx, []string{server.URL},
+		WithRefreshInterval(time.Minute),
+		WithRefreshErrorHandler(func(ctx context.Context, err error) {
+			handled.Add(1)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	if handled.Load() != 1 {
+		t.Fatalf("Expected the error handler to be called for the first request, got %d calls.", handled.Load())
+	}
+	s := store.(httpClient).httpURLs[0].store.(*httpStorage)
+	if s.options.RefreshInterval != time.Minute {
+		t.Fatalf("Expected the refresh interval to be changed, got %s.", s.options.RefreshInterval)
+	}
+}
+
+func TestDefaultHTTPClientShared(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	client := &http.Client{Transport: transport}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	urls := []string{server.URL + "/a", server.URL + "/b", server.URL + "/c"}
+	store, err := NewDefaultHTTPClientCtx(ctx, urls, WithSharedHTTPClient(client), WithSharedRateLimiter(limiter))
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	for _, h := range store.(httpClient).httpURLs {
+		s := h.store.(*httpStorage)
+		if s.options.Client != client || s.limiter.Load() != limiter {
+			t.Fatalf("Expected the HTTP client and rate limiter to be shared for %q.", h.url)
+		}
+	}
+	if transport.requests.Load() != int64(len(urls)) {
+		t.Fatalf("Expected %d requests through the shared HTTP client, got %d.", len(urls), transport.requests.Load())
+	}
+}
+
+func TestDefaultHTTPClientWaitForInitialRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	var requests atomic.Int64
+	var failures atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+
+	failures.Store(2)
+	store, err := NewDefaultHTTPClientCtx(ctx, []string{server.URL}, WithWaitForInitialRefresh(5*time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key after the initial refresh. %s", err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("Expected 3 requests, got %d.", requests.Load())
+	}
+
+	requests.Store(0)
+	failures.Store(1 << 30)
+	_, err = NewDefaultHTTPClientCtx(ctx, []string{server.URL}, WithWaitForInitialRefresh(50*time.Millisecond))
+	if !errors.Is(err, ErrInitialRefresh) || !errors.Is(err, ErrInvalidHTTPStatusCode) {
+		t.Fatalf("Expected the initial refresh to time out, got %v.", err)
+	}
+	if !strings.Contains(err.Error(), server.URL) {
+		t.Fatalf("Expected the error to list the failed URL, got %q.", err)
+	}
+}
+
+func TestClientKeyReadByUse(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	newKey := func(key []byte, kid string, use USE) JWK {
+		options := JWKOptions{
+			Marshal: JWKMarshalOptions{
+				Private: true,
+			},
+			Metadata: JWKMetadataOptions{
+				KID: kid,
+				USE: use,
+			},
+		}
+		return newJWK(t, key, options)
+	}
+	given := NewMemoryStorage()
+	writeKeys(ctx, t, given,
+		newKey([]byte("given sig"), "sig", UseSig),
+		newKey([]byte("given enc"), "enc", UseEnc),
+	)
+	remote := NewMemoryStorage()
+	writeKeys(ctx, t, remote,
+		newKey([]byte("remote sig"), "sig", UseSig),
+		newKey([]byte("remote none"), "none", ""),
+	)
+
+	for _, prioritizeHTTP := range []bool{false, true} {
+		c, err := NewHTTPClient(HTTPClientOptions{
+			Given:          given,
+			HTTPURLs:       map[string]Storage{"https://example.com": remote},
+			PrioritizeHTTP: prioritizeHTTP,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client. %s", err)
+		}
+		keys, err := c.KeyReadByUse(ctx, UseSig)
+		if err != nil {
+			t.Fatalf("Failed to read keys by use. %s", err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("Expected 2 deduplicated keys, got %d.", len(keys))
+		}
+		expected := []byte("given sig")
+		if prioritizeHTTP {
+			expected = []byte("remote sig")
+		}
+		for _, key := range keys {
+			if key.Marshal().USE == UseEnc {
+				t.Fatalf("Encryption key should not be returned.")
+			}
+			if key.Marshal().KID == "sig" && !bytes.Equal(key.Key().([]byte), expected) {
+				t.Fatalf("Wrong copy of duplicated key ID returned for PrioritizeHTTP %t.", prioritizeHTTP)
+			}
+		}
+	}
+}
+
+func TestClientKeyReadByAlgAndFuncDedupe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	newKey := func(key []byte, kid string) JWK {
+		options := JWKOptions{
+			Marshal: JWKMarshalOptions{
+				Private: true,
+			},
+			Metadata: JWKMetadataOptions{
+				ALG: AlgHS256,
+				KID: kid,
+			},
+		}
+		return newJWK(t, key, options)
+	}
+	given := NewMemoryStorage()
+	writeKeys(ctx, t, given, newKey([]byte("given"), myKeyID), newKey([]byte("given only"), kidWritten))
+	remote := NewMemoryStorage()
+	writeKeys(ctx, t, remote, newKey([]byte("remote"), myKeyID), newKey([]byte("remote only"), kidWritten2))
+
+	for _, prioritizeHTTP := range []bool{false, true} {
+		c, err := NewHTTPClient(HTTPClientOptions{
+			Given:          given,
+			HTTPURLs:       map[string]Storage{"https://example.com": remote},
+			PrioritizeHTTP: prioritizeHTTP,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client. %s", err)
+		}
+		byAlg, err := c.KeyReadByAlg(ctx, AlgHS256, false)
+		if err != nil {
+			t.Fatalf("Failed to read keys by alg. %s", err)
+		}
+		byFunc, err := c.KeyReadFunc(ctx, func(jwk JWK) bool { return true })
+		if err != nil {
+			t.Fatalf("Failed to read keys with function. %s", err)
+		}
+		expected := []byte("given")
+		if prioritizeHTTP {
+			expected = []byte("remote")
+		}
+		for name, keys := range map[string][]JWK{"KeyReadByAlg": byAlg, "KeyReadFunc": byFunc} {
+			if len(keys) != 3 {
+				t.Fatalf("Expected 3 deduplicated keys from %s, got %d.", name, len(keys))
+			}
+			for _, key := range keys {
+				if key.Marshal().KID == myKeyID && !bytes.Equal(key.Key().([]byte), expected) {
+					t.Fatalf("Wrong copy of duplicated key ID returned from %s for PrioritizeHTTP %t.", name, prioritizeHTTP)
+				}
+			}
+		}
+	}
+}
+
+func TestClientKeyReadFunc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	given := NewMemoryStorage()
+	writeKeys(ctx, t, given, newStorageTestJWK(t, hmacKey1, "given-1"), newStorageTestJWK(t, hmacKey2, "other"))
+	remote := NewMemoryStorage()
+	writeKeys(ctx, t, remote, newStorageTestJWK(t, hmacKey2, "remote-1"), newStorageTestJWK(t, hmacKey1, "remote-2"))
+	c, err := NewHTTPClient(HTTPClientOptions{
+		Given:    given,
+		HTTPURLs: map[string]Storage{"https://example.com": remote},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	keys, err := c.KeyReadFunc(ctx, func(jwk JWK) bool {
+		return bytes.Equal(jwk.Key().([]byte), hmacKey1)
+	})
+	if err != nil {
+		t.Fatalf("Failed to read keys with function. %s", err)
+	}
+	var kids []string
+	for _, key := range keys {
+		kids = append(kids, key.Marshal().KID)
+	}
+	if strings.Join(kids, ",") != "given-1,remote-2" {
+		t.Fatalf("Unexpected keys read with function %v.", kids)
+	}
+}
+
+func TestClientPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newServer := func(secret string) *httptest.Server {
+		options := JWKOptions{
+			Marshal: JWKMarshalOptions{
+				Private: true,
+			},
+			Metadata: JWKMetadataOptions{
+				KID: myKeyID,
+			},
+		}
+		store := NewMemoryStorage()
+		writeKeys(ctx, t, store, newJWK(t, []byte(secret), options))
+		rawJWKS, err := store.JSONPrivate(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get the JSON. %s", err)
+		}
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(rawJWKS)
+		}))
+	}
+	primary := newServer("primary")
+	defer primary.Close()
+	backup := newServer("backup")
+	defer backup.Close()
+
+	for _, primaryPriority := range []int{-1, 1} {
+		httpURLs := make(map[string]Storage)
+		for u, priority := range map[string]int{primary.URL: primaryPriority, backup.URL: 0} {
+			parsed, err := url.ParseRequestURI(u)
+			if err != nil {
+				t.Fatalf("Failed to parse URL. %s", err)
+			}
+			store, err := NewStorageFromHTTP(parsed, HTTPClientStorageOptions{
+				Ctx:      ctx,
+				Priority: priority,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create HTTP storage. %s", err)
+			}
+			httpURLs[u] = store
+		}
+		c, err := NewHTTPClient(HTTPClientOptions{
+			HTTPURLs: httpURLs,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client. %s", err)
+		}
+
+		expected := []byte("backup")
+		if primaryPriority > 0 {
+			expected = []byte("primary")
+		}
+		for i := 0; i < 10; i++ {
+			jwk, err := c.KeyRead(ctx, myKeyID)
+			if err != nil {
+				t.Fatalf("Failed to read key. %s", err)
+			}
+			if !bytes.Equal(jwk.Key().([]byte), expected) {
+				t.Fatalf("Expected key from the highest priority URL.")
+			}
+			all, err := c.KeyReadAll(ctx)
+			if err != nil {
+				t.Fatalf("Failed to read all keys. %s", err)
+			}
+			if len(all) != 1 || !bytes.Equal(all[0].Key().([]byte), expected) {
+				t.Fatalf("Expected only the key from the highest priority URL.")
+			}
+		}
+	}
+}
+
+type slowStorage struct {
+	Storage
+	delay time.Duration
+}
+
+func (s slowStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+		return s.Storage.KeyReadAll(ctx)
+	}
+}
+
+func TestClientKeyReadAllDedupe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten), newStorageTestJWK(t, makeEdDSA(t), kidWritten2))
+	rawJWKS, err := serverStore.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get the JSON. %s", err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(rawJWKS)
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	backup := httptest.NewServer(handler)
+	defer backup.Close()
+
+	for _, prioritizeHTTP := range []bool{false, true} {
+		httpURLs := make(map[string]Storage)
+		for _, rawURL := range []string{server.URL, backup.URL} {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				t.Fatalf("Failed to parse URL. %s", err)
+			}
+			store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+			if err != nil {
+				t.Fatalf("Failed to create HTTP storage. %s", err)
+			}
+			httpURLs[rawURL] = store
+		}
+		given := NewMemoryStorage()
+		writeKeys(ctx, t, given, newStorageTestJWK(t, hmacKey2, kidWritten))
+		c, err := NewHTTPClient(HTTPClientOptions{
+			Given:          given,
+			HTTPURLs:       httpURLs,
+			PrioritizeHTTP: prioritizeHTTP,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client. %s", err)
+		}
+
+		all, err := c.KeyReadAll(ctx)
+		if err != nil {
+			t.Fatalf("Failed to read all keys. %s", err)
+		}
+		if len(all) != 2 {
+			t.Fatalf("Expected the duplicate key ID to be read once, got %d keys.", len(all))
+		}
+		expected := hmacKey2
+		if prioritizeHTTP {
+			expected = hmacKey1
+		}
+		for _, jwk := range all {
+			if jwk.Marshal().KID == kidWritten && !bytes.Equal(jwk.Key().([]byte), expected) {
+				t.Fatalf("Unexpected key kept with PrioritizeHTTP %t.", prioritizeHTTP)
+			}
+		}
+	}
+}
+
+func TestClientCombineDuplicateKIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newServer := func(secret string) *httptest.Server {
+		store := NewMemoryStorage()
+		writeKeys(ctx, t, store, newStorageTestJWK(t, []byte(secret), myKeyID), newStorageTestJWK(t, []byte(secret), kidWritten))
+		rawJWKS, err := store.JSONPrivate(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get the JSON. %s", err)
+		}
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(rawJWKS)
+		}))
+	}
+	primary := newServer("primary")
+	defer primary.Close()
+	backup := newServer("backup")
+	defer backup.Close()
+
+	for _, prioritizeHTTP := range []bool{false, true} {
+		httpURLs := make(map[string]Storage)
+		for u, priority := range map[string]int{primary.URL: 1, backup.URL: 0} {
+			parsed, err := url.ParseRequestURI(u)
+			if err != nil {
+				t.Fatalf("Failed to parse URL. %s", err)
+			}
+			store, err := NewStorageFromHTTP(parsed, HTTPClientStorageOptions{
+				Ctx:      ctx,
+				Priority: priority,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create HTTP storage. %s", err)
+			}
+			httpURLs[u] = store
+		}
+		given := NewMemoryStorage()
+		writeKeys(ctx, t, given, newStorageTestJWK(t, []byte("given"), kidWritten))
+		c, err := NewHTTPClient(HTTPClientOptions{
+			Given:          given,
+			HTTPURLs:       httpURLs,
+			PrioritizeHTTP: prioritizeHTTP,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client. %s", err)
+		}
+
+		jwks, err := c.Marshal(ctx)
+		if err != nil {
+			t.Fatalf("Failed to marshal JWK Set. %s", err)
+		}
+		expected := map[string]string{
+			kidWritten: "given",
+			myKeyID:    "primary",
+		}
+		if prioritizeHTTP {
+			expected[kidWritten] = "primary"
+		}
+		if len(jwks.Keys) != len(expected) {
+			t.Fatalf("Expected %d keys, got %d.", len(expected), len(jwks.Keys))
+		}
+		for _, marshal := range jwks.Keys {
+			if marshal.K != base64.RawURLEncoding.EncodeToString([]byte(expected[marshal.KID])) {
+				t.Fatalf("Unexpected key kept for key ID %q with PrioritizeHTTP %t.", marshal.KID, prioritizeHTTP)
+			}
+		}
+	}
+}
+
+func TestClientKeyReadAllConcurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newStore := func(kid string) Storage {
+		store := NewMemoryStorage()
+		writeKeys(ctx, t, store, newStorageTestJWK(t, []byte(kid), kid))
+		return store
+	}
+	httpURLs := map[string]Storage{
+		"https://a.example.com": slowStorage{Storage: newStore("a"), delay: 50 * time.Millisecond},
+		"https://b.example.com": newStore("b"),
+		"https://c.example.com": slowStorage{Storage: newStore("c"), delay: time.Minute},
+		"https://d.example.com": storageError{},
+	}
+
+	c, err := NewHTTPClient(HTTPClientOptions{
+		ConcurrentKeyReadAll: true,
+		HTTPURLs:             httpURLs,
+		KeyReadAllTimeout:    200 * time.Millisecond,
+		PartialKeyReadAll:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		jwks, err := c.KeyReadAll(ctx)
+		if !errors.Is(err, ErrPartialKeyReadAll) || !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errStorage) {
+			t.Fatalf("Expected partial error with each failure, got %s.", err)
+		}
+		if len(jwks) != 2 || jwks[0].Marshal().KID != "a" || jwks[1].Marshal().KID != "b" {
+			t.Fatalf("Expected keys from the successful URLs in a stable order.")
+		}
+	}
+	_, err = c.JSON(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JSON from partial results. %s", err)
+	}
+
+	c, err = NewHTTPClient(HTTPClientOptions{
+		ConcurrentKeyReadAll: true,
+		HTTPURLs:             httpURLs,
+		KeyReadAllTimeout:    200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	jwks, err := c.KeyReadAll(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrPartialKeyReadAll) || jwks != nil {
+		t.Fatalf("Expected the first failure to be fatal, got %s.", err)
+	}
+}
+
+func TestClientUnknownKIDNegativeTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+
+	const ttl = time.Minute
+	clock := newFakeClock()
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	c, err := NewHTTPClient(HTTPClientOptions{
+		Clock:                 clock.Now,
+		HTTPURLs:              map[string]Storage{server.URL: store},
+		RateLimitWaitMax:      10 * time.Millisecond,
+		RefreshUnknownKID:     limiter,
+		UnknownKIDNegativeTTL: ttl,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	_, err = c.KeyRead(ctx, kidWritten2)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected key not found, got %v.", err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Expected an on-demand refresh, got %d requests.", requests.Load())
+	}
+	_, err = c.KeyRead(ctx, kidWritten2)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected a negatively cached key not found, got %v.", err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Expected no refresh for a negatively cached key ID, got %d requests.", requests.Load())
+	}
+
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	limiter.SetLimit(rate.Inf)
+	clock.Advance(ttl)
+	jwk, err := c.KeyRead(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Failed to read key after the negative cache expired. %s", err)
+	}
+	if !bytes.Equal(jwk.Key().([]byte), hmacKey2) {
+		t.Fatalf("Unexpected key read after refresh.")
+	}
+	if c.(httpClient).unknownKIDs.contains(kidWritten2) {
+		t.Fatalf("Expected the negative cache entry to be cleared.")
+	}
+}
+
+func TestNegativeCacheSweep(t *testing.T) {
+	const ttl = time.Minute
+	clock := newFakeClock()
+	n := &negativeCache{
+		clock:  clock.Now,
+		expiry: make(map[string]time.Time),
+		ttl:    ttl,
+	}
+	n.add("first")
+	clock.Advance(ttl / 2)
+	n.add("second")
+	clock.Advance(ttl / 2)
+	n.add("third")
+	if len(n.expiry) != 2 {
+		t.Fatalf("Expected the expired key ID to be swept, got %d entries.", len(n.expiry))
+	}
+	clock.Advance(3 * ttl / 4)
+	n.add("fourth")
+	if len(n.expiry) != 3 {
+		t.Fatalf("Expected no sweep within the TTL of the last sweep, got %d entries.", len(n.expiry))
+	}
+	if n.contains("second") {
+		t.Fatalf("Expected an expired key ID to not be contained before it is swept.")
+	}
+	if !n.contains("third") || !n.contains("fourth") {
+		t.Fatalf("Expected unexpired key IDs to be contained.")
+	}
+}
+
+func TestClientKeyExists(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	given := NewMemoryStorage()
+	writeKeys(ctx, t, given, newStorageTestJWK(t, hmacKey2, myKeyID))
+	c, err := NewHTTPClient(HTTPClientOptions{
+		Given:             given,
+		HTTPURLs:          map[string]Storage{server.URL: store},
+		RefreshUnknownKID: rate.NewLimiter(rate.Inf, 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	for _, kid := range []string{myKeyID, kidWritten} {
+		ok, err := c.KeyExists(ctx, kid)
+		if err != nil {
+			t.Fatalf("Failed to check for key %q. %s", kid, err)
+		}
+		if !ok {
+			t.Fatalf("Expected key %q to exist.", kid)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Expected no refresh for known keys, got %d requests.", requests.Load())
+	}
+
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	ok, err := c.KeyExists(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Failed to check for key. %s", err)
+	}
+	if !ok || requests.Load() != 2 {
+		t.Fatalf("Expected an on-demand refresh to find the new key, got %d requests.", requests.Load())
+	}
+	ok, err = c.KeyExists(ctx, kidMissing)
+	if err != nil {
+		t.Fatalf("Failed to check for missing key. %s", err)
+	}
+	if ok {
+		t.Fatalf("Expected missing key to not exist.")
+	}
+}
+
+func TestClientCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var requests atomic.Int64
+	rawJWKS := newStorageTestRawJWKS(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	var hooks atomic.Int64
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx: ctx,
+		RefreshMetricsHook: func(url string, duration time.Duration, err error) {
+			hooks.Add(1)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:          map[string]Storage{server.URL: store},
+		RefreshUnknownKID: rate.NewLimiter(rate.Every(time.Hour), 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	canceled, cancelCanceled := context.WithCancel(ctx)
+	cancelCanceled()
+	_, err = c.KeyRead(canceled, kidMissing)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v.", err)
+	}
+	_, err = c.KeyReadAll(canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v.", err)
+	}
+	_, err = c.KeyExists(canceled, kidWritten)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v.", err)
+	}
+	err = store.(*httpStorage).refresh(canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v.", err)
+	}
+	status := store.(*httpStorage).RefreshStatus()[server.URL]
+	if requests.Load() != 1 || hooks.Load() != 1 || status.LastError != nil {
+		t.Fatalf("Expected no refresh attempt with a canceled context.")
+	}
+
+	_, err = c.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+}
+
+func TestClientKeyValidityExtractor(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	newKey := func(kid string, members string) JWK {
+		raw := []byte(`{"kty":"OKP","crv":"Ed25519","x":"` + eddsaPublic + `","kid":"` + kid + `"` + members + `}`)
+		jwk, err := NewJWKFromRawJSON(raw, JWKMarshalOptions{}, JWKValidateOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create JWK from raw JSON. %s", err)
+		}
+		return jwk
+	}
+	now := clock.Now().Unix()
+	given := NewMemoryStorage()
+	writeKeys(ctx, t, given,
+		newKey("current", fmt.Sprintf(`,"nbf":%d,"exp":%d`, now-60, now+60)),
+		newKey("future", fmt.Sprintf(`,"nbf":%d`, now+60)),
+		newKey("expired", fmt.Sprintf(`,"exp":%d`, now-60)),
+		newKey("unbounded", `,"exp":"never"`),
+		newKey("farFuture", `,"exp":253402300799`),
+		newKey("overflow", `,"exp":1e300`),
+	)
+	c, err := NewHTTPClient(HTTPClientOptions{
+		Clock:                clock.Now,
+		Given:                given,
+		KeyValidityExtractor: KeyValidityFromMembers("nbf", "exp"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	for kid, found := range map[string]bool{
+		"current":   true,
+		"future":    false,
+		"expired":   false,
+		"unbounded": true,
+		"farFuture": true,
+		"overflow":  true,
+	} {
+		_, err = c.KeyRead(ctx, kid)
+		if found && err != nil {
+			t.Fatalf("Failed to read key %q. %s", kid, err)
+		}
+		if !found && !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("Expected ErrKeyNotFound for key %q, got %v.", kid, err)
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+	_, err = c.KeyRead(ctx, "future")
+	if err != nil {
+		t.Fatalf("Failed to read key after its not before time. %s", err)
+	}
+	_, err = c.KeyRead(ctx, "current")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound after expiry, got %v.", err)
+	}
+}
+
+func TestClientRefreshUnknownKIDCoalesce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	var requests atomic.Int64
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			select {
+			case arrived <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:          map[string]Storage{server.URL: store},
+		RefreshUnknownKID: rate.NewLimiter(rate.Inf, 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey2, kidWritten2))
+
+	const readers = 10
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			_, err := c.KeyRead(ctx, kidWritten2)
+			errs <- err
+		}()
+	}
+	<-arrived
+	time.Sleep(50 * time.Millisecond) // Let the remaining readers join the refresh in flight.
+	close(release)
+	for i := 0; i < readers; i++ {
+		err = <-errs
+		if err != nil {
+			t.Fatalf("Failed to read key after refresh. %s", err)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Expected concurrent reads to share one refresh, got %d requests.", requests.Load())
+	}
+}
+
+func TestClientRefreshCorrelationID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	var mux sync.Mutex
+	var handled, measured []string
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx: WithRefreshCorrelationID(ctx, "construction"),
+		RefreshErrorHandler: func(ctx context.Context, err error) {
+			mux.Lock()
+			handled = append(handled, RefreshCorrelationID(ctx))
+			mux.Unlock()
+		},
+		RefreshMetricsContextHook: func(ctx context.Context, url string, duration time.Duration, err error) {
+			mux.Lock()
+			measured = append(measured, RefreshCorrelationID(ctx))
+			mux.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:          map[string]Storage{server.URL: store},
+		RefreshUnknownKID: rate.NewLimiter(rate.Inf, 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	_, err = c.KeyRead(WithRefreshCorrelationID(ctx, "request"), kidWritten2)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected key not found, got %v.", err)
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if len(measured) != 2 || measured[0] != "construction" || measured[1] != "request" {
+		t.Fatalf("Unexpected correlation IDs in the metrics hook: %v", measured)
+	}
+	if len(handled) != 1 || handled[0] != "request" {
+		t.Fatalf("Unexpected correlation IDs in the error handler: %v", handled)
+	}
+	if RefreshCorrelationID(ctx) != "" {
+		t.Fatalf("Expected no correlation ID in a plain context.")
+	}
+}
+
+func TestClientRefreshRateLimiter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+
+	httpURLs := make(map[string]Storage)
+	for _, path := range []string{"/a", "/b"} {
+		u, err := url.Parse(server.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to parse URL. %s", err)
+		}
+		httpURLs[u.String()], err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+		if err != nil {
+			t.Fatalf("Failed to create HTTP storage. %s", err)
+		}
+	}
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	_, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:           httpURLs,
+		RefreshRateLimiter: limiter,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	err = httpURLs[server.URL+"/a"].(*httpStorage).refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh. %s", err)
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer shortCancel()
+	err = httpURLs[server.URL+"/b"].(*httpStorage).refresh(shortCtx)
+	if err == nil {
+		t.Fatalf("Expected the shared rate limiter to block the second refresh.")
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("Expected 3 requests, got %d.", requests.Load())
+	}
+}
+
 func TestClientError(t *testing.T) {
 	_, err := NewHTTPClient(HTTPClientOptions{})
 	if err == nil {
@@ -0,0 +1,105 @@
+package jwkset
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrKeyNotForEncryption indicates that a JWK is not permitted or not suitable for JWE key management.
+var ErrKeyNotForEncryption = errors.New("key is not for encryption")
+
+// KeyEncrypter reads the JWK with the given key ID from the storage and returns its public key for encrypting or
+// agreeing on a JWE Content Encryption Key. The key is an *rsa.PublicKey for RSA-OAEP, used with rsa.EncryptOAEP, or
+// an *ecdh.PublicKey for ECDH-ES, used as the recipient key.
+// https://www.rfc-editor.org/rfc/rfc7518#section-4
+//
+// An error that wraps ErrKeyNotForEncryption is returned if the use parameter is not "enc" or empty, the key_ops
+// parameter is set without one of "encrypt", "wrapKey", "deriveKey", or "deriveBits", the alg parameter is set to an
+// algorithm other than an RSA-OAEP variant for RSA keys or an ECDH-ES variant for EC and OKP keys, or the key type is
+// not RSA, EC, or X25519.
+func KeyEncrypter(ctx context.Context, store Storage, keyID string) (crypto.PublicKey, error) {
+	jwk, err := readEncryptionKey(ctx, store, keyID, KeyOpsEncrypt, KeyOpsWrapKey)
+	if err != nil {
+		return nil, err
+	}
+	switch k := publicKey(jwk.Key()).(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		pub, err := k.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("EC key with ID %q cannot be used for ECDH: %w", keyID, errors.Join(ErrKeyNotForEncryption, err))
+		}
+		return pub, nil
+	case *ecdh.PublicKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: key with ID %q is of unsupported type %T", ErrKeyNotForEncryption, keyID, jwk.Key())
+}
+
+// KeyDecrypter reads the JWK with the given key ID from the storage and returns its private key for decrypting or
+// agreeing on a JWE Content Encryption Key. The key is a crypto.Decrypter for RSA-OAEP, used with rsa.OAEPOptions, or
+// an *ecdh.PrivateKey for ECDH-ES, used with the ephemeral public key from the JWE header.
+//
+// The key is checked like in KeyEncrypter, except that key_ops must contain one of "decrypt", "unwrapKey",
+// "deriveKey", or "deriveBits". An error that wraps ErrKeyNotForEncryption is also returned if the JWK has no private
+// key material.
+func KeyDecrypter(ctx context.Context, store Storage, keyID string) (crypto.PrivateKey, error) {
+	jwk, err := readEncryptionKey(ctx, store, keyID, KeyOpsDecrypt, KeyOpsUnwrapKey)
+	if err != nil {
+		return nil, err
+	}
+	switch k := jwk.Key().(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		priv, err := k.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("EC key with ID %q cannot be used for ECDH: %w", keyID, errors.Join(ErrKeyNotForEncryption, err))
+		}
+		return priv, nil
+	case *ecdh.PrivateKey:
+		return k, nil
+	case *rsa.PublicKey, *ecdsa.PublicKey, *ecdh.PublicKey:
+		return nil, fmt.Errorf("%w: key with ID %q has no private key material", ErrKeyNotForEncryption, keyID)
+	}
+	return nil, fmt.Errorf("%w: key with ID %q is of unsupported type %T", ErrKeyNotForEncryption, keyID, jwk.Key())
+}
+
+// readEncryptionKey reads the JWK with the given key ID and checks that its use, key_ops, and alg parameters permit JWE
+// key management. The key_ops parameter must contain one of the given operations or a key agreement operation.
+func readEncryptionKey(ctx context.Context, store Storage, keyID string, ops ...KEYOPS) (JWK, error) {
+	jwk, err := store.KeyRead(ctx, keyID)
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to read key with ID %q: %w", keyID, err)
+	}
+	m := jwk.Marshal()
+	if m.USE != "" && m.USE != UseEnc {
+		return JWK{}, fmt.Errorf("%w: key with ID %q has use %q", ErrKeyNotForEncryption, keyID, m.USE)
+	}
+	if len(m.KEYOPS) > 0 {
+		ops = append(ops, KeyOpsDeriveKey, KeyOpsDeriveBits)
+		if !slices.ContainsFunc(m.KEYOPS, func(o KEYOPS) bool { return slices.Contains(ops, o) }) {
+			return JWK{}, fmt.Errorf("%w: key with ID %q has key_ops %q", ErrKeyNotForEncryption, keyID, m.KEYOPS)
+		}
+	}
+	if m.ALG != "" {
+		var allowed bool
+		switch m.KTY {
+		case KtyRSA:
+			allowed = slices.Contains([]ALG{AlgRSAOAEP, AlgRSAOAEP256, AlgRSAOAEP384, AlgRSAOAEP512}, m.ALG)
+		case KtyEC, KtyOKP:
+			allowed = slices.Contains([]ALG{AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW}, m.ALG)
+		}
+		if !allowed {
+			return JWK{}, fmt.Errorf("%w: key with ID %q has alg %q", ErrKeyNotForEncryption, keyID, m.ALG)
+		}
+	}
+	return jwk, nil
+}
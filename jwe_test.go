@@ -0,0 +1,111 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestKeyEncrypterDecrypter(t *testing.T) {
+	ctx := context.Background()
+	rsaKey := makeRSA(t)
+	ecKey := makeECDSAP256(t)
+	x25519Key := makeECDHX25519Private(t)
+	store := NewMemoryStorage()
+	writeKeys(ctx, t, store,
+		newJWK(t, rsaKey, JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgRSAOAEP256, KID: "rsa", USE: UseEnc}}),
+		newJWK(t, ecKey, JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgECDHES, KID: "ec", KEYOPS: []KEYOPS{KeyOpsDeriveKey}}}),
+		newJWK(t, x25519Key, JWKOptions{Metadata: JWKMetadataOptions{KID: "x25519"}}),
+		newJWK(t, &rsaKey.PublicKey, JWKOptions{Metadata: JWKMetadataOptions{KID: "public"}}),
+		newJWK(t, makeEdDSA(t), JWKOptions{Metadata: JWKMetadataOptions{KID: "sig", USE: UseSig}}),
+		newJWK(t, rsaKey, JWKOptions{Metadata: JWKMetadataOptions{KID: "verify", KEYOPS: []KEYOPS{KeyOpsVerify}}}),
+		newJWK(t, rsaKey, JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgRS256, KID: "rs256"}}),
+		newJWK(t, rsaKey, JWKOptions{Metadata: JWKMetadataOptions{KID: "wrap", KEYOPS: []KEYOPS{KeyOpsWrapKey}}}),
+	)
+
+	cek := []byte("content encryption key")
+	pub, err := KeyEncrypter(ctx, store, "rsa")
+	if err != nil {
+		t.Fatalf("Failed to get RSA encrypter. %s", err)
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub.(*rsa.PublicKey), cek, nil)
+	if err != nil {
+		t.Fatalf("Failed to wrap key. %s", err)
+	}
+	priv, err := KeyDecrypter(ctx, store, "rsa")
+	if err != nil {
+		t.Fatalf("Failed to get RSA decrypter. %s", err)
+	}
+	unwrapped, err := priv.(crypto.Decrypter).Decrypt(rand.Reader, wrapped, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("Failed to unwrap key. %s", err)
+	}
+	if !bytes.Equal(unwrapped, cek) {
+		t.Fatalf("Unwrapped key does not match.")
+	}
+
+	for _, kid := range []string{"ec", "x25519"} {
+		pub, err := KeyEncrypter(ctx, store, kid)
+		if err != nil {
+			t.Fatalf("Failed to get %s encrypter. %s", kid, err)
+		}
+		recipient := pub.(*ecdh.PublicKey)
+		ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate ephemeral key. %s", err)
+		}
+		sender, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			t.Fatalf("Failed to agree on key. %s", err)
+		}
+		priv, err := KeyDecrypter(ctx, store, kid)
+		if err != nil {
+			t.Fatalf("Failed to get %s decrypter. %s", kid, err)
+		}
+		receiver, err := priv.(*ecdh.PrivateKey).ECDH(ephemeral.PublicKey())
+		if err != nil {
+			t.Fatalf("Failed to agree on key. %s", err)
+		}
+		if !bytes.Equal(sender, receiver) {
+			t.Fatalf("Agreed %s keys do not match.", kid)
+		}
+	}
+
+	_, err = KeyEncrypter(ctx, store, "public")
+	if err != nil {
+		t.Fatalf("Failed to get encrypter for a public key. %s", err)
+	}
+	_, err = KeyEncrypter(ctx, store, "wrap")
+	if err != nil {
+		t.Fatalf("Failed to get encrypter for a wrapKey key. %s", err)
+	}
+	for _, tc := range []struct {
+		kid     string
+		decrypt bool
+	}{
+		{kid: "public", decrypt: true},
+		{kid: "sig"},
+		{kid: "verify"},
+		{kid: "rs256"},
+		{kid: "wrap", decrypt: true},
+	} {
+		if tc.decrypt {
+			_, err = KeyDecrypter(ctx, store, tc.kid)
+		} else {
+			_, err = KeyEncrypter(ctx, store, tc.kid)
+		}
+		if !errors.Is(err, ErrKeyNotForEncryption) {
+			t.Fatalf("Expected ErrKeyNotForEncryption for key %q, got %v.", tc.kid, err)
+		}
+	}
+	_, err = KeyEncrypter(ctx, store, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v.", err)
+	}
+}
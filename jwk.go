@@ -3,6 +3,8 @@ package jwkset
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
@@ -12,16 +14,27 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"net/http"
 	"net/url"
 	"slices"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 var (
+	// ErrNoPrivateKey indicates that a private key was requested from a JWK without private key material.
+	ErrNoPrivateKey = errors.New("JWK has no private key material")
 	// ErrPadding indicates that there is invalid padding.
 	ErrPadding = errors.New("padding error")
+	// ErrThumbprint indicates that a JWK thumbprint could not be computed.
+	ErrThumbprint = errors.New("failed to compute JWK thumbprint")
+	// ErrUnknownMember indicates that a JWK has a member that is not defined by RFC 7517 or RFC 7518.
+	ErrUnknownMember = errors.New("JWK has an unknown member")
 )
 
 // JWK represents a JSON Web Key.
@@ -29,14 +42,23 @@ type JWK struct {
 	key     any
 	marshal JWKMarshal
 	options JWKOptions
+	signer  crypto.Signer // Set by NewJWKFromSigner. The key is then the public key of the signer.
 }
 
 // JWKMarshalOptions are used to specify options for JSON marshaling a JWK.
 type JWKMarshalOptions struct {
+	// Canonical is used to indicate that the members of each JWK should be JSON marshaled in lexicographic order,
+	// without insignificant whitespace or HTML escaping, similar to RFC 8785. Absent optional members are omitted. This
+	// is effectual for the JSONWithOptions method of Storage.
+	Canonical bool
 	// Private is used to indicate that the JWK's private key material should be JSON marshaled and unmarshalled. This
 	// includes symmetric and asymmetric keys. Setting this to true is the only way to marshal and unmarshal symmetric
 	// keys.
 	Private bool
+	// SortKeys is used to indicate that the keys of a JWK Set should be sorted by key ID (kid) when JSON marshaling,
+	// so the output is reproducible. Keys with the same key ID are ordered by their JSON representation. This has no
+	// effect when marshaling a single JWK.
+	SortKeys bool
 }
 
 // JWKX509Options holds the X.509 certificate information for a JWK. This data structure is not used for JSON marshaling.
@@ -58,11 +80,48 @@ type JWKValidateOptions struct {
 		This package intentionally does not confirm if certificate's usage or compare that to the JWK's use parameter.
 		Please open a GitHub issue if you think this should be an option.
 	*/
-	// CheckX509ValidTime is used to indicate that the X.509 certificate's valid time should be checked.
+	// AllowDuplicateKIDDifferentUse is used with RejectDuplicateKIDs to allow JWKs to share a key ID if they have
+	// different key uses (use), such as a sig and enc pair.
+	AllowDuplicateKIDDifferentUse bool
+	// AllowedAlgs, if not empty, are the only algorithms (alg) a JWK may declare. Keys that do not declare an algorithm
+	// are accepted unless AllowedAlgsInferred is set. Storage that validates keys it reads from a remote resource, such
+	// as NewStorageFromHTTP, never stores keys with a disallowed algorithm.
+	AllowedAlgs []ALG
+	// AllowedAlgsInferred is used to also reject keys that do not declare an algorithm (alg) when none of AllowedAlgs
+	// is compatible with the key type and curve.
+	AllowedAlgsInferred bool
+	// AllowedCurves, if not empty, are the only curves (crv) accepted for EC and OKP keys.
+	AllowedCurves []CRV
+	// AllowedMembers are additional JWK members that are allowed when RejectUnknownMembers is set.
+	AllowedMembers []string
+	// CheckKeyConsistency is used to check that the public components of a private key are derived from the private
+	// components, such as an RSA modulus that is the product of its primes or an EC point that is the private scalar
+	// multiplied by the base point. This recomputes the public key, which is expensive, so it is disabled by default.
+	CheckKeyConsistency bool
+	// CheckKeyOpsUse is used to reject JWKs whose key operations (key_ops) contradict their key use (use). The sig use
+	// is consistent with the sign and verify operations. The enc use is consistent with the encrypt, decrypt, wrapKey,
+	// unwrapKey, deriveKey, and deriveBits operations.
+	CheckKeyOpsUse bool
+	// CheckX509ValidTime is used to indicate that the X.509 certificate's valid time should be checked. The first
+	// certificate in the X.509 certificate chain is rejected if it is expired or not yet valid, allowing for
+	// X509ClockSkew.
 	CheckX509ValidTime bool
+	// Clock returns the current time. It is used to check the X.509 certificate's valid time with CheckX509ValidTime.
+	// This defaults to time.Now.
+	Clock func() time.Time
 	// GetX5U is used to get and validate the X.509 certificate from the X5U URI. Use DefaultGetX5U for the default
 	// behavior.
 	GetX5U func(x5u *url.URL) ([]*x509.Certificate, error)
+	// MinRSABits is the minimum size of an RSA modulus in bits. RSA keys with a smaller modulus are rejected. If zero,
+	// RSA keys of any size are accepted.
+	MinRSABits int
+	// RejectDuplicateKIDs is used to reject a JWK Set in which more than one JWK has the same key ID (kid), which would
+	// make key ID lookups ambiguous. It applies to JWK Sets read by NewStorageFromHTTP and to ValidateJWKS. JWKs without
+	// a key ID are not checked.
+	RejectDuplicateKIDs bool
+	// RejectUnknownMembers is used to reject JWKs unmarshalled from JSON with members that are not defined by RFC 7517
+	// or RFC 7518, unless they are in AllowedMembers.
+	RejectUnknownMembers bool
 	// SkipAll is used to skip all validation.
 	SkipAll bool
 	// SkipKeyOps is used to skip validation of the key operations (key_ops).
@@ -71,10 +130,25 @@ type JWKValidateOptions struct {
 	SkipMetadata bool
 	// SkipUse is used to skip validation of the key use (use).
 	SkipUse bool
+	// SkipX5T is used to skip checking that the X.509 certificate thumbprints (x5t and x5t#S256) match the first
+	// certificate in the X.509 certificate chain (x5c).
+	SkipX5T bool
 	// SkipX5UScheme is used to skip checking if the X5U URI scheme is https.
 	SkipX5UScheme bool
+	// StrictBase64 is used to reject JWKs with base64url members, such as n, e, x, y, d, k, x5t, and x5t#S256, that
+	// are not in the canonical unpadded base64url encoding. Values with padding, line breaks, or non-zero trailing bits
+	// are rejected. By default, such values are accepted for compatibility.
+	StrictBase64 bool
 	// StrictPadding is used to indicate that the JWK should be validated with strict padding.
 	StrictPadding bool
+	// X509ClockSkew is the allowed clock skew when checking the X.509 certificate's valid time with
+	// CheckX509ValidTime.
+	X509ClockSkew time.Duration
+	// X509VerifyOptions is used to verify the first certificate in the X.509 certificate chain (x5c) up to a trusted
+	// root. The remaining certificates in the chain are used as intermediates. The verification includes the validity
+	// period and extended key usages described by the options. Keys without an X.509 certificate chain are not
+	// affected.
+	X509VerifyOptions *x509.VerifyOptions
 }
 
 // JWKMetadataOptions are direct passthroughs into the JWKMarshal.
@@ -83,7 +157,8 @@ type JWKMetadataOptions struct {
 	ALG ALG
 	// KID is the key ID (kid).
 	KID string
-	// KEYOPS is the key operations (key_ops).
+	// KEYOPS is the key operations (key_ops). If nil, NewJWKFromKey infers them from the use and alg parameters, such as
+	// sign and verify for a signing key, or only verify for a public key. Use an empty slice to omit key_ops.
 	KEYOPS []KEYOPS
 	// USE is the key use (use).
 	USE USE
@@ -91,15 +166,68 @@ type JWKMetadataOptions struct {
 
 // JWKOptions are used to specify options for marshaling a JSON Web Key.
 type JWKOptions struct {
-	Marshal  JWKMarshalOptions
-	Metadata JWKMetadataOptions
-	Validate JWKValidateOptions
-	X509     JWKX509Options
+	// KIDStrategy derives the key ID (kid) of a key created without one in the Metadata option. It is used by
+	// NewJWKFromKey, NewJWKFromX5C, JWKFromPEM, and GenerateJWK. GenerateJWK defaults to KIDThumbprintSHA256.
+	KIDStrategy KIDStrategy
+	Marshal     JWKMarshalOptions
+	Metadata    JWKMetadataOptions
+	Validate    JWKValidateOptions
+	X509        JWKX509Options
+}
+
+// KIDStrategy derives a key ID (kid) from a JWK.
+type KIDStrategy interface {
+	KID(jwk JWK) (string, error)
+}
+
+// KIDStrategyFunc adapts a function to the KIDStrategy interface, such as for a provider-specific key ID format.
+type KIDStrategyFunc func(jwk JWK) (string, error)
+
+// KID implements KIDStrategy.
+func (f KIDStrategyFunc) KID(jwk JWK) (string, error) {
+	return f(jwk)
+}
+
+// ThumbprintKIDStrategy derives the key ID from the base64url encoded JWK thumbprint of the key, as defined in
+// https://www.rfc-editor.org/rfc/rfc7638, with the given hash function.
+type ThumbprintKIDStrategy struct {
+	Hash crypto.Hash
 }
 
+// KID implements KIDStrategy.
+func (t ThumbprintKIDStrategy) KID(jwk JWK) (string, error) {
+	thumbprint, err := jwk.Thumbprint(t.Hash)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+var (
+	// KIDThumbprintSHA256 derives the key ID from the SHA-256 JWK thumbprint of the key.
+	KIDThumbprintSHA256 KIDStrategy = ThumbprintKIDStrategy{Hash: crypto.SHA256}
+	// KIDThumbprintSHA1 derives the key ID from the SHA-1 JWK thumbprint of the key, for interoperability with systems
+	// that expect it.
+	KIDThumbprintSHA1 KIDStrategy = ThumbprintKIDStrategy{Hash: crypto.SHA1}
+)
+
 // NewJWKFromKey uses the given key and options to create a JWK. It is possible to provide a private key with an X.509
 // certificate, which will be validated to contain the correct public key.
+//
+// The key is one of *rsa.PrivateKey, *rsa.PublicKey, *ecdsa.PrivateKey, *ecdsa.PublicKey, ed25519.PrivateKey,
+// ed25519.PublicKey, *ecdh.PrivateKey, *ecdh.PublicKey, or []byte for a symmetric key (oct). The key type, curve, and
+// key members are set from the key. The use, alg, and key_ops parameters come from the Metadata option, and the key ID
+// is derived with the KIDStrategy option if the Metadata option does not have one. If the Metadata option has no key
+// operations, they are inferred from the use and alg parameters. Any other key type, including a nil key, returns an
+// error that wraps ErrUnsupportedKey.
 func NewJWKFromKey(key any, options JWKOptions) (JWK, error) {
+	key, err := normalizeKey(key)
+	if err != nil {
+		return JWK{}, err
+	}
+	if options.Metadata.KEYOPS == nil {
+		options.Metadata.KEYOPS = defaultKeyOps(options.Metadata, key, isPrivateKey(key))
+	}
 	marshal, err := keyMarshal(key, options)
 	if err != nil {
 		return JWK{}, fmt.Errorf("failed to marshal JSON Web Key: %w", err)
@@ -117,6 +245,10 @@ func NewJWKFromKey(key any, options JWKOptions) (JWK, error) {
 		marshal: marshal,
 		options: options,
 	}
+	err = j.deriveKID()
+	if err != nil {
+		return JWK{}, err
+	}
 	err = j.Validate()
 	if err != nil {
 		return JWK{}, fmt.Errorf("failed to validate JSON Web Key: %w", err)
@@ -124,6 +256,30 @@ func NewJWKFromKey(key any, options JWKOptions) (JWK, error) {
 	return j, nil
 }
 
+// NewJWKFromSigner uses the public key of the given crypto.Signer and options to create a JWK, such as for a private
+// key in a KMS or HSM. The JWK only has public key material, so it is marshaled with only public members, and
+// PrivateKey returns an error that wraps ErrNoPrivateKey. Use the Signer method to sign with it, as SignJWKS does.
+//
+// The signer is kept in memory with the JWK. It is kept by MemoryStorage, but a Storage that stores keys as JSON, such
+// as Redis or SQL storage, returns keys without it.
+func NewJWKFromSigner(signer crypto.Signer, options JWKOptions) (JWK, error) {
+	if signer == nil {
+		return JWK{}, fmt.Errorf("%w: nil crypto.Signer", ErrUnsupportedKey)
+	}
+	if k, ok := signer.(*ecdsa.PrivateKey); ok && k.Curve.Params().Name == string(CrvSECP256K1) {
+		return JWK{}, fmt.Errorf("%w: curve %q has no constant time implementation", ErrUnsupportedKey, CrvSECP256K1)
+	}
+	if options.Metadata.KEYOPS == nil {
+		options.Metadata.KEYOPS = defaultKeyOps(options.Metadata, signer.Public(), true)
+	}
+	j, err := NewJWKFromKey(signer.Public(), options)
+	if err != nil {
+		return JWK{}, err
+	}
+	j.signer = signer
+	return j, nil
+}
+
 // NewJWKFromRawJSON uses the given raw JSON to create a JWK.
 func NewJWKFromRawJSON(j json.RawMessage, marshalOptions JWKMarshalOptions, validateOptions JWKValidateOptions) (JWK, error) {
 	marshal := JWKMarshal{}
@@ -170,6 +326,10 @@ func NewJWKFromX5C(options JWKOptions) (JWK, error) {
 		marshal: marshal,
 		options: options,
 	}
+	err = j.deriveKID()
+	if err != nil {
+		return JWK{}, err
+	}
 	err = j.Validate()
 	if err != nil {
 		return JWK{}, fmt.Errorf("failed to validate JSON Web Key: %w", err)
@@ -205,6 +365,48 @@ func NewJWKFromX5U(options JWKOptions) (JWK, error) {
 	return jwk, nil
 }
 
+// Extra returns the raw JSON of a top-level member that is not defined by RFC 7517 or RFC 7518, such as proprietary
+// metadata added by a JWK Set provider.
+func (j JWK) Extra(name string) (json.RawMessage, bool) {
+	raw, ok := j.marshal.Extra[name]
+	return raw, ok
+}
+
+// KeyValidityFromMembers returns a function for the KeyValidityExtractor option of HTTPClientOptions that reads the
+// validity window of a JWK from the given top-level members, such as "nbf" and "exp". The members must be JSON numbers
+// of seconds since the Unix epoch, like a JWT NumericDate. An empty name or a missing or non-numeric member leaves that
+// side of the window open. If neither member is usable, ok is false and the key is not checked.
+func KeyValidityFromMembers(notBeforeMember, notAfterMember string) func(jwk JWK) (notBefore, notAfter time.Time, ok bool) {
+	return func(jwk JWK) (notBefore, notAfter time.Time, ok bool) {
+		var nbf, exp bool
+		notBefore, nbf = numericDateMember(jwk, notBeforeMember)
+		notAfter, exp = numericDateMember(jwk, notAfterMember)
+		return notBefore, notAfter, nbf || exp
+	}
+}
+
+// numericDateMember parses the given top-level member of the JWK as a number of seconds since the Unix epoch. Values
+// that are not finite or do not fit in an int64 number of seconds are not usable.
+func numericDateMember(jwk JWK, name string) (time.Time, bool) {
+	if name == "" {
+		return time.Time{}, false
+	}
+	raw, ok := jwk.Extra(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	var seconds float64
+	err := json.Unmarshal(raw, &seconds)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds >= math.MaxInt64 || seconds < math.MinInt64 {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+}
+
 // Key returns the public or private cryptographic key associated with the JWK.
 func (j JWK) Key() any {
 	return j.key
@@ -215,29 +417,289 @@ func (j JWK) Marshal() JWKMarshal {
 	return j.marshal
 }
 
+// PrivateKey returns the private cryptographic key associated with the JWK. It is an *rsa.PrivateKey,
+// *ecdsa.PrivateKey, ed25519.PrivateKey, *ecdh.PrivateKey, or []byte for a symmetric key (oct). An error that wraps
+// ErrNoPrivateKey is returned if the JWK only has a public key.
+func (j JWK) PrivateKey() (crypto.PrivateKey, error) {
+	switch k := j.key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey, *ecdh.PrivateKey, []byte:
+		return k, nil
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey:
+		return nil, fmt.Errorf("%w: key ID %q", ErrNoPrivateKey, j.marshal.KID)
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, j.key)
+}
+
+// Public returns a copy of the JWK with the private key and all private members removed. The key ID, use, algorithm,
+// key operations, X.509 members, and any extra members are kept. An error that wraps ErrUnsupportedKey is returned for
+// a symmetric key (oct), which has no public form.
+func (j JWK) Public() (JWK, error) {
+	if j.marshal.KTY == KtyOct {
+		return JWK{}, fmt.Errorf("%w: symmetric key (oct) has no public form", ErrUnsupportedKey)
+	}
+	j = j.clone()
+	j.key = publicKey(j.key)
+	j.signer = nil
+	j.marshal.D = ""
+	j.marshal.DP = ""
+	j.marshal.DQ = ""
+	j.marshal.OTH = nil
+	j.marshal.P = ""
+	j.marshal.Q = ""
+	j.marshal.QI = ""
+	j.options.Marshal.Private = false
+	return j, nil
+}
+
+// Signer returns the crypto.Signer of a JWK created by NewJWKFromSigner, or the private key of an RSA, ECDSA, or
+// Ed25519 JWK, which implements crypto.Signer. An error that wraps ErrNoPrivateKey is returned if the JWK cannot sign.
+// Private keys on the secp256k1 curve cannot sign, because there is no constant time implementation of the curve, but
+// NewJWKFromSigner can be used with a signer for the curve from another package.
+func (j JWK) Signer() (crypto.Signer, error) {
+	if j.signer != nil {
+		return j.signer, nil
+	}
+	switch k := j.key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve.Params().Name == string(CrvSECP256K1) {
+			return nil, fmt.Errorf("%w: key ID %q is on curve %q, which has no constant time implementation", ErrNoPrivateKey, j.marshal.KID, CrvSECP256K1)
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: key ID %q cannot sign", ErrNoPrivateKey, j.marshal.KID)
+}
+
+// PublicKey returns the public cryptographic key associated with the JWK, deriving it from the private key if needed.
+// It is an *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey, or []byte for a symmetric key (oct),
+// which has no public part.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k := publicKey(j.key).(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey, []byte:
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, j.key)
+}
+
+// SupportedAlgs returns the algorithms the JWK can be used with. If the alg parameter is set, only that algorithm is
+// returned, or nil if it is incompatible with the key. Otherwise, the algorithms are inferred from the key type and
+// curve according to https://www.rfc-editor.org/rfc/rfc7518, with EdDSA from https://www.rfc-editor.org/rfc/rfc8037
+// and ES256K from https://www.rfc-editor.org/rfc/rfc8812, followed by any other ALGs of a curve registered with
+// RegisterCurve. A use parameter of "sig" limits the result to signature algorithms and "enc" limits it to encryption
+// algorithms. Other ALGs of a registered curve are kept for either use unless they are a known algorithm of the other
+// kind.
+func (j JWK) SupportedAlgs() []ALG {
+	if j.marshal.ALG != "" {
+		if !j.marshal.ALG.compatible(j.marshal.KTY, j.marshal.CRV) {
+			return nil
+		}
+		return []ALG{j.marshal.ALG}
+	}
+	var candidates, excluded []ALG
+	switch j.marshal.USE {
+	case UseSig:
+		candidates, excluded = signatureAlgs, encryptionAlgs
+	case UseEnc:
+		candidates, excluded = encryptionAlgs, signatureAlgs
+	default:
+		candidates = append(slices.Clip(signatureAlgs), encryptionAlgs...)
+	}
+	var algs []ALG
+	for _, alg := range candidates {
+		if alg.compatible(j.marshal.KTY, j.marshal.CRV) {
+			algs = append(algs, alg)
+		}
+	}
+	if impl, ok := lookupCurve(j.marshal.KTY, j.marshal.CRV); ok {
+		for _, alg := range impl.ALGs {
+			if !slices.Contains(algs, alg) && !slices.Contains(candidates, alg) && !slices.Contains(excluded, alg) {
+				algs = append(algs, alg)
+			}
+		}
+	}
+	return algs
+}
+
 // X509 returns the X.509 certificate information for the JWK.
 func (j JWK) X509() JWKX509Options {
 	return j.options.X509
 }
 
+// Thumbprint computes the JWK thumbprint of the key with the given hash function as defined in
+// https://www.rfc-editor.org/rfc/rfc7638. Only the required members for the key type are used, so the thumbprint does
+// not change with metadata such as the key ID. The thumbprint of a symmetric key (oct) requires the JWK to have been
+// created with private key material.
+func (j JWK) Thumbprint(h crypto.Hash) ([]byte, error) {
+	if !h.Available() {
+		return nil, fmt.Errorf("%w: hash function %s is not available", ErrThumbprint, h)
+	}
+	var required []string
+	switch j.marshal.KTY {
+	case KtyEC:
+		required = []string{"crv", "kty", "x", "y"}
+	case KtyOKP:
+		required = []string{"crv", "kty", "x"}
+	case KtyRSA:
+		required = []string{"e", "kty", "n"}
+	case KtyOct:
+		required = []string{"k", "kty"}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %q", errors.Join(ErrThumbprint, ErrUnsupportedKey), j.marshal.KTY)
+	}
+	values := map[string]string{
+		"crv": string(j.marshal.CRV),
+		"e":   j.marshal.E,
+		"k":   j.marshal.K,
+		"kty": string(j.marshal.KTY),
+		"n":   j.marshal.N,
+		"x":   j.marshal.X,
+		"y":   j.marshal.Y,
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, name := range required {
+		value := values[name]
+		if name != "kty" && name != "crv" {
+			value = strings.TrimRight(value, "=")
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%w: %s key is missing required member %q", ErrThumbprint, j.marshal.KTY, name)
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		quoted, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal member %q: %w", name, errors.Join(ErrThumbprint, err))
+		}
+		buf.WriteString(`"` + name + `":`)
+		buf.Write(quoted)
+	}
+	buf.WriteByte('}')
+	hash := h.New()
+	hash.Write(buf.Bytes())
+	return hash.Sum(nil), nil
+}
+
+// Equal reports whether the JWKs have the same key type, curve, key material, key ID, algorithm, use, and key
+// operations. Base64url encoded members are compared without trailing padding, and key operations are compared
+// without regard to order. Other members, such as the X.509 members, are ignored. Private key material is only
+// compared if it is present in the JWK, which requires the Marshal.Private option.
+func (j JWK) Equal(other JWK) bool {
+	return jwkMarshalEqual(j.marshal, other.marshal)
+}
+
+// ThumbprintURI returns the SHA-256 JWK thumbprint URI of the key as defined in
+// https://www.rfc-editor.org/rfc/rfc9278.
+func (j JWK) ThumbprintURI() (string, error) {
+	thumbprint, err := j.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return "urn:ietf:params:oauth:jwk-thumbprint:sha-256:" + base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// deriveKID sets the key ID with the KIDStrategy option if the JWK has no key ID.
+func (j *JWK) deriveKID() error {
+	if j.options.KIDStrategy == nil || j.marshal.KID != "" {
+		return nil
+	}
+	kid, err := j.options.KIDStrategy.KID(*j)
+	if err != nil {
+		return fmt.Errorf("failed to derive key ID: %w", err)
+	}
+	j.marshal.KID = kid
+	j.options.Metadata.KID = kid
+	return nil
+}
+
 // Validate validates the JWK. The JWK is automatically validated when created from a function in this package.
 func (j JWK) Validate() error {
 	if j.options.Validate.SkipAll {
 		return nil
 	}
+	if j.options.Validate.StrictBase64 {
+		err := checkStrictBase64(j.marshal)
+		if err != nil {
+			return err
+		}
+	}
 	if !j.marshal.KTY.IANARegistered() {
 		return fmt.Errorf("%w: invalid or unsupported key type %q", ErrJWKValidation, j.marshal.KTY)
 	}
+	if j.marshal.KTY == KtyEC || j.marshal.KTY == KtyOKP {
+		impl, ok := lookupCurve(j.marshal.KTY, j.marshal.CRV)
+		if !ok {
+			return fmt.Errorf("%w: invalid or unsupported curve %q for key type %q", ErrJWKValidation, j.marshal.CRV, j.marshal.KTY)
+		}
+		if impl.Validate != nil {
+			err := impl.Validate(j.key)
+			if err != nil {
+				return fmt.Errorf("key is invalid for curve %q: %w", j.marshal.CRV, errors.Join(ErrJWKValidation, err))
+			}
+		}
+	}
+	if len(j.options.Validate.AllowedAlgs) > 0 {
+		if j.marshal.ALG != "" {
+			if !slices.Contains(j.options.Validate.AllowedAlgs, j.marshal.ALG) {
+				return fmt.Errorf("%w: algorithm %q is not allowed", ErrJWKValidation, j.marshal.ALG)
+			}
+		} else if j.options.Validate.AllowedAlgsInferred {
+			compatible := slices.ContainsFunc(j.options.Validate.AllowedAlgs, func(alg ALG) bool {
+				return alg.compatible(j.marshal.KTY, j.marshal.CRV)
+			})
+			if !compatible {
+				return fmt.Errorf("%w: no allowed algorithm is compatible with key type %q and curve %q", ErrJWKValidation, j.marshal.KTY, j.marshal.CRV)
+			}
+		}
+	}
+	if len(j.options.Validate.AllowedCurves) > 0 && (j.marshal.KTY == KtyEC || j.marshal.KTY == KtyOKP) {
+		if !slices.Contains(j.options.Validate.AllowedCurves, j.marshal.CRV) {
+			return fmt.Errorf("%w: curve %q is not allowed", ErrJWKValidation, j.marshal.CRV)
+		}
+	}
+	if j.options.Validate.MinRSABits > 0 {
+		if pub, ok := publicKey(j.key).(*rsa.PublicKey); ok && pub.N.BitLen() < j.options.Validate.MinRSABits {
+			return fmt.Errorf("%w: RSA key is %d bits, which is less than the minimum of %d bits", ErrJWKValidation, pub.N.BitLen(), j.options.Validate.MinRSABits)
+		}
+	}
+
+	if j.options.Validate.RejectUnknownMembers {
+		names := make([]string, 0, len(j.marshal.Extra))
+		for name := range j.marshal.Extra {
+			if !slices.Contains(j.options.Validate.AllowedMembers, name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			slices.Sort(names)
+			return fmt.Errorf("%w: %q", errors.Join(ErrJWKValidation, ErrUnknownMember), names)
+		}
+	}
 
 	if !j.options.Validate.SkipUse && !j.marshal.USE.IANARegistered() {
 		return fmt.Errorf("%w: invalid or unsupported key use %q", ErrJWKValidation, j.marshal.USE)
 	}
 
 	if !j.options.Validate.SkipKeyOps {
-		for _, o := range j.marshal.KEYOPS {
+		for i, o := range j.marshal.KEYOPS {
 			if !o.IANARegistered() {
 				return fmt.Errorf("%w: invalid or unsupported key_opt %q", ErrJWKValidation, o)
 			}
+			if slices.Contains(j.marshal.KEYOPS[:i], o) {
+				return fmt.Errorf("%w: duplicate key_ops value %q", ErrJWKValidation, o)
+			}
+		}
+	}
+
+	if j.options.Validate.CheckKeyOpsUse && j.marshal.USE != "" {
+		for _, o := range j.marshal.KEYOPS {
+			if o.use() != j.marshal.USE {
+				return fmt.Errorf("%w: key_ops value %q is inconsistent with use %q", ErrJWKValidation, o, j.marshal.USE)
+			}
 		}
 	}
 
@@ -256,51 +718,17 @@ func (j JWK) Validate() error {
 		}
 	}
 
-	if len(j.options.X509.X5C) > 0 {
-		cert := j.options.X509.X5C[0]
-		i := cert.PublicKey
-		switch k := j.key.(type) {
-		// ECDH keys are not used to sign certificates.
-		case *ecdsa.PublicKey:
-			pub, ok := i.(*ecdsa.PublicKey)
-			if !ok {
-				return fmt.Errorf("%w: Golang key is type *ecdsa.Public but X.509 public key was of type %T", errors.Join(ErrJWKValidation, ErrX509Mismatch), i)
-			}
-			if !k.Equal(pub) {
-				return fmt.Errorf("%w: Golang *ecdsa.PublicKey does not match the X.509 public key", errors.Join(ErrJWKValidation, ErrX509Mismatch))
-			}
-		case ed25519.PublicKey:
-			pub, ok := i.(ed25519.PublicKey)
-			if !ok {
-				return fmt.Errorf("%w: Golang key is type ed25519.PublicKey but X.509 public key was of type %T", errors.Join(ErrJWKValidation, ErrX509Mismatch), i)
-			}
-			if !bytes.Equal(k, pub) {
-				return fmt.Errorf("%w: Golang ed25519.PublicKey does not match the X.509 public key", errors.Join(ErrJWKValidation, ErrX509Mismatch))
-			}
-		case *rsa.PublicKey:
-			pub, ok := i.(*rsa.PublicKey)
-			if !ok {
-				return fmt.Errorf("%w: Golang key is type *rsa.PublicKey but X.509 public key was of type %T", errors.Join(ErrJWKValidation, ErrX509Mismatch), i)
-			}
-			if !k.Equal(pub) {
-				return fmt.Errorf("%w: Golang *rsa.PublicKey does not match the X.509 public key", errors.Join(ErrJWKValidation, ErrX509Mismatch))
-			}
-		default:
-			return fmt.Errorf("%w: Golang key is type %T, which is not supported, so it cannot be compared to given X.509 certificates", errors.Join(ErrJWKValidation, ErrUnsupportedKey, ErrX509Mismatch), j.key)
-		}
-		if cert.PublicKeyAlgorithm == x509.Ed25519 {
-			if j.marshal.ALG != AlgEdDSA {
-				return fmt.Errorf("%w: ALG in marshal does not match ALG in X.509 certificate", errors.Join(ErrJWKValidation, ErrX509Mismatch))
-			}
+	if j.options.Validate.CheckKeyConsistency {
+		err := checkKeyConsistency(j.key)
+		if err != nil {
+			return fmt.Errorf("public key is not derived from private key: %w", errors.Join(ErrJWKValidation, err))
 		}
-		if j.options.Validate.CheckX509ValidTime {
-			now := time.Now()
-			if now.Before(cert.NotBefore) {
-				return fmt.Errorf("%w: X.509 certificate is not yet valid", ErrJWKValidation)
-			}
-			if now.After(cert.NotAfter) {
-				return fmt.Errorf("%w: X.509 certificate is expired", ErrJWKValidation)
-			}
+	}
+
+	if len(j.options.X509.X5C) > 0 {
+		err := j.validateX5C(j.options.X509.X5C)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -309,19 +737,17 @@ func (j JWK) Validate() error {
 		return fmt.Errorf("failed to marshal JSON Web Key: %w", errors.Join(ErrJWKValidation, err))
 	}
 
-	// Remove automatically computed thumbprints if not set in given JWK.
-	if j.marshal.X5T == "" {
-		marshalled.X5T = ""
-	}
-	if j.marshal.X5TS256 == "" {
-		marshalled.X5TS256 = ""
-	}
-
-	if j.marshal.X5T != marshalled.X5T {
-		return fmt.Errorf("%w: X5T in marshal does not match X5T in marshalled", ErrJWKValidation)
-	}
-	if j.marshal.X5TS256 != marshalled.X5TS256 {
-		return fmt.Errorf("%w: X5TS256 in marshal does not match X5TS256 in marshalled", ErrJWKValidation)
+	// The thumbprints of a chain only referenced by x5u are compared after it has been fetched.
+	x5uOnly := len(j.options.X509.X5C) == 0 && j.marshal.X5U != "" && j.options.Validate.GetX5U != nil
+	if !j.options.Validate.SkipX5T && !x5uOnly {
+		err = cmpThumbprint(j.marshal.X5T, marshalled.X5T)
+		if err != nil {
+			return fmt.Errorf("%w: x5t does not match the SHA-1 thumbprint of the first X.509 certificate", errors.Join(ErrJWKValidation, err))
+		}
+		err = cmpThumbprint(j.marshal.X5TS256, marshalled.X5TS256)
+		if err != nil {
+			return fmt.Errorf("%w: x5t#S256 does not match the SHA-256 thumbprint of the first X.509 certificate", errors.Join(ErrJWKValidation, err))
+		}
 	}
 	if j.marshal.CRV != marshalled.CRV {
 		return fmt.Errorf("%w: CRV in marshal does not match CRV in marshalled", ErrJWKValidation)
@@ -433,22 +859,491 @@ func (j JWK) Validate() error {
 					return fmt.Errorf("%w: the X5C and X5U (remote resource) parameters are not a full or partial match", errors.Join(ErrJWKValidation, ErrOptions))
 				}
 			}
+			if x5uOnly {
+				err = j.validateX5C(certs)
+				if err != nil {
+					return fmt.Errorf("failed to validate X5U certificate chain: %w", err)
+				}
+				if !j.options.Validate.SkipX5T {
+					x5t, x5tS256 := x509Thumbprints(certs[0])
+					err = cmpThumbprint(j.marshal.X5T, x5t)
+					if err != nil {
+						return fmt.Errorf("%w: x5t does not match the SHA-1 thumbprint of the first X5U certificate", errors.Join(ErrJWKValidation, err))
+					}
+					err = cmpThumbprint(j.marshal.X5TS256, x5tS256)
+					if err != nil {
+						return fmt.Errorf("%w: x5t#S256 does not match the SHA-256 thumbprint of the first X5U certificate", errors.Join(ErrJWKValidation, err))
+					}
+				}
+			}
 		}
 	}
 
 	return nil
 }
 
+// Clone returns a deep copy of the JWK that does not share slices, maps, or cryptographic keys with the original, so
+// either can be mutated without affecting the other, such as a key read from a Storage. RSA, ECDSA, Ed25519, and
+// symmetric keys are copied, including the big.Int components. X.509 certificates, ECDH keys, and the crypto.Signer of
+// a JWK created by NewJWKFromSigner are shared, because they cannot be mutated through the JWK.
+func (j JWK) Clone() JWK {
+	j = j.clone()
+	j.key = cloneKey(j.key)
+	return j
+}
+
+// Destroy zeroes the private key material of the JWK in memory and removes it from the JWK, so the JWK only has its
+// public key, such as when a key is retired or a symmetric secret is no longer needed. The RSA private exponent, prime
+// factors, and precomputed values, the ECDSA private scalar, the Ed25519 seed, and the symmetric key bytes are zeroed.
+// Because the memory is shared, other JWKs with the same key, such as copies read from MemoryStorage before, can no
+// longer sign with it.
+//
+// This is defense in depth with limited guarantees. The Go runtime may have copied the key material, such as when a
+// slice grew or the garbage collector moved memory, and copies made by this package and the standard library are not
+// zeroed, such as the base64url encoded private members of the JWK, which are only dropped because strings are
+// immutable, the internal values of an rsa.PrivateKey, symmetric keys cloned by MemoryStorage, and ECDH keys, which
+// cannot be zeroed through their API. The crypto.Signer of a JWK created by NewJWKFromSigner is only dropped.
+func (j *JWK) Destroy() {
+	zeroInt := func(i *big.Int) {
+		if i != nil {
+			clear(i.Bits())
+			i.SetInt64(0)
+		}
+	}
+	switch k := j.key.(type) {
+	case *rsa.PrivateKey:
+		zeroInt(k.D)
+		for _, prime := range k.Primes {
+			zeroInt(prime)
+		}
+		zeroInt(k.Precomputed.Dp)
+		zeroInt(k.Precomputed.Dq)
+		zeroInt(k.Precomputed.Qinv)
+		for _, crt := range k.Precomputed.CRTValues {
+			zeroInt(crt.Exp)
+			zeroInt(crt.Coeff)
+			zeroInt(crt.R)
+		}
+		j.key = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		zeroInt(k.D)
+		j.key = &k.PublicKey
+	case ed25519.PrivateKey:
+		public := k.Public()
+		clear(k)
+		j.key = public
+	case *ecdh.PrivateKey:
+		j.key = k.PublicKey()
+	case []byte:
+		clear(k)
+		j.key = nil
+	}
+	j.signer = nil
+	j.marshal.D = ""
+	j.marshal.DP = ""
+	j.marshal.DQ = ""
+	j.marshal.K = ""
+	j.marshal.OTH = nil
+	j.marshal.P = ""
+	j.marshal.Q = ""
+	j.marshal.QI = ""
+	j.options.Marshal.Private = false
+}
+
+// cloneKey returns a deep copy of the cryptographic key. Immutable keys, such as ECDH keys, are returned as is.
+func cloneKey(key any) any {
+	cloneInt := func(i *big.Int) *big.Int {
+		if i == nil {
+			return nil
+		}
+		return new(big.Int).Set(i)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		c := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: cloneInt(k.N), E: k.E},
+			D:         cloneInt(k.D),
+			Primes:    make([]*big.Int, len(k.Primes)),
+		}
+		for i, prime := range k.Primes {
+			c.Primes[i] = cloneInt(prime)
+		}
+		if k.Precomputed.Dp != nil {
+			c.Precompute()
+		}
+		return c
+	case *rsa.PublicKey:
+		return &rsa.PublicKey{N: cloneInt(k.N), E: k.E}
+	case *ecdsa.PrivateKey:
+		return &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: k.Curve, X: cloneInt(k.X), Y: cloneInt(k.Y)},
+			D:         cloneInt(k.D),
+		}
+	case *ecdsa.PublicKey:
+		return &ecdsa.PublicKey{Curve: k.Curve, X: cloneInt(k.X), Y: cloneInt(k.Y)}
+	case ed25519.PrivateKey:
+		return slices.Clone(k)
+	case ed25519.PublicKey:
+		return slices.Clone(k)
+	case []byte:
+		return slices.Clone(k)
+	}
+	return key
+}
+
+// clone returns a copy of the JWK that does not share slices or maps with the original. Cryptographic keys other than
+// symmetric keys are shared because they are not mutated by this package.
+func (j JWK) clone() JWK {
+	if key, ok := j.key.([]byte); ok {
+		j.key = slices.Clone(key)
+	}
+	j.marshal.KEYOPS = slices.Clone(j.marshal.KEYOPS)
+	j.marshal.OTH = slices.Clone(j.marshal.OTH)
+	j.marshal.X5C = slices.Clone(j.marshal.X5C)
+	if j.marshal.Extra != nil {
+		extra := make(map[string]json.RawMessage, len(j.marshal.Extra))
+		for name, raw := range j.marshal.Extra {
+			extra[name] = slices.Clone(raw)
+		}
+		j.marshal.Extra = extra
+	}
+	j.options.Metadata.KEYOPS = slices.Clone(j.options.Metadata.KEYOPS)
+	j.options.Validate.AllowedMembers = slices.Clone(j.options.Validate.AllowedMembers)
+	j.options.X509.X5C = slices.Clone(j.options.X509.X5C)
+	return j
+}
+
+// normalizeKey converts key types that are commonly passed by mistake, such as an rsa.PrivateKey instead of an
+// *rsa.PrivateKey, to the key types used by JWK. It returns an error for nil keys.
+func normalizeKey(key any) (any, error) {
+	switch k := key.(type) {
+	case nil:
+		return nil, fmt.Errorf("%w: key is nil", ErrUnsupportedKey)
+	case rsa.PrivateKey:
+		return &k, nil
+	case rsa.PublicKey:
+		return &k, nil
+	case ecdsa.PrivateKey:
+		return &k, nil
+	case ecdsa.PublicKey:
+		return &k, nil
+	case *ed25519.PrivateKey:
+		if k != nil {
+			return *k, nil
+		}
+	case *ed25519.PublicKey:
+		if k != nil {
+			return *k, nil
+		}
+	case *rsa.PrivateKey:
+		if k != nil {
+			return k, nil
+		}
+	case *rsa.PublicKey:
+		if k != nil {
+			return k, nil
+		}
+	case *ecdsa.PrivateKey:
+		if k != nil {
+			return k, nil
+		}
+	case *ecdsa.PublicKey:
+		if k != nil {
+			return k, nil
+		}
+	case *ecdh.PrivateKey:
+		if k != nil {
+			return k, nil
+		}
+	case *ecdh.PublicKey:
+		if k != nil {
+			return k, nil
+		}
+	default:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: key is a nil %T", ErrUnsupportedKey, key)
+}
+
+// isPrivateKey reports whether the given normalized key has private key material. Symmetric keys are always private.
+func isPrivateKey(key any) bool {
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey:
+		return false
+	}
+	return true
+}
+
+// defaultKeyOps infers the key operations (key_ops) for the given key from the use and alg parameters of the metadata.
+// A public key is limited to the operations that do not need private key material, which is none for key agreement.
+// It returns nil if the key operations cannot be inferred.
+func defaultKeyOps(metadata JWKMetadataOptions, key any, private bool) []KEYOPS {
+	use := metadata.USE
+	if use == "" {
+		switch {
+		case slices.Contains(signatureAlgs, metadata.ALG):
+			use = UseSig
+		case slices.Contains(encryptionAlgs, metadata.ALG):
+			use = UseEnc
+		default:
+			return nil
+		}
+	}
+	var privateOps, publicOps []KEYOPS
+	switch use {
+	case UseSig:
+		privateOps, publicOps = []KEYOPS{KeyOpsSign, KeyOpsVerify}, []KEYOPS{KeyOpsVerify}
+	case UseEnc:
+		switch metadata.ALG {
+		case AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW:
+			privateOps = []KEYOPS{KeyOpsDeriveKey, KeyOpsDeriveBits}
+		case AlgDir, AlgA128CBCHS256, AlgA192CBCHS384, AlgA256CBCHS512, AlgA128GCM, AlgA192GCM, AlgA256GCM:
+			privateOps, publicOps = []KEYOPS{KeyOpsEncrypt, KeyOpsDecrypt}, []KEYOPS{KeyOpsEncrypt}
+		case "":
+			switch key.(type) {
+			case *ecdsa.PrivateKey, *ecdsa.PublicKey, *ecdh.PrivateKey, *ecdh.PublicKey:
+				privateOps = []KEYOPS{KeyOpsDeriveKey, KeyOpsDeriveBits}
+			default:
+				privateOps, publicOps = []KEYOPS{KeyOpsEncrypt, KeyOpsDecrypt}, []KEYOPS{KeyOpsEncrypt}
+			}
+		default:
+			privateOps, publicOps = []KEYOPS{KeyOpsWrapKey, KeyOpsUnwrapKey}, []KEYOPS{KeyOpsWrapKey}
+		}
+	}
+	if private {
+		return privateOps
+	}
+	return publicOps
+}
+
+// publicKey returns the public key of the given private key. Any other key is returned as is.
+func publicKey(key any) any {
+	switch k := key.(type) {
+	case *ecdh.PrivateKey:
+		return k.PublicKey()
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	}
+	return key
+}
+
+// checkKeyConsistency recomputes the public components of the given private key and compares them to the public
+// components of the key. Public keys and private keys that always derive their public key are not checked.
+func checkKeyConsistency(key any) error {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k.D.Sign() <= 0 || k.D.Cmp(k.Curve.Params().N) >= 0 {
+			return errors.New("EC private key is out of range")
+		}
+		x, y := k.Curve.ScalarBaseMult(k.D.Bytes())
+		if x.Cmp(k.X) != 0 || y.Cmp(k.Y) != 0 {
+			return errors.New("EC public point does not match the private key")
+		}
+	case ed25519.PrivateKey:
+		if !ed25519.NewKeyFromSeed(k.Seed()).Equal(k) {
+			return errors.New("Ed25519 public key does not match the private key")
+		}
+	case *rsa.PrivateKey:
+		err := k.Validate()
+		if err != nil {
+			return err
+		}
+		if len(k.Primes) < 2 || k.Precomputed.Dp == nil || k.Precomputed.Dq == nil || k.Precomputed.Qinv == nil {
+			return nil
+		}
+		one := big.NewInt(1)
+		p, q := k.Primes[0], k.Primes[1]
+		if new(big.Int).Mod(k.D, new(big.Int).Sub(p, one)).Cmp(k.Precomputed.Dp) != 0 {
+			return errors.New("RSA dp does not match the private key")
+		}
+		if new(big.Int).Mod(k.D, new(big.Int).Sub(q, one)).Cmp(k.Precomputed.Dq) != 0 {
+			return errors.New("RSA dq does not match the private key")
+		}
+		if new(big.Int).Mod(new(big.Int).Mul(q, k.Precomputed.Qinv), p).Cmp(one) != 0 {
+			return errors.New("RSA qi does not match the private key")
+		}
+	}
+	return nil
+}
+
+// validateX5C validates the given X.509 certificate chain against the JWK. The chain is either embedded in the JWK (x5c)
+// or fetched from the X.509 URL (x5u).
+func (j JWK) validateX5C(certs []*x509.Certificate) error {
+	cert := certs[0]
+	i := cert.PublicKey
+	switch k := publicKey(j.key).(type) {
+	// ECDH keys are not used to sign certificates.
+	case *ecdsa.PublicKey:
+		pub, ok := i.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: Golang key is type *ecdsa.Public but X.509 public key was of type %T", errors.Join(ErrJWKValidation, ErrX509Mismatch), i)
+		}
+		if !k.Equal(pub) {
+			return fmt.Errorf("%w: Golang *ecdsa.PublicKey does not match the X.509 public key", errors.Join(ErrJWKValidation, ErrX509Mismatch))
+		}
+	case ed25519.PublicKey:
+		pub, ok := i.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: Golang key is type ed25519.PublicKey but X.509 public key was of type %T", errors.Join(ErrJWKValidation, ErrX509Mismatch), i)
+		}
+		if !bytes.Equal(k, pub) {
+			return fmt.Errorf("%w: Golang ed25519.PublicKey does not match the X.509 public key", errors.Join(ErrJWKValidation, ErrX509Mismatch))
+		}
+	case *rsa.PublicKey:
+		pub, ok := i.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: Golang key is type *rsa.PublicKey but X.509 public key was of type %T", errors.Join(ErrJWKValidation, ErrX509Mismatch), i)
+		}
+		if !k.Equal(pub) {
+			return fmt.Errorf("%w: Golang *rsa.PublicKey does not match the X.509 public key", errors.Join(ErrJWKValidation, ErrX509Mismatch))
+		}
+	default:
+		return fmt.Errorf("%w: Golang key is type %T, which is not supported, so it cannot be compared to given X.509 certificates", errors.Join(ErrJWKValidation, ErrUnsupportedKey, ErrX509Mismatch), j.key)
+	}
+	if cert.PublicKeyAlgorithm == x509.Ed25519 {
+		if j.marshal.ALG != AlgEdDSA {
+			return fmt.Errorf("%w: ALG in marshal does not match ALG in X.509 certificate", errors.Join(ErrJWKValidation, ErrX509Mismatch))
+		}
+	}
+	if j.options.Validate.CheckX509ValidTime {
+		now := time.Now()
+		if j.options.Validate.Clock != nil {
+			now = j.options.Validate.Clock()
+		}
+		skew := j.options.Validate.X509ClockSkew
+		if now.Add(skew).Before(cert.NotBefore) {
+			return fmt.Errorf("%w: X.509 certificate %q is not valid until %s", ErrJWKValidation, cert.Subject.String(), cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.Add(-skew).After(cert.NotAfter) {
+			return fmt.Errorf("%w: X.509 certificate %q expired at %s", ErrJWKValidation, cert.Subject.String(), cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+	if j.options.Validate.X509VerifyOptions != nil {
+		err := verifyX509Chain(certs, *j.options.Validate.X509VerifyOptions)
+		if err != nil {
+			return fmt.Errorf("failed to verify X.509 certificate chain: %w", errors.Join(ErrJWKValidation, err))
+		}
+	}
+	return nil
+}
+
 // DefaultGetX5U is the default implementation of the GetX5U field for JWKValidateOptions.
 func DefaultGetX5U(u *url.URL) ([]*x509.Certificate, error) {
 	timeout := time.Minute
 	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, fmt.Errorf("%w: timeout of %s reached", ErrGetX5U, timeout.String()))
 	defer cancel()
+	return getX5U(ctx, http.DefaultClient, nil, DefaultUserAgent, nil, u)
+}
+
+// GetX5UOptions are used to configure the behavior of NewGetX5U.
+type GetX5UOptions struct {
+	// CacheTTL is the duration a fetched X.509 certificate chain is cached for, keyed by the X5U URI. Failed requests
+	// are not cached. Expired entries are removed when a new entry is cached. A negative value disables caching.
+	//
+	// This defaults to time.Hour.
+	CacheTTL time.Duration
+	// Client is the HTTP client to use for requests. Provide the same client used for JWK Set requests to reuse its
+	// configuration.
+	//
+	// This defaults to http.DefaultClient.
+	Client *http.Client
+	// Header is added to each HTTP request, such as an Authorization header for a protected endpoint.
+	Header http.Header
+	// RateLimiter is waited on before each HTTP request, if it is not nil. It can be shared with other rate limited
+	// operations, such as the RefreshUnknownKID option of HTTPClientOptions.
+	RateLimiter *rate.Limiter
+	// RequestAuthorizer is called with each HTTP request before it is sent, if it is not nil. See the option of the same
+	// name in HTTPClientStorageOptions.
+	RequestAuthorizer func(ctx context.Context, req *http.Request) error
+	// Timeout is the timeout for waiting on the rate limiter and performing the HTTP request.
+	//
+	// This defaults to time.Minute.
+	Timeout time.Duration
+	// UserAgent is the User-Agent header of each HTTP request. A User-Agent in Header takes precedence.
+	//
+	// This defaults to DefaultUserAgent.
+	UserAgent string
+}
+
+type x5uCacheEntry struct {
+	certs   []*x509.Certificate
+	expires time.Time
+}
+
+// NewGetX5U creates an implementation of the GetX5U field for JWKValidateOptions with the given options. Fetching X.509
+// certificate chains is opt-in, because the X5U URI is controlled by the JWK provider.
+func NewGetX5U(options GetX5UOptions) func(x5u *url.URL) ([]*x509.Certificate, error) {
+	if options.CacheTTL == 0 {
+		options.CacheTTL = time.Hour
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.Timeout == 0 {
+		options.Timeout = time.Minute
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
+	var mux sync.Mutex
+	cache := make(map[string]x5uCacheEntry)
+	return func(u *url.URL) ([]*x509.Certificate, error) {
+		key := u.String()
+		if options.CacheTTL > 0 {
+			mux.Lock()
+			entry, ok := cache[key]
+			mux.Unlock()
+			if ok && time.Now().Before(entry.expires) {
+				return slices.Clone(entry.certs), nil
+			}
+		}
+		ctx, cancel := context.WithTimeoutCause(context.Background(), options.Timeout, fmt.Errorf("%w: timeout of %s reached", ErrGetX5U, options.Timeout.String()))
+		defer cancel()
+		if options.RateLimiter != nil {
+			err := options.RateLimiter.Wait(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to wait for X5U rate limiter: %w", errors.Join(ErrGetX5U, err))
+			}
+		}
+		certs, err := getX5U(ctx, options.Client, options.Header, options.UserAgent, options.RequestAuthorizer, u)
+		if err != nil {
+			return nil, err
+		}
+		if options.CacheTTL > 0 {
+			mux.Lock()
+			now := time.Now()
+			for k, entry := range cache {
+				if !now.Before(entry.expires) {
+					delete(cache, k)
+				}
+			}
+			cache[key] = x5uCacheEntry{
+				certs:   certs,
+				expires: now.Add(options.CacheTTL),
+			}
+			mux.Unlock()
+		}
+		return slices.Clone(certs), nil
+	}
+}
+
+func getX5U(ctx context.Context, client *http.Client, header http.Header, userAgent string, authorizer func(ctx context.Context, req *http.Request) error, u *url.URL) ([]*x509.Certificate, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create X5U request: %w", errors.Join(ErrGetX5U, err))
 	}
-	resp, err := http.DefaultClient.Do(req)
+	addHeader(req, header, userAgent)
+	if authorizer != nil {
+		err = authorizer(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authorize X5U request: %w", errors.Join(ErrGetX5U, err))
+		}
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to do X5U request: %w", errors.Join(ErrGetX5U, err))
 	}
@@ -467,6 +1362,74 @@ func DefaultGetX5U(u *url.URL) ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
+// cmpThumbprint compares a declared X.509 certificate thumbprint to the computed thumbprint. The declared thumbprint
+// may have trailing padding. An empty declared thumbprint is not compared.
+func cmpThumbprint(declared, computed string) error {
+	if declared == "" || declared == computed {
+		return nil
+	}
+	if computed == "" {
+		return fmt.Errorf("%w: thumbprint %q is present without an X.509 certificate chain", ErrX509Mismatch, declared)
+	}
+	d, err := base64urlTrailingPadding(declared)
+	if err != nil {
+		return fmt.Errorf("failed to Base64 raw URL decode thumbprint %q: %w", declared, err)
+	}
+	c, err := base64.RawURLEncoding.DecodeString(computed)
+	if err != nil {
+		return fmt.Errorf("failed to Base64 raw URL decode computed thumbprint %q: %w", computed, err)
+	}
+	if !bytes.Equal(d, c) {
+		return fmt.Errorf("%w: declared thumbprint %q, computed thumbprint %q", ErrX509Mismatch, declared, computed)
+	}
+	return nil
+}
+
+// checkStrictBase64 checks that each base64url member of the JWK is in the canonical unpadded base64url encoding.
+// https://www.rfc-editor.org/rfc/rfc7515#section-2
+func checkStrictBase64(marshal JWKMarshal) error {
+	type member struct {
+		name  string
+		value string
+	}
+	members := []member{
+		{name: "x5t", value: marshal.X5T},
+		{name: "x5t#S256", value: marshal.X5TS256},
+		{name: "x", value: marshal.X},
+		{name: "y", value: marshal.Y},
+		{name: "d", value: marshal.D},
+		{name: "n", value: marshal.N},
+		{name: "e", value: marshal.E},
+		{name: "p", value: marshal.P},
+		{name: "q", value: marshal.Q},
+		{name: "dp", value: marshal.DP},
+		{name: "dq", value: marshal.DQ},
+		{name: "qi", value: marshal.QI},
+		{name: "k", value: marshal.K},
+	}
+	for i, o := range marshal.OTH {
+		members = append(members,
+			member{name: fmt.Sprintf("oth[%d].r", i), value: o.R},
+			member{name: fmt.Sprintf("oth[%d].d", i), value: o.D},
+			member{name: fmt.Sprintf("oth[%d].t", i), value: o.T},
+		)
+	}
+	for _, m := range members {
+		if m.value == "" {
+			continue
+		}
+		// The decoder ignores line breaks, even in strict mode.
+		if strings.ContainsAny(m.value, "\r\n") {
+			return fmt.Errorf("%w: member %q contains a line break", ErrJWKValidation, m.name)
+		}
+		_, err := base64.RawURLEncoding.Strict().DecodeString(m.value)
+		if err != nil {
+			return fmt.Errorf("member %q is not canonical unpadded base64url: %w", m.name, errors.Join(ErrJWKValidation, err))
+		}
+	}
+	return nil
+}
+
 func cmpBase64Int(first, second string, strictPadding bool) error {
 	if first == second {
 		return nil
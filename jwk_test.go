@@ -1,14 +1,24 @@
 package jwkset
 
 import (
+	"bytes"
 	"context"
+	"crypto"
 	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"math/big"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -36,6 +46,47 @@ func TestNewJWKFromRawJSON(t *testing.T) {
 	}
 }
 
+func TestJWK_Thumbprint(t *testing.T) {
+	// https://www.rfc-editor.org/rfc/rfc7638#section-3.1
+	marshal := JWKMarshal{
+		KTY: KtyRSA,
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		ALG: AlgRS256,
+		KID: "2011-04-29",
+	}
+	jwk, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from marshal. %s", err)
+	}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint. %s", err)
+	}
+	const expected = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	if base64.RawURLEncoding.EncodeToString(thumbprint) != expected {
+		t.Fatalf("Thumbprint does not match RFC 7638 example.")
+	}
+	uri, err := jwk.ThumbprintURI()
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint URI. %s", err)
+	}
+	if uri != "urn:ietf:params:oauth:jwk-thumbprint:sha-256:"+expected {
+		t.Fatalf("Unexpected thumbprint URI %q.", uri)
+	}
+
+	public := newJWK(t, []byte(hmacSecret), JWKOptions{Marshal: JWKMarshalOptions{Private: true}})
+	public.marshal.K = ""
+	_, err = public.Thumbprint(crypto.SHA256)
+	if !errors.Is(err, ErrThumbprint) {
+		t.Fatalf("Expected error for missing required member.\n  Actual: %s\n  Expected: %s", err, ErrThumbprint)
+	}
+	_, err = JWK{}.Thumbprint(crypto.SHA256)
+	if !errors.Is(err, ErrThumbprint) {
+		t.Fatalf("Expected error for empty JWK.\n  Actual: %s\n  Expected: %s", err, ErrThumbprint)
+	}
+}
+
 func TestJSON(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -103,6 +154,819 @@ func TestMissingThumbprint(t *testing.T) {
 	}
 }
 
+func TestThumbprintMismatch(t *testing.T) {
+	block, _ := pem.Decode([]byte(ed25519Cert))
+	cert, err := LoadCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to load certificate. %s", err)
+	}
+	options := JWKOptions{
+		X509: JWKX509Options{
+			X5C: []*x509.Certificate{cert},
+		},
+	}
+	jwk, err := NewJWKFromKey(cert.PublicKey, options)
+	if err != nil {
+		t.Fatalf("Failed to create JWK from key. %s", err)
+	}
+
+	padded := jwk.Marshal()
+	padded.X5TS256 += "="
+	_, err = NewJWKFromMarshal(padded, JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Padded thumbprint should be accepted. %s", err)
+	}
+
+	tampered := jwk.Marshal()
+	tampered.X5T = base64.RawURLEncoding.EncodeToString(make([]byte, 20))
+	_, err = NewJWKFromMarshal(tampered, JWKMarshalOptions{}, JWKValidateOptions{})
+	if !errors.Is(err, ErrX509Mismatch) {
+		t.Fatalf("Expected thumbprint mismatch error.\n  Actual: %s\n  Expected: %s", err, ErrX509Mismatch)
+	}
+	_, err = NewJWKFromMarshal(tampered, JWKMarshalOptions{}, JWKValidateOptions{SkipX5T: true})
+	if err != nil {
+		t.Fatalf("Thumbprint check should be skipped. %s", err)
+	}
+}
+
+func TestRejectUnknownMembers(t *testing.T) {
+	raw := []byte(`{"kty":"OKP","crv":"Ed25519","x":"` + eddsaPublic + `","key_expiry":1700000000,"custom":"value"}`)
+	jwks := []byte(`{"keys":[` + string(raw) + `]}`)
+
+	_, err := NewJWKFromRawJSON(raw, JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Unknown members should be ignored by default. %s", err)
+	}
+
+	validateOptions := JWKValidateOptions{
+		RejectUnknownMembers: true,
+	}
+	_, err = NewJWKFromRawJSON(raw, JWKMarshalOptions{}, validateOptions)
+	if !errors.Is(err, ErrUnknownMember) || !errors.Is(err, ErrJWKValidation) {
+		t.Fatalf("Expected an unknown member error, got %s.", err)
+	}
+	if !strings.Contains(err.Error(), `"custom"`) || !strings.Contains(err.Error(), `"key_expiry"`) {
+		t.Fatalf("Expected the error to name the unknown members, got %s.", err)
+	}
+
+	var set JWKSMarshal
+	err = json.Unmarshal(jwks, &set)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal JWK Set. %s", err)
+	}
+	_, err = NewJWKFromMarshal(set.Keys[0], JWKMarshalOptions{}, validateOptions)
+	if !errors.Is(err, ErrUnknownMember) {
+		t.Fatalf("Expected an unknown member error for a key from a JWK Set, got %s.", err)
+	}
+
+	validateOptions.AllowedMembers = []string{"custom", "key_expiry"}
+	_, err = NewJWKFromRawJSON(raw, JWKMarshalOptions{}, validateOptions)
+	if err != nil {
+		t.Fatalf("Allowed members should not be rejected. %s", err)
+	}
+}
+
+func TestJWKExtra(t *testing.T) {
+	ctx := context.Background()
+	raw := []byte(`{"kty":"OKP","crv":"Ed25519","x":"` + eddsaPublic + `","kid":"` + myKeyID + `","key_expiry":1700000000,"meta":{"env":"prod"}}`)
+
+	jwk, err := NewJWKFromRawJSON(raw, JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from raw JSON. %s", err)
+	}
+	expiry, ok := jwk.Extra("key_expiry")
+	if !ok || string(expiry) != "1700000000" {
+		t.Fatalf("Expected key_expiry member, got %q.", expiry)
+	}
+	_, ok = jwk.Extra("kty")
+	if ok {
+		t.Fatalf("Defined members should not be reported as extra.")
+	}
+
+	store := NewMemoryStorage()
+	err = store.KeyWrite(ctx, jwk)
+	if err != nil {
+		t.Fatalf("Failed to write JWK. %s", err)
+	}
+	jwksJSON, err := store.JSON(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set JSON. %s", err)
+	}
+	var set JWKSMarshal
+	err = json.Unmarshal(jwksJSON, &set)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal JWK Set. %s", err)
+	}
+	if len(set.Keys) != 1 {
+		t.Fatalf("Expected 1 key, got %d.", len(set.Keys))
+	}
+	if string(set.Keys[0].Extra["key_expiry"]) != "1700000000" || string(set.Keys[0].Extra["meta"]) != `{"env":"prod"}` {
+		t.Fatalf("Expected extra members to survive a round trip, got %s.", jwksJSON)
+	}
+
+	marshal := JWKMarshal{
+		KTY:   KtyOKP,
+		Extra: map[string]json.RawMessage{"kty": json.RawMessage(`"EC"`), "b": json.RawMessage(`2`), "a": json.RawMessage(`1`)},
+	}
+	data, err := json.Marshal(marshal)
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK. %s", err)
+	}
+	if string(data) != `{"kty":"OKP","a":1,"b":2}` {
+		t.Fatalf("Unexpected JSON %s.", data)
+	}
+}
+
+func TestCheckKeyOpsUse(t *testing.T) {
+	raw := func(use USE, keyOps ...KEYOPS) []byte {
+		marshal := JWKMarshal{
+			CRV:    CrvEd25519,
+			KEYOPS: keyOps,
+			KTY:    KtyOKP,
+			USE:    use,
+			X:      eddsaPublic,
+		}
+		data, err := json.Marshal(marshal)
+		if err != nil {
+			t.Fatalf("Failed to marshal JWK. %s", err)
+		}
+		return data
+	}
+	validateOptions := JWKValidateOptions{
+		CheckKeyOpsUse: true,
+	}
+
+	_, err := NewJWKFromRawJSON(raw(UseSig, KeyOpsVerify, KeyOpsVerify), JWKMarshalOptions{}, JWKValidateOptions{})
+	if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), `"verify"`) {
+		t.Fatalf("Expected a duplicate key_ops error, got %s.", err)
+	}
+
+	_, err = NewJWKFromRawJSON(raw(UseSig, KeyOpsEncrypt), JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Inconsistent use and key_ops should be allowed by default. %s", err)
+	}
+	_, err = NewJWKFromRawJSON(raw(UseSig, KeyOpsEncrypt), JWKMarshalOptions{}, validateOptions)
+	if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), `"encrypt"`) || !strings.Contains(err.Error(), `"sig"`) {
+		t.Fatalf("Expected an inconsistent use and key_ops error, got %s.", err)
+	}
+	_, err = NewJWKFromRawJSON(raw(UseEnc, KeyOpsSign), JWKMarshalOptions{}, validateOptions)
+	if !errors.Is(err, ErrJWKValidation) {
+		t.Fatalf("Expected an inconsistent use and key_ops error, got %s.", err)
+	}
+
+	for _, data := range [][]byte{
+		raw(UseSig, KeyOpsSign, KeyOpsVerify),
+		raw(UseEnc, KeyOpsWrapKey, KeyOpsUnwrapKey, KeyOpsDeriveBits),
+		raw("", KeyOpsSign, KeyOpsEncrypt),
+	} {
+		_, err = NewJWKFromRawJSON(data, JWKMarshalOptions{}, validateOptions)
+		if err != nil {
+			t.Fatalf("Failed to validate consistent JWK %s. %s", data, err)
+		}
+	}
+}
+
+func TestCheckKeyConsistency(t *testing.T) {
+	marshalOptions := JWKMarshalOptions{Private: true}
+	validateOptions := JWKValidateOptions{CheckKeyConsistency: true}
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key. %s", err)
+	}
+	_, otherEdDSA, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key. %s", err)
+	}
+	otherEdDSAJWK := newJWK(t, otherEdDSA, JWKOptions{Marshal: marshalOptions})
+
+	testCases := []struct {
+		name   string
+		key    any
+		tamper func(m *JWKMarshal)
+	}{
+		{
+			name: "EC",
+			key:  makeECDSAP256(t),
+			tamper: func(m *JWKMarshal) {
+				m.D = bigIntToBase64RawURL(otherEC.D, 32)
+			},
+		},
+		{
+			name: "Ed25519",
+			key:  makeEdDSA(t),
+			tamper: func(m *JWKMarshal) {
+				m.X = otherEdDSAJWK.Marshal().X
+			},
+		},
+		{
+			name: "RSA",
+			key:  makeRSA(t),
+			tamper: func(m *JWKMarshal) {
+				m.DP = m.DQ
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			marshal := newJWK(t, tc.key, JWKOptions{Marshal: marshalOptions}).Marshal()
+			_, err := NewJWKFromMarshal(marshal, marshalOptions, validateOptions)
+			if err != nil {
+				t.Fatalf("Failed to validate consistent key. %s", err)
+			}
+			tc.tamper(&marshal)
+			_, err = NewJWKFromMarshal(marshal, marshalOptions, JWKValidateOptions{})
+			if err != nil {
+				t.Fatalf("Inconsistent keys should not be checked by default. %s", err)
+			}
+			_, err = NewJWKFromMarshal(marshal, marshalOptions, validateOptions)
+			if !errors.Is(err, ErrJWKValidation) {
+				t.Fatalf("Expected a key consistency error, got %v.", err)
+			}
+		})
+	}
+}
+
+func TestKeyPolicy(t *testing.T) {
+	rsaKey := makeRSA(t)
+	_, err := NewJWKFromKey(rsaKey, JWKOptions{Validate: JWKValidateOptions{MinRSABits: 3072}})
+	if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), "2048 bits") {
+		t.Fatalf("Expected a small RSA key to be rejected with its size, got %v.", err)
+	}
+	_, err = NewJWKFromKey(&rsaKey.PublicKey, JWKOptions{Validate: JWKValidateOptions{MinRSABits: 2048}})
+	if err != nil {
+		t.Fatalf("Failed to accept an RSA key of the minimum size. %s", err)
+	}
+
+	validateOptions := JWKValidateOptions{
+		AllowedCurves: []CRV{CrvP384, CrvEd25519},
+	}
+	_, err = NewJWKFromKey(makeECDSAP256(t), JWKOptions{Validate: validateOptions})
+	if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), CrvP256.String()) {
+		t.Fatalf("Expected a disallowed curve to be rejected, got %v.", err)
+	}
+	for _, key := range []any{makeECDSAP384(t), makeEdDSA(t), rsaKey} {
+		_, err = NewJWKFromKey(key, JWKOptions{Validate: validateOptions})
+		if err != nil {
+			t.Fatalf("Failed to accept a key of type %T. %s", key, err)
+		}
+	}
+}
+
+func TestAllowedAlgs(t *testing.T) {
+	validateOptions := JWKValidateOptions{
+		AllowedAlgs: []ALG{AlgES256, AlgRS256},
+	}
+	raw := []byte(`{"kty":"OKP","crv":"Ed25519","alg":"none","x":"` + eddsaPublic + `"}`)
+	_, err := NewJWKFromRawJSON(raw, JWKMarshalOptions{}, validateOptions)
+	if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), `"none"`) {
+		t.Fatalf("Expected a disallowed algorithm to be rejected, got %v.", err)
+	}
+
+	options := JWKOptions{
+		Metadata: JWKMetadataOptions{ALG: AlgES256},
+		Validate: validateOptions,
+	}
+	_, err = NewJWKFromKey(makeECDSAP256(t), options)
+	if err != nil {
+		t.Fatalf("Failed to accept an allowed algorithm. %s", err)
+	}
+
+	options = JWKOptions{
+		Validate: validateOptions,
+	}
+	_, err = NewJWKFromKey(makeECDSAP384(t), options)
+	if err != nil {
+		t.Fatalf("Keys without an algorithm should be accepted by default. %s", err)
+	}
+	options.Validate.AllowedAlgsInferred = true
+	_, err = NewJWKFromKey(makeECDSAP384(t), options)
+	if !errors.Is(err, ErrJWKValidation) {
+		t.Fatalf("Expected a key incompatible with the allowed algorithms to be rejected, got %v.", err)
+	}
+	_, err = NewJWKFromKey(makeRSA(t), options)
+	if err != nil {
+		t.Fatalf("Failed to accept a key compatible with an allowed algorithm. %s", err)
+	}
+}
+
+func TestX509ClockSkew(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key. %s", err)
+	}
+	makeCert := func(notBefore, notAfter time.Time) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "rotate me"},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("Failed to create certificate. %s", err)
+		}
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			t.Fatalf("Failed to parse certificate. %s", err)
+		}
+		return cert
+	}
+	now := time.Now()
+	testCases := []struct {
+		name string
+		cert *x509.Certificate
+	}{
+		{name: "Expired", cert: makeCert(now.Add(-time.Hour), now.Add(-time.Minute))},
+		{name: "NotYetValid", cert: makeCert(now.Add(time.Minute), now.Add(time.Hour))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := JWKOptions{
+				Validate: JWKValidateOptions{
+					CheckX509ValidTime: true,
+				},
+				X509: JWKX509Options{
+					X5C: []*x509.Certificate{tc.cert},
+				},
+			}
+			_, err := NewJWKFromKey(key, options)
+			if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), "rotate me") {
+				t.Fatalf("Expected an invalid certificate time naming the subject, got %v.", err)
+			}
+			options.Validate.X509ClockSkew = 5 * time.Minute
+			_, err = NewJWKFromKey(key, options)
+			if err != nil {
+				t.Fatalf("Failed to accept a certificate within the clock skew. %s", err)
+			}
+		})
+	}
+
+	options := JWKOptions{
+		Validate: JWKValidateOptions{
+			CheckX509ValidTime: true,
+			Clock: func() time.Time {
+				return now.Add(-30 * time.Minute)
+			},
+		},
+		X509: JWKX509Options{
+			X5C: []*x509.Certificate{testCases[0].cert},
+		},
+	}
+	_, err = NewJWKFromKey(key, options)
+	if err != nil {
+		t.Fatalf("Failed to accept a certificate that is valid according to the clock. %s", err)
+	}
+}
+
+func TestJWKEqual(t *testing.T) {
+	key := makeEdDSA(t)
+	options := JWKOptions{
+		Metadata: JWKMetadataOptions{
+			KEYOPS: []KEYOPS{KeyOpsSign, KeyOpsVerify},
+			KID:    myKeyID,
+		},
+	}
+	jwk := newJWK(t, key, options)
+	options.Metadata.KEYOPS = []KEYOPS{KeyOpsVerify, KeyOpsSign}
+	options.X509.X5U = "https://example.com/cert.pem"
+	reordered := newJWK(t, key, options)
+	if !jwk.Equal(reordered) {
+		t.Fatalf("Expected JWKs with reordered key operations and different X.509 members to be equal.")
+	}
+
+	marshal := jwk.Marshal()
+	marshal.X += "="
+	padded, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{}, JWKValidateOptions{SkipAll: true})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from padded marshal. %s", err)
+	}
+	if !jwk.Equal(padded) {
+		t.Fatalf("Expected JWKs with and without base64url padding to be equal.")
+	}
+
+	options.Metadata.KID = kidWritten
+	if jwk.Equal(newJWK(t, key, options)) {
+		t.Fatalf("Expected JWKs with different key IDs to not be equal.")
+	}
+	if jwk.Equal(newJWK(t, makeEdDSA(t), JWKOptions{Metadata: JWKMetadataOptions{KID: myKeyID}})) {
+		t.Fatalf("Expected JWKs with different key material to not be equal.")
+	}
+}
+
+func TestJWKPublicPrivateKey(t *testing.T) {
+	ecKey := makeECDSAP256(t)
+	edKey := makeEdDSA(t)
+	rsaKey := makeRSA(t)
+	x25519Key := makeECDHX25519Private(t)
+	for _, tc := range []struct {
+		private crypto.PrivateKey
+		public  crypto.PublicKey
+	}{
+		{private: ecKey, public: &ecKey.PublicKey},
+		{private: edKey, public: edKey.Public()},
+		{private: rsaKey, public: &rsaKey.PublicKey},
+		{private: x25519Key, public: x25519Key.PublicKey()},
+	} {
+		jwk := newJWK(t, tc.private, JWKOptions{})
+		private, err := jwk.PrivateKey()
+		if err != nil {
+			t.Fatalf("Failed to get private key. %s", err)
+		}
+		if !private.(interface{ Equal(crypto.PrivateKey) bool }).Equal(tc.private) {
+			t.Fatalf("Unexpected private key of type %T.", private)
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("Failed to get public key. %s", err)
+		}
+		if !public.(interface{ Equal(crypto.PublicKey) bool }).Equal(tc.public) {
+			t.Fatalf("Unexpected public key of type %T.", public)
+		}
+
+		jwk = newJWK(t, tc.public, JWKOptions{})
+		_, err = jwk.PrivateKey()
+		if !errors.Is(err, ErrNoPrivateKey) {
+			t.Fatalf("Expected ErrNoPrivateKey for a public key of type %T, got %v.", tc.public, err)
+		}
+		public, err = jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("Failed to get public key. %s", err)
+		}
+		if !public.(interface{ Equal(crypto.PublicKey) bool }).Equal(tc.public) {
+			t.Fatalf("Unexpected public key of type %T.", public)
+		}
+	}
+
+	jwk := newStorageTestJWK(t, hmacKey1, myKeyID)
+	private, err := jwk.PrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to get symmetric key. %s", err)
+	}
+	public, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatalf("Failed to get symmetric key. %s", err)
+	}
+	if string(private.([]byte)) != string(hmacKey1) || string(public.([]byte)) != string(hmacKey1) {
+		t.Fatalf("Unexpected symmetric key.")
+	}
+	_, err = JWK{}.PublicKey()
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("Expected ErrUnsupportedKey for an empty JWK, got %v.", err)
+	}
+}
+
+func TestNewJWKFromKeyTypes(t *testing.T) {
+	rsaKey := makeRSA(t)
+	jwk, err := NewJWKFromKey(*rsaKey, JWKOptions{KIDStrategy: KIDThumbprintSHA256})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from an RSA private key value. %s", err)
+	}
+	if _, ok := jwk.Key().(*rsa.PrivateKey); !ok {
+		t.Fatalf("Expected the key to be an *rsa.PrivateKey, got %T.", jwk.Key())
+	}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint. %s", err)
+	}
+	if jwk.Marshal().KID != base64.RawURLEncoding.EncodeToString(thumbprint) || jwk.Marshal().KTY != KtyRSA {
+		t.Fatalf("Unexpected JWK members.")
+	}
+	edKey := makeEdDSA(t)
+	jwk, err = NewJWKFromKey(&edKey, JWKOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create JWK from an Ed25519 key pointer. %s", err)
+	}
+	if jwk.Marshal().CRV != CrvEd25519 {
+		t.Fatalf("Unexpected curve %q.", jwk.Marshal().CRV)
+	}
+
+	for _, key := range []any{nil, (*rsa.PrivateKey)(nil), (*ecdsa.PublicKey)(nil), (*ed25519.PrivateKey)(nil), "key"} {
+		_, err = NewJWKFromKey(key, JWKOptions{})
+		if !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("Expected ErrUnsupportedKey for key of type %T, got %v.", key, err)
+		}
+	}
+}
+
+func TestJWKSupportedAlgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		jwk      JWK
+		expected []ALG
+	}{
+		{name: "EC", jwk: newJWK(t, makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{USE: UseSig}}), expected: []ALG{AlgES256}},
+		{name: "Ed25519", jwk: newJWK(t, makeEdDSA(t), JWKOptions{}), expected: []ALG{AlgEdDSA}},
+		{name: "X25519", jwk: newJWK(t, makeECDHX25519Private(t), JWKOptions{}), expected: []ALG{AlgECDHES, AlgECDHESA128KW, AlgECDHESA192KW, AlgECDHESA256KW}},
+		{name: "RSA", jwk: newJWK(t, makeRSA(t), JWKOptions{Metadata: JWKMetadataOptions{USE: UseSig}}), expected: []ALG{AlgRS256, AlgRS384, AlgRS512, AlgPS256, AlgPS384, AlgPS512}},
+		{name: "RSAEnc", jwk: newJWK(t, makeRSA(t), JWKOptions{Metadata: JWKMetadataOptions{USE: UseEnc}}), expected: []ALG{AlgRSAOAEP256, AlgRSAOAEP384, AlgRSAOAEP512, AlgRSAOAEP, AlgRSA1_5}},
+		{name: "ALG", jwk: newJWK(t, makeRSA(t), JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgPS256}}), expected: []ALG{AlgPS256}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.jwk.SupportedAlgs()
+			if !slices.Equal(actual, tc.expected) {
+				t.Fatalf("Unexpected algorithms.\n  Actual: %v\n  Expected: %v", actual, tc.expected)
+			}
+		})
+	}
+	algs := newStorageTestJWK(t, hmacKey1, myKeyID).SupportedAlgs()
+	if !slices.Contains(algs, AlgHS256) || !slices.Contains(algs, AlgA256GCM) || slices.Contains(algs, AlgRS256) {
+		t.Fatalf("Unexpected algorithms for a symmetric key: %v", algs)
+	}
+}
+
+func TestJWKPublic(t *testing.T) {
+	cert, certKey := makeX509Cert(t, nil, nil, false)
+	testCases := []struct {
+		name    string
+		key     any
+		options JWKOptions
+	}{
+		{name: "RSA", key: makeRSA(t)},
+		{name: "EC", key: makeECDSAP256(t)},
+		{name: "EdDSA", key: makeEdDSA(t)},
+		{name: "X25519", key: makeECDHX25519Private(t)},
+		{name: "X5C", key: certKey, options: JWKOptions{X509: JWKX509Options{X5C: []*x509.Certificate{cert}}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := tc.options
+			options.Marshal.Private = true
+			options.Metadata = JWKMetadataOptions{
+				KEYOPS: []KEYOPS{KeyOpsVerify},
+				KID:    myKeyID,
+				USE:    UseSig,
+			}
+			jwk := newJWK(t, tc.key, options)
+			public, err := jwk.Public()
+			if err != nil {
+				t.Fatalf("Failed to get public JWK. %s", err)
+			}
+			m := public.Marshal()
+			if m.D != "" || m.P != "" || m.Q != "" || m.DP != "" || m.DQ != "" || m.QI != "" || len(m.OTH) != 0 {
+				t.Fatalf("Expected no private members.")
+			}
+			if m.KID != myKeyID || m.USE != UseSig || len(m.KEYOPS) != 1 || len(m.X5C) != len(tc.options.X509.X5C) {
+				t.Fatalf("Expected metadata to be kept.")
+			}
+			_, err = public.PrivateKey()
+			if !errors.Is(err, ErrNoPrivateKey) {
+				t.Fatalf("Expected no private key, got %v.", err)
+			}
+			err = public.Validate()
+			if err != nil {
+				t.Fatalf("Failed to validate public JWK. %s", err)
+			}
+			raw, err := json.Marshal(public.Marshal())
+			if err != nil {
+				t.Fatalf("Failed to marshal public JWK. %s", err)
+			}
+			if strings.Contains(string(raw), `"d":`) {
+				t.Fatalf("Expected no private members in JSON: %s", raw)
+			}
+			if jwk.Marshal().D == "" {
+				t.Fatalf("Expected the original JWK to be unchanged.")
+			}
+		})
+	}
+
+	_, err := newStorageTestJWK(t, hmacKey1, myKeyID).Public()
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("Expected ErrUnsupportedKey for a symmetric key, got %v.", err)
+	}
+}
+
+func TestJWKClone(t *testing.T) {
+	rsaJWK := newJWK(t, makeRSA(t), JWKOptions{
+		Marshal: JWKMarshalOptions{
+			Private: true,
+		},
+		Metadata: JWKMetadataOptions{
+			KEYOPS: []KEYOPS{KeyOpsSign},
+			KID:    myKeyID,
+		},
+	})
+	marshal := rsaJWK.Marshal()
+	marshal.Extra = map[string]json.RawMessage{"meta": json.RawMessage(`{"env":"prod"}`)}
+	original, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{Private: true}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create JWK with extra members. %s", err)
+	}
+	originalJSON, err := json.Marshal(original.Marshal())
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK. %s", err)
+	}
+	originalKey := original.Key().(*rsa.PrivateKey)
+	d, n, prime := new(big.Int).Set(originalKey.D), new(big.Int).Set(originalKey.N), new(big.Int).Set(originalKey.Primes[2])
+
+	clone := original.Clone()
+	m := clone.Marshal()
+	m.KEYOPS[0] = KeyOpsVerify
+	m.OTH[0].R = "mutated"
+	m.Extra["meta"][0] = '['
+	cloneKey := clone.Key().(*rsa.PrivateKey)
+	cloneKey.D.SetInt64(1)
+	cloneKey.N.SetInt64(1)
+	cloneKey.Primes[2].SetInt64(1)
+	cloneKey.E = 3
+
+	afterJSON, err := json.Marshal(original.Marshal())
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK. %s", err)
+	}
+	if !bytes.Equal(originalJSON, afterJSON) {
+		t.Fatalf("Expected the original members to be independent of the clone.")
+	}
+	if originalKey.D.Cmp(d) != 0 || originalKey.N.Cmp(n) != 0 || originalKey.Primes[2].Cmp(prime) != 0 || originalKey.E == 3 {
+		t.Fatalf("Expected the original RSA key to be independent of the clone.")
+	}
+	if original.Clone().Key().(*rsa.PrivateKey).Validate() != nil {
+		t.Fatalf("Expected the cloned RSA key to be valid.")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key. %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &ecKey.PublicKey, ecKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate. %s", err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate. %s", err)
+	}
+	ecJWK := newJWK(t, ecKey, JWKOptions{
+		Marshal: JWKMarshalOptions{
+			Private: true,
+		},
+		X509: JWKX509Options{
+			X5C: []*x509.Certificate{cert},
+		},
+	})
+	ecClone := ecJWK.Clone()
+	ecClone.Key().(*ecdsa.PrivateKey).D.SetInt64(1)
+	ecClone.Key().(*ecdsa.PrivateKey).X.SetInt64(1)
+	ecClone.Marshal().X5C[0] = "mutated"
+	ecClone.X509().X5C[0] = nil
+	if ecKey.D.Cmp(big.NewInt(1)) == 0 || ecKey.X.Cmp(big.NewInt(1)) == 0 {
+		t.Fatalf("Expected the original ECDSA key to be independent of the clone.")
+	}
+	if ecJWK.Marshal().X5C[0] == "mutated" || ecJWK.X509().X5C[0] == nil {
+		t.Fatalf("Expected the original X.509 members to be independent of the clone.")
+	}
+
+	for _, key := range []any{makeEdDSA(t), []byte(hmacSecret)} {
+		jwk := newJWK(t, key, JWKOptions{Marshal: JWKMarshalOptions{Private: true}})
+		c := jwk.Clone()
+		switch k := c.Key().(type) {
+		case ed25519.PrivateKey:
+			k[0] ^= 0xFF
+			if jwk.Key().(ed25519.PrivateKey)[0] == k[0] {
+				t.Fatalf("Expected the original Ed25519 key to be independent of the clone.")
+			}
+		case []byte:
+			k[0] ^= 0xFF
+			if jwk.Key().([]byte)[0] == k[0] {
+				t.Fatalf("Expected the original symmetric key to be independent of the clone.")
+			}
+		}
+	}
+}
+
+func TestJWKDestroy(t *testing.T) {
+	options := JWKOptions{Marshal: JWKMarshalOptions{Private: true}}
+	rsaJWK := newJWK(t, makeRSA(t), options)
+	rsaKey := rsaJWK.Key().(*rsa.PrivateKey)
+	shared := rsaJWK
+	rsaJWK.Destroy()
+	if _, ok := rsaJWK.Key().(*rsa.PublicKey); !ok {
+		t.Fatalf("Expected only the public key to remain, got %T.", rsaJWK.Key())
+	}
+	m := rsaJWK.Marshal()
+	if m.D != "" || m.P != "" || m.Q != "" || m.DP != "" || m.DQ != "" || m.QI != "" || len(m.OTH) != 0 || m.N == "" {
+		t.Fatalf("Expected only the public members to remain.")
+	}
+	if rsaKey.D.Sign() != 0 || rsaKey.Primes[0].Sign() != 0 || rsaKey.Primes[2].Sign() != 0 || rsaKey.Precomputed.Dp.Sign() != 0 {
+		t.Fatalf("Expected the RSA private key material to be zeroed.")
+	}
+	if shared.Key().(*rsa.PrivateKey).D.Sign() != 0 {
+		t.Fatalf("Expected a copy of the JWK to share the zeroed key.")
+	}
+	_, err := rsaJWK.PrivateKey()
+	if !errors.Is(err, ErrNoPrivateKey) {
+		t.Fatalf("Expected ErrNoPrivateKey after Destroy, got %v.", err)
+	}
+
+	ecJWK := newJWK(t, makeECDSAP256(t), options)
+	ecKey := ecJWK.Key().(*ecdsa.PrivateKey)
+	ecJWK.Destroy()
+	if ecKey.D.Sign() != 0 || ecJWK.Marshal().D != "" {
+		t.Fatalf("Expected the ECDSA private key material to be zeroed.")
+	}
+	if _, ok := ecJWK.Key().(*ecdsa.PublicKey); !ok {
+		t.Fatalf("Expected only the public key to remain, got %T.", ecJWK.Key())
+	}
+
+	edJWK := newJWK(t, makeEdDSA(t), options)
+	edKey := edJWK.Key().(ed25519.PrivateKey)
+	public := edKey.Public().(ed25519.PublicKey)
+	edJWK.Destroy()
+	if !bytes.Equal(edKey, make([]byte, len(edKey))) {
+		t.Fatalf("Expected the Ed25519 private key to be zeroed.")
+	}
+	if !public.Equal(edJWK.Key()) {
+		t.Fatalf("Expected the Ed25519 public key to remain.")
+	}
+
+	secret := []byte(hmacSecret)
+	octJWK := newJWK(t, secret, options)
+	octJWK.Destroy()
+	if !bytes.Equal(secret, make([]byte, len(secret))) || octJWK.Marshal().K != "" || octJWK.Key() != nil {
+		t.Fatalf("Expected the symmetric key to be zeroed and removed.")
+	}
+}
+
+// opaqueSigner hides the private key behind crypto.Signer, like a key in a KMS or HSM.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestNewJWKFromSigner(t *testing.T) {
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key. %s", err)
+	}
+	for _, tc := range []struct {
+		alg    ALG
+		signer crypto.Signer
+	}{
+		{alg: AlgES256, signer: makeECDSAP256(t)},
+		{alg: AlgPS256, signer: rsaKey},
+		{alg: AlgEdDSA, signer: makeEdDSA(t)},
+	} {
+		jwk, err := NewJWKFromSigner(opaqueSigner{tc.signer}, JWKOptions{
+			Marshal: JWKMarshalOptions{
+				Private: true,
+			},
+			Metadata: JWKMetadataOptions{
+				KID: myKeyID,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create JWK from %s signer. %s", tc.alg, err)
+		}
+		if jwk.Marshal().D != "" {
+			t.Fatalf("Expected only public members for a signer.")
+		}
+		_, err = jwk.PrivateKey()
+		if !errors.Is(err, ErrNoPrivateKey) {
+			t.Fatalf("Expected ErrNoPrivateKey for a signer, got %v.", err)
+		}
+
+		store := NewMemoryStorage()
+		writeKeys(ctx, t, store, jwk)
+		raw, err := store.JSONPrivate(ctx)
+		if err != nil {
+			t.Fatalf("Failed to marshal JWK Set. %s", err)
+		}
+		if strings.Contains(string(raw), `"d"`) {
+			t.Fatalf("Expected no private key material in %s.", raw)
+		}
+		stored, err := store.KeyRead(ctx, myKeyID)
+		if err != nil {
+			t.Fatalf("Failed to read key. %s", err)
+		}
+		token, err := SignJWKS(ctx, store, stored, tc.alg)
+		if err != nil {
+			t.Fatalf("Failed to sign JWK Set with %s signer. %s", tc.alg, err)
+		}
+		public, err := jwk.Public()
+		if err != nil {
+			t.Fatalf("Failed to get public JWK. %s", err)
+		}
+		_, err = public.Signer()
+		if !errors.Is(err, ErrNoPrivateKey) {
+			t.Fatalf("Expected the public JWK to not have a signer, got %v.", err)
+		}
+		_, err = VerifySignedJWKS(token, public)
+		if err != nil {
+			t.Fatalf("Failed to verify JWK Set signed with %s signer. %s", tc.alg, err)
+		}
+	}
+
+	_, err = NewJWKFromSigner(nil, JWKOptions{})
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("Expected ErrUnsupportedKey for a nil signer, got %v.", err)
+	}
+}
+
 func TestJWK_Validate(t *testing.T) {
 	jwk := JWK{}
 	err := jwk.Validate()
@@ -206,6 +1070,39 @@ func TestJWK_Validate_Padding(t *testing.T) {
 	}
 }
 
+func TestStrictBase64(t *testing.T) {
+	const canonical = "aGVsbG8"
+	for _, tc := range []struct {
+		name string
+		k    string
+		lax  bool
+	}{
+		{name: "line break", k: "aGVs\nbG8", lax: true},
+		{name: "non-zero trailing bits", k: "aGVsbG9", lax: true},
+		{name: "padding", k: canonical + "="},
+	} {
+		marshal := JWKMarshal{KTY: KtyOct, K: tc.k}
+		_, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{Private: true}, JWKValidateOptions{})
+		if tc.lax && err != nil {
+			t.Fatalf("Expected %s to be accepted by default. %s", tc.name, err)
+		}
+		_, err = NewJWKFromMarshal(marshal, JWKMarshalOptions{Private: true}, JWKValidateOptions{StrictBase64: true})
+		if !errors.Is(err, ErrJWKValidation) || !strings.Contains(err.Error(), `"k"`) {
+			t.Fatalf("Expected %s to be rejected with StrictBase64, got %v.", tc.name, err)
+		}
+	}
+
+	_, err := NewJWKFromMarshal(JWKMarshal{KTY: KtyOct, K: canonical}, JWKMarshalOptions{Private: true}, JWKValidateOptions{StrictBase64: true})
+	if err != nil {
+		t.Fatalf("Failed to accept canonical base64url with StrictBase64. %s", err)
+	}
+	rsaJWK := newStorageTestJWK(t, makeRSA(t), myKeyID)
+	_, err = NewJWKFromMarshal(rsaJWK.Marshal(), JWKMarshalOptions{Private: true}, JWKValidateOptions{StrictBase64: true})
+	if err != nil {
+		t.Fatalf("Failed to accept a multi-prime RSA key with StrictBase64. %s", err)
+	}
+}
+
 func testJSON(ctx context.Context, t *testing.T, jwks Storage) {
 	b, err := base64.RawURLEncoding.DecodeString(x25519PrivateKey)
 	if err != nil {
@@ -0,0 +1,147 @@
+// Package jwksettest provides an HTTP server that serves a JWK Set for testing code that uses the jwkset package, such
+// as a client created by jwkset.NewDefaultHTTPClient.
+package jwksettest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+)
+
+// Server is an HTTP server for tests that serves a JWK Set. The served keys can be replaced with SetKeys to simulate
+// key rotation, and failures and delays can be injected. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mux        sync.Mutex
+	body       []byte
+	delay      time.Duration
+	etag       string
+	failStatus int
+	failures   int
+	noETag     bool
+
+	requests atomic.Int64
+}
+
+// NewServer starts a Server that serves the given keys as a JWK Set. The keys are served as given by JWK.Marshal, so
+// use JWK.Public for keys that should be served without private key material. It panics if the keys cannot be
+// marshaled, like httptest.NewServer panics if it cannot listen. The caller should call Close when finished.
+func NewServer(keys []jwkset.JWK) *Server {
+	s := &Server{}
+	err := s.SetKeys(keys)
+	if err != nil {
+		panic(fmt.Sprintf("jwksettest: %s", err))
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewTestJWKSServer starts a Server that serves the given keys as a JWK Set and returns its httptest.Server and a
+// function that closes it. Use NewServer to replace the keys or inject failures and delays.
+func NewTestJWKSServer(keys []jwkset.JWK) (*httptest.Server, func()) {
+	s := NewServer(keys)
+	return s.Server, s.Close
+}
+
+// Requests returns the number of requests the server has received, including failed requests.
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+// SetDelay delays each following response by the given duration, or until the request is canceled. A zero duration
+// removes the delay.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.delay = d
+}
+
+// SetETag enables or disables the ETag header. When enabled, which is the default, each response has an ETag header
+// computed over the JWK Set, and requests with a matching If-None-Match header are answered with
+// http.StatusNotModified.
+func (s *Server) SetETag(enabled bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.noETag = !enabled
+}
+
+// SetFailures makes the next n requests fail with the given HTTP status code, such as
+// http.StatusServiceUnavailable.
+func (s *Server) SetFailures(n int, status int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.failures = n
+	s.failStatus = status
+}
+
+// SetKeys replaces the served keys, such as to simulate key rotation.
+func (s *Server) SetKeys(keys []jwkset.JWK) error {
+	jwks := jwkset.JWKSMarshal{
+		Keys: make([]jwkset.JWKMarshal, 0, len(keys)),
+	}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, key.Marshal())
+	}
+	body, err := json.Marshal(jwks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JWK Set: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.body = body
+	s.etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.mux.Lock()
+	body, delay, etag := s.body, s.delay, s.etag
+	if s.noETag {
+		etag = ""
+	}
+	failStatus := 0
+	if s.failures > 0 {
+		s.failures--
+		failStatus = s.failStatus
+	}
+	s.mux.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	if failStatus != 0 {
+		w.WriteHeader(failStatus)
+		return
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+		for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+			if strings.TrimSpace(candidate) == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", jwkset.ContentTypeJWKSet)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write(body)
+}
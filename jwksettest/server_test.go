@@ -0,0 +1,129 @@
+package jwksettest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+)
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key1 := newKey(t, "key1")
+	key2 := newKey(t, "key2")
+	s := NewServer([]jwkset.JWK{key1})
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	s.SetFailures(1, http.StatusServiceUnavailable)
+	_, err = jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{Ctx: ctx})
+	if !errors.Is(err, jwkset.ErrInvalidHTTPStatusCode) {
+		t.Fatalf("Expected an injected failure, got %v.", err)
+	}
+	store, err := jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{
+		Ctx:                    ctx,
+		UseConditionalRequests: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	_, err = store.KeyRead(ctx, "key1")
+	if err != nil {
+		t.Fatalf("Failed to read served key. %s", err)
+	}
+
+	err = s.SetKeys([]jwkset.JWK{key1, key2})
+	if err != nil {
+		t.Fatalf("Failed to set keys. %s", err)
+	}
+	store, err = jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Expected the rotated keys to be served, got %d keys. %v", len(keys), err)
+	}
+	if s.Requests() != 3 {
+		t.Fatalf("Expected 3 requests, got %d.", s.Requests())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request. %s", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to perform request. %s", err)
+	}
+	_ = resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to perform request. %s", err)
+	}
+	_ = resp.Body.Close()
+	if etag == "" || resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Expected a not modified response for a matching ETag, got %d.", resp.StatusCode)
+	}
+	s.SetETag(false)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to perform request. %s", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != "" {
+		t.Fatalf("Expected no ETag handling when disabled, got %d.", resp.StatusCode)
+	}
+
+	s.SetDelay(time.Second)
+	_, err = jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{
+		Ctx:         ctx,
+		HTTPTimeout: 10 * time.Millisecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the delayed response to time out, got %v.", err)
+	}
+}
+
+func TestNewTestJWKSServer(t *testing.T) {
+	ctx := context.Background()
+	server, closeServer := NewTestJWKSServer([]jwkset.JWK{newKey(t, "key1")})
+	defer closeServer()
+	store, err := jwkset.NewDefaultHTTPClient([]string{server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	_, err = store.KeyRead(ctx, "key1")
+	if err != nil {
+		t.Fatalf("Failed to read served key. %s", err)
+	}
+}
+
+func newKey(t *testing.T, kid string) jwkset.JWK {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key. %s", err)
+	}
+	jwk, err := jwkset.NewJWKFromKey(priv.Public(), jwkset.JWKOptions{
+		Metadata: jwkset.JWKMetadataOptions{
+			KID: kid,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create JWK. %s", err)
+	}
+	return jwk
+}
@@ -0,0 +1,188 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrJWSHeader indicates that a key could not be taken from a JWS header.
+	ErrJWSHeader = errors.New("failed to get key from JWS header")
+	// ErrJKUNotAllowed indicates that the jku parameter of a JWS header is not in the AllowedURLs option.
+	ErrJKUNotAllowed = errors.New("jku is not allowed")
+)
+
+// JWKFromJWSHeader creates a JWK from the jwk parameter of a decoded JWS header.
+// https://www.rfc-editor.org/rfc/rfc7515#section-4.1.3
+//
+// The jwk parameter is chosen by whoever created the JWS, so the returned key is attacker-controlled. A signature that
+// verifies with it proves nothing about the signer, unless a separate trust decision is made for the key, such as
+// comparing its thumbprint to a known value or verifying its X.509 certificate chain. Symmetric keys and keys with
+// private key material are rejected.
+func JWKFromJWSHeader(header map[string]any) (JWK, error) {
+	value, ok := header["jwk"]
+	if !ok {
+		return JWK{}, fmt.Errorf(`%w: no "jwk" parameter`, ErrJWSHeader)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return JWK{}, fmt.Errorf(`failed to marshal "jwk" parameter: %w`, errors.Join(ErrJWSHeader, err))
+	}
+	var marshal JWKMarshal
+	err = json.Unmarshal(raw, &marshal)
+	if err != nil {
+		return JWK{}, fmt.Errorf(`failed to unmarshal "jwk" parameter: %w`, errors.Join(ErrJWSHeader, err))
+	}
+	if marshal.KTY == KtyOct {
+		return JWK{}, fmt.Errorf("%w: symmetric keys are not allowed", ErrJWSHeader)
+	}
+	if marshal.D != "" || marshal.P != "" || marshal.Q != "" || marshal.DP != "" || marshal.DQ != "" || marshal.QI != "" || len(marshal.OTH) != 0 {
+		return JWK{}, fmt.Errorf("%w: private key material is not allowed", ErrJWSHeader)
+	}
+	jwk, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{}, JWKValidateOptions{})
+	if err != nil {
+		return JWK{}, fmt.Errorf(`failed to create JWK from "jwk" parameter: %w`, errors.Join(ErrJWSHeader, err))
+	}
+	return jwk, nil
+}
+
+// JKUResolverOptions are used to configure the behavior of NewJKUResolver.
+type JKUResolverOptions struct {
+	// AllowedURLs are the only jku URLs that are fetched. The jku parameter must exactly match one of them. This is
+	// required.
+	AllowedURLs []string
+	// HTTPOptions are passed to NewStorageFromHTTP for each allowed jku URL, so the same security options, such as
+	// MaxResponseBytes, MaxKeys, and ValidateOptions, apply as for any other remote JWK Set.
+	HTTPOptions HTTPClientStorageOptions
+}
+
+// NewJKUResolver creates a function that returns the key referenced by the jku and kid parameters of a decoded JWS
+// header. https://www.rfc-editor.org/rfc/rfc7515#section-4.1.2
+//
+// The jku parameter is chosen by whoever created the JWS, so only the URLs in the AllowedURLs option are fetched, and
+// the caller is still responsible for deciding that keys from the URL are trusted for the JWS. The JWK Set of each URL
+// is fetched the first time it is referenced, and the Storage is reused afterward. If the header has no kid parameter,
+// the JWK Set must contain exactly one key.
+func NewJKUResolver(options JKUResolverOptions) (func(ctx context.Context, header map[string]any) (JWK, error), error) {
+	if len(options.AllowedURLs) == 0 {
+		return nil, fmt.Errorf("%w: AllowedURLs is required", ErrOptions)
+	}
+	var mux sync.Mutex
+	stores := make(map[string]Storage)
+	return func(ctx context.Context, header map[string]any) (JWK, error) {
+		jku, _ := header["jku"].(string)
+		if jku == "" {
+			return JWK{}, fmt.Errorf(`%w: no "jku" parameter`, ErrJWSHeader)
+		}
+		if !slices.Contains(options.AllowedURLs, jku) {
+			return JWK{}, fmt.Errorf("%w: %q", errors.Join(ErrJWSHeader, ErrJKUNotAllowed), jku)
+		}
+
+		mux.Lock()
+		store, ok := stores[jku]
+		if !ok {
+			u, err := url.ParseRequestURI(jku)
+			if err != nil {
+				mux.Unlock()
+				return JWK{}, fmt.Errorf("failed to parse jku %q: %w", jku, errors.Join(ErrJWSHeader, err))
+			}
+			store, err = NewStorageFromHTTP(u, options.HTTPOptions)
+			if err != nil {
+				mux.Unlock()
+				return JWK{}, fmt.Errorf("failed to get JWK Set from jku %q: %w", jku, errors.Join(ErrJWSHeader, err))
+			}
+			stores[jku] = store
+		}
+		mux.Unlock()
+
+		kid, _ := header["kid"].(string)
+		if kid != "" {
+			jwk, err := store.KeyRead(ctx, kid)
+			if err != nil {
+				return JWK{}, fmt.Errorf("failed to read key from jku %q: %w", jku, errors.Join(ErrJWSHeader, err))
+			}
+			return jwk, nil
+		}
+		keys, err := store.KeyReadAll(ctx)
+		if err != nil {
+			return JWK{}, fmt.Errorf("failed to read keys from jku %q: %w", jku, errors.Join(ErrJWSHeader, err))
+		}
+		if len(keys) != 1 {
+			return JWK{}, fmt.Errorf(`%w: no "kid" parameter and the JWK Set from jku %q has %d keys`, ErrJWSHeader, jku, len(keys))
+		}
+		return keys[0], nil
+	}, nil
+}
+
+// JWSHeader holds the parameters of a decoded JWS header that are used to choose the key to verify the JWS with.
+// https://www.rfc-editor.org/rfc/rfc7515#section-4.1
+type JWSHeader struct {
+	// ALG is the algorithm (alg) the JWS was signed with.
+	ALG ALG `json:"alg,omitempty"`
+	// KID is the key ID (kid) of the key the JWS was signed with.
+	KID string `json:"kid,omitempty"`
+}
+
+// KeysForJWSHeader returns the candidate keys in the storage for verifying a JWS with the given header, in best match
+// order. If the header has a key ID, only the key with that key ID is returned, and it is read with KeyRead, so a
+// Storage created with the RefreshUnknownKID option refreshes for a key ID it does not have. Otherwise, the keys that
+// declare the alg parameter of the header are returned, followed by the keys that do not declare an algorithm but
+// have a compatible key type and curve.
+//
+// Keys with an enc use or with key operations that do not include verify are never returned. An error that wraps
+// ErrKeyNotFound is returned if there are no candidate keys, and one that wraps ErrJWSHeader if the header has neither
+// a key ID nor an algorithm, or the key with the key ID cannot be used with the algorithm.
+func KeysForJWSHeader(ctx context.Context, storage Storage, header JWSHeader) ([]JWK, error) {
+	if header.KID != "" {
+		jwk, err := storage.KeyRead(ctx, header.KID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key for JWS header: %w", err)
+		}
+		if !canVerify(jwk) {
+			return nil, fmt.Errorf("%w: key ID %q cannot verify", ErrJWSHeader, header.KID)
+		}
+		if header.ALG != "" && len(filterByAlg([]JWK{jwk}, header.ALG, true)) == 0 {
+			return nil, fmt.Errorf("%w: key ID %q cannot be used with alg %q", ErrJWSHeader, header.KID, header.ALG)
+		}
+		return []JWK{jwk}, nil
+	}
+	if header.ALG == "" {
+		return nil, fmt.Errorf(`%w: no "kid" or "alg" parameter`, ErrJWSHeader)
+	}
+	keys, err := storage.KeyReadByAlg(ctx, header.ALG, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys for JWS header: %w", err)
+	}
+	keys = slices.DeleteFunc(keys, func(jwk JWK) bool {
+		return !canVerify(jwk)
+	})
+	slices.SortStableFunc(keys, func(a, b JWK) int {
+		return declaresAlg(b) - declaresAlg(a)
+	})
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key for alg %q", ErrKeyNotFound, header.ALG)
+	}
+	return keys, nil
+}
+
+// canVerify reports whether the use and key operations of the JWK allow verifying a JWS.
+func canVerify(jwk JWK) bool {
+	marshal := jwk.Marshal()
+	if marshal.USE == UseEnc {
+		return false
+	}
+	return len(marshal.KEYOPS) == 0 || slices.Contains(marshal.KEYOPS, KeyOpsVerify)
+}
+
+// declaresAlg returns 1 if the JWK declares an algorithm and 0 otherwise.
+func declaresAlg(jwk JWK) int {
+	if jwk.Marshal().ALG != "" {
+		return 1
+	}
+	return 0
+}
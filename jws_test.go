@@ -0,0 +1,183 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestJWKFromJWSHeader(t *testing.T) {
+	key := makeEdDSA(t)
+	jwk := newJWK(t, key, JWKOptions{Metadata: JWKMetadataOptions{KID: myKeyID}})
+	var header map[string]any
+	err := json.Unmarshal([]byte(`{"alg":"EdDSA","jwk":`+string(mustMarshal(t, jwk.Marshal()))+`}`), &header)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal header. %s", err)
+	}
+	headerJWK, err := JWKFromJWSHeader(header)
+	if err != nil {
+		t.Fatalf("Failed to create JWK from JWS header. %s", err)
+	}
+	if headerJWK.Marshal().KID != myKeyID || headerJWK.Marshal().X != jwk.Marshal().X {
+		t.Fatalf("Expected the JWK from the JWS header to match.")
+	}
+
+	private := newJWK(t, key, JWKOptions{Marshal: JWKMarshalOptions{Private: true}})
+	for _, value := range []any{
+		private.Marshal(),
+		newJWK(t, []byte(hmacKey1), JWKOptions{Marshal: JWKMarshalOptions{Private: true}}).Marshal(),
+		"not a JWK",
+	} {
+		_, err = JWKFromJWSHeader(map[string]any{"jwk": value})
+		if !errors.Is(err, ErrJWSHeader) {
+			t.Fatalf("Expected ErrJWSHeader for %v, got %v.", value, err)
+		}
+	}
+	_, err = JWKFromJWSHeader(map[string]any{})
+	if !errors.Is(err, ErrJWSHeader) {
+		t.Fatalf("Expected ErrJWSHeader without a jwk parameter, got %v.", err)
+	}
+}
+
+func TestJKUResolver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newJWK(t, makeEdDSA(t), JWKOptions{Metadata: JWKMetadataOptions{KID: myKeyID}}))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		rawJWKS, err := serverStore.JSONPublic(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+
+	_, err := NewJKUResolver(JKUResolverOptions{})
+	if !errors.Is(err, ErrOptions) {
+		t.Fatalf("Expected ErrOptions without AllowedURLs, got %v.", err)
+	}
+	resolve, err := NewJKUResolver(JKUResolverOptions{
+		AllowedURLs: []string{server.URL},
+		HTTPOptions: HTTPClientStorageOptions{Ctx: ctx},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create jku resolver. %s", err)
+	}
+
+	for _, header := range []map[string]any{
+		{"jku": server.URL, "kid": myKeyID},
+		{"jku": server.URL},
+	} {
+		jwk, err := resolve(ctx, header)
+		if err != nil {
+			t.Fatalf("Failed to resolve jku. %s", err)
+		}
+		if jwk.Marshal().KID != myKeyID {
+			t.Fatalf("Expected key ID %q, got %q.", myKeyID, jwk.Marshal().KID)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Expected the JWK Set to be fetched once, got %d requests.", requests.Load())
+	}
+
+	_, err = resolve(ctx, map[string]any{"jku": server.URL + "/other", "kid": myKeyID})
+	if !errors.Is(err, ErrJKUNotAllowed) {
+		t.Fatalf("Expected ErrJKUNotAllowed, got %v.", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Expected no request for a jku that is not allowed.")
+	}
+	_, err = resolve(ctx, map[string]any{"jku": server.URL, "kid": kidMissing})
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v.", err)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal JSON. %s", err)
+	}
+	return raw
+}
+
+func TestKeysForJWSHeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := NewMemoryStorage()
+	writeKeys(ctx, t, store,
+		newJWK(t, makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{KID: "inferred"}}),
+		newJWK(t, makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgES256, KID: "declared"}}),
+		newJWK(t, makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{KID: "enc", USE: UseEnc}}),
+		newJWK(t, makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{KEYOPS: []KEYOPS{KeyOpsSign}, KID: "sign only"}}),
+		newJWK(t, makeEdDSA(t), JWKOptions{Metadata: JWKMetadataOptions{KID: myKeyID}}),
+	)
+
+	testCases := []struct {
+		name     string
+		header   JWSHeader
+		expected []string
+		err      error
+	}{
+		{name: "ALG", header: JWSHeader{ALG: AlgES256}, expected: []string{"declared", "inferred"}},
+		{name: "KID", header: JWSHeader{ALG: AlgES256, KID: "inferred"}, expected: []string{"inferred"}},
+		{name: "KIDWithoutALG", header: JWSHeader{KID: myKeyID}, expected: []string{myKeyID}},
+		{name: "KIDWrongALG", header: JWSHeader{ALG: AlgES256, KID: myKeyID}, err: ErrJWSHeader},
+		{name: "KIDEnc", header: JWSHeader{KID: "enc"}, err: ErrJWSHeader},
+		{name: "KIDMissing", header: JWSHeader{ALG: AlgES256, KID: kidMissing}, err: ErrKeyNotFound},
+		{name: "NoCandidates", header: JWSHeader{ALG: AlgRS256}, err: ErrKeyNotFound},
+		{name: "Empty", err: ErrJWSHeader},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := KeysForJWSHeader(ctx, store, tc.header)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Expected error %v, got %v.", tc.err, err)
+			}
+			var kids []string
+			for _, jwk := range keys {
+				kids = append(kids, jwk.Marshal().KID)
+			}
+			if !slices.Equal(kids, tc.expected) {
+				t.Fatalf("Expected keys %v, got %v.", tc.expected, kids)
+			}
+		})
+	}
+
+	serverStore := NewMemoryStorage()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	c, err := NewDefaultHTTPClientCtx(ctx, []string{server.URL}, WithRefreshUnknownKIDLimiter(rate.NewLimiter(rate.Inf, 1)))
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	keys, err := KeysForJWSHeader(ctx, c, JWSHeader{ALG: AlgHS256, KID: kidWritten})
+	if err != nil {
+		t.Fatalf("Expected an unknown key ID to be refreshed. %s", err)
+	}
+	if len(keys) != 1 || keys[0].Marshal().KID != kidWritten {
+		t.Fatalf("Unexpected keys for the refreshed key ID: %v.", keys)
+	}
+}
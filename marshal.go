@@ -1,31 +1,38 @@
 package jwkset
 
 import (
+	"bytes"
+	"cmp"
 	"context"
-	"crypto/ecdh"
-	"crypto/ecdsa"
 	"crypto/ed25519"
-	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"maps"
 	"math/big"
+	"reflect"
 	"slices"
 	"strings"
 )
 
 var (
+	// ErrDuplicateKID indicates that more than one JWK in a JWK Set has the same key ID.
+	ErrDuplicateKID = errors.New("duplicate key ID in JWK Set")
 	// ErrGetX5U indicates there was an error getting the X5U remote resource.
 	ErrGetX5U = errors.New("failed to get X5U via given URI")
 	// ErrJWKValidation indicates that a JWK failed to validate.
 	ErrJWKValidation = errors.New("failed to validate JWK")
+	// ErrInvalidECPoint indicates that the x and y coordinates of an EC key are not a point on its curve.
+	ErrInvalidECPoint = errors.New("EC point is not on the curve")
 	// ErrKeyUnmarshalParameter indicates that a JWK's attributes are invalid and cannot be unmarshaled.
 	ErrKeyUnmarshalParameter = errors.New("unable to unmarshal JWK due to invalid attributes")
+	// ErrMergeConflict indicates that two JWKs with the same key ID have different key material.
+	ErrMergeConflict = errors.New("JWKs with the same key ID have different key material")
 	// ErrOptions indicates that the given options caused an error.
 	ErrOptions = errors.New("the given options caused an error")
 	// ErrUnsupportedKey indicates a key is not supported.
@@ -71,6 +78,84 @@ type JWKMarshal struct {
 	QI      string        `json:"qi,omitempty"`       // https://www.rfc-editor.org/rfc/rfc7518#section-6.3.2.6
 	OTH     []OtherPrimes `json:"oth,omitempty"`      // https://www.rfc-editor.org/rfc/rfc7518#section-6.3.2.7
 	K       string        `json:"k,omitempty"`        // https://www.rfc-editor.org/rfc/rfc7518#section-6.4.1
+
+	// Extra holds the top-level members that are not defined by RFC 7517 or RFC 7518, such as proprietary metadata.
+	// It is populated when unmarshalling from JSON and the members are emitted again when marshalling to JSON.
+	// Members with the same name as a defined member are ignored when marshalling.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// jwkMembers are the JSON members of a JWKMarshal.
+var jwkMembers = func() map[string]struct{} {
+	members := make(map[string]struct{})
+	t := reflect.TypeOf(JWKMarshal{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			members[name] = struct{}{}
+		}
+	}
+	return members
+}()
+
+// MarshalJSON implements json.Marshaler. The members in Extra are emitted after the defined members, sorted by name.
+func (j JWKMarshal) MarshalJSON() ([]byte, error) {
+	type jwkMarshal JWKMarshal // Drops the MarshalJSON method to avoid recursion.
+	raw, err := json.Marshal(jwkMarshal(j))
+	if err != nil || len(j.Extra) == 0 {
+		return raw, err
+	}
+	names := make([]string, 0, len(j.Extra))
+	for name := range j.Extra {
+		if _, ok := jwkMembers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	buf := bytes.NewBuffer(raw[:len(raw)-1]) // Remove the closing brace.
+	for _, name := range names {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JWK member name %q: %w", name, err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		err = json.Compact(buf, j.Extra[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JWK member %q: %w", name, err)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Members that are not defined by RFC 7517 or RFC 7518 are kept in Extra.
+func (j *JWKMarshal) UnmarshalJSON(data []byte) error {
+	type jwkMarshal JWKMarshal // Drops the UnmarshalJSON method to avoid recursion.
+	var m jwkMarshal
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	err = json.Unmarshal(data, &members)
+	if err != nil {
+		return err
+	}
+	for name := range members {
+		if _, ok := jwkMembers[name]; ok {
+			delete(members, name)
+		}
+	}
+	if len(members) == 0 {
+		members = nil
+	}
+	m.Extra = members
+	*j = JWKMarshal(m)
+	return nil
 }
 
 // JWKSMarshal is used to marshal or unmarshal a JSON Web Key Set.
@@ -78,6 +163,89 @@ type JWKSMarshal struct {
 	Keys []JWKMarshal `json:"keys"`
 }
 
+// JWKSDiff reports the differences between two JWK Sets, identified by key ID. Each slice is sorted.
+type JWKSDiff struct {
+	// Added are the key IDs only in the second JWK Set.
+	Added []string
+	// Changed are the key IDs in both JWK Sets with keys that are not equal, as defined by JWK.Equal.
+	Changed []string
+	// Removed are the key IDs only in the first JWK Set.
+	Removed []string
+}
+
+// Empty reports whether there are no differences.
+func (d JWKSDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// DiffJWKS compares the keys in JWK Set a to the keys in JWK Set b by key ID. If a JWK Set has more than one key with
+// the same key ID, the last one is used.
+func DiffJWKS(a, b JWKSMarshal) JWKSDiff {
+	byKID := func(jwks JWKSMarshal) map[string]JWKMarshal {
+		m := make(map[string]JWKMarshal, len(jwks.Keys))
+		for _, marshal := range jwks.Keys {
+			m[marshal.KID] = marshal
+		}
+		return m
+	}
+	before, after := byKID(a), byKID(b)
+	var diff JWKSDiff
+	for kid, marshal := range before {
+		other, ok := after[kid]
+		if !ok {
+			diff.Removed = append(diff.Removed, kid)
+		} else if !jwkMarshalEqual(marshal, other) {
+			diff.Changed = append(diff.Changed, kid)
+		}
+	}
+	for kid := range after {
+		if _, ok := before[kid]; !ok {
+			diff.Added = append(diff.Added, kid)
+		}
+	}
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Changed)
+	slices.Sort(diff.Removed)
+	return diff
+}
+
+// canonicalJSON marshals the value to JSON with object members in lexicographic order, no insignificant whitespace,
+// and no HTML escaping. Numbers are kept as they were marshaled.
+func canonicalJSON(v any) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic any
+	err = dec.Decode(&generic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON for canonicalization: %w", err)
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(generic) // Maps are encoded with sorted keys.
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode canonical JSON: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// sortJWKMarshals sorts the keys by key ID (kid), then by their JSON representation.
+func sortJWKMarshals(keys []JWKMarshal) {
+	slices.SortStableFunc(keys, func(a, b JWKMarshal) int {
+		c := cmp.Compare(a.KID, b.KID)
+		if c != 0 {
+			return c
+		}
+		rawA, _ := json.Marshal(a)
+		rawB, _ := json.Marshal(b)
+		return bytes.Compare(rawA, rawB)
+	})
+}
+
 // JWKSlice converts the JWKSMarshal to a []JWK.
 func (j JWKSMarshal) JWKSlice() ([]JWK, error) {
 	slice := make([]JWK, len(j.Keys))
@@ -110,63 +278,205 @@ func (j JWKSMarshal) ToStorage() (Storage, error) {
 	return m, nil
 }
 
+// CheckUniqueKIDs returns an error that wraps ErrDuplicateKID and names the first key ID used by more than one JWK in
+// the JWK Set. If allowDifferentUse is true, JWKs may share a key ID if they have different key uses (use). JWKs without
+// a key ID are not checked.
+func (j JWKSMarshal) CheckUniqueKIDs(allowDifferentUse bool) error {
+	tracker := newKIDTracker(allowDifferentUse)
+	for _, marshal := range j.Keys {
+		err := tracker.add(marshal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kidTracker finds duplicate key IDs in the keys of a JWK Set.
+type kidTracker struct {
+	allowDifferentUse bool
+	uses              map[string][]USE
+}
+
+func newKIDTracker(allowDifferentUse bool) *kidTracker {
+	return &kidTracker{
+		allowDifferentUse: allowDifferentUse,
+		uses:              make(map[string][]USE),
+	}
+}
+
+// add records the key ID of the JWK and returns an error if it duplicates one already recorded.
+func (t *kidTracker) add(marshal JWKMarshal) error {
+	if marshal.KID == "" {
+		return nil
+	}
+	uses, ok := t.uses[marshal.KID]
+	if ok && (!t.allowDifferentUse || marshal.USE == "" || slices.Contains(uses, marshal.USE) || slices.Contains(uses, "")) {
+		return fmt.Errorf("%w: %q", errors.Join(ErrJWKValidation, ErrDuplicateKID), marshal.KID)
+	}
+	t.uses[marshal.KID] = append(uses, marshal.USE)
+	return nil
+}
+
+// JWKValidationResult is the result of validating one key with ValidateJWKS.
+type JWKValidationResult struct {
+	// Err is the reason the key failed validation. It is nil if the key passed.
+	Err error
+	// Index is the position of the key in the keys member of the JWK Set.
+	Index int
+	// KID is the key ID of the key, if it could be read.
+	KID string
+}
+
+// ValidateJWKS parses each key in the raw JWK Set and validates it with the given options, without storing it. A result
+// is returned for every key, so all failures are reported instead of only the first. An error is only returned if the
+// raw JSON is not a JWK Set. With the RejectDuplicateKIDs option, each JWK that reuses the key ID of an earlier JWK
+// fails validation.
+//
+// ValidateJWKS never makes network calls, so the GetX5U field of the options is ignored and certificate chains
+// referenced by the x5u parameter are not checked.
+func ValidateJWKS(raw json.RawMessage, options JWKValidateOptions) ([]JWKValidationResult, error) {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	err := json.Unmarshal(raw, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JWK Set: %w", err)
+	}
+	if set.Keys == nil {
+		return nil, fmt.Errorf(`%w: JWK Set has no "keys" member`, ErrJWKValidation)
+	}
+	options.GetX5U = nil
+	tracker := newKIDTracker(options.AllowDuplicateKIDDifferentUse)
+	results := make([]JWKValidationResult, len(set.Keys))
+	for i, rawKey := range set.Keys {
+		results[i].Index = i
+		var marshal JWKMarshal
+		err = json.Unmarshal(rawKey, &marshal)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to unmarshal JWK: %w", err)
+			continue
+		}
+		results[i].KID = marshal.KID
+		_, err = NewJWKFromMarshal(marshal, JWKMarshalOptions{Private: true}, options)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if options.RejectDuplicateKIDs && !options.SkipAll {
+			results[i].Err = tracker.add(marshal)
+		}
+	}
+	return results, nil
+}
+
+// MergeConflictPolicy determines which JWK is kept when JWK Sets being merged contain more than one JWK with the same
+// key ID.
+type MergeConflictPolicy int
+
+const (
+	// MergeFirstWins keeps the first JWK with a given key ID.
+	MergeFirstWins MergeConflictPolicy = iota
+	// MergeLastWins keeps the last JWK with a given key ID, in the position of the first.
+	MergeLastWins
+	// MergeErrorOnConflict returns ErrMergeConflict if JWKs with the same key ID have different key material. JWKs with
+	// the same key ID and key material are deduplicated.
+	MergeErrorOnConflict
+)
+
+// MergeJWKSOptions are used to configure the behavior of MergeJWKSWithOptions.
+type MergeJWKSOptions struct {
+	// ConflictPolicy determines which JWK is kept when more than one JWK has the same key ID.
+	//
+	// This defaults to MergeFirstWins.
+	ConflictPolicy MergeConflictPolicy
+}
+
+// MergeJWKS concatenates the keys of the given JWK Sets and deduplicates them by key ID. The first JWK with a given key
+// ID is kept. JWKs without a key ID are never deduplicated.
+func MergeJWKS(sets ...JWKSMarshal) (JWKSMarshal, error) {
+	return MergeJWKSWithOptions(MergeJWKSOptions{}, sets...)
+}
+
+// MergeJWKSWithOptions is like MergeJWKS, but uses the given options to resolve JWKs with the same key ID.
+func MergeJWKSWithOptions(options MergeJWKSOptions, sets ...JWKSMarshal) (JWKSMarshal, error) {
+	merged := JWKSMarshal{
+		Keys: make([]JWKMarshal, 0),
+	}
+	index := make(map[string]int)
+	for _, set := range sets {
+		for _, key := range set.Keys {
+			if key.KID == "" {
+				merged.Keys = append(merged.Keys, key)
+				continue
+			}
+			i, ok := index[key.KID]
+			if !ok {
+				index[key.KID] = len(merged.Keys)
+				merged.Keys = append(merged.Keys, key)
+				continue
+			}
+			switch options.ConflictPolicy {
+			case MergeFirstWins:
+			case MergeLastWins:
+				merged.Keys[i] = key
+			case MergeErrorOnConflict:
+				if !sameKeyMaterial(merged.Keys[i], key) {
+					return JWKSMarshal{}, fmt.Errorf("%w: kid %q", ErrMergeConflict, key.KID)
+				}
+			default:
+				return JWKSMarshal{}, fmt.Errorf("%w: unknown merge conflict policy %d", ErrOptions, options.ConflictPolicy)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// sameKeyMaterial reports whether the public key material, or symmetric key, of the given JWKs is the same. Base64url
+// encoded members are compared without trailing padding.
+func sameKeyMaterial(a, b JWKMarshal) bool {
+	return a.KTY == b.KTY &&
+		a.CRV == b.CRV &&
+		sameBase64URL(a.X, b.X) &&
+		sameBase64URL(a.Y, b.Y) &&
+		sameBase64URL(a.N, b.N) &&
+		sameBase64URL(a.E, b.E) &&
+		sameBase64URL(a.K, b.K)
+}
+
+// jwkMarshalEqual implements JWK.Equal for JWKMarshal.
+func jwkMarshalEqual(a, b JWKMarshal) bool {
+	if !sameKeyMaterial(a, b) || a.KID != b.KID || a.ALG != b.ALG || a.USE != b.USE {
+		return false
+	}
+	if !sameBase64URL(a.D, b.D) ||
+		!sameBase64URL(a.P, b.P) ||
+		!sameBase64URL(a.Q, b.Q) ||
+		!sameBase64URL(a.DP, b.DP) ||
+		!sameBase64URL(a.DQ, b.DQ) ||
+		!sameBase64URL(a.QI, b.QI) {
+		return false
+	}
+	if !slices.EqualFunc(a.OTH, b.OTH, func(a, b OtherPrimes) bool {
+		return sameBase64URL(a.R, b.R) && sameBase64URL(a.D, b.D) && sameBase64URL(a.T, b.T)
+	}) {
+		return false
+	}
+	opsA, opsB := slices.Clone(a.KEYOPS), slices.Clone(b.KEYOPS)
+	slices.Sort(opsA)
+	slices.Sort(opsB)
+	return slices.Equal(opsA, opsB)
+}
+
+// sameBase64URL reports whether the base64url encoded values are the same, ignoring trailing padding.
+func sameBase64URL(a, b string) bool {
+	return strings.TrimRight(a, "=") == strings.TrimRight(b, "=")
+}
+
 func keyMarshal(key any, options JWKOptions) (JWKMarshal, error) {
 	m := JWKMarshal{}
 	m.ALG = options.Metadata.ALG
 	switch key := key.(type) {
-	case *ecdh.PublicKey:
-		pub := key.Bytes()
-		m.CRV = CrvX25519
-		m.X = base64.RawURLEncoding.EncodeToString(pub)
-		m.KTY = KtyOKP
-	case *ecdh.PrivateKey:
-		pub := key.PublicKey().Bytes()
-		m.CRV = CrvX25519
-		m.X = base64.RawURLEncoding.EncodeToString(pub)
-		m.KTY = KtyOKP
-		if options.Marshal.Private {
-			priv := key.Bytes()
-			m.D = base64.RawURLEncoding.EncodeToString(priv)
-		}
-	case *ecdsa.PrivateKey:
-		pub := key.PublicKey
-		m.CRV = CRV(pub.Curve.Params().Name)
-		l := uint(pub.Curve.Params().BitSize / 8)
-		if pub.Curve.Params().BitSize%8 != 0 {
-			l++
-		}
-		m.X = bigIntToBase64RawURL(pub.X, l)
-		m.Y = bigIntToBase64RawURL(pub.Y, l)
-		m.KTY = KtyEC
-		if options.Marshal.Private {
-			params := key.Curve.Params()
-			f, _ := params.N.Float64()
-			l = uint(math.Ceil(math.Log2(f) / 8))
-			m.D = bigIntToBase64RawURL(key.D, l)
-		}
-	case *ecdsa.PublicKey:
-		l := uint(key.Curve.Params().BitSize / 8)
-		if key.Curve.Params().BitSize%8 != 0 {
-			l++
-		}
-		m.CRV = CRV(key.Curve.Params().Name)
-		m.X = bigIntToBase64RawURL(key.X, l)
-		m.Y = bigIntToBase64RawURL(key.Y, l)
-		m.KTY = KtyEC
-	case ed25519.PrivateKey:
-		pub := key.Public().(ed25519.PublicKey)
-		m.ALG = AlgEdDSA
-		m.CRV = CrvEd25519
-		m.X = base64.RawURLEncoding.EncodeToString(pub)
-		m.KTY = KtyOKP
-		if options.Marshal.Private {
-			m.D = base64.RawURLEncoding.EncodeToString(key[:32])
-		}
-	case ed25519.PublicKey:
-		m.ALG = AlgEdDSA
-		m.CRV = CrvEd25519
-		m.X = base64.RawURLEncoding.EncodeToString(key)
-		m.KTY = KtyOKP
 	case *rsa.PrivateKey:
 		pub := key.PublicKey
 		m.E = bigIntToBase64RawURL(big.NewInt(int64(pub.E)), 0)
@@ -199,20 +509,29 @@ func keyMarshal(key any, options JWKOptions) (JWKMarshal, error) {
 			m.KTY = KtyOct
 			m.K = base64.RawURLEncoding.EncodeToString(key)
 		} else {
-			return JWKMarshal{}, fmt.Errorf("%w: incorrect options to marshal symmetric key (oct)", ErrOptions)
+			return JWKMarshal{}, fmt.Errorf("%w: incorrect options to marshal symmetric key (oct), the Marshal.Private option is required", ErrOptions)
 		}
 	default:
-		return JWKMarshal{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+		c, err := encodeCurve(key, options.Marshal.Private)
+		if err != nil {
+			return JWKMarshal{}, err
+		}
+		m.CRV = c.CRV
+		m.X = c.X
+		m.Y = c.Y
+		m.D = c.D
+		m.KTY = c.KTY
+		switch key.(type) {
+		case ed25519.PrivateKey, ed25519.PublicKey:
+			m.ALG = AlgEdDSA
+		}
 	}
 	haveX5C := len(options.X509.X5C) > 0
 	if haveX5C {
 		for i, cert := range options.X509.X5C {
 			m.X5C = append(m.X5C, base64.StdEncoding.EncodeToString(cert.Raw))
 			if i == 0 {
-				h1 := sha1.Sum(cert.Raw)
-				m.X5T = base64.RawURLEncoding.EncodeToString(h1[:])
-				h256 := sha256.Sum256(cert.Raw)
-				m.X5TS256 = base64.RawURLEncoding.EncodeToString(h256[:])
+				m.X5T, m.X5TS256 = x509Thumbprints(cert)
 			}
 		}
 	}
@@ -223,108 +542,36 @@ func keyMarshal(key any, options JWKOptions) (JWKMarshal, error) {
 	return m, nil
 }
 
+// x509Thumbprints computes the x5t and x5t#S256 thumbprints of the given certificate.
+func x509Thumbprints(cert *x509.Certificate) (x5t, x5tS256 string) {
+	h1 := sha1.Sum(cert.Raw)
+	h256 := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(h1[:]), base64.RawURLEncoding.EncodeToString(h256[:])
+}
+
 func keyUnmarshal(marshal JWKMarshal, options JWKMarshalOptions, validateOptions JWKValidateOptions) (JWK, error) {
 	marshalCopy := JWKMarshal{}
 	var key any
 	switch marshal.KTY {
-	case KtyEC:
-		if marshal.CRV == "" || marshal.X == "" || marshal.Y == "" {
-			return JWK{}, fmt.Errorf(`%w: %s requires parameters "crv", "x", and "y"`, ErrKeyUnmarshalParameter, KtyEC)
+	case KtyEC, KtyOKP:
+		if marshal.CRV == "" {
+			return JWK{}, fmt.Errorf(`%w: %s requires parameter "crv"`, ErrKeyUnmarshalParameter, marshal.KTY)
 		}
-		x, err := base64urlTrailingPadding(marshal.X)
-		if err != nil {
-			return JWK{}, fmt.Errorf(`failed to decode %s key parameter "x": %w`, KtyEC, err)
+		impl, ok := lookupCurve(marshal.KTY, marshal.CRV)
+		if !ok {
+			return JWK{}, fmt.Errorf("%w: unsupported curve type %q", ErrKeyUnmarshalParameter, marshal.CRV)
 		}
-		y, err := base64urlTrailingPadding(marshal.Y)
+		private := options.Private && marshal.D != ""
+		var err error
+		key, err = impl.Decode(marshal, private)
 		if err != nil {
-			return JWK{}, fmt.Errorf(`failed to decode %s key parameter "y": %w`, KtyEC, err)
-		}
-		publicKey := &ecdsa.PublicKey{
-			X: new(big.Int).SetBytes(x),
-			Y: new(big.Int).SetBytes(y),
-		}
-		switch marshal.CRV {
-		case CrvP256:
-			publicKey.Curve = elliptic.P256()
-		case CrvP384:
-			publicKey.Curve = elliptic.P384()
-		case CrvP521:
-			publicKey.Curve = elliptic.P521()
-		default:
-			return JWK{}, fmt.Errorf("%w: unsupported curve type %q", ErrKeyUnmarshalParameter, marshal.CRV)
+			return JWK{}, err
 		}
 		marshalCopy.CRV = marshal.CRV
 		marshalCopy.X = marshal.X
 		marshalCopy.Y = marshal.Y
-		if options.Private && marshal.D != "" {
-			d, err := base64urlTrailingPadding(marshal.D)
-			if err != nil {
-				return JWK{}, fmt.Errorf(`failed to decode %s key parameter "d": %w`, KtyEC, err)
-			}
-			privateKey := &ecdsa.PrivateKey{
-				PublicKey: *publicKey,
-				D:         new(big.Int).SetBytes(d),
-			}
-			key = privateKey
+		if private {
 			marshalCopy.D = marshal.D
-		} else {
-			key = publicKey
-		}
-	case KtyOKP:
-		if marshal.CRV == "" || marshal.X == "" {
-			return JWK{}, fmt.Errorf(`%w: %s requires parameters "crv" and "x"`, ErrKeyUnmarshalParameter, KtyOKP)
-		}
-		public, err := base64urlTrailingPadding(marshal.X)
-		if err != nil {
-			return JWK{}, fmt.Errorf(`failed to decode %s key parameter "x": %w`, KtyOKP, err)
-		}
-		marshalCopy.CRV = marshal.CRV
-		marshalCopy.X = marshal.X
-		var private []byte
-		if options.Private && marshal.D != "" {
-			private, err = base64urlTrailingPadding(marshal.D)
-			if err != nil {
-				return JWK{}, fmt.Errorf(`failed to decode %s key parameter "d": %w`, KtyOKP, err)
-			}
-		}
-		switch marshal.CRV {
-		case CrvEd25519:
-			if len(public) != ed25519.PublicKeySize {
-				return JWK{}, fmt.Errorf("%w: %s key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, ed25519.PublicKeySize)
-			}
-			if options.Private && marshal.D != "" {
-				private = append(private, public...)
-				if len(private) != ed25519.PrivateKeySize {
-					return JWK{}, fmt.Errorf("%w: %s key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, ed25519.PrivateKeySize)
-				}
-				key = ed25519.PrivateKey(private)
-				marshalCopy.D = marshal.D
-			} else {
-				key = ed25519.PublicKey(public)
-			}
-		case CrvX25519:
-			const x25519PublicKeySize = 32
-			if len(public) != x25519PublicKeySize {
-				return JWK{}, fmt.Errorf("%w: %s with curve %s public key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, CrvEd25519, x25519PublicKeySize)
-			}
-			if options.Private && marshal.D != "" {
-				const x25519PrivateKeySize = 32
-				if len(private) != x25519PrivateKeySize {
-					return JWK{}, fmt.Errorf("%w: %s with curve %s private key should be %d bytes", ErrKeyUnmarshalParameter, KtyOKP, CrvEd25519, x25519PrivateKeySize)
-				}
-				key, err = ecdh.X25519().NewPrivateKey(private)
-				if err != nil {
-					return JWK{}, fmt.Errorf("failed to create X25519 private key: %w", err)
-				}
-				marshalCopy.D = marshal.D
-			} else {
-				key, err = ecdh.X25519().NewPublicKey(public)
-				if err != nil {
-					return JWK{}, fmt.Errorf("failed to create X25519 public key: %w", err)
-				}
-			}
-		default:
-			return JWK{}, fmt.Errorf("%w: unsupported curve type %q", ErrKeyUnmarshalParameter, marshal.CRV)
 		}
 	case KtyRSA:
 		if marshal.N == "" || marshal.E == "" {
@@ -473,6 +720,7 @@ func keyUnmarshal(marshal JWKMarshal, options JWKMarshalOptions, validateOptions
 	marshalCopy.KID = marshal.KID
 	marshalCopy.KEYOPS = slices.Clone(marshal.KEYOPS)
 	marshalCopy.USE = marshal.USE
+	marshalCopy.Extra = maps.Clone(marshal.Extra)
 	opts := JWKOptions{
 		Metadata: metadata,
 		Marshal:  options,
@@ -7,10 +7,14 @@ import (
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"math/big"
+	"net/url"
 	"slices"
+	"strings"
 	"testing"
 )
 
@@ -323,6 +327,37 @@ func TestUnmarshalECDSA(t *testing.T) {
 	marshal.Y = ecdsaP521Y
 }
 
+func TestInvalidECPoint(t *testing.T) {
+	for _, tc := range []struct {
+		crv  CRV
+		x, y string
+	}{
+		{crv: CrvP256, x: ecdsaP256X, y: ecdsaP256Y},
+		{crv: CrvP384, x: ecdsaP384X, y: ecdsaP384Y},
+		{crv: CrvP521, x: ecdsaP521X, y: ecdsaP521Y},
+	} {
+		marshal := JWKMarshal{
+			CRV: tc.crv,
+			KTY: KtyEC,
+			X:   tc.x,
+			Y:   tc.x,
+		}
+		_, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{}, JWKValidateOptions{})
+		if !errors.Is(err, ErrInvalidECPoint) || !errors.Is(err, ErrKeyUnmarshalParameter) {
+			t.Fatalf("Expected ErrInvalidECPoint for a point that is not on curve %q, got %v.", tc.crv, err)
+		}
+
+		marshal.Y = tc.y
+		jwk := newJWKFromMarshal(t, marshal, JWKMarshalOptions{})
+		pub := *jwk.Key().(*ecdsa.PublicKey)
+		pub.Y = new(big.Int).Add(pub.Y, big.NewInt(1))
+		_, err = NewJWKFromKey(&pub, JWKOptions{})
+		if !errors.Is(err, ErrInvalidECPoint) || !errors.Is(err, ErrJWKValidation) {
+			t.Fatalf("Expected ErrInvalidECPoint for a key that is not on curve %q, got %v.", tc.crv, err)
+		}
+	}
+}
+
 func TestMarshalEdDSA(t *testing.T) {
 	checkJWK := func(marshal JWKMarshal, options JWKOptions) {
 		if marshal.ALG != AlgEdDSA {
@@ -1030,3 +1065,207 @@ func newJWKFromMarshal(t *testing.T, marshal JWKMarshal, marshalOptions JWKMarsh
 	}
 	return jwk
 }
+
+func TestMergeJWKS(t *testing.T) {
+	key1 := JWKMarshal{KTY: KtyOct, KID: myKeyID, K: "a2V5MQ"}
+	key1Copy := JWKMarshal{KTY: KtyOct, KID: myKeyID, K: "a2V5MQ", USE: UseSig}
+	key2 := JWKMarshal{KTY: KtyOct, KID: myKeyID, K: "a2V5Mg"}
+	other := JWKMarshal{KTY: KtyOct, KID: "other", K: "b3RoZXI"}
+	noKID := JWKMarshal{KTY: KtyOct, K: "bm9LSUQ"}
+	first := JWKSMarshal{Keys: []JWKMarshal{key1, noKID}}
+	second := JWKSMarshal{Keys: []JWKMarshal{key2, other, noKID}}
+
+	testCases := []struct {
+		name     string
+		policy   MergeConflictPolicy
+		sets     []JWKSMarshal
+		expected []JWKMarshal
+		err      error
+	}{
+		{
+			name:     "FirstWins",
+			policy:   MergeFirstWins,
+			sets:     []JWKSMarshal{first, second},
+			expected: []JWKMarshal{key1, noKID, other, noKID},
+		},
+		{
+			name:     "LastWins",
+			policy:   MergeLastWins,
+			sets:     []JWKSMarshal{first, second},
+			expected: []JWKMarshal{key2, noKID, other, noKID},
+		},
+		{
+			name:   "ErrorOnConflict",
+			policy: MergeErrorOnConflict,
+			sets:   []JWKSMarshal{first, second},
+			err:    ErrMergeConflict,
+		},
+		{
+			name:     "ErrorOnConflictSameMaterial",
+			policy:   MergeErrorOnConflict,
+			sets:     []JWKSMarshal{first, {Keys: []JWKMarshal{key1Copy}}},
+			expected: []JWKMarshal{key1, noKID},
+		},
+		{
+			name:   "UnknownPolicy",
+			policy: MergeConflictPolicy(-1),
+			sets:   []JWKSMarshal{first, second},
+			err:    ErrOptions,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := MergeJWKSWithOptions(MergeJWKSOptions{ConflictPolicy: tc.policy}, tc.sets...)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("Expected error %s, got %s.", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to merge JWK Sets. %s", err)
+			}
+			if !slices.EqualFunc(merged.Keys, tc.expected, func(a, b JWKMarshal) bool {
+				return a.KID == b.KID && a.K == b.K
+			}) {
+				t.Fatalf("Unexpected merged keys.\n  Actual: %v\n  Expected: %v", merged.Keys, tc.expected)
+			}
+		})
+	}
+
+	merged, err := MergeJWKS(first, second)
+	if err != nil {
+		t.Fatalf("Failed to merge JWK Sets. %s", err)
+	}
+	if len(merged.Keys) != 4 || merged.Keys[0].K != key1.K {
+		t.Fatalf("Expected MergeJWKS to keep the first key.")
+	}
+}
+
+func TestDiffJWKS(t *testing.T) {
+	key1 := JWKMarshal{KTY: KtyOct, KID: myKeyID, K: "a2V5MQ"}
+	key1Padded := JWKMarshal{KTY: KtyOct, KID: myKeyID, K: "a2V5MQ=="}
+	key1Use := JWKMarshal{KTY: KtyOct, KID: myKeyID, K: "a2V5MQ", USE: UseSig}
+	removed := JWKMarshal{KTY: KtyOct, KID: "removed", K: "cmVtb3ZlZA"}
+	added := JWKMarshal{KTY: KtyOct, KID: "added", K: "YWRkZWQ"}
+
+	diff := DiffJWKS(JWKSMarshal{Keys: []JWKMarshal{key1, removed}}, JWKSMarshal{Keys: []JWKMarshal{added, key1Padded}})
+	if !slices.Equal(diff.Added, []string{"added"}) || !slices.Equal(diff.Removed, []string{"removed"}) || len(diff.Changed) != 0 {
+		t.Fatalf("Unexpected diff: %+v.", diff)
+	}
+	diff = DiffJWKS(JWKSMarshal{Keys: []JWKMarshal{key1}}, JWKSMarshal{Keys: []JWKMarshal{key1Use}})
+	if !slices.Equal(diff.Changed, []string{myKeyID}) || diff.Empty() {
+		t.Fatalf("Expected the key to be changed: %+v.", diff)
+	}
+	diff = DiffJWKS(JWKSMarshal{Keys: []JWKMarshal{key1}}, JWKSMarshal{Keys: []JWKMarshal{key1Padded}})
+	if !diff.Empty() {
+		t.Fatalf("Expected no differences: %+v.", diff)
+	}
+}
+
+func TestCheckUniqueKIDs(t *testing.T) {
+	sig := JWKMarshal{KTY: KtyOct, K: "AA", KID: myKeyID, USE: UseSig}
+	enc := JWKMarshal{KTY: KtyOct, K: "AA", KID: myKeyID, USE: UseEnc}
+	noUse := JWKMarshal{KTY: KtyOct, K: "AA", KID: myKeyID}
+	noKID := JWKMarshal{KTY: KtyOct, K: "AA"}
+	testCases := []struct {
+		name              string
+		keys              []JWKMarshal
+		allowDifferentUse bool
+		duplicate         bool
+	}{
+		{name: "Unique", keys: []JWKMarshal{sig, noKID, noKID}},
+		{name: "Duplicate", keys: []JWKMarshal{sig, sig}, allowDifferentUse: true, duplicate: true},
+		{name: "DifferentUse", keys: []JWKMarshal{sig, enc}, duplicate: true},
+		{name: "DifferentUseAllowed", keys: []JWKMarshal{sig, enc}, allowDifferentUse: true},
+		{name: "NoUse", keys: []JWKMarshal{noUse, enc}, allowDifferentUse: true, duplicate: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := JWKSMarshal{Keys: tc.keys}.CheckUniqueKIDs(tc.allowDifferentUse)
+			if errors.Is(err, ErrDuplicateKID) != tc.duplicate {
+				t.Fatalf("Unexpected result for duplicate key IDs: %v.", err)
+			}
+			if tc.duplicate && !strings.Contains(err.Error(), myKeyID) {
+				t.Fatalf("Expected the error to name the duplicate key ID, got %s.", err)
+			}
+		})
+	}
+
+	key := base64.RawURLEncoding.EncodeToString([]byte(hmacSecret))
+	raw := json.RawMessage(`{"keys":[{"kty":"oct","k":"` + key + `","kid":"a","use":"sig"},{"kty":"oct","k":"` + key + `","kid":"a","use":"enc"}]}`)
+	results, err := ValidateJWKS(raw, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to validate JWK Set. %s", err)
+	}
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Expected duplicate key IDs to be accepted by default: %+v", results)
+	}
+	results, err = ValidateJWKS(raw, JWKValidateOptions{RejectDuplicateKIDs: true})
+	if err != nil {
+		t.Fatalf("Failed to validate JWK Set. %s", err)
+	}
+	if results[0].Err != nil || !errors.Is(results[1].Err, ErrDuplicateKID) {
+		t.Fatalf("Expected only the second key to fail validation: %+v", results)
+	}
+	results, err = ValidateJWKS(raw, JWKValidateOptions{AllowDuplicateKIDDifferentUse: true, RejectDuplicateKIDs: true})
+	if err != nil {
+		t.Fatalf("Failed to validate JWK Set. %s", err)
+	}
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Expected a sig and enc pair to be accepted: %+v", results)
+	}
+}
+
+func TestValidateJWKS(t *testing.T) {
+	valid := newJWK(t, makeEdDSA(t), JWKOptions{Metadata: JWKMetadataOptions{KID: myKeyID}}).Marshal()
+	badCurve := JWKMarshal{KTY: KtyEC, CRV: CrvP256, X: "AA", Y: "AA", KID: "bad curve"}
+	rsaPublic := newJWK(t, &makeRSA(t).PublicKey, JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgRS256, KID: "rsa"}}).Marshal()
+	x5u := valid
+	x5u.KID = "x5u"
+	x5u.X5U = "https://example.com/cert.pem"
+	raw, err := json.Marshal(map[string]any{
+		"keys": []any{valid, badCurve, map[string]any{"kty": 1}, rsaPublic, x5u},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK Set. %s", err)
+	}
+
+	var fetched bool
+	results, err := ValidateJWKS(raw, JWKValidateOptions{
+		AllowedAlgs: []ALG{AlgEdDSA},
+		GetX5U: func(x5u *url.URL) ([]*x509.Certificate, error) {
+			fetched = true
+			return nil, ErrGetX5U
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to validate JWK Set. %s", err)
+	}
+	if fetched {
+		t.Fatalf("Expected no X5U request.")
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected a result for each key, got %d.", len(results))
+	}
+	for i, valid := range []bool{true, false, false, false, true} {
+		if results[i].Index != i || (results[i].Err == nil) != valid {
+			t.Fatalf("Unexpected result for key %d: %+v", i, results[i])
+		}
+	}
+	if results[1].KID != "bad curve" {
+		t.Fatalf("Unexpected result for the key with an invalid point: %+v", results[1])
+	}
+	if !errors.Is(results[3].Err, ErrJWKValidation) {
+		t.Fatalf("Unexpected result for the key with a disallowed algorithm: %+v", results[3])
+	}
+
+	_, err = ValidateJWKS(json.RawMessage(`{"kty":"oct"}`), JWKValidateOptions{})
+	if !errors.Is(err, ErrJWKValidation) {
+		t.Fatalf("Expected an error for JSON that is not a JWK Set, got %v.", err)
+	}
+	_, err = ValidateJWKS(json.RawMessage(`[`), JWKValidateOptions{})
+	if err == nil {
+		t.Fatalf("Expected an error for invalid JSON.")
+	}
+}
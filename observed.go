@@ -0,0 +1,202 @@
+package jwkset
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// StorageHooks are callbacks for NewObservedStorage. Each hook is called after the operation on the underlying
+// storage returns, with the duration of the operation and its error. A nil hook is not called.
+type StorageHooks struct {
+	// KeyDelete is called after KeyDelete.
+	KeyDelete func(ctx context.Context, keyID string, duration time.Duration, err error)
+
+	// KeyExists is called after KeyExists.
+	KeyExists func(ctx context.Context, keyID string, duration time.Duration, err error)
+
+	// KeyRead is called after KeyRead. A key that is not found has an error that wraps ErrKeyNotFound.
+	KeyRead func(ctx context.Context, keyID string, duration time.Duration, err error)
+
+	// KeyReadAll is called after KeyReadAll.
+	KeyReadAll func(ctx context.Context, duration time.Duration, err error)
+
+	// KeyReadAllPublic is called after KeyReadAllPublic.
+	KeyReadAllPublic func(ctx context.Context, duration time.Duration, err error)
+
+	// KeyReadByAlg is called after KeyReadByAlg.
+	KeyReadByAlg func(ctx context.Context, alg ALG, duration time.Duration, err error)
+
+	// KeyReadByUse is called after KeyReadByUse.
+	KeyReadByUse func(ctx context.Context, use USE, duration time.Duration, err error)
+
+	// KeyReadFunc is called after KeyReadFunc.
+	KeyReadFunc func(ctx context.Context, duration time.Duration, err error)
+
+	// KeyWrite is called after KeyWrite.
+	KeyWrite func(ctx context.Context, keyID string, duration time.Duration, err error)
+
+	// KeyWriteBatch is called after KeyWriteBatch with the key IDs of the batch, in order.
+	KeyWriteBatch func(ctx context.Context, keyIDs []string, duration time.Duration, err error)
+
+	// Logger is used to log a recovered panic in a hook.
+	//
+	// This defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+type observedStorage struct {
+	hooks StorageHooks
+	Storage
+}
+
+// NewObservedStorage creates a new Storage implementation that calls the given hooks around the operations of the
+// given storage, such as to record metrics. It works with any Storage, such as MemoryStorage, Redis, or SQL storage.
+//
+// The methods without a hook, such as JSON and Marshal, are passed through to the given storage unchanged. The returned
+// Storage implements io.Closer, which closes the given storage if it implements io.Closer. Other optional interfaces
+// of the given storage, such as RefreshStatusProvider, are not implemented by the returned Storage, but NewHTTPClient
+// still uses the Priority, refreshes, and rate limiter of wrapped storage from NewStorageFromHTTP. A panic in a hook is
+// recovered and logged to the Logger of the hooks.
+func NewObservedStorage(s Storage, hooks StorageHooks) Storage {
+	return observedStorage{
+		hooks:   hooks,
+		Storage: s,
+	}
+}
+
+func (o observedStorage) Close() error {
+	if closer, ok := o.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (o observedStorage) httpStorage() (*httpStorage, bool) {
+	return asHTTPStorage(o.Storage)
+}
+
+func (o observedStorage) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	start := time.Now()
+	ok, err = o.Storage.KeyDelete(ctx, keyID)
+	if o.hooks.KeyDelete != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyDelete", func() {
+			o.hooks.KeyDelete(ctx, keyID, duration, err)
+		})
+	}
+	return ok, err
+}
+
+func (o observedStorage) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	start := time.Now()
+	ok, err := o.Storage.KeyExists(ctx, keyID)
+	if o.hooks.KeyExists != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyExists", func() {
+			o.hooks.KeyExists(ctx, keyID, duration, err)
+		})
+	}
+	return ok, err
+}
+
+func (o observedStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	start := time.Now()
+	jwk, err := o.Storage.KeyRead(ctx, keyID)
+	if o.hooks.KeyRead != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyRead", func() {
+			o.hooks.KeyRead(ctx, keyID, duration, err)
+		})
+	}
+	return jwk, err
+}
+
+func (o observedStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	start := time.Now()
+	jwks, err := o.Storage.KeyReadAll(ctx)
+	if o.hooks.KeyReadAll != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyReadAll", func() {
+			o.hooks.KeyReadAll(ctx, duration, err)
+		})
+	}
+	return jwks, err
+}
+
+func (o observedStorage) KeyReadAllPublic(ctx context.Context) ([]JWK, error) {
+	start := time.Now()
+	jwks, err := o.Storage.KeyReadAllPublic(ctx)
+	if o.hooks.KeyReadAllPublic != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyReadAllPublic", func() {
+			o.hooks.KeyReadAllPublic(ctx, duration, err)
+		})
+	}
+	return jwks, err
+}
+
+func (o observedStorage) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	start := time.Now()
+	jwks, err := o.Storage.KeyReadByAlg(ctx, alg, inferred)
+	if o.hooks.KeyReadByAlg != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyReadByAlg", func() {
+			o.hooks.KeyReadByAlg(ctx, alg, duration, err)
+		})
+	}
+	return jwks, err
+}
+
+func (o observedStorage) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	start := time.Now()
+	jwks, err := o.Storage.KeyReadByUse(ctx, use)
+	if o.hooks.KeyReadByUse != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyReadByUse", func() {
+			o.hooks.KeyReadByUse(ctx, use, duration, err)
+		})
+	}
+	return jwks, err
+}
+
+func (o observedStorage) KeyReadFunc(ctx context.Context, f func(jwk JWK) bool) ([]JWK, error) {
+	start := time.Now()
+	jwks, err := o.Storage.KeyReadFunc(ctx, f)
+	if o.hooks.KeyReadFunc != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyReadFunc", func() {
+			o.hooks.KeyReadFunc(ctx, duration, err)
+		})
+	}
+	return jwks, err
+}
+
+func (o observedStorage) KeyWrite(ctx context.Context, jwk JWK) error {
+	start := time.Now()
+	err := o.Storage.KeyWrite(ctx, jwk)
+	if o.hooks.KeyWrite != nil {
+		duration := time.Since(start)
+		runHook(ctx, o.hooks.Logger, "KeyWrite", func() {
+			o.hooks.KeyWrite(ctx, jwk.Marshal().KID, duration, err)
+		})
+	}
+	return err
+}
+
+func (o observedStorage) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	start := time.Now()
+	err := o.Storage.KeyWriteBatch(ctx, jwks)
+	if o.hooks.KeyWriteBatch != nil {
+		duration := time.Since(start)
+		keyIDs := make([]string, len(jwks))
+		for i, jwk := range jwks {
+			keyIDs[i] = jwk.Marshal().KID
+		}
+		runHook(ctx, o.hooks.Logger, "KeyWriteBatch", func() {
+			o.hooks.KeyWriteBatch(ctx, keyIDs, duration, err)
+		})
+	}
+	return err
+}
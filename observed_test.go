@@ -0,0 +1,194 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestObservedStorage(t *testing.T) {
+	ctx := context.Background()
+	counts := make(map[string]int)
+	var lastErr error
+	var lastKID string
+	record := func(op string) func(ctx context.Context, keyID string, duration time.Duration, err error) {
+		return func(ctx context.Context, keyID string, duration time.Duration, err error) {
+			counts[op]++
+			lastKID = keyID
+			lastErr = err
+		}
+	}
+	store := NewObservedStorage(NewMemoryStorage(), StorageHooks{
+		KeyDelete: record("delete"),
+		KeyExists: record("exists"),
+		KeyRead:   record("read"),
+		KeyReadAll: func(ctx context.Context, duration time.Duration, err error) {
+			counts["readAll"]++
+		},
+		KeyReadAllPublic: func(ctx context.Context, duration time.Duration, err error) {
+			counts["readAllPublic"]++
+		},
+		KeyReadByAlg: func(ctx context.Context, alg ALG, duration time.Duration, err error) {
+			counts["readByAlg"]++
+		},
+		KeyReadByUse: func(ctx context.Context, use USE, duration time.Duration, err error) {
+			counts["readByUse"]++
+		},
+		KeyReadFunc: func(ctx context.Context, duration time.Duration, err error) {
+			counts["readFunc"]++
+		},
+		KeyWrite: record("write"),
+		KeyWriteBatch: func(ctx context.Context, keyIDs []string, duration time.Duration, err error) {
+			counts["writeBatch"]++
+			lastKID = strings.Join(keyIDs, ",")
+			lastErr = err
+		},
+	})
+
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten))
+	if counts["write"] != 1 || lastKID != kidWritten || lastErr != nil {
+		t.Fatalf("Expected the write to be observed. %v", counts)
+	}
+	err := store.KeyWriteBatch(ctx, []JWK{newStorageTestJWK(t, hmacKey2, kidWritten2), newStorageTestJWK(t, hmacKey2, kidWritten)})
+	if err != nil {
+		t.Fatalf("Failed to write keys. %s", err)
+	}
+	if counts["writeBatch"] != 1 || counts["write"] != 1 || lastKID != kidWritten2+","+kidWritten || lastErr != nil {
+		t.Fatalf("Expected the batch write to be observed. %v", counts)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v.", err)
+	}
+	if counts["read"] != 2 || lastKID != kidMissing || !errors.Is(lastErr, ErrKeyNotFound) {
+		t.Fatalf("Expected both reads to be observed. %v", counts)
+	}
+	_, err = store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read all keys. %s", err)
+	}
+	_, err = store.KeyDelete(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to delete key. %s", err)
+	}
+	if counts["readAll"] != 1 || counts["delete"] != 1 {
+		t.Fatalf("Expected the read all and delete to be observed. %v", counts)
+	}
+	_, err = store.KeyExists(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Failed to check key existence. %s", err)
+	}
+	if counts["exists"] != 1 || lastKID != kidWritten2 {
+		t.Fatalf("Expected the existence check to be observed. %v", counts)
+	}
+	_, err = store.KeyReadAllPublic(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read all public keys. %s", err)
+	}
+	_, err = store.KeyReadByAlg(ctx, AlgHS256, false)
+	if err != nil {
+		t.Fatalf("Failed to read keys by algorithm. %s", err)
+	}
+	_, err = store.KeyReadByUse(ctx, UseSig)
+	if err != nil {
+		t.Fatalf("Failed to read keys by use. %s", err)
+	}
+	_, err = store.KeyReadFunc(ctx, func(jwk JWK) bool { return true })
+	if err != nil {
+		t.Fatalf("Failed to read keys by function. %s", err)
+	}
+	for _, op := range []string{"readAllPublic", "readByAlg", "readByUse", "readFunc"} {
+		if counts[op] != 1 {
+			t.Fatalf("Expected %s to be observed. %v", op, counts)
+		}
+	}
+
+	writeKeys(ctx, t, store, newStorageTestJWK(t, makeEdDSA(t), kidWritten2))
+	jwks, err := store.Marshal(ctx)
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK Set. %s", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KID != kidWritten2 {
+		t.Fatalf("Expected Marshal to be passed through to the storage.")
+	}
+
+	var buf bytes.Buffer
+	store = NewObservedStorage(NewMemoryStorage(), StorageHooks{
+		KeyRead: func(ctx context.Context, keyID string, duration time.Duration, err error) {
+			panic("hook panic")
+		},
+		Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+	})
+	_, err = store.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound despite a panicking hook, got %v.", err)
+	}
+	if !strings.Contains(buf.String(), "hook panic") {
+		t.Fatalf("Expected the panic to be logged to the given logger, got %q.", buf.String())
+	}
+}
+
+func TestObservedStorageHTTPClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	httpStore, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:      ctx,
+		Priority: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	store := NewObservedStorage(httpStore, StorageHooks{})
+
+	httpURLs := map[string]Storage{server.URL: store, "https://example.com": NewMemoryStorage()}
+	if ordered := orderHTTPURLs(httpURLs); ordered[0].url != server.URL {
+		t.Fatalf("Expected the Priority of the wrapped HTTP storage to be used, got %q first.", ordered[0].url)
+	}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:           map[string]Storage{server.URL: store},
+		RefreshRateLimiter: limiter,
+		RefreshUnknownKID:  rate.NewLimiter(rate.Inf, 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	if httpStore.(*httpStorage).limiter.Load() != limiter {
+		t.Fatalf("Expected the RefreshRateLimiter to be set on the wrapped HTTP storage.")
+	}
+
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	_, err = c.KeyRead(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Expected the unknown key ID to refresh the wrapped HTTP storage. %s", err)
+	}
+}
@@ -0,0 +1,104 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrOIDCDiscovery indicates that the OpenID Connect discovery document could not be used to locate the JWK Set.
+	ErrOIDCDiscovery = errors.New("failed to perform OpenID Connect discovery")
+)
+
+// oidcDiscoveryMaxBytes is the size limit of the discovery document when the MaxResponseBytes option is not set.
+const oidcDiscoveryMaxBytes = 1 << 20
+
+// oidcDiscovery is the subset of the OpenID Provider Metadata used to locate the JWK Set.
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// NewStorageFromOIDCDiscovery creates a new Storage implementation for the JWK Set of an OpenID Connect issuer. The
+// discovery document at <issuer>/.well-known/openid-configuration is fetched with the given context, and its jwks_uri
+// is passed to NewStorageFromHTTP with the given options.
+//
+// The Client, Header, HTTPTimeout, MaxResponseBytes, RequestAuthorizer, and UserAgent options are also used for the
+// discovery request. If the MaxResponseBytes option is 0, the discovery document is limited to 1 MiB. The issuer in the
+// discovery document must exactly match the given issuer.
+func NewStorageFromOIDCDiscovery(ctx context.Context, issuer string, options HTTPClientStorageOptions) (Storage, error) {
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	timeout := options.HTTPTimeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	maxBytes := options.MaxResponseBytes
+	if maxBytes <= 0 {
+		maxBytes = oidcDiscoveryMaxBytes
+	}
+	userAgent := options.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	discoveryURL, err := url.ParseRequestURI(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issuer %q: %w", issuer, errors.Join(ErrOIDCDiscovery, err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request for OpenID Connect discovery: %w", errors.Join(ErrOIDCDiscovery, err))
+	}
+	addHeader(req, options.Header, userAgent)
+	if options.RequestAuthorizer != nil {
+		err = options.RequestAuthorizer(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authorize HTTP request for OpenID Connect discovery: %w", errors.Join(ErrOIDCDiscovery, err))
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform HTTP request for OpenID Connect discovery: %w", errors.Join(ErrOIDCDiscovery, err))
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d", errors.Join(ErrOIDCDiscovery, ErrInvalidHTTPStatusCode), resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenID Connect discovery document: %w", errors.Join(ErrOIDCDiscovery, err))
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("%w: limit is %d bytes", errors.Join(ErrOIDCDiscovery, ErrResponseTooLarge), maxBytes)
+	}
+	var discovery oidcDiscovery
+	err = json.Unmarshal(body, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode OpenID Connect discovery document: %w", errors.Join(ErrOIDCDiscovery, err))
+	}
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("%w: discovery document issuer %q does not match the requested issuer %q", ErrOIDCDiscovery, discovery.Issuer, issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document for issuer %q has no jwks_uri", ErrOIDCDiscovery, issuer)
+	}
+	jwksURI, err := url.ParseRequestURI(discovery.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jwks_uri %q: %w", discovery.JWKSURI, errors.Join(ErrOIDCDiscovery, err))
+	}
+	return NewStorageFromHTTP(jwksURI, options)
+}
@@ -0,0 +1,111 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewStorageFromOIDCDiscovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStorage()
+	writeKeys(ctx, t, store, newStorageTestJWK(t, []byte(hmacKey1), kidWritten))
+	rawJWKS, err := store.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get the JSON. %s", err)
+	}
+
+	var discovery oidcDiscovery
+	var authorization string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_ = json.NewEncoder(w).Encode(discovery)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(rawJWKS)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testCases := []struct {
+		name          string
+		discovery     oidcDiscovery
+		options       HTTPClientStorageOptions
+		authorization string
+		expected      error
+	}{
+		{
+			name:      "Valid",
+			discovery: oidcDiscovery{Issuer: server.URL, JWKSURI: server.URL + "/jwks"},
+		},
+		{
+			name:      "IssuerMismatch",
+			discovery: oidcDiscovery{Issuer: "https://example.com", JWKSURI: server.URL + "/jwks"},
+			expected:  ErrOIDCDiscovery,
+		},
+		{
+			name:      "MissingJWKSURI",
+			discovery: oidcDiscovery{Issuer: server.URL},
+			expected:  ErrOIDCDiscovery,
+		},
+		{
+			name:      "RequestAuthorizer",
+			discovery: oidcDiscovery{Issuer: server.URL, JWKSURI: server.URL + "/jwks"},
+			options: HTTPClientStorageOptions{
+				RequestAuthorizer: func(ctx context.Context, req *http.Request) error {
+					req.Header.Set("Authorization", "Bearer token")
+					return nil
+				},
+			},
+			authorization: "Bearer token",
+		},
+		{
+			name:      "RequestAuthorizerError",
+			discovery: oidcDiscovery{Issuer: server.URL, JWKSURI: server.URL + "/jwks"},
+			options: HTTPClientStorageOptions{
+				RequestAuthorizer: func(ctx context.Context, req *http.Request) error {
+					return errors.New("no token")
+				},
+			},
+			expected: ErrOIDCDiscovery,
+		},
+		{
+			name:      "TooLarge",
+			discovery: oidcDiscovery{Issuer: server.URL, JWKSURI: server.URL + "/jwks"},
+			options: HTTPClientStorageOptions{
+				MaxResponseBytes: 10,
+			},
+			expected: ErrResponseTooLarge,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			discovery = tc.discovery
+			authorization = ""
+			tc.options.Ctx = ctx
+			s, err := NewStorageFromOIDCDiscovery(ctx, server.URL, tc.options)
+			if tc.expected != nil {
+				if !errors.Is(err, tc.expected) || !errors.Is(err, ErrOIDCDiscovery) {
+					t.Fatalf("Expected error %s, got %s.", tc.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to create storage from OpenID Connect discovery. %s", err)
+			}
+			if authorization != tc.authorization {
+				t.Fatalf("Expected the discovery request to have Authorization %q, got %q.", tc.authorization, authorization)
+			}
+			_, err = s.KeyRead(ctx, kidWritten)
+			if err != nil {
+				t.Fatalf("Failed to read key. %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package jwkset
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+var (
+	// ErrPEMDecrypt indicates that an encrypted PEM block could not be decrypted, such as due to a wrong passphrase or
+	// an unsupported encryption scheme.
+	ErrPEMDecrypt = errors.New("failed to decrypt PEM block")
+	// ErrPEMPassphrase indicates that a PEM block is encrypted, but no PEMDecrypter was supplied.
+	ErrPEMPassphrase = errors.New("PEM block is encrypted and requires a passphrase")
+)
+
+// PEMDecrypter decrypts an encrypted PEM block, such as an ENCRYPTED PRIVATE KEY block, and returns the DER bytes of
+// the decrypted key. The DER bytes of an ENCRYPTED PRIVATE KEY block must be a PKCS#8 private key. For other encrypted
+// blocks, such as legacy blocks with a Proc-Type header, they must be in the format of the block type.
+type PEMDecrypter func(block *pem.Block) (der []byte, err error)
+
+// maxPBKDF2Iterations limits the work done for an encrypted PKCS#8 private key.
+const maxPBKDF2Iterations = 10_000_000
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// https://www.rfc-editor.org/rfc/rfc5958#section-3
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// https://www.rfc-editor.org/rfc/rfc8018#appendix-A.4
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// https://www.rfc-editor.org/rfc/rfc8018#appendix-A.2
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// PKCS8Passphrase returns a PEMDecrypter for ENCRYPTED PRIVATE KEY blocks that are encrypted with the given passphrase
+// using PBES2, such as those created by "openssl pkcs8 -topk8". The key derivation function must be PBKDF2 with
+// HMAC-SHA-1, HMAC-SHA-224, HMAC-SHA-256, HMAC-SHA-384, or HMAC-SHA-512, and the encryption scheme must be AES-CBC.
+// Legacy PEM encryption with a Proc-Type header and PBES1 are not supported.
+// https://www.rfc-editor.org/rfc/rfc8018#section-6.2
+func PKCS8Passphrase(passphrase []byte) PEMDecrypter {
+	return func(block *pem.Block) ([]byte, error) {
+		if block.Type != "ENCRYPTED PRIVATE KEY" {
+			return nil, fmt.Errorf("%w: unsupported PEM block type %q", ErrPEMDecrypt, block.Type)
+		}
+		var info encryptedPrivateKeyInfo
+		rest, err := asn1.Unmarshal(block.Bytes, &info)
+		if err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("%w: malformed encrypted private key", ErrPEMDecrypt)
+		}
+		if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+			return nil, fmt.Errorf("%w: unsupported encryption algorithm %s", ErrPEMDecrypt, info.EncryptionAlgorithm.Algorithm)
+		}
+		var params pbes2Params
+		_, err = asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed PBES2 parameters", ErrPEMDecrypt)
+		}
+
+		var keyLen int
+		switch scheme := params.EncryptionScheme.Algorithm; {
+		case scheme.Equal(oidAES128CBC):
+			keyLen = 16
+		case scheme.Equal(oidAES192CBC):
+			keyLen = 24
+		case scheme.Equal(oidAES256CBC):
+			keyLen = 32
+		default:
+			return nil, fmt.Errorf("%w: unsupported encryption scheme %s", ErrPEMDecrypt, scheme)
+		}
+		var iv []byte
+		_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+		if err != nil || len(iv) != aes.BlockSize {
+			return nil, fmt.Errorf("%w: malformed AES-CBC initialization vector", ErrPEMDecrypt)
+		}
+
+		if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+			return nil, fmt.Errorf("%w: unsupported key derivation function %s", ErrPEMDecrypt, params.KeyDerivationFunc.Algorithm)
+		}
+		var kdf pbkdf2Params
+		_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed PBKDF2 parameters", ErrPEMDecrypt)
+		}
+		if kdf.IterationCount < 1 || kdf.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("%w: unsupported PBKDF2 iteration count %d", ErrPEMDecrypt, kdf.IterationCount)
+		}
+		if kdf.KeyLength != 0 && kdf.KeyLength != keyLen {
+			return nil, fmt.Errorf("%w: PBKDF2 key length %d does not match the encryption scheme", ErrPEMDecrypt, kdf.KeyLength)
+		}
+		var prf func() hash.Hash
+		switch alg := kdf.PRF.Algorithm; {
+		case len(alg) == 0, alg.Equal(oidHMACWithSHA1):
+			prf = sha1.New
+		case alg.Equal(oidHMACWithSHA224):
+			prf = sha256.New224
+		case alg.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		case alg.Equal(oidHMACWithSHA384):
+			prf = sha512.New384
+		case alg.Equal(oidHMACWithSHA512):
+			prf = sha512.New
+		default:
+			return nil, fmt.Errorf("%w: unsupported PBKDF2 pseudorandom function %s", ErrPEMDecrypt, alg)
+		}
+
+		key := pbkdf2Key(prf, passphrase, kdf.Salt, kdf.IterationCount, keyLen)
+		der, err := decryptAESCBC(key, iv, info.EncryptedData)
+		if err != nil {
+			return nil, fmt.Errorf("%w: wrong passphrase or corrupted data", ErrPEMDecrypt)
+		}
+		return der, nil
+	}
+}
+
+// pbkdf2Key derives a key with PBKDF2. https://www.rfc-editor.org/rfc/rfc8018#section-5.2
+func pbkdf2Key(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	dk := make([]byte, 0, blocks*hashLen)
+	var counter [4]byte
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// decryptAESCBC decrypts AES-CBC ciphertext and removes its PKCS#7 padding.
+func decryptAESCBC(key, iv, ciphertext []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a multiple of the block size")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,43 @@
+package jwkset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrReadOnly is returned when writing to or deleting from a Storage created by NewReadOnlyStorage.
+var ErrReadOnly = errors.New("storage is read-only")
+
+type readOnlyStorage struct {
+	Storage
+}
+
+// NewReadOnlyStorage creates a new Storage implementation that passes reads through to the given storage, but
+// returns ErrReadOnly from KeyDelete, KeyWrite, and KeyWriteBatch, such as to hand out a fixed set of trusted keys
+// that must not be mutated. The given storage can still be mutated directly.
+//
+// The returned Storage implements io.Closer, which closes the given storage if it implements io.Closer.
+func NewReadOnlyStorage(s Storage) Storage {
+	return readOnlyStorage{
+		Storage: s,
+	}
+}
+
+func (r readOnlyStorage) Close() error {
+	if closer, ok := r.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (r readOnlyStorage) KeyDelete(_ context.Context, keyID string) (ok bool, err error) {
+	return false, fmt.Errorf("%w: cannot delete key with ID %q", ErrReadOnly, keyID)
+}
+func (r readOnlyStorage) KeyWrite(_ context.Context, jwk JWK) error {
+	return fmt.Errorf("%w: cannot write key with ID %q", ErrReadOnly, jwk.Marshal().KID)
+}
+func (r readOnlyStorage) KeyWriteBatch(_ context.Context, _ []JWK) error {
+	return fmt.Errorf("%w: cannot write keys", ErrReadOnly)
+}
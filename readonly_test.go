@@ -0,0 +1,39 @@
+package jwkset
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReadOnlyStorage(t *testing.T) {
+	ctx := context.Background()
+	backing := NewMemoryStorage()
+	writeKeys(ctx, t, backing, newStorageTestJWK(t, hmacKey1, kidWritten))
+	store := NewReadOnlyStorage(backing)
+
+	err := store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected ErrReadOnly for KeyWrite, got %v.", err)
+	}
+	err = store.KeyWriteBatch(ctx, []JWK{newStorageTestJWK(t, hmacKey2, kidWritten2)})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected ErrReadOnly for KeyWriteBatch, got %v.", err)
+	}
+	ok, err := store.KeyDelete(ctx, kidWritten)
+	if !errors.Is(err, ErrReadOnly) || ok {
+		t.Fatalf("Expected ErrReadOnly for KeyDelete, got %v.", err)
+	}
+
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Expected the key to remain readable. %s", err)
+	}
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read all keys. %s", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("Expected the read-only storage to not be mutated, got %d keys.", len(keys))
+	}
+}
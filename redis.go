@@ -0,0 +1,238 @@
+package jwkset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RedisClient is the subset of Redis commands used by the Storage returned from NewStorageFromRedis. This package
+// does not depend on a Redis driver, so a small adapter is needed for the driver of your choice. For example, with
+// github.com/redis/go-redis/v9 the Get method would call client.Get and report redis.Nil as ok being false.
+type RedisClient interface {
+	// Del deletes the given key. It reports whether the key existed.
+	Del(ctx context.Context, key string) (ok bool, err error)
+	// Get returns the value of the given key. It reports ok as false if the key does not exist.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Scan performs one iteration of the SCAN command with the given MATCH pattern and COUNT hint.
+	Scan(ctx context.Context, cursor uint64, match string, count int64) (keys []string, next uint64, err error)
+	// Set sets the value of the given key. A zero ttl means the key does not expire.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// RedisBatchClient is an optional extension of RedisClient. If the client implements it, KeyWriteBatch sets all keys
+// at once, such as with a MULTI/EXEC transaction, so other instances never observe part of the batch.
+type RedisBatchClient interface {
+	// SetBatch sets the value of each given key with the same ttl. Either all keys are set, or an error is returned and
+	// none are. A zero ttl means the keys do not expire.
+	SetBatch(ctx context.Context, values map[string][]byte, ttl time.Duration) error
+}
+
+// RedisStorageOptions are used to configure the behavior of NewStorageFromRedis.
+type RedisStorageOptions struct {
+	// Namespace is prepended to the key ID to create the Redis key for each JWK.
+	//
+	// This defaults to "jwkset:".
+	Namespace string
+
+	// ScanCount is the COUNT hint used when scanning the namespace in KeyReadAll.
+	//
+	// This defaults to 100.
+	ScanCount int64
+
+	// TTL is the expiration set on each JWK when it is written. A zero value means keys do not expire.
+	TTL time.Duration
+}
+
+var _ Storage = redisStorage{}
+
+type redisStorage struct {
+	client  RedisClient
+	options RedisStorageOptions
+	snapshotStorage
+}
+
+// NewStorageFromRedis creates a new Storage implementation that stores each JWK as JSON under a namespaced Redis key.
+// Writes performed by one instance are immediately visible to all other instances sharing the Redis server.
+//
+// KeyWriteBatch uses SetBatch if the client implements RedisBatchClient. Otherwise, the keys are set one at a time and,
+// if a write fails, the previous values are restored on a best-effort basis. In that case, other instances may observe
+// part of the batch while it is written.
+//
+// The stored JSON contains private key material, so the Redis server should be protected accordingly.
+func NewStorageFromRedis(client RedisClient, options RedisStorageOptions) (Storage, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: Redis client is required", ErrOptions)
+	}
+	if options.Namespace == "" {
+		options.Namespace = "jwkset:"
+	}
+	if options.ScanCount == 0 {
+		options.ScanCount = 100
+	}
+	s := redisStorage{
+		client:  client,
+		options: options,
+	}
+	s.snapshotStorage = snapshotStorage{keyReadAll: s.KeyReadAll}
+	return s, nil
+}
+
+func (s redisStorage) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	ok, err = s.client.Del(ctx, s.options.Namespace+keyID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete key with ID %q from Redis: %w", keyID, err)
+	}
+	return ok, nil
+}
+func (s redisStorage) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	_, ok, err := s.client.Get(ctx, s.options.Namespace+keyID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for key with ID %q in Redis: %w", keyID, err)
+	}
+	return ok, nil
+}
+func (s redisStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	raw, ok, err := s.client.Get(ctx, s.options.Namespace+keyID)
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to read key with ID %q from Redis: %w", keyID, err)
+	}
+	if !ok {
+		return JWK{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, keyID)
+	}
+	return storedUnmarshal(raw)
+}
+func (s redisStorage) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	jwks, err := s.KeyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterByAlg(jwks, alg, inferred), nil
+}
+func (s redisStorage) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	jwks, err := s.KeyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterByUse(jwks, use), nil
+}
+func (s redisStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	var jwks []JWK
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, s.options.Namespace+"*", s.options.ScanCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Redis namespace %q: %w", s.options.Namespace, err)
+		}
+		for _, key := range keys {
+			raw, ok, err := s.client.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Redis key %q: %w", key, err)
+			}
+			if !ok {
+				continue // Expired or deleted since the scan.
+			}
+			jwk, err := storedUnmarshal(raw)
+			if err != nil {
+				return nil, err
+			}
+			jwks = append(jwks, jwk)
+		}
+		cursor = next
+		if cursor == 0 {
+			return jwks, nil
+		}
+	}
+}
+func (s redisStorage) KeyWrite(ctx context.Context, jwk JWK) error {
+	raw, err := storedMarshal(jwk)
+	if err != nil {
+		return err
+	}
+	err = s.client.Set(ctx, s.options.Namespace+jwk.Marshal().KID, raw, s.options.TTL)
+	if err != nil {
+		return fmt.Errorf("failed to write key with ID %q to Redis: %w", jwk.Marshal().KID, err)
+	}
+	return nil
+}
+func (s redisStorage) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	values := make(map[string][]byte, len(jwks))
+	order := make([]string, 0, len(jwks))
+	for _, jwk := range jwks {
+		raw, err := storedMarshal(jwk)
+		if err != nil {
+			return err
+		}
+		key := s.options.Namespace + jwk.Marshal().KID
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] = raw
+	}
+	if batch, ok := s.client.(RedisBatchClient); ok {
+		err := batch.SetBatch(ctx, values, s.options.TTL)
+		if err != nil {
+			return fmt.Errorf("failed to write batch of %d keys to Redis: %w", len(values), err)
+		}
+		return nil
+	}
+
+	type previous struct {
+		key   string
+		value []byte
+		ok    bool
+	}
+	written := make([]previous, 0, len(order))
+	for _, key := range order {
+		value, ok, err := s.client.Get(ctx, key)
+		if err == nil {
+			written = append(written, previous{key: key, value: value, ok: ok})
+			err = s.client.Set(ctx, key, values[key], s.options.TTL)
+		}
+		if err != nil {
+			var rollbackErrs []error
+			for _, p := range written {
+				var rollbackErr error
+				if p.ok {
+					rollbackErr = s.client.Set(ctx, p.key, p.value, s.options.TTL)
+				} else {
+					_, rollbackErr = s.client.Del(ctx, p.key)
+				}
+				rollbackErrs = append(rollbackErrs, rollbackErr)
+			}
+			return fmt.Errorf("failed to write Redis key %q of batch: %w", key, errors.Join(append([]error{err}, rollbackErrs...)...))
+		}
+	}
+	return nil
+}
+
+// storedMarshal marshals the JWK, including private key material, for Storage implementations that persist each JWK as
+// JSON.
+func storedMarshal(jwk JWK) ([]byte, error) {
+	options := jwk.options
+	options.Marshal.Private = true
+	marshal, err := keyMarshal(jwk.Key(), options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key with ID %q: %w", jwk.Marshal().KID, err)
+	}
+	marshal.Extra = jwk.marshal.Extra
+	raw, err := json.Marshal(marshal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to JSON marshal key with ID %q: %w", jwk.Marshal().KID, err)
+	}
+	return raw, nil
+}
+
+// storedUnmarshal creates a JWK from JSON created by storedMarshal.
+func storedUnmarshal(raw []byte) (JWK, error) {
+	marshalOptions := JWKMarshalOptions{
+		Private: true,
+	}
+	jwk, err := NewJWKFromRawJSON(raw, marshalOptions, JWKValidateOptions{})
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to create JWK from stored JSON: %w", err)
+	}
+	return jwk, nil
+}
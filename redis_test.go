@@ -0,0 +1,213 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type redisTestClient struct {
+	mux  sync.Mutex
+	data map[string][]byte
+	ttls map[string]time.Duration
+}
+
+func (r *redisTestClient) Del(_ context.Context, key string) (ok bool, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	_, ok = r.data[key]
+	delete(r.data, key)
+	return ok, nil
+}
+func (r *redisTestClient) Get(_ context.Context, key string) (value []byte, ok bool, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	value, ok = r.data[key]
+	return value, ok, nil
+}
+func (r *redisTestClient) Scan(_ context.Context, cursor uint64, match string, count int64) (keys []string, next uint64, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var all []string
+	for key := range r.data {
+		ok, _ := path.Match(match, key)
+		if ok {
+			all = append(all, key)
+		}
+	}
+	slices.Sort(all)
+	end := min(cursor+uint64(count), uint64(len(all)))
+	if end < uint64(len(all)) {
+		next = end
+	}
+	return all[cursor:end], next, nil
+}
+func (r *redisTestClient) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.data[key] = value
+	r.ttls[key] = ttl
+	return nil
+}
+
+func TestRedisStorage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	client := &redisTestClient{
+		data: make(map[string][]byte),
+		ttls: make(map[string]time.Duration),
+	}
+	client.data["other:key"] = []byte("not a JWK")
+	options := RedisStorageOptions{
+		ScanCount: 1,
+		TTL:       time.Hour,
+	}
+	store, err := NewStorageFromRedis(client, options)
+	if err != nil {
+		t.Fatalf("Failed to create Redis storage. %s", err)
+	}
+
+	err = store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey1, kidWritten))
+	if err != nil {
+		t.Fatalf("Failed to write key. %s", err)
+	}
+	err = store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	if err != nil {
+		t.Fatalf("Failed to write key. %s", err)
+	}
+	if client.ttls["jwkset:"+kidWritten] != time.Hour {
+		t.Fatalf("Expected TTL to be set on written key.")
+	}
+
+	key, err := store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey1) {
+		t.Fatalf("Read key does not match written key.")
+	}
+	ok, err := store.KeyExists(ctx, kidWritten)
+	if err != nil || !ok {
+		t.Fatalf("Expected the written key to exist. %v", err)
+	}
+	ok, err = store.KeyExists(ctx, kidMissing)
+	if err != nil || ok {
+		t.Fatalf("Expected the missing key to not exist. %v", err)
+	}
+	_, err = store.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Should have specific error when reading missing key.\n  Actual: %s\n  Expected: %s", err, ErrKeyNotFound)
+	}
+
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Snapshot should have 2 keys. %d", len(keys))
+	}
+
+	ok, err = store.KeyDelete(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to delete key. %s", err)
+	}
+	if !ok {
+		t.Fatalf("Expected key to be deleted.")
+	}
+	_, err = store.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create JSON. %s", err)
+	}
+}
+
+type redisTestBatchClient struct {
+	*redisTestClient
+	batches int
+}
+
+func (r *redisTestBatchClient) SetBatch(ctx context.Context, values map[string][]byte, ttl time.Duration) error {
+	r.batches++
+	for key, value := range values {
+		err := r.Set(ctx, key, value, ttl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type redisTestFailingClient struct {
+	*redisTestClient
+	failKey string
+}
+
+func (r *redisTestFailingClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if key == r.failKey {
+		return errStorage
+	}
+	return r.redisTestClient.Set(ctx, key, value, ttl)
+}
+
+func TestRedisStorageKeyWriteBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	batchClient := &redisTestBatchClient{
+		redisTestClient: &redisTestClient{
+			data: make(map[string][]byte),
+			ttls: make(map[string]time.Duration),
+		},
+	}
+	store, err := NewStorageFromRedis(batchClient, RedisStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create Redis storage. %s", err)
+	}
+	err = store.KeyWriteBatch(ctx, []JWK{
+		newStorageTestJWK(t, hmacKey1, kidWritten),
+		newStorageTestJWK(t, hmacKey2, kidWritten2),
+	})
+	if err != nil {
+		t.Fatalf("Failed to write batch. %s", err)
+	}
+	if batchClient.batches != 1 || len(batchClient.data) != 2 {
+		t.Fatalf("Expected the batch to be written with one SetBatch call.")
+	}
+
+	failingClient := &redisTestFailingClient{
+		redisTestClient: &redisTestClient{
+			data: make(map[string][]byte),
+			ttls: make(map[string]time.Duration),
+		},
+		failKey: "jwkset:" + kidMissing,
+	}
+	store, err = NewStorageFromRedis(failingClient, RedisStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create Redis storage. %s", err)
+	}
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten))
+	err = store.KeyWriteBatch(ctx, []JWK{
+		newStorageTestJWK(t, hmacKey2, kidWritten),
+		newStorageTestJWK(t, hmacKey2, kidWritten2),
+		newStorageTestJWK(t, hmacKey2, kidMissing),
+	})
+	if !errors.Is(err, errStorage) {
+		t.Fatalf("Expected the write error, got %v.", err)
+	}
+	key, err := store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey1) {
+		t.Fatalf("Expected the overwritten key to be rolled back.")
+	}
+	_, err = store.KeyRead(ctx, kidWritten2)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected the added key to be rolled back, got %v.", err)
+	}
+}
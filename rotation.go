@@ -0,0 +1,307 @@
+package jwkset
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotationOptions are used to configure the behavior of NewRotationManager.
+type RotationOptions struct {
+	// ActiveKeys is the number of the newest keys that NextSigningKey selects from, such as 2 to spread signing across
+	// the current key and the previous key during a rotation overlap. It does not affect Current, and every key
+	// remains in the Storage for verification until it is deleted after the grace period.
+	//
+	// This defaults to 1.
+	ActiveKeys int
+
+	// Clock returns the current time. It is used for the key ID of each new key and to decide when keys are rotated
+	// and deleted.
+	//
+	// This defaults to time.Now.
+	Clock func() time.Time
+
+	// Ctx is used to end the rotation goroutine when it's no longer needed.
+	//
+	// This defaults to context.Background().
+	Ctx context.Context
+
+	// GenerateFunc creates each new key with the given key ID, instead of generating it with GenerateOptions, such as
+	// with NewJWKFromSigner for a key in a KMS or HSM. The returned key must have the given key ID.
+	GenerateFunc func(ctx context.Context, kid string) (JWK, error)
+
+	// GenerateOptions are used to generate each new key. The key ID is always set by the RotationManager.
+	GenerateOptions GenerateOptions
+
+	// GracePeriod is how long a retired key remains in the Storage after it was replaced by a new key, so that
+	// signatures made with it can still be verified. After the grace period, the key is deleted and its private key
+	// material is zeroed with JWK.Destroy.
+	//
+	// This defaults to 24 hours.
+	GracePeriod time.Duration
+
+	// Interval is the interval at which a new key is generated and made current. This option will launch a "rotation
+	// goroutine". If zero, keys are only rotated by calling Rotate, but retired keys are still deleted when it is
+	// called.
+	Interval time.Duration
+
+	// KIDPrefix is the prefix of the key ID of each key managed by the RotationManager. Keys in the Storage without
+	// this prefix are ignored.
+	//
+	// This defaults to "rotation-".
+	KIDPrefix string
+
+	// RotationErrorHandler is a function that consumes errors that happen in the rotation goroutine.
+	RotationErrorHandler func(ctx context.Context, err error)
+
+	// SigningWeight is the weight of an active key for NextSigningKey. Active keys are selected in proportion to their
+	// weights, and a key with a weight of zero or less is not selected.
+	//
+	// This defaults to a weight of 1 for each key.
+	SigningWeight func(jwk JWK) int
+}
+
+// RotationManager generates new signing keys at an interval and retires old keys after a grace period. Its state is
+// kept entirely in the Storage, because the key ID of each managed key contains its creation time. So a
+// RotationManager backed by a persistent Storage survives restarts, and multiple RotationManagers can share one
+// Storage.
+//
+// It is safe for concurrent use.
+type RotationManager struct {
+	mux     sync.Mutex
+	options RotationOptions
+	store   Storage
+	weights map[string]int // The current weight of each active key for NextSigningKey, by key ID.
+}
+
+// rotationKey is a key managed by a RotationManager.
+type rotationKey struct {
+	created time.Time
+	jwk     JWK
+}
+
+// NewRotationManager creates a new RotationManager for the given Storage. If the Storage has no current key, or the
+// current key is older than the Interval option, a new key is generated immediately.
+func NewRotationManager(store Storage, options RotationOptions) (*RotationManager, error) {
+	if store == nil {
+		return nil, fmt.Errorf("%w: Storage is required", ErrOptions)
+	}
+	if options.ActiveKeys < 0 {
+		return nil, fmt.Errorf("%w: ActiveKeys must not be negative", ErrOptions)
+	}
+	if options.ActiveKeys == 0 {
+		options.ActiveKeys = 1
+	}
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+	if options.Ctx == nil {
+		options.Ctx = context.Background()
+	}
+	if options.GracePeriod == 0 {
+		options.GracePeriod = 24 * time.Hour
+	}
+	if options.KIDPrefix == "" {
+		options.KIDPrefix = "rotation-"
+	}
+	if options.SigningWeight == nil {
+		options.SigningWeight = func(JWK) int {
+			return 1
+		}
+	}
+	r := &RotationManager{
+		options: options,
+		store:   store,
+		weights: make(map[string]int),
+	}
+	next, err := r.maintain(options.Ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform initial key rotation: %w", err)
+	}
+
+	if options.Interval != 0 {
+		go func() { // Rotation goroutine.
+			timer := time.NewTimer(next)
+			defer timer.Stop()
+			for {
+				select {
+				case <-options.Ctx.Done():
+					return
+				case <-timer.C:
+					next, err = r.maintain(options.Ctx, false)
+					if err != nil {
+						if options.RotationErrorHandler != nil {
+							options.RotationErrorHandler(options.Ctx, err)
+						}
+						next = options.Interval
+					}
+					timer.Reset(next)
+				}
+			}
+		}()
+	}
+
+	return r, nil
+}
+
+// Current returns the current signing key.
+func (r *RotationManager) Current(ctx context.Context) (JWK, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	keys, err := r.keys(ctx)
+	if err != nil {
+		return JWK{}, err
+	}
+	if len(keys) == 0 {
+		return JWK{}, fmt.Errorf("%w: no current key with key ID prefix %q", ErrKeyNotFound, r.options.KIDPrefix)
+	}
+	return keys[len(keys)-1].jwk, nil
+}
+
+// NextSigningKey returns the next signing key from the active keys, which are the newest keys as configured by the
+// ActiveKeys option. The keys are selected in a smooth weighted round-robin order using the SigningWeight option, so
+// that with weights of 3 and 1, the first key is selected 3 times out of every 4 calls, without being selected 3 times
+// in a row. In a RotationManager without the ActiveKeys option, it is equivalent to Current.
+func (r *RotationManager) NextSigningKey(ctx context.Context) (JWK, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	keys, err := r.keys(ctx)
+	if err != nil {
+		return JWK{}, err
+	}
+	active := keys[max(0, len(keys)-r.options.ActiveKeys):]
+
+	// Smooth weighted round-robin, as used by nginx. Each active key accumulates its weight on every call, and the key
+	// with the highest accumulated weight is selected and reduced by the total weight. The accumulated weights of keys
+	// that are no longer active are dropped.
+	weights := make(map[string]int, len(active))
+	total := 0
+	selected := ""
+	var jwk JWK
+	for _, key := range active {
+		weight := r.options.SigningWeight(key.jwk)
+		if weight <= 0 {
+			continue
+		}
+		kid := key.jwk.Marshal().KID
+		weights[kid] = r.weights[kid] + weight
+		total += weight
+		if selected == "" || weights[kid] > weights[selected] {
+			selected = kid
+			jwk = key.jwk
+		}
+	}
+	if selected == "" {
+		return JWK{}, fmt.Errorf("%w: no active signing key with key ID prefix %q", ErrKeyNotFound, r.options.KIDPrefix)
+	}
+	weights[selected] -= total
+	r.weights = weights
+	return jwk, nil
+}
+
+// Rotate generates a new key and makes it current, regardless of the Interval option. The previous key is retired
+// and deleted after the grace period.
+func (r *RotationManager) Rotate(ctx context.Context) error {
+	_, err := r.maintain(ctx, true)
+	return err
+}
+
+// maintain generates a new key if forced or if the current key is due for rotation, then deletes retired keys past
+// the grace period. It returns the duration until it needs to be called again.
+func (r *RotationManager) maintain(ctx context.Context, force bool) (time.Duration, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	keys, err := r.keys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := r.options.Clock()
+	if force || len(keys) == 0 || (r.options.Interval != 0 && now.Sub(keys[len(keys)-1].created) >= r.options.Interval) {
+		key, err := r.generate(ctx, now)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	next := r.options.Interval
+	if next != 0 {
+		next -= now.Sub(keys[len(keys)-1].created)
+	}
+	for i, key := range keys[:len(keys)-1] {
+		retired := keys[i+1].created
+		expires := retired.Add(r.options.GracePeriod)
+		if now.Before(expires) {
+			if until := expires.Sub(now); next == 0 || until < next {
+				next = until
+			}
+			continue
+		}
+		_, err = r.store.KeyDelete(ctx, key.jwk.Marshal().KID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete retired key with ID %q: %w", key.jwk.Marshal().KID, err)
+		}
+		key.jwk.Destroy()
+	}
+	return next, nil
+}
+
+// generate creates a new key with a key ID that contains the given creation time and writes it to the Storage.
+func (r *RotationManager) generate(ctx context.Context, created time.Time) (rotationKey, error) {
+	kid := r.options.KIDPrefix + strconv.FormatInt(created.UnixNano(), 10)
+	var jwk JWK
+	var err error
+	if r.options.GenerateFunc != nil {
+		jwk, err = r.options.GenerateFunc(ctx, kid)
+	} else {
+		options := r.options.GenerateOptions
+		options.Options.Metadata.KID = kid
+		jwk, err = GenerateJWK(options)
+	}
+	if err != nil {
+		return rotationKey{}, fmt.Errorf("failed to generate new key: %w", err)
+	}
+	if jwk.Marshal().KID != kid {
+		return rotationKey{}, fmt.Errorf("%w: generated key ID %q does not match %q", ErrOptions, jwk.Marshal().KID, kid)
+	}
+	err = r.store.KeyWrite(ctx, jwk)
+	if err != nil {
+		return rotationKey{}, fmt.Errorf("failed to write new key: %w", err)
+	}
+	return rotationKey{
+		created: created,
+		jwk:     jwk,
+	}, nil
+}
+
+// keys returns the keys managed by the RotationManager, oldest first.
+func (r *RotationManager) keys(ctx context.Context) ([]rotationKey, error) {
+	jwks, err := r.store.KeyReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys for rotation: %w", err)
+	}
+	var keys []rotationKey
+	for _, jwk := range jwks {
+		suffix, ok := strings.CutPrefix(jwk.Marshal().KID, r.options.KIDPrefix)
+		if !ok {
+			continue
+		}
+		nanos, err := strconv.ParseInt(suffix, 10, 64)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, rotationKey{
+			created: time.Unix(0, nanos),
+			jwk:     jwk,
+		})
+	}
+	slices.SortFunc(keys, func(a, b rotationKey) int {
+		return a.created.Compare(b.created)
+	})
+	return keys, nil
+}
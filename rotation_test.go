@@ -0,0 +1,291 @@
+package jwkset
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRotationManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStorage()
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten))
+	options := RotationOptions{
+		Ctx:             ctx,
+		GenerateOptions: GenerateOptions{KTY: KtyEC},
+		GracePeriod:     100 * time.Millisecond,
+	}
+	r, err := NewRotationManager(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	first, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	second, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	if second.Marshal().KID == first.Marshal().KID {
+		t.Fatalf("Expected a new current key after rotation.")
+	}
+	_, err = store.KeyRead(ctx, first.Marshal().KID)
+	if err != nil {
+		t.Fatalf("Expected the retired key to remain during the grace period. %s", err)
+	}
+
+	restarted, err := NewRotationManager(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	current, err := restarted.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	if current.Marshal().KID != second.Marshal().KID {
+		t.Fatalf("Expected the current key to survive a restart.")
+	}
+
+	time.Sleep(options.GracePeriod)
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	_, err = store.KeyRead(ctx, first.Marshal().KID)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected the retired key to be deleted after the grace period, got %s.", err)
+	}
+	if first.Key().(*ecdsa.PrivateKey).D.Sign() != 0 {
+		t.Fatalf("Expected the private key material of the deleted key to be zeroed.")
+	}
+	_, err = store.KeyRead(ctx, second.Marshal().KID)
+	if err != nil {
+		t.Fatalf("Expected the recently retired key to remain. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Expected unmanaged keys to be ignored. %s", err)
+	}
+}
+
+func TestRotationManagerInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStorage()
+	options := RotationOptions{
+		Ctx:             ctx,
+		GenerateOptions: GenerateOptions{KTY: KtyOct},
+		GracePeriod:     time.Hour,
+		Interval:        50 * time.Millisecond,
+	}
+	r, err := NewRotationManager(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	first, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	current, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	if current.Marshal().KID == first.Marshal().KID {
+		t.Fatalf("Expected the rotation goroutine to rotate the key.")
+	}
+	jwks, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read all keys. %s", err)
+	}
+	if len(jwks) < 2 {
+		t.Fatalf("Expected retired keys to remain during the grace period.")
+	}
+}
+
+func TestRotationManagerClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	store := NewMemoryStorage()
+	r, err := NewRotationManager(store, RotationOptions{
+		Clock:           clock.Now,
+		Ctx:             ctx,
+		GenerateOptions: GenerateOptions{KTY: KtyOct},
+		GracePeriod:     time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	first, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	if first.Marshal().KID != "rotation-"+strconv.FormatInt(clock.Now().UnixNano(), 10) {
+		t.Fatalf("Expected the key ID to use the clock, got %q.", first.Marshal().KID)
+	}
+
+	clock.Advance(time.Minute)
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	clock.Advance(30 * time.Minute)
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	_, err = store.KeyRead(ctx, first.Marshal().KID)
+	if err != nil {
+		t.Fatalf("Expected the retired key to remain during the grace period. %s", err)
+	}
+
+	clock.Advance(time.Hour)
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	_, err = store.KeyRead(ctx, first.Marshal().KID)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected the retired key to be deleted after the grace period, got %v.", err)
+	}
+}
+
+func TestRotationManagerNegativeActiveKeys(t *testing.T) {
+	_, err := NewRotationManager(NewMemoryStorage(), RotationOptions{ActiveKeys: -1})
+	if !errors.Is(err, ErrOptions) {
+		t.Fatalf("Expected ErrOptions for negative ActiveKeys, got %v.", err)
+	}
+}
+
+func TestRotationManagerGenerateFunc(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStorage()
+	options := RotationOptions{
+		Ctx: ctx,
+		GenerateFunc: func(ctx context.Context, kid string) (JWK, error) {
+			return NewJWKFromSigner(makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{KID: kid}})
+		},
+	}
+	r, err := NewRotationManager(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	current, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	_, err = current.Signer()
+	if err != nil {
+		t.Fatalf("Expected the current key to have a signer. %s", err)
+	}
+
+	options.GenerateFunc = func(ctx context.Context, kid string) (JWK, error) {
+		return NewJWKFromSigner(makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{KID: kidWritten}})
+	}
+	_, err = NewRotationManager(NewMemoryStorage(), options)
+	if !errors.Is(err, ErrOptions) {
+		t.Fatalf("Expected ErrOptions for a mismatched key ID, got %v.", err)
+	}
+}
+
+func TestRotationManagerNextSigningKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStorage()
+	var canary string
+	options := RotationOptions{
+		ActiveKeys:      2,
+		Ctx:             ctx,
+		GenerateOptions: GenerateOptions{KTY: KtyEC},
+		SigningWeight: func(jwk JWK) int {
+			if jwk.Marshal().KID == canary {
+				return 1
+			}
+			return 3
+		},
+	}
+	r, err := NewRotationManager(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	first, err := r.NextSigningKey(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get next signing key. %s", err)
+	}
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	current, err := r.Current(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get current key. %s", err)
+	}
+	canary = current.Marshal().KID
+
+	counts := make(map[string]int)
+	previous := ""
+	for i := 0; i < 8; i++ {
+		jwk, err := r.NextSigningKey(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get next signing key. %s", err)
+		}
+		kid := jwk.Marshal().KID
+		if kid == canary && previous == canary {
+			t.Fatalf("Expected the canary key to not be selected twice in a row.")
+		}
+		counts[kid]++
+		previous = kid
+	}
+	if counts[first.Marshal().KID] != 6 || counts[canary] != 2 {
+		t.Fatalf("Expected signing to be spread 3 to 1 across the active keys, got %v.", counts)
+	}
+
+	err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to rotate. %s", err)
+	}
+	counts = make(map[string]int)
+	for i := 0; i < 8; i++ {
+		jwk, err := r.NextSigningKey(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get next signing key. %s", err)
+		}
+		counts[jwk.Marshal().KID]++
+	}
+	if counts[first.Marshal().KID] != 0 {
+		t.Fatalf("Expected only the %d newest keys to be active, got %v.", options.ActiveKeys, counts)
+	}
+	_, err = store.KeyRead(ctx, first.Marshal().KID)
+	if err != nil {
+		t.Fatalf("Expected the inactive key to remain for verification. %s", err)
+	}
+
+	options.SigningWeight = func(JWK) int {
+		return 0
+	}
+	r, err = NewRotationManager(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create rotation manager. %s", err)
+	}
+	_, err = r.NextSigningKey(ctx)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound without a positive weight, got %v.", err)
+	}
+}
@@ -0,0 +1,140 @@
+//go:build jwkset_secp256k1
+
+package jwkset
+
+import (
+	"crypto/elliptic"
+	"math/big"
+)
+
+func init() {
+	// Keys are not generated on the curve, because the curve implementation is not constant time.
+	impl := ecdsaCurve(secp256k1, AlgES256K)
+	impl.Generate = nil
+	RegisterCurve(string(CrvSECP256K1), impl)
+}
+
+// secp256k1 is the elliptic curve used by the ES256K algorithm.
+// https://www.rfc-editor.org/rfc/rfc8812#section-3.1
+//
+// The Golang standard library does not implement secp256k1, and elliptic.CurveParams only implements curves with
+// a = -3, so this is a minimal implementation with a = 0 using math/big. It is not constant time, so it would leak
+// private keys through timing if it were used with them. It is only used to parse, validate, and verify with public
+// keys, and JWK.Signer refuses private keys on the curve. It is only included with the jwkset_secp256k1 build tag.
+var secp256k1 = newSECP256K1()
+
+type secp256k1Curve struct {
+	params *elliptic.CurveParams
+}
+
+func newSECP256K1() secp256k1Curve {
+	hex := func(s string) *big.Int {
+		i, _ := new(big.Int).SetString(s, 16)
+		return i
+	}
+	return secp256k1Curve{
+		params: &elliptic.CurveParams{
+			P:       hex("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F"),
+			N:       hex("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141"),
+			B:       big.NewInt(7),
+			Gx:      hex("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798"),
+			Gy:      hex("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8"),
+			BitSize: 256,
+			Name:    string(CrvSECP256K1),
+		},
+	}
+}
+
+func (c secp256k1Curve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+// IsOnCurve reports whether y² = x³ + 7 (mod p). The point at infinity is not on the curve.
+func (c secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	p := c.params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, p)
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.params.B)
+	x3.Mod(x3, p)
+	return y2.Cmp(x3) == 0
+}
+
+// Add returns the sum of the given points in affine coordinates. (0, 0) is the point at infinity, which is not on the
+// curve.
+func (c secp256k1Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+	switch {
+	case x1.Sign() == 0 && y1.Sign() == 0:
+		return new(big.Int).Set(x2), new(big.Int).Set(y2)
+	case x2.Sign() == 0 && y2.Sign() == 0:
+		return new(big.Int).Set(x1), new(big.Int).Set(y1)
+	case x1.Cmp(x2) == 0:
+		if y1.Cmp(y2) == 0 {
+			return c.Double(x1, y1)
+		}
+		return new(big.Int), new(big.Int)
+	}
+	// λ = (y2 - y1) / (x2 - x1)
+	num := new(big.Int).Sub(y2, y1)
+	den := new(big.Int).Sub(x2, x1)
+	den.Mod(den, p)
+	den.ModInverse(den, p)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, p)
+	return c.affine(lambda, x1, y1, x2)
+}
+
+// Double returns 2 * (x, y).
+func (c secp256k1Curve) Double(x, y *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+	if y.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+	// λ = 3x² / 2y, because a = 0.
+	num := new(big.Int).Mul(x, x)
+	num.Mul(num, big.NewInt(3))
+	den := new(big.Int).Lsh(y, 1)
+	den.Mod(den, p)
+	den.ModInverse(den, p)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, p)
+	return c.affine(lambda, x, y, x)
+}
+
+// affine computes x3 = λ² - x1 - x2 and y3 = λ(x1 - x3) - y1.
+func (c secp256k1Curve) affine(lambda, x1, y1, x2 *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+	x3 := new(big.Int).Mul(lambda, lambda)
+	x3.Sub(x3, x1)
+	x3.Sub(x3, x2)
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, lambda)
+	y3.Sub(y3, y1)
+	y3.Mod(y3, p)
+	return x3, y3
+}
+
+// ScalarMult returns k * (x, y), where k is a big-endian integer.
+func (c secp256k1Curve) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	rx, ry := new(big.Int), new(big.Int)
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			rx, ry = c.Double(rx, ry)
+			if b>>uint(bit)&1 == 1 {
+				rx, ry = c.Add(rx, ry, x, y)
+			}
+		}
+	}
+	return rx, ry
+}
+
+// ScalarBaseMult returns k * G, where G is the base point of the curve and k is a big-endian integer.
+func (c secp256k1Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.params.Gx, c.params.Gy, k)
+}
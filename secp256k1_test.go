@@ -0,0 +1,116 @@
+//go:build jwkset_secp256k1
+
+package jwkset
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestSECP256K1Curve(t *testing.T) {
+	params := secp256k1.Params()
+	if !secp256k1.IsOnCurve(params.Gx, params.Gy) {
+		t.Fatalf("Base point is not on the curve.")
+	}
+	x, _ := secp256k1.ScalarBaseMult([]byte{2})
+	expected, _ := new(big.Int).SetString("C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5", 16)
+	if x.Cmp(expected) != 0 {
+		t.Fatalf("Unexpected x coordinate of 2G.")
+	}
+	x, y := secp256k1.ScalarBaseMult(params.N.Bytes())
+	if x.Sign() != 0 || y.Sign() != 0 {
+		t.Fatalf("Expected nG to be the point at infinity.")
+	}
+}
+
+func TestSECP256K1JWK(t *testing.T) {
+	_, err := GenerateJWK(GenerateOptions{CRV: CrvSECP256K1, KTY: KtyEC})
+	if !errors.Is(err, ErrGenerateJWK) {
+		t.Fatalf("Expected secp256k1 keys to not be generated, got %v.", err)
+	}
+
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: secp256k1},
+		D:         big.NewInt(0x5eed),
+	}
+	key.X, key.Y = secp256k1.ScalarBaseMult(key.D.Bytes())
+	options := JWKOptions{
+		Marshal: JWKMarshalOptions{
+			Private: true,
+		},
+		Metadata: JWKMetadataOptions{
+			ALG: AlgES256K,
+			KID: myKeyID,
+		},
+	}
+	jwk, err := NewJWKFromKey(key, options)
+	if err != nil {
+		t.Fatalf("Failed to create secp256k1 JWK. %s", err)
+	}
+	_, err = jwk.Signer()
+	if !errors.Is(err, ErrNoPrivateKey) {
+		t.Fatalf("Expected secp256k1 private keys to not sign, got %v.", err)
+	}
+	_, err = SignJWKS(context.Background(), NewMemoryStorage(), jwk, AlgES256K)
+	if !errors.Is(err, ErrSignedJWKS) {
+		t.Fatalf("Expected SignJWKS to refuse a secp256k1 private key, got %v.", err)
+	}
+	_, err = NewJWKFromSigner(key, options)
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("Expected NewJWKFromSigner to refuse a secp256k1 private key, got %v.", err)
+	}
+	external, err := NewJWKFromSigner(opaqueSigner{key}, options)
+	if err != nil {
+		t.Fatalf("Failed to create secp256k1 JWK from signer. %s", err)
+	}
+	token, err := SignJWKS(context.Background(), NewMemoryStorage(), external, AlgES256K)
+	if err != nil {
+		t.Fatalf("Failed to sign JWK Set with an external signer. %s", err)
+	}
+	_, err = VerifySignedJWKS(token, external)
+	if err != nil {
+		t.Fatalf("Failed to verify ES256K signature. %s", err)
+	}
+
+	raw, err := json.Marshal(jwk.Marshal())
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK. %s", err)
+	}
+	parsed, err := NewJWKFromRawJSON(raw, JWKMarshalOptions{Private: true}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse secp256k1 JWK. %s", err)
+	}
+	if parsed.Marshal().CRV != CrvSECP256K1 {
+		t.Fatalf("Unexpected curve %q.", parsed.Marshal().CRV)
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	priv := parsed.Key().(*ecdsa.PrivateKey)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign. %s", err)
+	}
+	if !ecdsa.VerifyASN1(&jwk.Key().(*ecdsa.PrivateKey).PublicKey, digest[:], sig) {
+		t.Fatalf("Failed to verify signature.")
+	}
+
+	m := parsed.Marshal()
+	y, err := base64.RawURLEncoding.DecodeString(m.Y)
+	if err != nil {
+		t.Fatalf("Failed to decode y. %s", err)
+	}
+	y[len(y)-1] ^= 1
+	m.Y = base64.RawURLEncoding.EncodeToString(y)
+	m.D = ""
+	_, err = NewJWKFromMarshal(m, JWKMarshalOptions{}, JWKValidateOptions{})
+	if !errors.Is(err, ErrInvalidECPoint) {
+		t.Fatalf("Expected ErrInvalidECPoint for a point that is not on the curve, got %v.", err)
+	}
+}
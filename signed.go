@@ -0,0 +1,227 @@
+package jwkset
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// ErrSignedJWKS indicates that a signed JWK Set could not be created or verified.
+var ErrSignedJWKS = errors.New("invalid signed JWK Set")
+
+// signedJWKSContentType is the cty header parameter of a signed JWK Set. It is the media type application/jwk-set+json
+// with the "application/" prefix omitted. https://www.rfc-editor.org/rfc/rfc7515#section-4.1.10
+const signedJWKSContentType = "jwk-set+json"
+
+// signedJWKSHeader is the protected header of a signed JWK Set.
+type signedJWKSHeader struct {
+	ALG  ALG      `json:"alg"`
+	CRIT []string `json:"crit,omitempty"`
+	CTY  string   `json:"cty,omitempty"`
+	KID  string   `json:"kid,omitempty"`
+}
+
+// SignJWKS creates a JWS in the compact serialization whose payload is the public JWK Set of the storage, so the JWK
+// Set can be distributed over untrusted channels and checked with VerifySignedJWKS.
+// https://www.rfc-editor.org/rfc/rfc7515#section-7.1
+//
+// The payload is marshaled like Storage.JSONWithOptions with the Canonical and SortKeys options, so private key
+// material and symmetric keys are never included. The signing key must have private key material, be created by
+// NewJWKFromSigner, or be a symmetric key for HMAC, and be compatible with the given algorithm. Its key ID, if any, is
+// set as the kid header parameter.
+// The supported algorithms are HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512,
+// ES256K with a signing key created by NewJWKFromSigner, and EdDSA with Ed25519.
+func SignJWKS(ctx context.Context, storage Storage, signingKey JWK, alg ALG) (string, error) {
+	err := checkSignedJWKSAlg(signingKey, alg)
+	if err != nil {
+		return "", err
+	}
+	marshalOptions := JWKMarshalOptions{
+		Canonical: true,
+		SortKeys:  true,
+	}
+	payload, err := storage.JSONWithOptions(ctx, marshalOptions, JWKValidateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public JWK Set: %w", err)
+	}
+	header, err := json.Marshal(signedJWKSHeader{
+		ALG: alg,
+		CTY: signedJWKSContentType,
+		KID: signingKey.Marshal().KID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWS header: %w", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := jwsSign(signingKey, alg, []byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWK Set: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifySignedJWKS verifies a JWK Set created by SignJWKS with the given key and returns the JWK Set. The alg header
+// parameter must be compatible with the key and match its alg parameter, if any. The returned JWK Set is not
+// validated, so JWKSMarshal.ToStorage or ValidateJWKS should be used before trusting its keys.
+func VerifySignedJWKS(token string, verifyKey JWK) (JWKSMarshal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return JWKSMarshal{}, fmt.Errorf("%w: expected 3 parts in the compact serialization, got %d", ErrSignedJWKS, len(parts))
+	}
+	rawHeader, err := base64.RawURLEncoding.Strict().DecodeString(parts[0])
+	if err != nil {
+		return JWKSMarshal{}, fmt.Errorf("failed to decode JWS header: %w", errors.Join(ErrSignedJWKS, err))
+	}
+	var header signedJWKSHeader
+	err = json.Unmarshal(rawHeader, &header)
+	if err != nil {
+		return JWKSMarshal{}, fmt.Errorf("failed to unmarshal JWS header: %w", errors.Join(ErrSignedJWKS, err))
+	}
+	if len(header.CRIT) > 0 {
+		return JWKSMarshal{}, fmt.Errorf("%w: unsupported critical header parameters %q", ErrSignedJWKS, header.CRIT)
+	}
+	err = checkSignedJWKSAlg(verifyKey, header.ALG)
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil {
+		return JWKSMarshal{}, fmt.Errorf("failed to decode JWS signature: %w", errors.Join(ErrSignedJWKS, err))
+	}
+	signingInput := parts[0] + "." + parts[1]
+	err = jwsVerify(publicKey(verifyKey.Key()), header.ALG, []byte(signingInput), signature)
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	payload, err := base64.RawURLEncoding.Strict().DecodeString(parts[1])
+	if err != nil {
+		return JWKSMarshal{}, fmt.Errorf("failed to decode JWS payload: %w", errors.Join(ErrSignedJWKS, err))
+	}
+	var jwks JWKSMarshal
+	err = json.Unmarshal(payload, &jwks)
+	if err != nil {
+		return JWKSMarshal{}, fmt.Errorf("failed to unmarshal JWK Set from JWS payload: %w", errors.Join(ErrSignedJWKS, err))
+	}
+	return jwks, nil
+}
+
+// checkSignedJWKSAlg checks that the algorithm is a supported JWS algorithm that is compatible with the key.
+func checkSignedJWKSAlg(jwk JWK, alg ALG) error {
+	m := jwk.Marshal()
+	if !slices.Contains(signatureAlgs, alg) {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrSignedJWKS, alg)
+	}
+	if !alg.compatible(m.KTY, m.CRV) {
+		return fmt.Errorf("%w: algorithm %q is not compatible with key type %q", ErrSignedJWKS, alg, m.KTY)
+	}
+	if m.ALG != "" && m.ALG != alg {
+		return fmt.Errorf("%w: algorithm %q does not match the key algorithm %q", ErrSignedJWKS, alg, m.ALG)
+	}
+	return nil
+}
+
+// jwsHash returns the hash function used by the JWS algorithm. EdDSA signs the message itself, so 0 is returned.
+func jwsHash(alg ALG) crypto.Hash {
+	switch alg {
+	case AlgHS256, AlgRS256, AlgPS256, AlgES256, AlgES256K:
+		return crypto.SHA256
+	case AlgHS384, AlgRS384, AlgPS384, AlgES384:
+		return crypto.SHA384
+	case AlgHS512, AlgRS512, AlgPS512, AlgES512:
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// jwsDigest returns the digest of the signing input for the JWS algorithm.
+func jwsDigest(alg ALG, signingInput []byte) []byte {
+	h := jwsHash(alg).New()
+	h.Write(signingInput)
+	return h.Sum(nil)
+}
+
+// jwsSign creates the JWS signature of the signing input. https://www.rfc-editor.org/rfc/rfc7518#section-3
+func jwsSign(jwk JWK, alg ALG, signingInput []byte) ([]byte, error) {
+	if k, ok := jwk.Key().([]byte); ok {
+		mac := hmac.New(jwsHash(alg).New, k)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	}
+	signer, err := jwk.Signer()
+	if err != nil {
+		return nil, fmt.Errorf("%w: signing key of type %T has no private key material", ErrSignedJWKS, jwk.Key())
+	}
+	var opts crypto.SignerOpts = jwsHash(alg)
+	digest := signingInput
+	if alg != AlgEdDSA {
+		digest = jwsDigest(alg, signingInput)
+	}
+	if strings.HasPrefix(string(alg), "PS") {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: jwsHash(alg)}
+	}
+	signature, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		// A crypto.Signer creates an ASN.1 ECDSA signature, but a JWS has the fixed-size r and s values.
+		// https://www.rfc-editor.org/rfc/rfc7518#section-3.4
+		var sig struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(signature, &sig)
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if err != nil || len(rest) != 0 || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > 8*size || sig.S.BitLen() > 8*size {
+			return nil, fmt.Errorf("%w: malformed ECDSA signature from signer", ErrSignedJWKS)
+		}
+		signature = make([]byte, 2*size)
+		sig.R.FillBytes(signature[:size])
+		sig.S.FillBytes(signature[size:])
+	}
+	return signature, nil
+}
+
+// jwsVerify verifies the JWS signature of the signing input.
+func jwsVerify(key any, alg ALG, signingInput, signature []byte) error {
+	var valid bool
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(jwsHash(alg).New, k)
+		mac.Write(signingInput)
+		valid = hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		digest := jwsDigest(alg, signingInput)
+		if strings.HasPrefix(string(alg), "PS") {
+			valid = rsa.VerifyPSS(k, jwsHash(alg), digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		} else {
+			valid = rsa.VerifyPKCS1v15(k, jwsHash(alg), digest, signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(k, jwsDigest(alg, signingInput), r, s)
+		}
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, signingInput, signature)
+	default:
+		return fmt.Errorf("%w: verification key of type %T is not supported", ErrSignedJWKS, key)
+	}
+	if !valid {
+		return fmt.Errorf("%w: signature verification failed", ErrSignedJWKS)
+	}
+	return nil
+}
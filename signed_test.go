@@ -0,0 +1,109 @@
+package jwkset
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignJWKS(t *testing.T) {
+	ctx := context.Background()
+	rsaKey := makeRSA(t)
+	store := NewMemoryStorage()
+	writeKeys(ctx, t, store,
+		newStorageTestJWK(t, rsaKey, kidWritten),
+		newStorageTestJWK(t, makeEdDSA(t), kidWritten2),
+		newStorageTestJWK(t, []byte(hmacKey1), "hmac"),
+	)
+	expected, err := store.JSONWithOptions(ctx, JWKMarshalOptions{Canonical: true, SortKeys: true}, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to marshal public JWK Set. %s", err)
+	}
+
+	for _, tc := range []struct {
+		alg ALG
+		key any
+	}{
+		{alg: AlgRS256, key: rsaKey},
+		{alg: AlgPS384, key: rsaKey},
+		{alg: AlgES256, key: makeECDSAP256(t)},
+		{alg: AlgEdDSA, key: makeEdDSA(t)},
+		{alg: AlgHS512, key: []byte(hmacKey2)},
+	} {
+		signingKey := newStorageTestJWK(t, tc.key, myKeyID)
+		token, err := SignJWKS(ctx, store, signingKey, tc.alg)
+		if err != nil {
+			t.Fatalf("Failed to sign JWK Set with %s. %s", tc.alg, err)
+		}
+		parts := strings.Split(token, ".")
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			t.Fatalf("Failed to decode payload. %s", err)
+		}
+		if string(payload) != string(expected) {
+			t.Fatalf("Payload is not the canonical public JWK Set.\nExpected: %s\nActual: %s", expected, payload)
+		}
+
+		verifyKey := signingKey
+		if tc.alg != AlgHS512 {
+			verifyKey, err = signingKey.Public()
+			if err != nil {
+				t.Fatalf("Failed to get public JWK. %s", err)
+			}
+		}
+		jwks, err := VerifySignedJWKS(token, verifyKey)
+		if err != nil {
+			t.Fatalf("Failed to verify JWK Set signed with %s. %s", tc.alg, err)
+		}
+		if len(jwks.Keys) != 2 {
+			t.Fatalf("Expected 2 public keys, got %d.", len(jwks.Keys))
+		}
+		for _, k := range jwks.Keys {
+			if k.D != "" || k.K != "" {
+				t.Fatalf("Signed JWK Set contains private key material.")
+			}
+		}
+
+		tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"keys":[]}`)) + "." + parts[2]
+		_, err = VerifySignedJWKS(tampered, verifyKey)
+		if !errors.Is(err, ErrSignedJWKS) {
+			t.Fatalf("Expected ErrSignedJWKS for a tampered payload with %s, got %v.", tc.alg, err)
+		}
+	}
+
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key. %s", err)
+	}
+	otherKey := newStorageTestJWK(t, otherRSA, myKeyID)
+	token, err := SignJWKS(ctx, store, newStorageTestJWK(t, rsaKey, myKeyID), AlgRS256)
+	if err != nil {
+		t.Fatalf("Failed to sign JWK Set. %s", err)
+	}
+	_, err = VerifySignedJWKS(token, otherKey)
+	if !errors.Is(err, ErrSignedJWKS) {
+		t.Fatalf("Expected ErrSignedJWKS for the wrong key, got %v.", err)
+	}
+	_, err = VerifySignedJWKS(token, newJWK(t, &rsaKey.PublicKey, JWKOptions{Metadata: JWKMetadataOptions{ALG: AlgPS256}}))
+	if !errors.Is(err, ErrSignedJWKS) {
+		t.Fatalf("Expected ErrSignedJWKS for an algorithm mismatch, got %v.", err)
+	}
+
+	for _, tc := range []struct {
+		alg ALG
+		key JWK
+	}{
+		{alg: AlgES256, key: newStorageTestJWK(t, rsaKey, myKeyID)},
+		{alg: AlgNone, key: newStorageTestJWK(t, rsaKey, myKeyID)},
+		{alg: AlgRS256, key: newJWK(t, &rsaKey.PublicKey, JWKOptions{})},
+	} {
+		_, err = SignJWKS(ctx, store, tc.key, tc.alg)
+		if !errors.Is(err, ErrSignedJWKS) {
+			t.Fatalf("Expected ErrSignedJWKS when signing with %s, got %v.", tc.alg, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package jwkset
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlIdentifier matches a table name that is safe to use in a query without quoting. Table names cannot be passed as
+// query parameters.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLStorageOptions are used to configure the behavior of NewStorageFromSQL and MigrateSQL.
+type SQLStorageOptions struct {
+	// Clock returns the current time. It is used for the created_at column.
+	//
+	// This defaults to time.Now.
+	Clock func() time.Time
+
+	// Placeholder returns the query parameter placeholder for the nth parameter, starting at 1. Use
+	// SQLPlaceholderDollar for PostgreSQL.
+	//
+	// This defaults to SQLPlaceholderQuestion, which is used by MySQL and SQLite.
+	Placeholder func(n int) string
+
+	// Table is the name of the table, optionally qualified with a schema. It must only contain letters, digits, and
+	// underscores.
+	//
+	// This defaults to "jwkset_keys".
+	Table string
+}
+
+// SQLPlaceholderDollar returns PostgreSQL style placeholders, such as $1.
+func SQLPlaceholderDollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// SQLPlaceholderQuestion returns MySQL and SQLite style placeholders, which are always ?.
+func SQLPlaceholderQuestion(_ int) string {
+	return "?"
+}
+
+var _ Storage = sqlStorage{}
+
+type sqlStorage struct {
+	db      *sql.DB
+	options SQLStorageOptions
+	snapshotStorage
+}
+
+// NewStorageFromSQL creates a new Storage implementation that stores each JWK as JSON in a row of a SQL table. The
+// table has the columns kid, json, created_at, key_use, and alg, and can be created with MigrateSQL. The key_use and
+// alg columns hold the use and alg parameters, so KeyReadByUse and KeyReadByAlg filter in SQL. Keys are read ordered
+// by the created_at column, which is set when a key is first written, and then by key ID. Keys written in the same
+// KeyWriteBatch, or within the precision of the created_at column, such as one second for a MySQL TIMESTAMP, are
+// therefore ordered by key ID.
+//
+// KeyWriteBatch writes all keys in one transaction. All queries use parameters and the given context.
+//
+// The stored JSON contains private key material, so the database should be protected accordingly.
+func NewStorageFromSQL(db *sql.DB, options SQLStorageOptions) (Storage, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: SQL database is required", ErrOptions)
+	}
+	options, err := sqlDefaults(options)
+	if err != nil {
+		return nil, err
+	}
+	s := sqlStorage{
+		db:      db,
+		options: options,
+	}
+	s.snapshotStorage = snapshotStorage{keyReadAll: s.KeyReadAll}
+	return s, nil
+}
+
+// MigrateSQL creates the table used by NewStorageFromSQL, if it does not exist.
+func MigrateSQL(ctx context.Context, db *sql.DB, options SQLStorageOptions) error {
+	options, err := sqlDefaults(options)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+options.Table+` (
+	kid VARCHAR(255) NOT NULL PRIMARY KEY,
+	json TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	key_use VARCHAR(32) NOT NULL,
+	alg VARCHAR(64) NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("failed to create SQL table %q: %w", options.Table, err)
+	}
+	return nil
+}
+
+func sqlDefaults(options SQLStorageOptions) (SQLStorageOptions, error) {
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+	if options.Placeholder == nil {
+		options.Placeholder = SQLPlaceholderQuestion
+	}
+	if options.Table == "" {
+		options.Table = "jwkset_keys"
+	}
+	if !sqlIdentifier.MatchString(options.Table) {
+		return SQLStorageOptions{}, fmt.Errorf("%w: invalid SQL table name %q", ErrOptions, options.Table)
+	}
+	return options, nil
+}
+
+func (s sqlStorage) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	result, err := s.db.ExecContext(ctx, s.query("DELETE FROM %t WHERE kid = %p"), keyID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete key with ID %q from SQL: %w", keyID, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected by deleting key with ID %q from SQL: %w", keyID, err)
+	}
+	return n > 0, nil
+}
+func (s sqlStorage) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	var one int
+	err := s.db.QueryRowContext(ctx, s.query("SELECT 1 FROM %t WHERE kid = %p"), keyID).Scan(&one)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check for key with ID %q in SQL: %w", keyID, err)
+	}
+	return true, nil
+}
+func (s sqlStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, s.query("SELECT json FROM %t WHERE kid = %p"), keyID).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return JWK{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, keyID)
+		}
+		return JWK{}, fmt.Errorf("failed to read key with ID %q from SQL: %w", keyID, err)
+	}
+	return storedUnmarshal(raw)
+}
+func (s sqlStorage) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	if !inferred {
+		return s.readRows(ctx, "SELECT json FROM %t WHERE alg = %p ORDER BY created_at, kid", string(alg))
+	}
+	jwks, err := s.readRows(ctx, "SELECT json FROM %t WHERE alg = %p OR alg = %p ORDER BY created_at, kid", string(alg), "")
+	if err != nil {
+		return nil, err
+	}
+	return filterByAlg(jwks, alg, inferred), nil
+}
+func (s sqlStorage) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	return s.readRows(ctx, "SELECT json FROM %t WHERE key_use = %p OR key_use = %p ORDER BY created_at, kid", string(use), "")
+}
+func (s sqlStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	return s.readRows(ctx, "SELECT json FROM %t ORDER BY created_at, kid")
+}
+func (s sqlStorage) KeyWrite(ctx context.Context, jwk JWK) error {
+	return s.KeyWriteBatch(ctx, []JWK{jwk})
+}
+func (s sqlStorage) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin SQL transaction: %w", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer tx.Rollback()
+	now := s.options.Clock().UTC()
+	for _, jwk := range jwks {
+		raw, err := storedMarshal(jwk)
+		if err != nil {
+			return err
+		}
+		m := jwk.Marshal()
+		// A SELECT followed by an UPDATE or INSERT is used instead of an upsert, because upsert syntax differs between
+		// databases. The rows affected by an UPDATE cannot be used instead of the SELECT, because MySQL does not count
+		// rows that are unchanged. The UPDATE keeps the created_at column, so the key keeps its position in the read
+		// order.
+		var one int
+		err = tx.QueryRowContext(ctx, s.query("SELECT 1 FROM %t WHERE kid = %p"), m.KID).Scan(&one)
+		switch {
+		case err == nil:
+			_, err = tx.ExecContext(ctx, s.query("UPDATE %t SET json = %p, key_use = %p, alg = %p WHERE kid = %p"), string(raw), string(m.USE), string(m.ALG), m.KID)
+			if err != nil {
+				return fmt.Errorf("failed to update key with ID %q in SQL: %w", m.KID, err)
+			}
+			continue
+		case !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("failed to check for key with ID %q in SQL: %w", m.KID, err)
+		}
+		_, err = tx.ExecContext(ctx, s.query("INSERT INTO %t (kid, json, created_at, key_use, alg) VALUES (%p, %p, %p, %p, %p)"), m.KID, string(raw), now, string(m.USE), string(m.ALG))
+		if err != nil {
+			return fmt.Errorf("failed to insert key with ID %q into SQL: %w", m.KID, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit SQL transaction: %w", err)
+	}
+	return nil
+}
+
+// query replaces %t in the query with the table name and each %p with the next placeholder.
+func (s sqlStorage) query(q string) string {
+	q = strings.ReplaceAll(q, "%t", s.options.Table)
+	var b strings.Builder
+	n := 0
+	for {
+		i := strings.Index(q, "%p")
+		if i == -1 {
+			b.WriteString(q)
+			return b.String()
+		}
+		n++
+		b.WriteString(q[:i])
+		b.WriteString(s.options.Placeholder(n))
+		q = q[i+2:]
+	}
+}
+func (s sqlStorage) readRows(ctx context.Context, q string, args ...any) ([]JWK, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query keys from SQL: %w", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer rows.Close()
+	var jwks []JWK
+	for rows.Next() {
+		var raw []byte
+		err = rows.Scan(&raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan key from SQL: %w", err)
+		}
+		jwk, err := storedUnmarshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		jwks = append(jwks, jwk)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate keys from SQL: %w", err)
+	}
+	return jwks, nil
+}
@@ -0,0 +1,321 @@
+package jwkset
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sqlTestDB is an in-memory database that understands the queries used by NewStorageFromSQL.
+type sqlTestDB struct {
+	mux        sync.Mutex
+	created    bool
+	failInsert string
+	queries    []string
+	rows       map[string]sqlTestRow
+}
+
+type sqlTestRow struct {
+	kid       string
+	json      string
+	createdAt time.Time
+	use       string
+	alg       string
+}
+
+func (db *sqlTestDB) Connect(_ context.Context) (driver.Conn, error) {
+	return &sqlTestConn{db: db}, nil
+}
+func (db *sqlTestDB) Driver() driver.Driver {
+	return nil
+}
+
+type sqlTestConn struct {
+	db *sqlTestDB
+	tx map[string]sqlTestRow
+}
+
+func (c *sqlTestConn) Prepare(query string) (driver.Stmt, error) {
+	return &sqlTestStmt{conn: c, query: regexp.MustCompile(`\$\d+`).ReplaceAllString(query, "?")}, nil
+}
+func (c *sqlTestConn) Close() error {
+	return nil
+}
+func (c *sqlTestConn) Begin() (driver.Tx, error) {
+	c.db.mux.Lock()
+	defer c.db.mux.Unlock()
+	c.tx = maps.Clone(c.db.rows)
+	return c, nil
+}
+func (c *sqlTestConn) Commit() error {
+	c.db.mux.Lock()
+	defer c.db.mux.Unlock()
+	c.db.rows = c.tx
+	c.tx = nil
+	return nil
+}
+func (c *sqlTestConn) Rollback() error {
+	c.tx = nil
+	return nil
+}
+
+type sqlTestStmt struct {
+	conn  *sqlTestConn
+	query string
+}
+
+func (s *sqlTestStmt) Close() error {
+	return nil
+}
+func (s *sqlTestStmt) NumInput() int {
+	return -1
+}
+func (s *sqlTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.queries = append(db.queries, s.query)
+	rows := db.rows
+	if s.conn.tx != nil {
+		rows = s.conn.tx
+	}
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS jwkset_keys ("):
+		db.created = true
+		return driver.RowsAffected(0), nil
+	case s.query == "DELETE FROM jwkset_keys WHERE kid = ?":
+		kid := args[0].(string)
+		_, ok := rows[kid]
+		delete(rows, kid)
+		if ok {
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case s.query == "UPDATE jwkset_keys SET json = ?, key_use = ?, alg = ? WHERE kid = ?":
+		kid := args[3].(string)
+		row, ok := rows[kid]
+		if !ok {
+			return driver.RowsAffected(0), nil
+		}
+		updated := row
+		updated.json, updated.use, updated.alg = args[0].(string), args[1].(string), args[2].(string)
+		rows[kid] = updated
+		if updated == row {
+			return driver.RowsAffected(0), nil // Like MySQL, only changed rows are counted.
+		}
+		return driver.RowsAffected(1), nil
+	case s.query == "INSERT INTO jwkset_keys (kid, json, created_at, key_use, alg) VALUES (?, ?, ?, ?, ?)":
+		kid := args[0].(string)
+		if _, ok := rows[kid]; ok || kid == db.failInsert {
+			return nil, fmt.Errorf("failed to insert %q", kid)
+		}
+		rows[kid] = sqlTestRow{
+			kid:       kid,
+			json:      args[1].(string),
+			createdAt: args[2].(time.Time),
+			use:       args[3].(string),
+			alg:       args[4].(string),
+		}
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected exec %q", s.query)
+}
+func (s *sqlTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.queries = append(db.queries, s.query)
+	rows := db.rows
+	if s.conn.tx != nil {
+		rows = s.conn.tx
+	}
+	q, ok := strings.CutPrefix(s.query, "SELECT json FROM jwkset_keys")
+	exists := false
+	if !ok {
+		q, exists = strings.CutPrefix(s.query, "SELECT 1 FROM jwkset_keys")
+		if !exists {
+			return nil, fmt.Errorf("unexpected query %q", s.query)
+		}
+	}
+	q = strings.TrimSuffix(q, " ORDER BY created_at, kid")
+	var conditions []string
+	if where, ok := strings.CutPrefix(q, " WHERE "); ok {
+		conditions = strings.Split(where, " OR ")
+	}
+	var matched []sqlTestRow
+	for _, row := range rows {
+		columns := map[string]string{"kid": row.kid, "key_use": row.use, "alg": row.alg}
+		match := len(conditions) == 0
+		for i, condition := range conditions {
+			column, ok := strings.CutSuffix(condition, " = ?")
+			if !ok {
+				return nil, fmt.Errorf("unexpected condition %q", condition)
+			}
+			if columns[column] == args[i].(string) {
+				match = true
+			}
+		}
+		if match {
+			matched = append(matched, row)
+		}
+	}
+	slices.SortFunc(matched, func(a, b sqlTestRow) int {
+		if c := a.createdAt.Compare(b.createdAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.kid, b.kid)
+	})
+	return &sqlTestRows{exists: exists, rows: matched}, nil
+}
+
+type sqlTestRows struct {
+	exists bool
+	rows   []sqlTestRow
+}
+
+func (r *sqlTestRows) Columns() []string {
+	return []string{"json"}
+}
+func (r *sqlTestRows) Close() error {
+	return nil
+}
+func (r *sqlTestRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.rows[0].json
+	if r.exists {
+		dest[0] = int64(1)
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStorage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	testDB := &sqlTestDB{
+		failInsert: kidMissing,
+		rows:       make(map[string]sqlTestRow),
+	}
+	db := sql.OpenDB(testDB)
+	defer db.Close()
+	clock := newFakeClock()
+	options := SQLStorageOptions{
+		Clock:       clock.Now,
+		Placeholder: SQLPlaceholderDollar,
+	}
+	err := MigrateSQL(ctx, db, options)
+	if err != nil {
+		t.Fatalf("Failed to migrate SQL table. %s", err)
+	}
+	if !testDB.created {
+		t.Fatalf("Expected the table to be created.")
+	}
+	store, err := NewStorageFromSQL(db, options)
+	if err != nil {
+		t.Fatalf("Failed to create SQL storage. %s", err)
+	}
+
+	sig := newJWK(t, makeEdDSA(t), JWKOptions{Metadata: JWKMetadataOptions{KID: kidWritten2, USE: UseSig}})
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten))
+	clock.Advance(time.Second)
+	writeKeys(ctx, t, store, sig)
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey2, kidWritten))
+
+	key, err := store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey2) {
+		t.Fatalf("Expected the key to be overwritten.")
+	}
+	ok, err := store.KeyExists(ctx, kidWritten)
+	if err != nil || !ok {
+		t.Fatalf("Expected the written key to exist. %v", err)
+	}
+	ok, err = store.KeyExists(ctx, kidMissing)
+	if err != nil || ok {
+		t.Fatalf("Expected the missing key to not exist. %v", err)
+	}
+	_, err = store.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Should have specific error when reading missing key.\n  Actual: %s\n  Expected: %s", err, ErrKeyNotFound)
+	}
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	if len(keys) != 2 || keys[0].Marshal().KID != kidWritten {
+		t.Fatalf("Expected 2 keys in the order they were first written.")
+	}
+	keys, err = store.KeyReadByUse(ctx, UseEnc)
+	if err != nil {
+		t.Fatalf("Failed to read keys by use. %s", err)
+	}
+	if len(keys) != 1 || keys[0].Marshal().KID != kidWritten {
+		t.Fatalf("Expected only the key without a use.")
+	}
+	keys, err = store.KeyReadByAlg(ctx, AlgEdDSA, false)
+	if err != nil {
+		t.Fatalf("Failed to read keys by algorithm. %s", err)
+	}
+	if len(keys) != 1 || keys[0].Marshal().KID != kidWritten2 {
+		t.Fatalf("Expected only the EdDSA key.")
+	}
+
+	writeKeys(ctx, t, store, sig)
+	err = store.KeyWriteBatch(ctx, []JWK{sig, sig})
+	if err != nil {
+		t.Fatalf("Failed to rewrite an unchanged key. %s", err)
+	}
+
+	err = store.KeyWriteBatch(ctx, []JWK{
+		newStorageTestJWK(t, hmacKey1, kidWritten),
+		newStorageTestJWK(t, hmacKey1, kidMissing),
+	})
+	if err == nil {
+		t.Fatalf("Expected the batch to fail.")
+	}
+	key, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey2) {
+		t.Fatalf("Expected the failed batch to be rolled back.")
+	}
+
+	ok, err = store.KeyDelete(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to delete key. %s", err)
+	}
+	if !ok {
+		t.Fatalf("Expected key to be deleted.")
+	}
+	_, err = store.JSONPublic(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create JSON. %s", err)
+	}
+	for _, query := range testDB.queries {
+		if strings.Contains(query, kidWritten) {
+			t.Fatalf("Expected key IDs to be passed as parameters, got query %q.", query)
+		}
+	}
+
+	_, err = NewStorageFromSQL(db, SQLStorageOptions{Table: "keys; DROP TABLE users"})
+	if !errors.Is(err, ErrOptions) {
+		t.Fatalf("Expected ErrOptions for an invalid table name, got %v.", err)
+	}
+}
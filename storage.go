@@ -1,15 +1,34 @@
 package jwkset
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"runtime/debug"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -17,21 +36,86 @@ var (
 	ErrKeyNotFound = errors.New("key not found")
 	// ErrInvalidHTTPStatusCode is returned when the HTTP status code is invalid.
 	ErrInvalidHTTPStatusCode = errors.New("invalid HTTP status code")
+	// ErrClosed is returned by a Storage implementation that has been closed.
+	ErrClosed = errors.New("storage is closed")
+	// ErrResponseTooLarge is returned when an HTTP response body is larger than the MaxResponseBytes option.
+	ErrResponseTooLarge = errors.New("HTTP response body too large")
+	// ErrTooManyKeys is returned when a JWK Set from an HTTP response has more keys than the MaxKeys option.
+	ErrTooManyKeys = errors.New("too many keys in JWK Set")
+	// ErrKeysStale is returned when reading keys from a remote HTTP resource that has not been successfully refreshed
+	// within the MaxStaleness option.
+	ErrKeysStale = errors.New("keys are stale")
+	// ErrRefreshNetwork is wrapped by a refresh error when the HTTP request failed or the response had an unexpected
+	// status code.
+	ErrRefreshNetwork = errors.New("failed to fetch JWK Set")
+	// ErrRefreshDecode is wrapped by a refresh error when the HTTP response body could not be decoded as a JWK Set.
+	ErrRefreshDecode = errors.New("failed to decode JWK Set")
+	// ErrRefreshValidate is wrapped by a refresh error when a JWK in the JWK Set failed validation.
+	ErrRefreshValidate = errors.New("failed to validate JWK Set")
+	// ErrTrailingData is returned when a JWK Set from an HTTP response has data after its JSON object and the
+	// AllowTrailingData option is not set.
+	ErrTrailingData = errors.New("unexpected data after JWK Set")
+	// ErrContentType is returned when an HTTP response does not have the JWK Set media type and the StrictContentType
+	// option is set.
+	ErrContentType = errors.New("unexpected Content-Type for JWK Set")
 )
 
+// utf8BOM is the UTF-8 byte order mark, which some servers prefix to a JWK Set.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// modulePath is the Go module path of this library.
+const modulePath = "github.com/MicahParks/jwkset"
+
+// DefaultUserAgent is the default User-Agent header of HTTP requests made by this library, such as
+// "jwkset/v0.9.0 (+https://github.com/MicahParks/jwkset)". The version is read from the build information.
+var DefaultUserAgent = defaultUserAgent()
+
+// refreshError classifies a refresh error with ErrRefreshNetwork, ErrRefreshDecode, or ErrRefreshValidate without
+// changing its message.
+type refreshError struct {
+	class error
+	err   error
+}
+
+func (e refreshError) Error() string {
+	return e.err.Error()
+}
+func (e refreshError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
 // Storage handles storage operations for a JWKSet.
 type Storage interface {
 	// KeyDelete deletes a key from the storage. It will return ok as true if the key was present for deletion.
 	KeyDelete(ctx context.Context, keyID string) (ok bool, err error)
+	// KeyExists reports whether a key with the given key ID is in the storage, without the cost of returning the key.
+	KeyExists(ctx context.Context, keyID string) (bool, error)
 	// KeyRead reads a key from the storage. If the key is not present, it returns ErrKeyNotFound. Any pointers returned
 	// should be considered read-only.
 	KeyRead(ctx context.Context, keyID string) (JWK, error)
+	// KeyReadByAlg reads all keys that declare the given algorithm (alg). If inferred is true, keys that do not declare
+	// an algorithm are included when their key type and curve are compatible with the algorithm. As with KeyRead, any
+	// pointers returned should be considered read-only.
+	KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error)
+	// KeyReadByUse reads all keys with the given public key use (use). Keys that do not declare a use are included,
+	// because RFC 7517 does not restrict them. As with KeyRead, any pointers returned should be considered read-only.
+	KeyReadByUse(ctx context.Context, use USE) ([]JWK, error)
+	// KeyReadFunc reads all keys for which the given function returns true. The function may be called while the
+	// storage is locked, so it must not call methods of the storage. As with KeyRead, any pointers passed to the
+	// function or returned should be considered read-only.
+	KeyReadFunc(ctx context.Context, f func(jwk JWK) bool) ([]JWK, error)
 	// KeyReadAll reads a snapshot of all keys from storage. As with ReadKey, any pointers returned should be
 	// considered read-only.
 	KeyReadAll(ctx context.Context) ([]JWK, error)
+	// KeyReadAllPublic reads a snapshot of all asymmetric keys from storage, with any private key material removed from
+	// copies of the keys. Symmetric keys (oct) are omitted, as with JSONPublic.
+	KeyReadAllPublic(ctx context.Context) ([]JWK, error)
 	// KeyWrite writes a key to the storage. If the key already exists, it will be overwritten. After writing a key,
 	// any pointers written should be considered owned by the underlying storage.
 	KeyWrite(ctx context.Context, jwk JWK) error
+	// KeyWriteBatch writes the keys to the storage as one operation. Either all keys are written, or an error is
+	// returned and none are. As with KeyWrite, existing keys are overwritten.
+	KeyWriteBatch(ctx context.Context, jwks []JWK) error
 
 	// JSON creates the JSON representation of the JWKSet.
 	JSON(ctx context.Context) (json.RawMessage, error)
@@ -51,9 +135,24 @@ type Storage interface {
 
 var _ Storage = &memoryJWKSet{}
 
+// MemoryStorageOptions are used to configure the behavior of NewMemoryStorageWithOptions.
+type MemoryStorageOptions struct {
+	// AutoKID assigns the base64url encoded SHA-256 JWK thumbprint as the key ID (kid) of keys that are written
+	// without one. Writing the same key material more than once produces the same key ID, so the key is overwritten
+	// instead of duplicated. https://www.rfc-editor.org/rfc/rfc7638#section-3.5
+	AutoKID bool
+	// MaxKeys is the maximum number of keys held by the storage. When a write exceeds it, the least recently read keys
+	// are evicted. The key being written is never evicted by its own write. KeyRead is the only method that counts as a
+	// read. This defaults to 0, which means the storage is unbounded.
+	MaxKeys int
+}
+
 type memoryJWKSet struct {
-	set []JWK
-	mux sync.RWMutex
+	options MemoryStorageOptions
+	set     []JWK
+	mux     sync.RWMutex
+	tick    atomic.Uint64
+	used    map[string]*atomic.Uint64 // Only populated when MaxKeys is set.
 }
 
 // NewMemoryStorage creates a new in-memory Storage implementation.
@@ -61,43 +160,169 @@ func NewMemoryStorage() Storage {
 	return &memoryJWKSet{}
 }
 
+// NewMemoryStorageWithOptions creates a new in-memory Storage implementation with the given options.
+func NewMemoryStorageWithOptions(options MemoryStorageOptions) Storage {
+	return &memoryJWKSet{
+		options: options,
+	}
+}
+
 func (m *memoryJWKSet) KeyDelete(_ context.Context, keyID string) (ok bool, err error) {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 	for i, jwk := range m.set {
 		if jwk.Marshal().KID == keyID {
 			m.set = append(m.set[:i], m.set[i+1:]...)
+			delete(m.used, keyID)
 			return true, nil
 		}
 	}
 	return ok, nil
 }
+func (m *memoryJWKSet) KeyExists(_ context.Context, keyID string) (bool, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	for _, jwk := range m.set {
+		if jwk.Marshal().KID == keyID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 func (m *memoryJWKSet) KeyRead(_ context.Context, keyID string) (JWK, error) {
 	m.mux.RLock()
 	defer m.mux.RUnlock()
 	for _, jwk := range m.set {
 		if jwk.Marshal().KID == keyID {
-			return jwk, nil
+			if used, ok := m.used[keyID]; ok {
+				used.Store(m.tick.Add(1))
+			}
+			return jwk.clone(), nil
 		}
 	}
 	return JWK{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, keyID)
 }
+func (m *memoryJWKSet) KeyReadByAlg(_ context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return cloneJWKs(filterByAlg(m.set, alg, inferred)), nil
+}
+func (m *memoryJWKSet) KeyReadByUse(_ context.Context, use USE) ([]JWK, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return cloneJWKs(filterByUse(m.set, use)), nil
+}
+func (m *memoryJWKSet) KeyReadFunc(_ context.Context, f func(jwk JWK) bool) ([]JWK, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	var matched []JWK
+	for _, jwk := range m.set {
+		if f(jwk) {
+			matched = append(matched, jwk.clone())
+		}
+	}
+	return matched, nil
+}
 func (m *memoryJWKSet) KeyReadAll(_ context.Context) ([]JWK, error) {
 	m.mux.RLock()
 	defer m.mux.RUnlock()
-	return slices.Clone(m.set), nil
+	return cloneJWKs(m.set), nil
+}
+func (m *memoryJWKSet) KeyReadAllPublic(ctx context.Context) ([]JWK, error) {
+	jwks, err := m.KeyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return publicJWKs(jwks), nil
 }
 func (m *memoryJWKSet) KeyWrite(_ context.Context, jwk JWK) error {
+	jwk, err := m.autoKID(jwk)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.put(jwk)
+	m.evict(jwk.Marshal().KID)
+	return nil
+}
+func (m *memoryJWKSet) KeyWriteBatch(_ context.Context, jwks []JWK) error {
+	jwks = slices.Clone(jwks)
+	written := make([]string, len(jwks))
+	for i, jwk := range jwks {
+		var err error
+		jwks[i], err = m.autoKID(jwk)
+		if err != nil {
+			return err
+		}
+		written[i] = jwks[i].Marshal().KID
+	}
 	m.mux.Lock()
 	defer m.mux.Unlock()
+	for _, jwk := range jwks {
+		m.put(jwk)
+	}
+	m.evict(written...)
+	return nil
+}
+func (m *memoryJWKSet) autoKID(jwk JWK) (JWK, error) {
+	if m.options.AutoKID && jwk.Marshal().KID == "" {
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return JWK{}, fmt.Errorf("failed to compute key ID from thumbprint: %w", err)
+		}
+		jwk.marshal.KID = base64.RawURLEncoding.EncodeToString(thumbprint)
+		jwk.options.Metadata.KID = jwk.marshal.KID
+	}
+	return jwk, nil
+}
+func (m *memoryJWKSet) put(jwk JWK) {
+	m.markUsed(jwk.Marshal().KID)
 	for i, j := range m.set {
 		if j.Marshal().KID == jwk.Marshal().KID {
 			m.set[i] = jwk
-			return nil
+			return
 		}
 	}
 	m.set = append(m.set, jwk)
-	return nil
+}
+func (m *memoryJWKSet) markUsed(keyID string) {
+	if m.options.MaxKeys <= 0 {
+		return
+	}
+	if m.used == nil {
+		m.used = make(map[string]*atomic.Uint64)
+	}
+	used, ok := m.used[keyID]
+	if !ok {
+		used = &atomic.Uint64{}
+		m.used[keyID] = used
+	}
+	used.Store(m.tick.Add(1))
+}
+func (m *memoryJWKSet) evict(written ...string) {
+	if m.options.MaxKeys <= 0 {
+		return
+	}
+	for len(m.set) > m.options.MaxKeys {
+		oldest := -1
+		var oldestUsed uint64
+		for i, j := range m.set {
+			kid := j.Marshal().KID
+			if slices.Contains(written, kid) {
+				continue
+			}
+			used := m.used[kid].Load()
+			if oldest == -1 || used < oldestUsed {
+				oldest, oldestUsed = i, used
+			}
+		}
+		if oldest == -1 {
+			return
+		}
+		delete(m.used, m.set[oldest].Marshal().KID)
+		m.set = append(m.set[:oldest], m.set[oldest+1:]...)
+	}
 }
 
 func (m *memoryJWKSet) JSON(ctx context.Context) (json.RawMessage, error) {
@@ -121,6 +346,9 @@ func (m *memoryJWKSet) JSONWithOptions(ctx context.Context, marshalOptions JWKMa
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal JWK Set with options: %w", err)
 	}
+	if marshalOptions.Canonical {
+		return canonicalJSON(jwks)
+	}
 	return json.Marshal(jwks)
 }
 func (m *memoryJWKSet) Marshal(ctx context.Context) (JWKSMarshal, error) {
@@ -153,25 +381,199 @@ func (m *memoryJWKSet) MarshalWithOptions(ctx context.Context, marshalOptions JW
 			}
 			return JWKSMarshal{}, fmt.Errorf("failed to marshal key: %w", err)
 		}
+		marshal.Extra = key.marshal.Extra
 		jwks.Keys = append(jwks.Keys, marshal)
 	}
+	if marshalOptions.SortKeys {
+		sortJWKMarshals(jwks.Keys)
+	}
 
 	return jwks, nil
 }
 
+// cloneJWKs returns a copy of the given JWKs that does not share any slices or maps with them.
+func cloneJWKs(keys []JWK) []JWK {
+	if keys == nil {
+		return nil
+	}
+	cloned := make([]JWK, len(keys))
+	for i, jwk := range keys {
+		cloned[i] = jwk.clone()
+	}
+	return cloned
+}
+
+func filterByAlg(keys []JWK, alg ALG, inferred bool) []JWK {
+	var matched []JWK
+	for _, jwk := range keys {
+		marshal := jwk.Marshal()
+		if marshal.ALG == alg || inferred && marshal.ALG == "" && alg.compatible(marshal.KTY, marshal.CRV) {
+			matched = append(matched, jwk)
+		}
+	}
+	return matched
+}
+
+// snapshotStorage implements the methods of Storage that operate on all keys by reading them with keyReadAll. It is
+// embedded by Storage implementations that do not hold all keys in memory, such as Redis and SQL storage. JSON and
+// Marshal methods copy the keys into a MemoryStorage snapshot, so they are marshaled the same way.
+type snapshotStorage struct {
+	keyReadAll func(ctx context.Context) ([]JWK, error)
+}
+
+func (s snapshotStorage) KeyReadFunc(ctx context.Context, f func(jwk JWK) bool) ([]JWK, error) {
+	jwks, err := s.keyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matched []JWK
+	for _, jwk := range jwks {
+		if f(jwk) {
+			matched = append(matched, jwk)
+		}
+	}
+	return matched, nil
+}
+func (s snapshotStorage) KeyReadAllPublic(ctx context.Context) ([]JWK, error) {
+	jwks, err := s.keyReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return publicJWKs(jwks), nil
+}
+func (s snapshotStorage) JSON(ctx context.Context) (json.RawMessage, error) {
+	m, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.JSON(ctx)
+}
+func (s snapshotStorage) JSONPublic(ctx context.Context) (json.RawMessage, error) {
+	m, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.JSONPublic(ctx)
+}
+func (s snapshotStorage) JSONPrivate(ctx context.Context) (json.RawMessage, error) {
+	m, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.JSONPrivate(ctx)
+}
+func (s snapshotStorage) JSONWithOptions(ctx context.Context, marshalOptions JWKMarshalOptions, validationOptions JWKValidateOptions) (json.RawMessage, error) {
+	m, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.JSONWithOptions(ctx, marshalOptions, validationOptions)
+}
+func (s snapshotStorage) Marshal(ctx context.Context) (JWKSMarshal, error) {
+	m, err := s.snapshot(ctx)
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	return m.Marshal(ctx)
+}
+func (s snapshotStorage) MarshalWithOptions(ctx context.Context, marshalOptions JWKMarshalOptions, validationOptions JWKValidateOptions) (JWKSMarshal, error) {
+	m, err := s.snapshot(ctx)
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	return m.MarshalWithOptions(ctx, marshalOptions, validationOptions)
+}
+func (s snapshotStorage) snapshot(ctx context.Context) (Storage, error) {
+	jwks, err := s.keyReadAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot keys due to error: %w", err)
+	}
+	m := NewMemoryStorage()
+	err = m.KeyWriteBatch(ctx, jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write keys to memory storage due to error: %w", err)
+	}
+	return m, nil
+}
+
+// publicJWKs returns the public form of the given asymmetric keys. Symmetric keys are omitted.
+func publicJWKs(keys []JWK) []JWK {
+	var public []JWK
+	for _, jwk := range keys {
+		p, err := jwk.Public()
+		if err != nil {
+			continue // Symmetric keys have no public form.
+		}
+		public = append(public, p)
+	}
+	return public
+}
+
+func filterByUse(keys []JWK, use USE) []JWK {
+	var matched []JWK
+	for _, jwk := range keys {
+		u := jwk.Marshal().USE
+		if u == use || u == "" {
+			matched = append(matched, jwk)
+		}
+	}
+	return matched
+}
+
 // HTTPClientStorageOptions are used to configure the behavior of NewStorageFromHTTP.
 type HTTPClientStorageOptions struct {
-	// Client is the HTTP client to use for requests.
+	// AllowTrailingData ignores data after the JSON object of the JWK Set in the HTTP response, such as from a
+	// misbehaving server. By default, a response with anything but whitespace after the JSON object is rejected with
+	// ErrTrailingData.
+	AllowTrailingData bool
+
+	// CacheControlMaxInterval is the upper bound for a refresh interval derived from the Cache-Control header when
+	// RespectCacheControl is set. It is also used as the refresh interval when RefreshInterval is not set and the
+	// remote HTTP resource does not provide a max-age.
+	//
+	// This defaults to 24 hours.
+	CacheControlMaxInterval time.Duration
+
+	// CacheControlMinInterval is the lower bound for a refresh interval derived from the Cache-Control header when
+	// RespectCacheControl is set.
+	//
+	// This defaults to time.Minute.
+	CacheControlMinInterval time.Duration
+
+	// CacheFile is the path of a JWK Set file used to warm start the storage. If the file can be read, its keys are
+	// loaded and the first HTTP request happens in the background instead of blocking. After each successful refresh,
+	// the JWK Set is written to the file for the next start. Errors reading or writing the file are passed to
+	// RefreshErrorHandler. The file contains private key material if the remote resource does, so it is written with
+	// 0600 permissions.
+	CacheFile string
+
+	// Client is the HTTP client to use for requests. Provide a custom client, or a client with a custom
+	// http.RoundTripper, to use a proxy, custom TLS roots, or mTLS.
 	//
 	// This defaults to http.DefaultClient.
 	Client *http.Client
 
+	// Clock returns the current time. It is used for the refresh status, MaxStaleness, and, unless set in
+	// ValidateOptions, for validating keys. It does not affect the refresh interval timers. Tests can provide a fake
+	// clock to advance time without sleeping.
+	//
+	// This defaults to time.Now.
+	Clock func() time.Time
+
 	// Ctx is used when performing HTTP requests. It is also used to end the refresh goroutine when it's no longer
-	// needed.
+	// needed. The first HTTP request and the refreshes performed by the refresh goroutine use this context, so values
+	// attached to it, such as a correlation ID from WithRefreshCorrelationID, are passed to RefreshErrorHandler and the
+	// hooks. On-demand refreshes caused by the RefreshUnknownKID option of HTTPClientOptions use the context of the read
+	// instead.
 	//
 	// This defaults to context.Background().
 	Ctx context.Context
 
+	// Header is added to each HTTP request, such as an Authorization header for a protected JWK Set endpoint. Headers
+	// set internally, such as If-None-Match, take precedence. An Accept-Encoding header replaces the default of
+	// "gzip, deflate". Responses with a gzip or deflate Content-Encoding are decompressed either way.
+	Header http.Header
+
 	// HTTPExpectedStatus is the expected HTTP status code for the HTTP request.
 	//
 	// This defaults to http.StatusOK.
@@ -188,11 +590,68 @@ type HTTPClientStorageOptions struct {
 	// This defaults to time.Minute.
 	HTTPTimeout time.Duration
 
+	// InitialRetryInterval is the delay before retrying in the background until the first successful refresh, such as
+	// after the first HTTP request failed with NoErrorReturnFirstHTTPReq set. Each following retry doubles the delay, up
+	// to the delay of the normal refresh interval, and RefreshIntervalJitter applies to each delay. After the first
+	// successful refresh, the refresh goroutine continues with the normal refresh interval, or exits if there is none.
+	// Keys loaded from the CacheFile option count as a successful refresh. The retries end when the Ctx option is
+	// canceled or the storage is closed.
+	//
+	// This defaults to 0, which means a failed first HTTP request is retried at the normal refresh interval.
+	InitialRetryInterval time.Duration
+
+	// Logger is used to log, such as when a panic in a hook is recovered. NewDefaultHTTPClient also uses it to log
+	// refresh failures, unless the RefreshErrorHandler option is set.
+	//
+	// This defaults to slog.Default().
+	Logger *slog.Logger
+
+	// MaxKeys is the maximum number of keys in the JWK Set from the HTTP response. Decoding stops as soon as the limit
+	// is exceeded and the refresh fails with an error that wraps ErrTooManyKeys.
+	//
+	// This defaults to 0, which means there is no limit.
+	MaxKeys int
+
+	// MaxResponseBytes is the maximum size of the HTTP response body in bytes. The body is decoded as it is read, and
+	// a larger body causes the refresh to fail with an error that wraps ErrResponseTooLarge. For a compressed response,
+	// the limit applies to the decompressed body.
+	//
+	// This defaults to 0, which means there is no limit.
+	MaxResponseBytes int64
+
+	// MaxStaleness is how long the keys from the last successful refresh are served while refreshes fail. After this
+	// duration has passed since the last successful refresh, reads return an error that wraps ErrKeysStale until a
+	// refresh succeeds. A response with http.StatusNotModified counts as a successful refresh.
+	//
+	// This defaults to 0, which means keys are served regardless of how long refreshes have been failing.
+	MaxStaleness time.Duration
+
 	// NoErrorReturnFirstHTTPReq will create the Storage without error if the first HTTP request fails.
 	NoErrorReturnFirstHTTPReq bool
 
+	// PersistHook is called with the HTTP URL and the JWK Set after each successful refresh that processed a response
+	// body, so the keys can be persisted elsewhere, such as for a warm start with the CacheFile option or to share them
+	// between processes. An error returned by the hook is passed to RefreshErrorHandler and does not fail the refresh. A
+	// panic in the hook is recovered and logged.
+	PersistHook func(ctx context.Context, url string, set JWKSMarshal) error
+
+	// Priority determines the order in which the client created by NewHTTPClient consults this storage, relative to
+	// the storage for other HTTP URLs. Storage with a higher priority is consulted first, so it wins when the same key
+	// ID exists at more than one HTTP URL.
+	//
+	// This defaults to 0.
+	Priority int
+
+	// RefreshBackoff configures retries with exponential backoff for failed refreshes performed by the refresh
+	// goroutine. RefreshErrorHandler is only called after the final attempt fails.
+	//
+	// This defaults to no retries.
+	RefreshBackoff RefreshBackoff
+
 	// RefreshErrorHandler is a function that consumes errors that happen during an HTTP refresh. This is only effectual
-	// if RefreshInterval is set.
+	// if RefreshInterval is set or an on-demand refresh is performed. The context is the one used for the refresh, see
+	// the Ctx option. Use errors.Is with ErrRefreshNetwork, ErrRefreshDecode, and ErrRefreshValidate to classify the
+	// error.
 	//
 	// If NoErrorReturnFirstHTTPReq is set, this function will be called when if the first HTTP request fails.
 	RefreshErrorHandler func(ctx context.Context, err error)
@@ -203,26 +662,212 @@ type HTTPClientStorageOptions struct {
 	// Provide the Ctx option to end the goroutine when it's no longer needed.
 	RefreshInterval time.Duration
 
+	// RefreshIntervalJitter randomly shortens the delay before each refresh performed by the refresh goroutine by up to
+	// this duration, so a fleet of processes started at the same time spreads its refreshes over the window instead of
+	// refreshing together. If NoErrorReturnFirstHTTPReq is also set, the first HTTP request is made in the background
+	// after a random delay of up to this duration, instead of before NewStorageFromHTTP returns.
+	//
+	// This defaults to 0, which means there is no jitter.
+	RefreshIntervalJitter time.Duration
+
+	// RefreshMetricsContextHook is like RefreshMetricsHook, but it also receives the context used for the refresh
+	// attempt, so metrics can be correlated with the read that caused it. See the Ctx option and RefreshCorrelationID.
+	RefreshMetricsContextHook func(ctx context.Context, url string, duration time.Duration, err error)
+
+	// RefreshMetricsHook is called after every refresh attempt with the HTTP URL, the duration of the attempt, and the
+	// error, if any. It is intended for recording metrics, such as refresh counts, failures, and latency. A panic in the
+	// hook is recovered and logged.
+	RefreshMetricsHook func(url string, duration time.Duration, err error)
+
+	// RefreshRateLimiter is waited on before each HTTP request for the JWK Set, including the first request, the
+	// requests of the refresh goroutine, and on-demand refreshes. Share one limiter between storage for many HTTP URLs,
+	// or use the RefreshRateLimiter option of HTTPClientOptions, to cap the total rate of outbound requests.
+	//
+	// This defaults to nil, which means refreshes are not rate limited.
+	RefreshRateLimiter *rate.Limiter
+
+	// RefreshUnknownKIDHook is called with the HTTP URL and the key ID when the client created by NewHTTPClient
+	// performs an on-demand refresh because of an unknown key ID. See the RefreshUnknownKID option of
+	// HTTPClientOptions. A panic in the hook is recovered and logged.
+	RefreshUnknownKIDHook func(url string, keyID string)
+
+	// RequestAuthorizer is called with each HTTP request for the JWK Set after all headers are set and before it is
+	// sent, so credentials can be added, such as a bearer token that is refreshed as needed, or the request can be
+	// signed. An error fails the refresh. For mutual TLS, use the Client option instead. Pass the same function to
+	// NewGetX5U to authorize requests for X.509 certificate chains.
+	RequestAuthorizer func(ctx context.Context, req *http.Request) error
+
+	// RespectCacheControl uses the max-age directive of the Cache-Control header from the last HTTP response to
+	// schedule the next refresh. This option will launch a refresh goroutine even if RefreshInterval is not set. If
+	// RefreshInterval is also set, it is used unless the max-age is shorter.
+	RespectCacheControl bool
+
 	// Storage is the underlying storage implementation to use.
 	//
 	// This defaults to NewMemoryStorage().
 	Storage Storage
+
+	// StrictContentType is used to reject HTTP responses whose Content-Type header is not ContentTypeJWKSet. Media type
+	// parameters, such as charset, are ignored. By default, the Content-Type header is not checked, because many
+	// servers respond with application/json or text/plain, and the body is always parsed as JSON.
+	StrictContentType bool
+
+	// UseConditionalRequests will store the ETag header from the last successful HTTP response and send it in the
+	// If-None-Match header of the next refresh. If the server responds with http.StatusNotModified, the existing keys
+	// are kept and the response body is not processed.
+	UseConditionalRequests bool
+
+	// UserAgent is the User-Agent header of each HTTP request. A User-Agent in the Header option takes precedence. Pass
+	// the same value to NewGetX5U to identify requests for X.509 certificate chains.
+	//
+	// This defaults to DefaultUserAgent.
+	UserAgent string
+
+	// ValidateOptions are used to validate each JWK in the HTTP response. Set its GetX5U field to a function returned
+	// from NewGetX5U, with the same Client, Header, RequestAuthorizer, and UserAgent, to fetch and verify certificate
+	// chains referenced by the x5u parameter.
+	ValidateOptions JWKValidateOptions
+}
+
+// RefreshBackoff configures exponential backoff with jitter for retrying a failed HTTP refresh.
+type RefreshBackoff struct {
+	// Base is the delay before the first retry. Each following retry doubles the previous delay.
+	Base time.Duration
+	// Max is the upper bound for the delay between attempts. A zero value means there is no upper bound.
+	Max time.Duration
+	// Jitter is a factor between 0 and 1 used to randomly shorten each delay. For example, a value of 0.2 will
+	// randomly reduce each delay by up to 20%.
+	Jitter float64
+	// MaxAttempts is the maximum number of HTTP requests to perform for a single refresh, including the first. A
+	// value less than 2 disables retries.
+	MaxAttempts int
+}
+
+func (b RefreshBackoff) delay(retry int) time.Duration {
+	d := b.Base
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if b.Max > 0 && d >= b.Max {
+			break
+		}
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		jitter := b.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+	return d
+}
+
+var (
+	_ CachedJSONPublicProvider = &httpStorage{}
+	_ RefreshStatusProvider    = &httpStorage{}
+	_ KeySetSubscriber         = &httpStorage{}
+)
+
+// RefreshInfo describes the refreshes of a remote HTTP resource for a JWK Set.
+type RefreshInfo struct {
+	// KeyCount is the number of keys in the last successfully refreshed JWK Set.
+	KeyCount int
+	// LastAttempt is the time the last refresh started, successful or not.
+	LastAttempt time.Time
+	// LastError is the error from the last refresh. It is nil if the last refresh succeeded.
+	LastError error
+	// LastSuccess is the time of the last successful refresh. It is the zero time if no refresh has succeeded.
+	LastSuccess time.Time
+}
+
+// RefreshStatusProvider is implemented by Storage that refreshes keys from remote HTTP resources, such as the Storage
+// returned by NewStorageFromHTTP and NewHTTPClient. It can be used to build health checks.
+type RefreshStatusProvider interface {
+	// RefreshStatus returns the refresh information for each remote HTTP resource, keyed by URL.
+	RefreshStatus() map[string]RefreshInfo
+}
+
+// CachedJSONPublicProvider is implemented by Storage that caches its public JWK Set between refreshes, such as the
+// Storage returned by NewStorageFromHTTP. It can be used to serve a JWK Set without marshaling it on every request.
+type CachedJSONPublicProvider interface {
+	// CachedJSONPublic returns the same JWK Set as JSONPublic. The JSON is marshaled once and reused until the keys
+	// change through a refresh or a write.
+	CachedJSONPublic(ctx context.Context) (json.RawMessage, error)
+}
+
+// KeySetChangeBuffer is the number of events buffered by the channel returned from KeySetSubscriber.Subscribe.
+const KeySetChangeBuffer = 16
+
+// KeySetChange describes a refresh that added, removed, or changed keys in the JWK Set of a remote HTTP resource.
+type KeySetChange struct {
+	// Diff compares the previous JWK Set to the refreshed JWK Set.
+	Diff JWKSDiff
+	// Time is the time of the refresh.
+	Time time.Time
+	// URL is the URL of the remote HTTP resource.
+	URL string
+}
+
+// KeySetSubscriber is implemented by Storage that refreshes keys from a remote HTTP resource, such as the Storage
+// returned by NewStorageFromHTTP. It can be used to react to key rotation.
+type KeySetSubscriber interface {
+	// Subscribe returns a channel that receives an event after each refresh that changes the JWK Set, and a function
+	// that unsubscribes and closes the channel. Events are delivered on a best-effort basis. The channel buffers
+	// KeySetChangeBuffer events, and events are dropped while the buffer is full, so a refresh never waits for a
+	// subscriber. The channel is also closed when the Storage is closed.
+	Subscribe() (<-chan KeySetChange, func())
 }
 
 type httpStorage struct {
 	options HTTPClientStorageOptions
-	refresh func(ctx context.Context) error
+	u       *url.URL
+
+	mux         sync.Mutex
+	etag        string
+	lastRefresh time.Time
+	maxAge      time.Duration
+	keyCount    int
+	lastAttempt time.Time
+	lastErr     error
+	lastJWKS    JWKSMarshal
+	publicJSON  json.RawMessage // Cached result of JSONPublic. Nil if the keys changed since it was cached.
+	publicGen   uint64          // Incremented whenever publicJSON is invalidated.
+
+	subscribersMux sync.Mutex
+	subscribers    map[chan KeySetChange]struct{}
+
+	cancel  context.CancelFunc
+	closed  atomic.Bool
+	done    chan struct{}                // Closed when the refresh goroutine exits. Nil if there is no refresh goroutine.
+	limiter atomic.Pointer[rate.Limiter] // Set from the RefreshRateLimiter option, or later by NewHTTPClient.
+
 	Storage
 }
 
 // NewStorageFromHTTP creates a new Storage implementation that processes a remote HTTP resource for a JWK Set. If
 // the RefreshInterval option is not set, the remote HTTP resource will be requested and processed before returning. If
 // the RefreshInterval option is set, a background goroutine will be launched to refresh the remote HTTP resource and
-// not block the return of this function.
+// not block the return of this function. If keys are loaded from the CacheFile option, the first request is also made
+// in the background.
+//
+// The remote HTTP resource may be a JWK Set or a single JWK, such as an application/jwk+json document. A single JWK is
+// detected by the absence of the "keys" member.
+//
+// The returned Storage implements RefreshStatusProvider, KeySetSubscriber, and io.Closer. Closing it stops the refresh
+// goroutine, waits for it to exit, closes subscribed channels, and makes further Storage method calls return
+// ErrClosed.
 func NewStorageFromHTTP(u *url.URL, options HTTPClientStorageOptions) (Storage, error) {
 	if options.Client == nil {
 		options.Client = http.DefaultClient
 	}
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+	if options.ValidateOptions.Clock == nil {
+		options.ValidateOptions.Clock = options.Clock
+	}
 	if options.Ctx == nil {
 		options.Ctx = context.Background()
 	}
@@ -235,85 +880,871 @@ func NewStorageFromHTTP(u *url.URL, options HTTPClientStorageOptions) (Storage,
 	if options.HTTPMethod == "" {
 		options.HTTPMethod = http.MethodGet
 	}
+	if options.CacheControlMinInterval == 0 {
+		options.CacheControlMinInterval = time.Minute
+	}
+	if options.CacheControlMaxInterval == 0 {
+		options.CacheControlMaxInterval = 24 * time.Hour
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
 	store := options.Storage
 	if store == nil {
 		store = NewMemoryStorage()
 	}
 
-	refresh := func(ctx context.Context) error {
-		req, err := http.NewRequestWithContext(ctx, options.HTTPMethod, u.String(), nil)
-		if err != nil {
-			return fmt.Errorf("failed to create HTTP request for JWK Set refresh: %w", err)
-		}
-		resp, err := options.Client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to perform HTTP request for JWK Set refresh: %w", err)
-		}
-		//goland:noinspection GoUnhandledErrorResult
-		defer resp.Body.Close()
-		if resp.StatusCode != options.HTTPExpectedStatus {
-			return fmt.Errorf("%w: %d", ErrInvalidHTTPStatusCode, resp.StatusCode)
+	ctx, closeFunc := context.WithCancel(options.Ctx)
+	options.Ctx = ctx
+	s := &httpStorage{
+		options: options,
+		u:       u,
+		cancel:  closeFunc,
+		Storage: store,
+	}
+	s.limiter.Store(options.RefreshRateLimiter)
+
+	warm := false
+	if options.CacheFile != "" {
+		var err error
+		warm, err = s.loadCacheFile()
+		if err != nil && options.RefreshErrorHandler != nil {
+			options.RefreshErrorHandler(options.Ctx, err)
 		}
-		var jwks JWKSMarshal
-		err = json.NewDecoder(resp.Body).Decode(&jwks)
-		if err != nil {
-			return fmt.Errorf("failed to decode JWK Set response: %w", err)
+	}
+
+	deferred := !warm && options.NoErrorReturnFirstHTTPReq && options.RefreshIntervalJitter > 0
+	ctx, cancel := context.WithTimeout(options.Ctx, options.HTTPTimeout)
+	defer cancel()
+	var err error
+	if !warm && !deferred {
+		err = s.refresh(ctx)
+	}
+	cancel()
+	if err != nil {
+		if !options.NoErrorReturnFirstHTTPReq {
+			closeFunc()
+			return nil, fmt.Errorf("failed to perform first HTTP request for JWK Set: %w", err)
 		}
-		for _, marshal := range jwks.Keys {
-			marshalOptions := JWKMarshalOptions{
-				Private: true,
-			}
-			jwk, err := NewJWKFromMarshal(marshal, marshalOptions, JWKValidateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create JWK from JWK Marshal: %w", err)
-			}
-			err = store.KeyWrite(options.Ctx, jwk)
-			if err != nil {
-				return fmt.Errorf("failed to write JWK to memory storage: %w", err)
-			}
+		if options.RefreshErrorHandler != nil {
+			options.RefreshErrorHandler(ctx, err)
 		}
-		return nil
 	}
 
-	if options.RefreshInterval != 0 {
+	periodic := options.RefreshInterval != 0 || options.RespectCacheControl
+	retrying := options.InitialRetryInterval > 0 && (err != nil || deferred)
+	if periodic || warm || deferred || retrying {
+		s.done = make(chan struct{})
 		go func() { // Refresh goroutine.
-			ticker := time.NewTicker(options.RefreshInterval)
-			defer ticker.Stop()
+			defer close(s.done)
+			if deferred {
+				timer := time.NewTimer(time.Duration(rand.Int63n(int64(options.RefreshIntervalJitter))))
+				select {
+				case <-options.Ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+			if warm || deferred {
+				err := s.refreshWithBackoff()
+				if err != nil && options.RefreshErrorHandler != nil {
+					options.RefreshErrorHandler(options.Ctx, err)
+				}
+			}
+			retry := options.InitialRetryInterval
+			next := func() (time.Duration, bool) {
+				if retry > 0 && !s.refreshed() {
+					d := retry
+					limit := s.nextRefresh()
+					if limit == 0 {
+						limit = options.CacheControlMaxInterval
+					}
+					retry = max(min(2*retry, limit), retry)
+					return s.jitter(d), true
+				}
+				return s.jitter(s.nextRefresh()), periodic
+			}
+			d, ok := next()
+			if !ok {
+				return
+			}
+			timer := time.NewTimer(d)
+			defer timer.Stop()
 			for {
 				select {
 				case <-options.Ctx.Done():
 					return
-				case <-ticker.C:
-					ctx, cancel := context.WithTimeout(options.Ctx, options.HTTPTimeout)
-					err := refresh(ctx)
-					cancel()
+				case <-timer.C:
+					err := s.refreshWithBackoff()
 					if err != nil && options.RefreshErrorHandler != nil {
-						options.RefreshErrorHandler(ctx, err)
+						options.RefreshErrorHandler(options.Ctx, err)
+					}
+					d, ok = next()
+					if !ok {
+						return
 					}
+					timer.Reset(d)
 				}
 			}
 		}()
 	}
 
-	ctx, cancel := context.WithTimeout(options.Ctx, options.HTTPTimeout)
-	defer cancel()
-	err := refresh(ctx)
-	cancel()
+	return s, nil
+}
+
+// Close stops the refresh goroutine and waits for it to exit. It is safe to call more than once.
+func (s *httpStorage) Close() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	s.cancel()
+	if s.done != nil {
+		<-s.done
+	}
+	s.subscribersMux.Lock()
+	defer s.subscribersMux.Unlock()
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+	return nil
+}
+
+// Subscribe implements KeySetSubscriber.
+func (s *httpStorage) Subscribe() (<-chan KeySetChange, func()) {
+	ch := make(chan KeySetChange, KeySetChangeBuffer)
+	s.subscribersMux.Lock()
+	defer s.subscribersMux.Unlock()
+	if s.closed.Load() {
+		close(ch)
+		return ch, func() {}
+	}
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan KeySetChange]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		s.subscribersMux.Lock()
+		defer s.subscribersMux.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish sends the change to each subscriber without waiting.
+func (s *httpStorage) publish(change KeySetChange) {
+	s.subscribersMux.Lock()
+	defer s.subscribersMux.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// RefreshStatus implements RefreshStatusProvider.
+func (s *httpStorage) RefreshStatus() map[string]RefreshInfo {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return map[string]RefreshInfo{
+		s.u.String(): {
+			KeyCount:    s.keyCount,
+			LastAttempt: s.lastAttempt,
+			LastError:   s.lastErr,
+			LastSuccess: s.lastRefresh,
+		},
+	}
+}
+
+// refreshed reports whether keys were successfully refreshed or loaded from the CacheFile option.
+func (s *httpStorage) refreshed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return !s.lastRefresh.IsZero()
+}
+
+// readable returns an error if the keys should not be read, because the storage is closed or the keys are stale.
+func (s *httpStorage) readable() error {
+	if s.closed.Load() {
+		return ErrClosed
+	}
+	if s.options.MaxStaleness > 0 {
+		s.mux.Lock()
+		lastRefresh := s.lastRefresh
+		s.mux.Unlock()
+		if s.options.Clock().Sub(lastRefresh) > s.options.MaxStaleness {
+			return fmt.Errorf("%w: last successful refresh of %q was at %s", ErrKeysStale, s.u.String(), lastRefresh.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+func (s *httpStorage) KeyDelete(ctx context.Context, keyID string) (ok bool, err error) {
+	if s.closed.Load() {
+		return false, ErrClosed
+	}
+	defer s.invalidatePublicJSON()
+	return s.Storage.KeyDelete(ctx, keyID)
+}
+func (s *httpStorage) KeyExists(ctx context.Context, keyID string) (bool, error) {
+	err := s.readable()
+	if err != nil {
+		return false, err
+	}
+	return s.Storage.KeyExists(ctx, keyID)
+}
+func (s *httpStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	err := s.readable()
+	if err != nil {
+		return JWK{}, err
+	}
+	return s.Storage.KeyRead(ctx, keyID)
+}
+func (s *httpStorage) KeyReadByAlg(ctx context.Context, alg ALG, inferred bool) ([]JWK, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.KeyReadByAlg(ctx, alg, inferred)
+}
+func (s *httpStorage) KeyReadByUse(ctx context.Context, use USE) ([]JWK, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.KeyReadByUse(ctx, use)
+}
+func (s *httpStorage) KeyReadFunc(ctx context.Context, f func(jwk JWK) bool) ([]JWK, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.KeyReadFunc(ctx, f)
+}
+func (s *httpStorage) KeyReadAll(ctx context.Context) ([]JWK, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.KeyReadAll(ctx)
+}
+func (s *httpStorage) KeyReadAllPublic(ctx context.Context) ([]JWK, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.KeyReadAllPublic(ctx)
+}
+func (s *httpStorage) KeyWrite(ctx context.Context, jwk JWK) error {
+	if s.closed.Load() {
+		return ErrClosed
+	}
+	defer s.invalidatePublicJSON()
+	return s.Storage.KeyWrite(ctx, jwk)
+}
+func (s *httpStorage) KeyWriteBatch(ctx context.Context, jwks []JWK) error {
+	if s.closed.Load() {
+		return ErrClosed
+	}
+	defer s.invalidatePublicJSON()
+	return s.Storage.KeyWriteBatch(ctx, jwks)
+}
+func (s *httpStorage) JSON(ctx context.Context) (json.RawMessage, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.JSON(ctx)
+}
+func (s *httpStorage) JSONPublic(ctx context.Context) (json.RawMessage, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.JSONPublic(ctx)
+}
+func (s *httpStorage) JSONPrivate(ctx context.Context) (json.RawMessage, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.JSONPrivate(ctx)
+}
+func (s *httpStorage) JSONWithOptions(ctx context.Context, marshalOptions JWKMarshalOptions, validationOptions JWKValidateOptions) (json.RawMessage, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	return s.Storage.JSONWithOptions(ctx, marshalOptions, validationOptions)
+}
+func (s *httpStorage) Marshal(ctx context.Context) (JWKSMarshal, error) {
+	err := s.readable()
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	return s.Storage.Marshal(ctx)
+}
+func (s *httpStorage) MarshalWithOptions(ctx context.Context, marshalOptions JWKMarshalOptions, validationOptions JWKValidateOptions) (JWKSMarshal, error) {
+	err := s.readable()
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	return s.Storage.MarshalWithOptions(ctx, marshalOptions, validationOptions)
+}
+
+// CachedJSONPublic implements CachedJSONPublicProvider.
+func (s *httpStorage) CachedJSONPublic(ctx context.Context) (json.RawMessage, error) {
+	err := s.readable()
+	if err != nil {
+		return nil, err
+	}
+	s.mux.Lock()
+	cached, gen := s.publicJSON, s.publicGen
+	s.mux.Unlock()
+	if cached != nil {
+		return slices.Clone(cached), nil
+	}
+	raw, err := s.Storage.JSONPublic(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mux.Lock()
+	if s.publicGen == gen {
+		s.publicJSON = slices.Clone(raw)
+	}
+	s.mux.Unlock()
+	return raw, nil
+}
+
+// invalidatePublicJSON discards the JSON cached by CachedJSONPublic.
+func (s *httpStorage) invalidatePublicJSON() {
+	s.mux.Lock()
+	s.publicJSON = nil
+	s.publicGen++
+	s.mux.Unlock()
+}
+
+func (s *httpStorage) refresh(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		// Not a refresh attempt, so the refresh status and hooks are left alone.
+		return fmt.Errorf("failed to start JWK Set refresh: %w", err)
+	}
+	start := time.Now()
+	attempt := s.options.Clock()
+	err = s.refreshJWKS(ctx)
+	s.mux.Lock()
+	s.lastAttempt = attempt
+	s.lastErr = err
+	s.mux.Unlock()
+	duration := time.Since(start)
+	if s.options.RefreshMetricsHook != nil {
+		runHook(ctx, s.options.Logger, "RefreshMetricsHook", func() {
+			s.options.RefreshMetricsHook(s.u.String(), duration, err)
+		})
+	}
+	if s.options.RefreshMetricsContextHook != nil {
+		runHook(ctx, s.options.Logger, "RefreshMetricsContextHook", func() {
+			s.options.RefreshMetricsContextHook(ctx, s.u.String(), duration, err)
+		})
+	}
+	return err
+}
+func (s *httpStorage) refreshJWKS(ctx context.Context) error {
+	if limiter := s.limiter.Load(); limiter != nil {
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for JWK Set refresh rate limiter: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, s.options.HTTPMethod, s.u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request for JWK Set refresh: %w", err)
+	}
+	addHeader(req, s.options.Header, s.options.UserAgent)
+	if req.Header.Get("Accept-Encoding") == "" {
+		// Setting the header disables the transparent decompression of the http.Transport, so the response is
+		// decompressed by decompressBody.
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	if s.options.UseConditionalRequests {
+		s.mux.Lock()
+		etag := s.etag
+		s.mux.Unlock()
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+	}
+	if s.options.RequestAuthorizer != nil {
+		err = s.options.RequestAuthorizer(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to authorize HTTP request for JWK Set refresh: %w", err)
+		}
+	}
+	resp, err := s.options.Client.Do(req)
+	if err != nil {
+		return refreshError{class: ErrRefreshNetwork, err: fmt.Errorf("failed to perform HTTP request for JWK Set refresh: %w", err)}
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if s.options.UseConditionalRequests && resp.StatusCode == http.StatusNotModified {
+		s.mux.Lock()
+		s.lastRefresh = s.options.Clock()
+		s.maxAge = s.cacheControlInterval(resp.Header)
+		s.mux.Unlock()
+		return nil
+	}
+	if resp.StatusCode != s.options.HTTPExpectedStatus {
+		return refreshError{class: ErrRefreshNetwork, err: fmt.Errorf("%w: %d", ErrInvalidHTTPStatusCode, resp.StatusCode)}
+	}
+	if s.options.StrictContentType {
+		contentType := resp.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != ContentTypeJWKSet {
+			return refreshError{class: ErrRefreshDecode, err: fmt.Errorf("%w: %q", ErrContentType, contentType)}
+		}
+	}
+	decompressed, err := decompressBody(resp)
+	if err != nil {
+		return refreshError{class: ErrRefreshDecode, err: err}
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer decompressed.Close()
+	var body io.Reader = decompressed
+	var counter *countingReader
+	if s.options.MaxResponseBytes > 0 {
+		counter = &countingReader{r: io.LimitReader(body, s.options.MaxResponseBytes+1)}
+		body = counter
+	}
+	jwks, err := decodeJWKS(body, s.options.MaxKeys, s.options.AllowTrailingData)
+	if counter != nil && counter.n > s.options.MaxResponseBytes {
+		return refreshError{class: ErrRefreshDecode, err: fmt.Errorf("%w: limit is %d bytes", ErrResponseTooLarge, s.options.MaxResponseBytes)}
+	}
+	if err != nil {
+		return refreshError{class: ErrRefreshDecode, err: fmt.Errorf("failed to decode JWK Set response: %w", err)}
+	}
+	err = decompressed.Close()
 	if err != nil {
-		if options.NoErrorReturnFirstHTTPReq {
-			if options.RefreshErrorHandler != nil {
-				options.RefreshErrorHandler(ctx, err)
+		return refreshError{class: ErrRefreshDecode, err: fmt.Errorf("failed to decode JWK Set response: %w", err)}
+	}
+	err = s.checkUniqueKIDs(jwks)
+	if err != nil {
+		return refreshError{class: ErrRefreshValidate, err: err}
+	}
+	keys, err := s.newJWKs(jwks)
+	if err != nil {
+		return refreshError{class: ErrRefreshValidate, err: err}
+	}
+	err = s.Storage.KeyWriteBatch(s.options.Ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to write JWK Set to memory storage: %w", err)
+	}
+	s.mux.Lock()
+	if s.options.UseConditionalRequests {
+		s.etag = resp.Header.Get("ETag")
+	}
+	s.keyCount = len(jwks.Keys)
+	s.lastRefresh = s.options.Clock()
+	s.maxAge = s.cacheControlInterval(resp.Header)
+	s.publicJSON = nil
+	s.publicGen++
+	diff := DiffJWKS(s.lastJWKS, jwks)
+	s.lastJWKS = jwks
+	change := KeySetChange{
+		Diff: diff,
+		Time: s.lastRefresh,
+		URL:  s.u.String(),
+	}
+	s.mux.Unlock()
+	if !diff.Empty() {
+		s.publish(change)
+	}
+	if s.options.CacheFile != "" {
+		s.writeCacheFile(jwks)
+	}
+	if s.options.PersistHook != nil {
+		var err error
+		runHook(ctx, s.options.Logger, "PersistHook", func() {
+			err = s.options.PersistHook(ctx, s.u.String(), jwks)
+		})
+		if err != nil && s.options.RefreshErrorHandler != nil {
+			s.options.RefreshErrorHandler(ctx, fmt.Errorf("failed to persist JWK Set: %w", err))
+		}
+	}
+	return nil
+}
+
+// checkUniqueKIDs checks the JWK Set for duplicate key IDs if the RejectDuplicateKIDs validation option is set.
+func (s *httpStorage) checkUniqueKIDs(jwks JWKSMarshal) error {
+	validate := s.options.ValidateOptions
+	if !validate.RejectDuplicateKIDs || validate.SkipAll {
+		return nil
+	}
+	return jwks.CheckUniqueKIDs(validate.AllowDuplicateKIDDifferentUse)
+}
+
+// decodeJWKS decodes a JWK Set from the reader. A single JWK, which is an object with a "kty" member but no "keys"
+// member, is decoded as a JWK Set with one key. If maxKeys is positive, decoding stops with ErrTooManyKeys as soon as
+// the JWK Set is found to have more keys. A leading UTF-8 byte order mark is skipped. Unless allowTrailing is true,
+// anything but whitespace after the JSON object is rejected with ErrTrailingData.
+func decodeJWKS(r io.Reader, maxKeys int, allowTrailing bool) (JWKSMarshal, error) {
+	var jwks JWKSMarshal
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(len(utf8BOM))
+	if bytes.Equal(bom, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
+	}
+	dec := json.NewDecoder(br)
+	tok, err := dec.Token()
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	if tok != json.Delim('{') {
+		return JWKSMarshal{}, fmt.Errorf("expected JSON object for JWK Set, got %v", tok)
+	}
+	foundKeys := false
+	members := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return JWKSMarshal{}, err
+		}
+		name, _ := tok.(string)
+		if !strings.EqualFold(name, "keys") {
+			var member json.RawMessage
+			err = dec.Decode(&member)
+			if err != nil {
+				return JWKSMarshal{}, err
+			}
+			if !foundKeys {
+				members[name] = member
+			}
+			continue
+		}
+		foundKeys = true
+		members = nil
+		tok, err = dec.Token()
+		if err != nil {
+			return JWKSMarshal{}, err
+		}
+		if tok == nil {
+			jwks.Keys = nil
+			continue
+		}
+		if tok != json.Delim('[') {
+			return JWKSMarshal{}, fmt.Errorf("expected JSON array for JWK Set keys, got %v", tok)
+		}
+		jwks.Keys = nil
+		for dec.More() {
+			if maxKeys > 0 && len(jwks.Keys) >= maxKeys {
+				return JWKSMarshal{}, fmt.Errorf("%w: limit is %d keys", ErrTooManyKeys, maxKeys)
 			}
-			return store, nil
+			var marshal JWKMarshal
+			err = dec.Decode(&marshal)
+			if err != nil {
+				return JWKSMarshal{}, err
+			}
+			jwks.Keys = append(jwks.Keys, marshal)
+		}
+		_, err = dec.Token() // Closing bracket.
+		if err != nil {
+			return JWKSMarshal{}, err
+		}
+	}
+	_, err = dec.Token() // Closing brace.
+	if err != nil {
+		return JWKSMarshal{}, err
+	}
+	if !allowTrailing {
+		_, err = dec.Token()
+		if !errors.Is(err, io.EOF) {
+			return JWKSMarshal{}, ErrTrailingData
+		}
+	}
+	if _, ok := members["kty"]; ok {
+		raw, err := json.Marshal(members)
+		if err != nil {
+			return JWKSMarshal{}, fmt.Errorf("failed to marshal single JWK members: %w", err)
 		}
-		return nil, fmt.Errorf("failed to perform first HTTP request for JWK Set: %w", err)
+		var marshal JWKMarshal
+		err = json.Unmarshal(raw, &marshal)
+		if err != nil {
+			return JWKSMarshal{}, fmt.Errorf("failed to unmarshal single JWK: %w", err)
+		}
+		jwks.Keys = []JWKMarshal{marshal}
 	}
+	return jwks, nil
+}
 
-	s := httpStorage{
-		options: options,
-		refresh: refresh,
-		Storage: store,
+// loadCacheFile loads the keys from the CacheFile option. A false value is returned if the file does not exist.
+func (s *httpStorage) loadCacheFile() (bool, error) {
+	f, err := os.Open(s.options.CacheFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open JWK Set cache file: %w", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat JWK Set cache file: %w", err)
+	}
+	jwks, err := decodeJWKS(f, s.options.MaxKeys, s.options.AllowTrailingData)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode JWK Set cache file: %w", err)
+	}
+	err = s.checkUniqueKIDs(jwks)
+	if err != nil {
+		return false, fmt.Errorf("failed to validate JWK Set cache file: %w", err)
+	}
+	keys, err := s.newJWKs(jwks)
+	if err != nil {
+		return false, fmt.Errorf("failed to validate JWK Set cache file: %w", err)
+	}
+	err = s.Storage.KeyWriteBatch(s.options.Ctx, keys)
+	if err != nil {
+		return false, fmt.Errorf("failed to write JWK Set cache file to storage: %w", err)
 	}
+	s.mux.Lock()
+	s.keyCount = len(jwks.Keys)
+	s.lastRefresh = info.ModTime()
+	s.lastJWKS = jwks
+	s.publicJSON = nil
+	s.publicGen++
+	s.mux.Unlock()
+	return true, nil
+}
 
-	return s, nil
+// newJWKs creates a JWK for every key in the JWK Set. Nothing is written to storage, so a single invalid key can be
+// rejected before any key from the same JWK Set is stored.
+func (s *httpStorage) newJWKs(jwks JWKSMarshal) ([]JWK, error) {
+	marshalOptions := JWKMarshalOptions{
+		Private: true,
+	}
+	keys := make([]JWK, 0, len(jwks.Keys))
+	for _, marshal := range jwks.Keys {
+		jwk, err := NewJWKFromMarshal(marshal, marshalOptions, s.options.ValidateOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JWK from JWK Marshal: %w", err)
+		}
+		keys = append(keys, jwk)
+	}
+	return keys, nil
+}
+
+// writeCacheFile writes the JWK Set to the CacheFile option.
+func (s *httpStorage) writeCacheFile(jwks JWKSMarshal) {
+	raw, err := json.Marshal(jwks)
+	if err == nil {
+		err = writeFileAtomic(s.options.CacheFile, raw, 0600)
+	}
+	if err != nil && s.options.RefreshErrorHandler != nil {
+		s.options.RefreshErrorHandler(s.options.Ctx, fmt.Errorf("failed to write JWK Set cache file: %w", err))
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// cacheControlInterval returns the max-age from the Cache-Control header clamped to the configured bounds. Zero is
+// returned if there is no usable max-age.
+func (s *httpStorage) cacheControlInterval(header http.Header) time.Duration {
+	maxAge, ok := parseCacheControlMaxAge(header.Get("Cache-Control"))
+	if !ok {
+		return 0
+	}
+	return min(max(maxAge, s.options.CacheControlMinInterval), s.options.CacheControlMaxInterval)
+}
+
+func (s *httpStorage) nextRefresh() time.Duration {
+	interval := s.options.RefreshInterval
+	if !s.options.RespectCacheControl {
+		return interval
+	}
+	s.mux.Lock()
+	maxAge := s.maxAge
+	s.mux.Unlock()
+	if maxAge == 0 {
+		if interval == 0 {
+			return s.options.CacheControlMaxInterval
+		}
+		return interval
+	}
+	if interval == 0 || maxAge < interval {
+		return maxAge
+	}
+	return interval
+}
+
+// jitter randomly shortens the given delay by up to the RefreshIntervalJitter option.
+func (s *httpStorage) jitter(d time.Duration) time.Duration {
+	j := min(s.options.RefreshIntervalJitter, d)
+	if j <= 0 {
+		return d
+	}
+	return d - time.Duration(rand.Int63n(int64(j)))
+}
+func (s *httpStorage) refreshWithBackoff() error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(s.options.Ctx, s.options.HTTPTimeout)
+		err = s.refresh(ctx)
+		cancel()
+		if err == nil || attempt >= s.options.RefreshBackoff.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(s.options.RefreshBackoff.delay(attempt))
+		select {
+		case <-s.options.Ctx.Done():
+			timer.Stop()
+			return errors.Join(err, s.options.Ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+type refreshCorrelationIDKey struct{}
+
+// WithRefreshCorrelationID returns a copy of ctx that carries the given correlation ID. Pass the returned context to a
+// read that may cause an on-demand refresh, or use it as the Ctx option of HTTPClientStorageOptions, then use
+// RefreshCorrelationID in RefreshErrorHandler or RefreshMetricsContextHook to tie the refresh to its cause.
+func WithRefreshCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, refreshCorrelationIDKey{}, id)
+}
+
+// RefreshCorrelationID returns the correlation ID attached to ctx by WithRefreshCorrelationID. It returns an empty
+// string if there is none.
+func RefreshCorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(refreshCorrelationIDKey{}).(string)
+	return id
+}
+
+// runHook calls the given hook, recovering and logging a panic so a misbehaving hook cannot break the caller. A nil
+// logger logs to slog.Default().
+func runHook(ctx context.Context, logger *slog.Logger, name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if logger == nil {
+				logger = slog.Default()
+			}
+			logger.ErrorContext(ctx, "Recovered from panic in hook.",
+				"hook", name,
+				"panic", r,
+			)
+		}
+	}()
+	hook()
+}
+
+// addHeader adds the given header to the HTTP request. The User-Agent is set to the given user agent unless the header
+// has one.
+func addHeader(req *http.Request, header http.Header, userAgent string) {
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if req.Header.Get("User-Agent") == "" && userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+}
+
+// defaultUserAgent returns the User-Agent identifying this library and its version from the build information.
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+				if dep.Replace != nil && dep.Replace.Version != "" {
+					version = dep.Replace.Version
+				}
+				break
+			}
+		}
+	}
+	return "jwkset/" + version + " (+https://" + modulePath + ")"
+}
+
+// decompressBody returns a reader for the response body that decodes a gzip or deflate Content-Encoding. The deflate
+// encoding is zlib wrapped according to RFC 9110, but raw deflate data, which some servers send, is also accepted.
+// Closing the reader does not close the response body.
+func decompressBody(resp *http.Response) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gzip JWK Set response: %w", err)
+		}
+		return gzipBody{Reader: r}, nil
+	case "deflate":
+		br := bufio.NewReader(resp.Body)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			r, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode deflate JWK Set response: %w", err)
+			}
+			return r, nil
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q for JWK Set response", encoding)
+}
+
+// gzipBody is a gzip response body. The JWK Set can be decoded before the gzip trailer is read, so Close also reports
+// an error that the gzip.Reader kept from a read, such as for a truncated stream or a bad checksum.
+type gzipBody struct {
+	*gzip.Reader
+}
+
+func (g gzipBody) Close() error {
+	_, err := g.Reader.Read(nil)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to decode gzip JWK Set response: %w", err)
+	}
+	return g.Reader.Close()
+}
+
+// parseCacheControlMaxAge returns the max-age directive from a Cache-Control header. A false value is returned if the
+// directive is missing, invalid, or if the response must not be cached.
+func parseCacheControlMaxAge(header string) (time.Duration, bool) {
+	var maxAge time.Duration
+	var found bool
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0, false
+		case "max-age":
+			seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+			if err != nil || seconds < 0 {
+				return 0, false
+			}
+			maxAge = time.Duration(seconds) * time.Second
+			found = true
+		}
+	}
+	return maxAge, found
 }
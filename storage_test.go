@@ -2,10 +2,29 @@ package jwkset
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -19,6 +38,28 @@ var (
 	hmacKey2 = []byte("hamc key 2")
 )
 
+// fakeClock is a clock for tests that only moves when advanced.
+type fakeClock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Now(),
+	}
+}
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.now = f.now.Add(d)
+}
+func (f *fakeClock) Now() time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.now
+}
+
 type storageTestParams struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -104,6 +145,70 @@ func TestMemoryKeyRead(t *testing.T) {
 	}
 }
 
+func TestMemoryKeyExists(t *testing.T) {
+	params := setupMemory()
+	defer params.cancel()
+	store := params.jwks
+
+	writeKeys(params.ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten))
+	ok, err := store.KeyExists(params.ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to check for written key. %s", err)
+	}
+	if !ok {
+		t.Fatalf("Expected written key to exist.")
+	}
+	ok, err = store.KeyExists(params.ctx, kidMissing)
+	if err != nil {
+		t.Fatalf("Failed to check for missing key. %s", err)
+	}
+	if ok {
+		t.Fatalf("Expected missing key to not exist.")
+	}
+}
+
+func TestMemoryKeyReadByAlg(t *testing.T) {
+	params := setupMemory()
+	defer params.cancel()
+	store := params.jwks
+
+	options := JWKOptions{
+		Metadata: JWKMetadataOptions{
+			ALG: AlgRS256,
+			KID: "rsa",
+		},
+	}
+	writeKeys(params.ctx, t, store,
+		newJWK(t, makeRSA(t), options),
+		newJWK(t, makeECDSAP256(t), JWKOptions{Metadata: JWKMetadataOptions{KID: "ec"}}),
+		newJWK(t, makeECDSAP384(t), JWKOptions{Metadata: JWKMetadataOptions{KID: "ec384"}}),
+	)
+
+	keys, err := store.KeyReadByAlg(params.ctx, AlgRS256, false)
+	if err != nil {
+		t.Fatalf("Failed to read keys by alg. %s", err)
+	}
+	if len(keys) != 1 || keys[0].Marshal().KID != "rsa" {
+		t.Fatalf("Expected only the RSA key with a declared alg.")
+	}
+
+	keys, err = store.KeyReadByAlg(params.ctx, AlgES256, false)
+	if err != nil {
+		t.Fatalf("Failed to read keys by alg. %s", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("Expected no keys without inference.")
+	}
+
+	keys, err = store.KeyReadByAlg(params.ctx, AlgES256, true)
+	if err != nil {
+		t.Fatalf("Failed to read keys by alg. %s", err)
+	}
+	if len(keys) != 1 || keys[0].Marshal().KID != "ec" {
+		t.Fatalf("Expected only the P-256 key to be inferred for %s.", AlgES256)
+	}
+}
+
 func TestMemoryKeyReadAll(t *testing.T) {
 	params := setupMemory()
 	defer params.cancel()
@@ -148,6 +253,237 @@ func TestMemoryKeyReadAll(t *testing.T) {
 	}
 }
 
+func TestMemoryKeyReadAllSnapshot(t *testing.T) {
+	params := setupMemory()
+	defer params.cancel()
+	store := params.jwks
+
+	writeKeys(params.ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten), newStorageTestJWK(t, hmacKey2, kidWritten2))
+	snapshot, err := store.KeyReadAll(params.ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = store.KeyDelete(params.ctx, kidWritten)
+				_ = store.KeyWrite(params.ctx, newStorageTestJWK(t, hmacKey2, kidWritten))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = store.KeyRead(params.ctx, kidWritten2)
+				_, _ = store.KeyReadAll(params.ctx)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(snapshot) != 2 || snapshot[0].Marshal().KID != kidWritten || snapshot[1].Marshal().KID != kidWritten2 {
+		t.Fatalf("Snapshot should not be modified by writes.")
+	}
+	if !bytes.Equal(snapshot[0].Key().([]byte), hmacKey1) {
+		t.Fatalf("Snapshot key should not be modified by writes.")
+	}
+}
+
+func TestMemoryKeyReadAllClone(t *testing.T) {
+	params := setupMemory()
+	defer params.cancel()
+	store := params.jwks
+
+	options := JWKOptions{
+		Marshal: JWKMarshalOptions{
+			Private: true,
+		},
+		Metadata: JWKMetadataOptions{
+			KEYOPS: []KEYOPS{KeyOpsSign, KeyOpsVerify},
+			KID:    kidWritten,
+		},
+	}
+	writeKeys(params.ctx, t, store, newJWK(t, slices.Clone(hmacKey1), options))
+
+	keys, err := store.KeyReadAll(params.ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	keys[0].Marshal().KEYOPS[0] = KeyOpsDecrypt
+	keys[0].Key().([]byte)[0] = 0
+	key, err := store.KeyRead(params.ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	key.Marshal().KEYOPS[1] = KeyOpsEncrypt
+
+	key, err = store.KeyRead(params.ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	if !slices.Equal(key.Marshal().KEYOPS, []KEYOPS{KeyOpsSign, KeyOpsVerify}) {
+		t.Fatalf("Stored key operations should not be modified through a returned key. %v", key.Marshal().KEYOPS)
+	}
+	if !bytes.Equal(key.Key().([]byte), hmacKey1) {
+		t.Fatalf("Stored key should not be modified through a returned key.")
+	}
+	err = key.Validate()
+	if err != nil {
+		t.Fatalf("Stored key should still validate. %s", err)
+	}
+}
+
+func TestMemoryMaxKeys(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	store := NewMemoryStorageWithOptions(MemoryStorageOptions{
+		MaxKeys: 2,
+	})
+
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten), newStorageTestJWK(t, hmacKey2, kidWritten2))
+	_, err := store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey2, kidMissing))
+
+	_, err = store.KeyRead(ctx, kidWritten2)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected the least recently read key to be evicted, got %v.", err)
+	}
+	for _, kid := range []string{kidWritten, kidMissing} {
+		_, err = store.KeyRead(ctx, kid)
+		if err != nil {
+			t.Fatalf("Failed to read key %q. %s", kid, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				kid := strconv.Itoa(i*50 + j)
+				_ = store.KeyWrite(ctx, newStorageTestJWK(t, hmacKey1, kid))
+				_, _ = store.KeyRead(ctx, kid)
+			}
+		}(i)
+	}
+	wg.Wait()
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected storage to be capped at 2 keys, got %d.", len(keys))
+	}
+}
+
+func TestMemorySortKeys(t *testing.T) {
+	params := setupMemory()
+	defer params.cancel()
+	store := params.jwks
+
+	writeKeys(params.ctx, t, store, newStorageTestJWK(t, hmacKey1, "b"), newStorageTestJWK(t, hmacKey2, "c"), newStorageTestJWK(t, hmacKey1, "a"))
+	marshalOptions := JWKMarshalOptions{
+		Private:  true,
+		SortKeys: true,
+	}
+	jwks, err := store.MarshalWithOptions(params.ctx, marshalOptions, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK Set. %s", err)
+	}
+	var kids []string
+	for _, marshal := range jwks.Keys {
+		kids = append(kids, marshal.KID)
+	}
+	if !slices.Equal(kids, []string{"a", "b", "c"}) {
+		t.Fatalf("Expected keys sorted by key ID, got %v.", kids)
+	}
+
+	keys := []JWKMarshal{{KID: "a", KTY: KtyRSA}, {KID: "a", KTY: KtyEC}}
+	sortJWKMarshals(keys)
+	if keys[0].KTY != KtyEC {
+		t.Fatalf("Expected keys with the same key ID to be sorted by JSON representation.")
+	}
+}
+
+func TestMemoryCanonicalJSON(t *testing.T) {
+	params := setupMemory()
+	defer params.cancel()
+	store := params.jwks
+
+	options := JWKOptions{
+		Marshal: JWKMarshalOptions{
+			Private: true,
+		},
+		Metadata: JWKMetadataOptions{
+			KID: "<" + kidWritten + ">",
+			USE: UseSig,
+		},
+	}
+	writeKeys(params.ctx, t, store, newJWK(t, hmacKey1, options))
+	marshalOptions := JWKMarshalOptions{
+		Canonical: true,
+		Private:   true,
+	}
+	raw, err := store.JSONWithOptions(params.ctx, marshalOptions, JWKValidateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK Set. %s", err)
+	}
+	expected := `{"keys":[{"k":"` + base64.RawURLEncoding.EncodeToString(hmacKey1) + `","key_ops":["sign","verify"],"kid":"<` + kidWritten + `>","kty":"oct","use":"sig"}]}`
+	if string(raw) != expected {
+		t.Fatalf("Unexpected canonical JSON.\n  Actual: %s\n  Expected: %s", raw, expected)
+	}
+}
+
+func TestMemoryKeyReadAllPublic(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	private := JWKMarshalOptions{Private: true}
+	for i, key := range []any{makeRSA(t), makeECDSAP256(t), makeEdDSA(t), makeECDHX25519Private(t), hmacKey1} {
+		writeKeys(ctx, t, store, newJWK(t, key, JWKOptions{
+			Marshal:  private,
+			Metadata: JWKMetadataOptions{KID: strconv.Itoa(i), KEYOPS: []KEYOPS{KeyOpsVerify}},
+		}))
+	}
+
+	jwks, err := store.KeyReadAllPublic(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read public keys. %s", err)
+	}
+	if len(jwks) != 4 {
+		t.Fatalf("Expected 4 asymmetric keys, got %d.", len(jwks))
+	}
+	for _, jwk := range jwks {
+		m := jwk.Marshal()
+		if m.D != "" || m.P != "" || m.Q != "" || m.DP != "" || m.DQ != "" || m.QI != "" || m.K != "" || len(m.OTH) != 0 {
+			t.Fatalf("Expected no private members for key %q.", m.KID)
+		}
+		if _, err = jwk.PrivateKey(); !errors.Is(err, ErrNoPrivateKey) {
+			t.Fatalf("Expected no private key for key %q, got %v.", m.KID, err)
+		}
+		if len(m.KEYOPS) != 1 {
+			t.Fatalf("Expected metadata to be kept for key %q.", m.KID)
+		}
+		err = jwk.Validate()
+		if err != nil {
+			t.Fatalf("Failed to validate public key %q. %s", m.KID, err)
+		}
+	}
+	raw, err := store.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JSON. %s", err)
+	}
+	if !bytes.Contains(raw, []byte(`"d":`)) {
+		t.Fatalf("Expected the stored keys to keep their private members.")
+	}
+}
+
 func TestMemoryKeyWrite(t *testing.T) {
 	params := setupMemory()
 	defer params.cancel()
@@ -166,31 +502,1606 @@ func TestMemoryKeyWrite(t *testing.T) {
 	}
 }
 
-func setupMemory() (params storageTestParams) {
-	jwkSet := NewMemoryStorage()
+func TestMemoryKeyWriteBatch(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	params = storageTestParams{
-		ctx:    ctx,
-		cancel: cancel,
-		jwks:   jwkSet,
+	defer cancel()
+	store := NewMemoryStorageWithOptions(MemoryStorageOptions{
+		AutoKID: true,
+	})
+	writeKeys(ctx, t, store, newStorageTestJWK(t, hmacKey1, kidWritten))
+
+	err := store.KeyWriteBatch(ctx, []JWK{
+		newStorageTestJWK(t, hmacKey2, kidWritten),
+		newStorageTestJWK(t, hmacKey1, kidWritten2),
+	})
+	if err != nil {
+		t.Fatalf("Failed to write batch. %s", err)
+	}
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[0].Key().([]byte), hmacKey2) {
+		t.Fatalf("Expected the batch to overwrite and add keys.")
+	}
+
+	var unsupported JWK // No thumbprint can be computed without a key type.
+	err = store.KeyWriteBatch(ctx, []JWK{newStorageTestJWK(t, hmacKey1, kidMissing), unsupported})
+	if err == nil {
+		t.Fatalf("Expected an error for a key without a computable key ID.")
+	}
+	_, err = store.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected no key of a failed batch to be written.")
 	}
-	return params
 }
 
-func newStorageTestJWK(t *testing.T, key any, keyID string) JWK {
-	marshal := JWKMarshalOptions{
-		Private: true,
+func TestMemoryAutoKID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	store := NewMemoryStorageWithOptions(MemoryStorageOptions{
+		AutoKID: true,
+	})
+
+	jwk := newStorageTestJWK(t, hmacKey1, "")
+	writeKeys(ctx, t, store, jwk, jwk)
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to snapshot keys. %s", err)
 	}
-	metadata := JWKMetadataOptions{
-		KID: keyID,
+	if len(keys) != 1 {
+		t.Fatalf("Writing the same key material twice should not duplicate the key. %d", len(keys))
 	}
-	options := JWKOptions{
-		Marshal:  marshal,
-		Metadata: metadata,
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint. %s", err)
 	}
-	jwk, err := NewJWKFromKey(key, options)
+	kid := base64.RawURLEncoding.EncodeToString(thumbprint)
+	key, err := store.KeyRead(ctx, kid)
 	if err != nil {
-		t.Fatalf("Failed to create JWK. %s", err)
+		t.Fatalf("Failed to read key by thumbprint key ID. %s", err)
+	}
+	err = key.Validate()
+	if err != nil {
+		t.Fatalf("Key with assigned key ID should validate. %s", err)
+	}
+}
+
+func TestRefreshBackoffDelay(t *testing.T) {
+	b := RefreshBackoff{
+		Base: time.Second,
+		Max:  5 * time.Second,
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		d := b.delay(i + 1)
+		if d != e {
+			t.Fatalf("Unexpected delay for retry %d.\n  Actual: %s\n  Expected: %s", i+1, d, e)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 1; i < 10; i++ {
+		d := b.delay(i)
+		if d > b.Max || d < b.Base/2 {
+			t.Fatalf("Delay with jitter out of range for retry %d: %s", i, d)
+		}
+	}
+}
+
+func TestHTTPStorageRefreshBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if n > 1 && n < 4 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.ParseRequestURI(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	handlerErrs := make(chan error, 10)
+	options := HTTPClientStorageOptions{
+		Ctx: ctx,
+		RefreshBackoff: RefreshBackoff{
+			Base:        time.Millisecond,
+			MaxAttempts: 3,
+		},
+		RefreshErrorHandler: func(ctx context.Context, err error) {
+			handlerErrs <- err
+		},
+		RefreshInterval: 50 * time.Millisecond,
+	}
+	_, err = NewStorageFromHTTP(u, options)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+
+	deadline := time.After(time.Second)
+	for requests.Load() < 4 {
+		select {
+		case err = <-handlerErrs:
+			t.Fatalf("Refresh error handler should not be called when a retry succeeds. %s", err)
+		case <-deadline:
+			t.Fatalf("Timed out waiting for refresh retries.")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestHTTPStorageConditionalRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const etag = `"my-etag"`
+	rawJWKS := newStorageTestRawJWKS(t)
+	var notModified atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.ParseRequestURI(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	options := HTTPClientStorageOptions{
+		Ctx:                    ctx,
+		UseConditionalRequests: true,
+	}
+	store, err := NewStorageFromHTTP(u, options)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	s := store.(*httpStorage)
+	first := s.lastRefresh
+
+	err = s.refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh with conditional request. %s", err)
+	}
+	if notModified.Load() != 1 {
+		t.Fatalf("Expected the refresh to send the If-None-Match header.")
+	}
+	if !s.lastRefresh.After(first) {
+		t.Fatalf("A not modified response should update the last refresh time.")
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key after not modified response. %s", err)
+	}
+}
+
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPStorageClientAndHeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const authorization = "Bearer my-token"
+	rawJWKS := newStorageTestRawJWKS(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != authorization {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.ParseRequestURI(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if !errors.Is(err, ErrInvalidHTTPStatusCode) {
+		t.Fatalf("Expected an error without the Authorization header, got %s.", err)
+	}
+
+	transport := &countingTransport{}
+	options := HTTPClientStorageOptions{
+		Client: &http.Client{Transport: transport},
+		Ctx:    ctx,
+		Header: http.Header{"Authorization": []string{authorization}},
+	}
+	store, err := NewStorageFromHTTP(u, options)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage with header. %s", err)
+	}
+	if transport.requests.Load() != 1 {
+		t.Fatalf("Expected the request to use the custom client.")
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+}
+
+func TestHTTPStorageUserAgent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	userAgents := make(chan string, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.ParseRequestURI(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	testCases := []struct {
+		name     string
+		options  HTTPClientStorageOptions
+		expected string
+	}{
+		{
+			name:     "Default",
+			options:  HTTPClientStorageOptions{Ctx: ctx},
+			expected: DefaultUserAgent,
+		},
+		{
+			name:     "Option",
+			options:  HTTPClientStorageOptions{Ctx: ctx, UserAgent: "my-service/1.0"},
+			expected: "my-service/1.0",
+		},
+		{
+			name: "Header",
+			options: HTTPClientStorageOptions{
+				Ctx:       ctx,
+				Header:    http.Header{"User-Agent": []string{"from-header"}},
+				UserAgent: "my-service/1.0",
+			},
+			expected: "from-header",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewStorageFromHTTP(u, tc.options)
+			if err != nil {
+				t.Fatalf("Failed to create HTTP storage. %s", err)
+			}
+			userAgent := <-userAgents
+			if userAgent != tc.expected {
+				t.Fatalf("Expected User-Agent %q, got %q.", tc.expected, userAgent)
+			}
+		})
+	}
+	if !strings.HasPrefix(DefaultUserAgent, "jwkset/") {
+		t.Fatalf("Expected the default User-Agent to identify the library, got %q.", DefaultUserAgent)
+	}
+}
+
+func TestHTTPStorageRequestAuthorizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer my-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.ParseRequestURI(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	var calls atomic.Int64
+	options := HTTPClientStorageOptions{
+		Ctx: ctx,
+		RequestAuthorizer: func(ctx context.Context, req *http.Request) error {
+			calls.Add(1)
+			req.Header.Set("Authorization", "Bearer my-token")
+			return nil
+		},
+	}
+	store, err := NewStorageFromHTTP(u, options)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage with a request authorizer. %s", err)
+	}
+	err = store.(*httpStorage).refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh. %s", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("Expected the authorizer to be called for each request, got %d calls.", calls.Load())
+	}
+
+	errAuthorize := errors.New("no credentials")
+	options.RequestAuthorizer = func(ctx context.Context, req *http.Request) error {
+		return errAuthorize
+	}
+	_, err = NewStorageFromHTTP(u, options)
+	if !errors.Is(err, errAuthorize) {
+		t.Fatalf("Expected the authorizer error, got %v.", err)
+	}
+}
+
+func TestHTTPStorageHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.ParseRequestURI(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	var mux sync.Mutex
+	var refreshErrs []error
+	var unknownKIDs []string
+	options := HTTPClientStorageOptions{
+		Ctx: ctx,
+		RefreshMetricsHook: func(hookURL string, duration time.Duration, err error) {
+			mux.Lock()
+			refreshErrs = append(refreshErrs, err)
+			mux.Unlock()
+			if hookURL != server.URL || duration <= 0 {
+				t.Errorf("Unexpected metrics hook arguments %q and %s.", hookURL, duration)
+			}
+			panic("metrics hook panic")
+		},
+		RefreshUnknownKIDHook: func(hookURL string, keyID string) {
+			mux.Lock()
+			unknownKIDs = append(unknownKIDs, keyID)
+			mux.Unlock()
+		},
+	}
+	store, err := NewStorageFromHTTP(u, options)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage with a panicking hook. %s", err)
+	}
+
+	fail.Store(true)
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:          map[string]Storage{server.URL: store},
+		RefreshUnknownKID: rate.NewLimiter(rate.Inf, 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	_, err = c.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected key not found, got %s.", err)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(refreshErrs) != 2 || refreshErrs[0] != nil || !errors.Is(refreshErrs[1], ErrInvalidHTTPStatusCode) {
+		t.Fatalf("Expected the metrics hook to observe a successful and a failed refresh, got %v.", refreshErrs)
+	}
+	if len(unknownKIDs) != 1 || unknownKIDs[0] != kidMissing {
+		t.Fatalf("Expected the unknown key ID hook to be called once, got %v.", unknownKIDs)
+	}
+}
+
+func TestHTTPStorageClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		RefreshInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs: map[string]Storage{server.URL: store},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+	_, err = c.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key before close. %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = c.(io.Closer).Close()
+		if err != nil {
+			t.Fatalf("Failed to close client. %s", err)
+		}
+	}
+	select {
+	case <-store.(*httpStorage).done:
+	default:
+		t.Fatalf("Expected the refresh goroutine to exit.")
+	}
+
+	_, err = c.KeyRead(ctx, kidWritten)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected the client to be closed, got %v.", err)
+	}
+	_, err = c.JSON(ctx)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected the client to be closed, got %v.", err)
+	}
+	_, err = store.KeyReadAll(ctx)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected the HTTP storage to be closed, got %v.", err)
+	}
+	err = store.(io.Closer).Close()
+	if err != nil {
+		t.Fatalf("Closing a closed HTTP storage should not fail. %s", err)
+	}
+}
+
+func TestHTTPStorageRefreshStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten), newStorageTestJWK(t, hmacKey2, kidWritten2))
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	c, err := NewHTTPClient(HTTPClientOptions{
+		HTTPURLs:          map[string]Storage{server.URL: store},
+		RefreshUnknownKID: rate.NewLimiter(rate.Inf, 1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client. %s", err)
+	}
+
+	info := c.(RefreshStatusProvider).RefreshStatus()[server.URL]
+	if info.KeyCount != 2 || info.LastError != nil || info.LastSuccess.Before(info.LastAttempt) {
+		t.Fatalf("Unexpected refresh status after a successful refresh. %+v", info)
+	}
+	lastSuccess := info.LastSuccess
+
+	fail.Store(true)
+	_, err = c.KeyRead(ctx, kidMissing)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected key not found, got %v.", err)
+	}
+	info = c.(RefreshStatusProvider).RefreshStatus()[server.URL]
+	if info.KeyCount != 2 || !errors.Is(info.LastError, ErrInvalidHTTPStatusCode) || !info.LastSuccess.Equal(lastSuccess) || !info.LastAttempt.After(lastSuccess) {
+		t.Fatalf("Unexpected refresh status after a failed refresh. %+v", info)
+	}
+}
+
+func TestHTTPStorageCachedJSONPublic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, makeEdDSA(t), kidWritten))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	provider := store.(CachedJSONPublicProvider)
+
+	assertCached := func(keys int) {
+		t.Helper()
+		cached, err := provider.CachedJSONPublic(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get cached public JSON. %s", err)
+		}
+		expected, err := store.JSONPublic(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get public JSON. %s", err)
+		}
+		if !bytes.Equal(cached, expected) {
+			t.Fatalf("Cached public JSON does not match.\nExpected: %s\nActual: %s", expected, cached)
+		}
+		var jwks JWKSMarshal
+		err = json.Unmarshal(cached, &jwks)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal cached public JSON. %s", err)
+		}
+		if len(jwks.Keys) != keys {
+			t.Fatalf("Expected %d keys, got %d.", keys, len(jwks.Keys))
+		}
+		for _, k := range jwks.Keys {
+			if k.D != "" {
+				t.Fatalf("Cached public JSON contains private key material.")
+			}
+		}
+		if store.(*httpStorage).publicJSON == nil {
+			t.Fatalf("Expected public JSON to be cached.")
+		}
+	}
+	assertCached(1)
+	assertCached(1)
+
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, makeEdDSA(t), kidWritten2))
+	err = store.(*httpStorage).refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh. %s", err)
+	}
+	if store.(*httpStorage).publicJSON != nil {
+		t.Fatalf("Expected refresh to invalidate the cached public JSON.")
+	}
+	assertCached(2)
+
+	_, err = store.KeyDelete(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Failed to delete key. %s", err)
+	}
+	assertCached(1)
+}
+
+func TestHTTPStorageMaxStaleness(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	const maxStaleness = time.Hour
+	clock := newFakeClock()
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Clock:        clock.Now,
+		Ctx:          ctx,
+		MaxStaleness: maxStaleness,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	s := store.(*httpStorage)
+
+	fail.Store(true)
+	err = s.refresh(ctx)
+	if err == nil {
+		t.Fatalf("Expected the refresh to fail.")
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Keys should be served within the max staleness. %s", err)
+	}
+
+	clock.Advance(maxStaleness + time.Second)
+	_, err = store.KeyRead(ctx, kidWritten)
+	if !errors.Is(err, ErrKeysStale) {
+		t.Fatalf("Expected stale keys, got %v.", err)
+	}
+	_, err = store.JSONPublic(ctx)
+	if !errors.Is(err, ErrKeysStale) {
+		t.Fatalf("Expected stale keys, got %v.", err)
+	}
+
+	fail.Store(false)
+	err = s.refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Keys should be served after a successful refresh. %s", err)
+	}
+}
+
+func TestHTTPStorageMaxResponseBytes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	rawJWKS, err := serverStore.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set JSON. %s", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:              ctx,
+		MaxResponseBytes: int64(len(rawJWKS)) - 1,
+	})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("Expected the response to be too large, got %v.", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:              ctx,
+		MaxResponseBytes: int64(len(rawJWKS)),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage with a response at the limit. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+}
+
+func TestHTTPStorageContentEncoding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	rawJWKS, err := serverStore.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set JSON. %s", err)
+	}
+	compress := func(w io.WriteCloser) {
+		_, err := w.Write(rawJWKS)
+		if err != nil {
+			t.Fatalf("Failed to compress JWK Set. %s", err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("Failed to compress JWK Set. %s", err)
+		}
+	}
+	var gzipped, zlibbed, deflated bytes.Buffer
+	compress(gzip.NewWriter(&gzipped))
+	compress(zlib.NewWriter(&zlibbed))
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("Failed to create flate writer. %s", err)
+	}
+	compress(fw)
+
+	testCases := []struct {
+		name     string
+		encoding string
+		body     []byte
+		header   http.Header
+	}{
+		{name: "Gzip", encoding: "gzip", body: gzipped.Bytes()},
+		{name: "Deflate", encoding: "deflate", body: zlibbed.Bytes()},
+		{name: "RawDeflate", encoding: "deflate", body: deflated.Bytes()},
+		{name: "CustomHeader", encoding: "gzip", body: gzipped.Bytes(), header: http.Header{"Accept-Encoding": {"gzip"}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.Contains(r.Header.Get("Accept-Encoding"), tc.encoding) {
+					_, _ = w.Write(rawJWKS)
+					return
+				}
+				w.Header().Set("Content-Encoding", tc.encoding)
+				_, _ = w.Write(tc.body)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("Failed to parse URL. %s", err)
+			}
+			store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+				Ctx:    ctx,
+				Header: tc.header,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create HTTP storage. %s", err)
+			}
+			_, err = store.KeyRead(ctx, kidWritten)
+			if err != nil {
+				t.Fatalf("Failed to read key. %s", err)
+			}
+			_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+				Ctx:              ctx,
+				Header:           tc.header,
+				MaxResponseBytes: int64(len(rawJWKS)) - 1,
+			})
+			if !errors.Is(err, ErrResponseTooLarge) {
+				t.Fatalf("Expected the decompressed response to be too large, got %v.", err)
+			}
+		})
+	}
+
+	truncated := gzipped.Bytes()[:gzipped.Len()-4] // Drop part of the gzip trailer.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(truncated)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		AllowTrailingData: true,
+		Ctx:               ctx,
+	})
+	if !errors.Is(err, ErrRefreshDecode) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected a truncated gzip response to fail, got %v.", err)
+	}
+}
+
+func TestHTTPStorageDuplicateKIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := base64.RawURLEncoding.EncodeToString([]byte(hmacSecret))
+	body := `{"keys":[{"kty":"oct","k":"` + key + `","kid":"` + myKeyID + `"},{"kty":"oct","k":"` + key + `","kid":"` + myKeyID + `"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Expected duplicate key IDs to be accepted by default. %s", err)
+	}
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:             ctx,
+		ValidateOptions: JWKValidateOptions{RejectDuplicateKIDs: true},
+	})
+	if !errors.Is(err, ErrDuplicateKID) || !errors.Is(err, ErrRefreshValidate) {
+		t.Fatalf("Expected error to wrap %s and %s, got %v.", ErrDuplicateKID, ErrRefreshValidate, err)
+	}
+}
+
+func TestHTTPStorageStrictContentType(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	testCases := []struct {
+		contentType string
+		strictErr   bool
+	}{
+		{contentType: ContentTypeJWKSet},
+		{contentType: ContentTypeJWKSet + "; charset=utf-8"},
+		{contentType: "application/json", strictErr: true},
+		{contentType: "text/plain", strictErr: true},
+		{contentType: "", strictErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tc.contentType}
+				_, _ = w.Write(rawJWKS)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("Failed to parse URL. %s", err)
+			}
+
+			_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+			if err != nil {
+				t.Fatalf("Expected any Content-Type to be accepted by default. %s", err)
+			}
+			_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx, StrictContentType: true})
+			if errors.Is(err, ErrContentType) != tc.strictErr {
+				t.Fatalf("Unexpected result for a strict Content-Type check: %v.", err)
+			}
+			if tc.strictErr && (!errors.Is(err, ErrRefreshDecode) || !strings.Contains(err.Error(), strconv.Quote(tc.contentType))) {
+				t.Fatalf("Expected the error to be a decode error with the Content-Type, got %s.", err)
+			}
+		})
+	}
+}
+
+func TestHTTPStorageRefreshErrorClass(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testCases := []struct {
+		name     string
+		status   int
+		body     string
+		expected error
+	}{
+		{name: "Status", status: http.StatusInternalServerError, expected: ErrRefreshNetwork},
+		{name: "Garbage", status: http.StatusOK, body: "<html>", expected: ErrRefreshDecode},
+		{name: "InvalidKey", status: http.StatusOK, body: `{"keys":[{"kty":"EC","crv":"P-256","x":"AA","y":"AA"}]}`, expected: ErrRefreshValidate},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("Failed to parse URL. %s", err)
+			}
+			_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error to wrap %s, got %v.", tc.expected, err)
+			}
+			for _, other := range []error{ErrRefreshNetwork, ErrRefreshDecode, ErrRefreshValidate} {
+				if other != tc.expected && errors.Is(err, other) {
+					t.Fatalf("Expected error to not wrap %s.", other)
+				}
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	server.Close()
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if !errors.Is(err, ErrRefreshNetwork) {
+		t.Fatalf("Expected error to wrap %s for a closed server, got %v.", ErrRefreshNetwork, err)
+	}
+	if !strings.HasPrefix(err.Error(), "failed to perform first HTTP request for JWK Set: failed to perform HTTP request") {
+		t.Fatalf("Expected the error message to be unchanged, got %q.", err)
+	}
+}
+
+func TestHTTPStorageRefreshAtomic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	rawJWKS, err := serverStore.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set JSON. %s", err)
+	}
+	var mux sync.Mutex
+	body := string(rawJWKS)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+
+	valid := newStorageTestJWK(t, hmacKey2, kidWritten2)
+	validJSON, err := json.Marshal(valid.Marshal())
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK. %s", err)
+	}
+	mux.Lock()
+	body = `{"keys":[` + string(validJSON) + `,{"kty":"EC","crv":"P-256","x":"AA","y":"AA","kid":"invalid"}]}`
+	mux.Unlock()
+	err = store.(*httpStorage).refresh(ctx)
+	if !errors.Is(err, ErrRefreshValidate) {
+		t.Fatalf("Expected error to wrap %s, got %v.", ErrRefreshValidate, err)
+	}
+	ok, err := store.KeyExists(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Failed to check key existence. %s", err)
+	}
+	if ok {
+		t.Fatalf("Expected no key from the invalid JWK Set to be written.")
+	}
+	all, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read all keys. %s", err)
+	}
+	if len(all) != 1 || all[0].Marshal().KID != kidWritten {
+		t.Fatalf("Expected only the key from the first refresh, got %d keys.", len(all))
+	}
+}
+
+func TestHTTPStorageMaxKeys(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten), newStorageTestJWK(t, hmacKey2, kidWritten2))
+	rawJWKS, err := serverStore.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set JSON. %s", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:     ctx,
+		MaxKeys: 1,
+	})
+	if !errors.Is(err, ErrTooManyKeys) {
+		t.Fatalf("Expected too many keys, got %v.", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:     ctx,
+		MaxKeys: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage with keys at the limit. %s", err)
+	}
+	keys, err := store.KeyReadAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read keys. %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d.", len(keys))
+	}
+
+	jwks, err := decodeJWKS(strings.NewReader(`{"other":{"keys":[1]},"keys":[{"kty":"oct"}],"more":null}`), 1, false)
+	if err != nil {
+		t.Fatalf("Failed to decode JWK Set with other members. %s", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KTY != KtyOct {
+		t.Fatalf("Unexpected JWK Set %+v.", jwks)
+	}
+}
+
+func TestHTTPStorageSingleJWK(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jwk+json")
+		_, _ = w.Write([]byte(`{"kty":"OKP","crv":"Ed25519","x":"` + eddsaPublic + `","kid":"` + kidWritten + `","key_expiry":1700000000}`))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage from a single JWK. %s", err)
+	}
+	jwk, err := store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+	_, ok := jwk.Extra("key_expiry")
+	if !ok {
+		t.Fatalf("Expected unknown members of a single JWK to be kept.")
+	}
+
+	for _, raw := range []string{`{}`, `{"keys":[],"kty":"oct"}`, `{"kty":"oct","keys":null}`} {
+		jwks, err := decodeJWKS(strings.NewReader(raw), 0, false)
+		if err != nil {
+			t.Fatalf("Failed to decode %s. %s", raw, err)
+		}
+		if len(jwks.Keys) != 0 {
+			t.Fatalf("Expected no keys for %s, got %d.", raw, len(jwks.Keys))
+		}
+	}
+}
+
+func TestHTTPStorageBOMAndTrailingData(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	var body atomic.Pointer[[]byte]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(*body.Load())
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	bom := append([]byte("\xEF\xBB\xBF"), rawJWKS...)
+	body.Store(&bom)
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage from a BOM prefixed JWK Set. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+
+	whitespace := append(slices.Clone(rawJWKS), " \r\n"...)
+	body.Store(&whitespace)
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage from a JWK Set with trailing whitespace. %s", err)
+	}
+
+	trailing := append(slices.Clone(rawJWKS), `{"keys":[]}`...)
+	body.Store(&trailing)
+	_, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{Ctx: ctx})
+	if !errors.Is(err, ErrTrailingData) || !errors.Is(err, ErrRefreshDecode) {
+		t.Fatalf("Expected ErrTrailingData, got %v.", err)
+	}
+	store, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		AllowTrailingData: true,
+		Ctx:               ctx,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage with AllowTrailingData. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+}
+
+func TestHTTPStorageCacheFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cacheFile := filepath.Join(t.TempDir(), "jwks.json")
+	cached := NewMemoryStorage()
+	writeKeys(ctx, t, cached, newStorageTestJWK(t, hmacKey1, kidWritten))
+	rawCached, err := cached.JSONPrivate(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set JSON. %s", err)
+	}
+	err = os.WriteFile(cacheFile, rawCached, 0600)
+	if err != nil {
+		t.Fatalf("Failed to write cache file. %s", err)
+	}
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		CacheFile: cacheFile,
+		Ctx:       ctx,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key from cache file before the first refresh. %s", err)
+	}
+	close(release)
+	for {
+		_, err = store.KeyRead(ctx, kidWritten2)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Background refresh did not complete. %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	err = store.(io.Closer).Close()
+	if err != nil {
+		t.Fatalf("Failed to close HTTP storage. %s", err)
+	}
+
+	persisted, err := NewStorageFromFile(cacheFile, FileStorageOptions{})
+	if err != nil {
+		t.Fatalf("Failed to read persisted cache file. %s", err)
+	}
+	_, err = persisted.KeyRead(ctx, kidWritten2)
+	if err != nil {
+		t.Fatalf("Expected the refreshed JWK Set to be persisted. %s", err)
+	}
+}
+
+func TestHTTPStoragePersistHook(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+
+	hookErr := errors.New("persist failed")
+	var persistedURL string
+	var persisted JWKSMarshal
+	var handled error
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx: ctx,
+		PersistHook: func(ctx context.Context, url string, set JWKSMarshal) error {
+			persistedURL = url
+			persisted = set
+			return hookErr
+		},
+		RefreshErrorHandler: func(ctx context.Context, err error) {
+			handled = err
+		},
+	})
+	if err != nil {
+		t.Fatalf("An error in the persist hook should not fail the refresh. %s", err)
+	}
+	if persistedURL != server.URL {
+		t.Fatalf("Expected persisted URL %q, got %q.", server.URL, persistedURL)
+	}
+	if len(persisted.Keys) != 1 || persisted.Keys[0].KID != kidWritten {
+		t.Fatalf("Expected the persisted JWK Set to have the key %q.", kidWritten)
+	}
+	if !errors.Is(handled, hookErr) {
+		t.Fatalf("Expected the persist hook error to be passed to RefreshErrorHandler, got %v.", handled)
+	}
+	_, err = store.KeyRead(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to read key. %s", err)
+	}
+}
+
+func TestHTTPStorageSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx: ctx,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	s := store.(*httpStorage)
+	events, unsubscribe := store.(KeySetSubscriber).Subscribe()
+	slow, _ := store.(KeySetSubscriber).Subscribe()
+
+	err = s.refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh. %s", err)
+	}
+	select {
+	case change := <-events:
+		t.Fatalf("Expected no event for an unchanged JWK Set, got %+v.", change)
+	default:
+	}
+
+	_, err = serverStore.KeyDelete(ctx, kidWritten)
+	if err != nil {
+		t.Fatalf("Failed to delete key. %s", err)
+	}
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey2, kidWritten2))
+	err = s.refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh. %s", err)
+	}
+	change := <-events
+	if change.URL != server.URL || !slices.Equal(change.Diff.Added, []string{kidWritten2}) || !slices.Equal(change.Diff.Removed, []string{kidWritten}) {
+		t.Fatalf("Unexpected change: %+v.", change)
+	}
+
+	for i := 0; i < KeySetChangeBuffer+1; i++ {
+		writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, strconv.Itoa(i)))
+		err = s.refresh(ctx) // Must not block on the slow subscriber.
+		if err != nil {
+			t.Fatalf("Failed to refresh. %s", err)
+		}
+	}
+	if len(slow) != KeySetChangeBuffer {
+		t.Fatalf("Expected the slow subscriber to have a full buffer, got %d events.", len(slow))
+	}
+
+	unsubscribe()
+	unsubscribe()
+	for range events {
+	}
+	err = store.(io.Closer).Close()
+	if err != nil {
+		t.Fatalf("Failed to close HTTP storage. %s", err)
+	}
+	for range slow {
+	}
+}
+
+func TestParseCacheControlMaxAge(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{header: "public, max-age=3600", expected: time.Hour, ok: true},
+		{header: "max-age=60, must-revalidate", expected: time.Minute, ok: true},
+		{header: `Max-Age="120"`, expected: 2 * time.Minute, ok: true},
+		{header: "no-store, max-age=60"},
+		{header: "max-age=invalid"},
+		{header: "max-age=-1"},
+		{header: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			actual, ok := parseCacheControlMaxAge(tc.header)
+			if ok != tc.ok || actual != tc.expected {
+				t.Fatalf("Unexpected max-age.\n  Actual: %s %t\n  Expected: %s %t", actual, ok, tc.expected, tc.ok)
+			}
+		})
+	}
+}
+
+func TestHTTPStorageNextRefresh(t *testing.T) {
+	testCases := []struct {
+		name            string
+		refreshInterval time.Duration
+		cacheControl    string
+		expected        time.Duration
+	}{
+		{name: "HeaderOnly", cacheControl: "max-age=600", expected: 10 * time.Minute},
+		{name: "HeaderBelowMin", cacheControl: "max-age=1", expected: time.Minute},
+		{name: "HeaderAboveMax", cacheControl: "max-age=999999", expected: 2 * time.Hour},
+		{name: "NoHeader", expected: 2 * time.Hour},
+		{name: "IntervalShorter", refreshInterval: 5 * time.Minute, cacheControl: "max-age=600", expected: 5 * time.Minute},
+		{name: "HeaderShorter", refreshInterval: time.Hour, cacheControl: "max-age=600", expected: 10 * time.Minute},
+		{name: "IntervalNoHeader", refreshInterval: time.Hour, expected: time.Hour},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &httpStorage{
+				options: HTTPClientStorageOptions{
+					CacheControlMaxInterval: 2 * time.Hour,
+					CacheControlMinInterval: time.Minute,
+					RefreshInterval:         tc.refreshInterval,
+					RespectCacheControl:     true,
+				},
+			}
+			header := http.Header{}
+			header.Set("Cache-Control", tc.cacheControl)
+			s.maxAge = s.cacheControlInterval(header)
+			actual := s.nextRefresh()
+			if actual != tc.expected {
+				t.Fatalf("Unexpected refresh interval.\n  Actual: %s\n  Expected: %s", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPStorageInitialRetryInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rawJWKS := newStorageTestRawJWKS(t)
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:                       ctx,
+		InitialRetryInterval:      10 * time.Millisecond,
+		NoErrorReturnFirstHTTPReq: true,
+		RefreshInterval:           time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer store.(io.Closer).Close()
+
+	for {
+		_, err = store.KeyRead(ctx, kidWritten)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Expected the key to be read after the initial retries. %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if requests.Load() != 4 {
+		t.Fatalf("Expected retries to stop after the first success, got %d requests.", requests.Load())
+	}
+
+	store, err = NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:                       ctx,
+		InitialRetryInterval:      time.Hour,
+		NoErrorReturnFirstHTTPReq: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	if store.(*httpStorage).done != nil {
+		t.Fatalf("Expected no refresh goroutine after a successful first HTTP request without a refresh interval.")
+	}
+}
+
+func TestHTTPStorageRefreshIntervalJitter(t *testing.T) {
+	s := &httpStorage{
+		options: HTTPClientStorageOptions{
+			RefreshIntervalJitter: time.Second,
+		},
+	}
+	for i := 0; i < 100; i++ {
+		d := s.jitter(time.Minute)
+		if d > time.Minute || d <= time.Minute-time.Second {
+			t.Fatalf("Delay with jitter out of range: %s", d)
+		}
+		d = s.jitter(time.Millisecond)
+		if d > time.Millisecond || d <= 0 {
+			t.Fatalf("Delay with jitter longer than the jitter out of range: %s", d)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverStore := NewMemoryStorage()
+	writeKeys(ctx, t, serverStore, newStorageTestJWK(t, hmacKey1, kidWritten))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawJWKS, err := serverStore.JSONPrivate(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(rawJWKS)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL. %s", err)
+	}
+	store, err := NewStorageFromHTTP(u, HTTPClientStorageOptions{
+		Ctx:                       ctx,
+		NoErrorReturnFirstHTTPReq: true,
+		RefreshIntervalJitter:     20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP storage. %s", err)
+	}
+	defer store.(io.Closer).Close()
+	for {
+		_, err = store.KeyRead(ctx, kidWritten)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Expected the first HTTP request to be made in the background. %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func setupMemory() (params storageTestParams) {
+	jwkSet := NewMemoryStorage()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	params = storageTestParams{
+		ctx:    ctx,
+		cancel: cancel,
+		jwks:   jwkSet,
+	}
+	return params
+}
+
+func newStorageTestJWK(t *testing.T, key any, keyID string) JWK {
+	marshal := JWKMarshalOptions{
+		Private: true,
+	}
+	metadata := JWKMetadataOptions{
+		KID: keyID,
+	}
+	options := JWKOptions{
+		Marshal:  marshal,
+		Metadata: metadata,
+	}
+	jwk, err := NewJWKFromKey(key, options)
+	if err != nil {
+		t.Fatalf("Failed to create JWK. %s", err)
+	}
+	return jwk
+}
+
+func newStorageTestRawJWKS(t *testing.T) []byte {
+	store := NewMemoryStorage()
+	err := store.KeyWrite(context.Background(), newStorageTestJWK(t, hmacKey1, kidWritten))
+	if err != nil {
+		t.Fatalf("Failed to write key. %s", err)
+	}
+	rawJWKS, err := store.JSONPrivate(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to marshal JWK Set. %s", err)
+	}
+	return rawJWKS
+}
+
+func writeKeys(ctx context.Context, t *testing.T, store Storage, jwks ...JWK) {
+	for _, jwk := range jwks {
+		err := store.KeyWrite(ctx, jwk)
+		if err != nil {
+			t.Fatalf("Failed to write key. %s", err)
+		}
+	}
+}
+
+// mutexStorage serializes reads and writes with a single mutex. It is used as a baseline for benchmarks.
+type mutexStorage struct {
+	Storage
+	mux sync.Mutex
+}
+
+func (m *mutexStorage) KeyRead(ctx context.Context, keyID string) (JWK, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.Storage.KeyRead(ctx, keyID)
+}
+
+func BenchmarkMemoryKeyReadParallel(b *testing.B) {
+	ctx := context.Background()
+	const keys = 16
+	store := NewMemoryStorage()
+	for i := 0; i < keys; i++ {
+		marshal := JWKMarshal{
+			K:   base64.RawURLEncoding.EncodeToString(hmacKey1),
+			KID: strconv.Itoa(i),
+			KTY: KtyOct,
+		}
+		jwk, err := NewJWKFromMarshal(marshal, JWKMarshalOptions{Private: true}, JWKValidateOptions{})
+		if err != nil {
+			b.Fatalf("Failed to create JWK. %s", err)
+		}
+		err = store.KeyWrite(ctx, jwk)
+		if err != nil {
+			b.Fatalf("Failed to write JWK. %s", err)
+		}
+	}
+
+	for _, bc := range []struct {
+		name  string
+		store Storage
+	}{
+		{name: "Mutex", store: &mutexStorage{Storage: store}},
+		{name: "RWMutex", store: store},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					_, err := bc.store.KeyRead(ctx, strconv.Itoa(i%keys))
+					if err != nil {
+						b.Errorf("Failed to read JWK. %s", err)
+						return
+					}
+					i++
+				}
+			})
+		})
 	}
-	return jwk
 }
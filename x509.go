@@ -9,9 +9,14 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
+	// ErrX509Chain is returned when an X.509 certificate chain could not be verified up to a trusted root.
+	ErrX509Chain = errors.New("failed to verify X.509 certificate chain")
+	// ErrX509Expired is returned when an X.509 certificate is expired or not yet valid.
+	ErrX509Expired = errors.New("X.509 certificate is expired or not yet valid")
 	// ErrX509Infer is returned when the key type cannot be inferred from the PEM block type.
 	ErrX509Infer = errors.New("failed to infer X509 key type")
 )
@@ -74,7 +79,7 @@ func LoadX509KeyInfer(pemBlock *pem.Block) (key any, err error) {
 		return nil, ErrX509Infer
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to load key from inferred format %q: %w", key, err)
+		return nil, fmt.Errorf("failed to load key from inferred format %q: %w", pemBlock.Type, err)
 	}
 	return key, nil
 }
@@ -123,3 +128,131 @@ func loadPKIXPublic(pemBlock *pem.Block) (pub any, err error) {
 	}
 	return pub, nil
 }
+
+// JWKFromPEM creates a JWK from raw PEM data. The PEM data may contain one key block and any number of CERTIFICATE
+// blocks. The key block can be a PKCS#1 RSA, SEC 1 EC, or PKCS#8 private key, or a PKCS#1 RSA or PKIX public key. The
+// certificates are appended to the X5C option in the order they appear, so the first certificate must be for the key.
+// If there is no key block, the key is taken from the first certificate.
+//
+// An error that wraps ErrPEMPassphrase is returned for an encrypted key block, such as an ENCRYPTED PRIVATE KEY block.
+// Use JWKFromEncryptedPEM to decrypt it.
+func JWKFromPEM(pemBytes []byte, options JWKOptions) (JWK, error) {
+	return jwkFromPEM(pemBytes, nil, options)
+}
+
+// JWKFromEncryptedPEM is like JWKFromPEM, but an encrypted key block is decrypted with the given PEMDecrypter, such
+// as one returned by PKCS8Passphrase. Unencrypted key blocks are also accepted.
+func JWKFromEncryptedPEM(pemBytes []byte, decrypter PEMDecrypter, options JWKOptions) (JWK, error) {
+	if decrypter == nil {
+		return JWK{}, fmt.Errorf("%w: no PEMDecrypter was supplied", ErrPEMPassphrase)
+	}
+	return jwkFromPEM(pemBytes, decrypter, options)
+}
+
+func jwkFromPEM(pemBytes []byte, decrypter PEMDecrypter, options JWKOptions) (JWK, error) {
+	var key any
+	for {
+		block, rest := pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		pemBytes = rest
+		if block.Type == "CERTIFICATE" {
+			cert, err := LoadCertificate(block.Bytes)
+			if err != nil {
+				return JWK{}, fmt.Errorf("failed to load X.509 certificate from PEM: %w", err)
+			}
+			options.X509.X5C = append(options.X509.X5C, cert)
+			continue
+		}
+		if key != nil {
+			return JWK{}, fmt.Errorf("%w: more than one key block in PEM data", ErrOptions)
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" || strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+			if decrypter == nil {
+				return JWK{}, fmt.Errorf("%w: %q block", ErrPEMPassphrase, block.Type)
+			}
+			der, err := decrypter(block)
+			if err != nil {
+				if !errors.Is(err, ErrPEMDecrypt) {
+					err = errors.Join(ErrPEMDecrypt, err)
+				}
+				return JWK{}, fmt.Errorf("failed to decrypt %q block: %w", block.Type, err)
+			}
+			typ := block.Type
+			if typ == "ENCRYPTED PRIVATE KEY" {
+				typ = "PRIVATE KEY"
+			}
+			block = &pem.Block{Type: typ, Bytes: der}
+		}
+		var err error
+		key, err = LoadX509KeyInfer(block)
+		if err != nil {
+			return JWK{}, fmt.Errorf("failed to load key from PEM: %w", err)
+		}
+	}
+	if key != nil {
+		return NewJWKFromKey(key, options)
+	}
+	if len(options.X509.X5C) == 0 {
+		return JWK{}, fmt.Errorf("%w: no key or certificate blocks in PEM data", ErrOptions)
+	}
+	return NewJWKFromX5C(options)
+}
+
+// PEM encodes the key of the JWK and its X.509 certificate chain as PEM data. The private key is only encoded if the
+// Marshal.Private option is set, as a PKCS#8 PRIVATE KEY block. Otherwise, the public key is encoded as a PKIX PUBLIC
+// KEY block. Each certificate in the X5C option follows as a CERTIFICATE block.
+func (j JWK) PEM() ([]byte, error) {
+	key := j.key
+	if !j.options.Marshal.Private {
+		key = publicKey(key)
+	}
+	var block *pem.Block
+	switch key.(type) {
+	case *ecdh.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey, *rsa.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal PKCS8 private key: %w", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case *ecdh.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal PKIX public key: %w", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	default:
+		return nil, fmt.Errorf("%w: %T cannot be encoded as PEM", ErrUnsupportedKey, key)
+	}
+	b := pem.EncodeToMemory(block)
+	for _, cert := range j.options.X509.X5C {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return b, nil
+}
+
+// verifyX509Chain verifies the first certificate in the chain with the given options. The remaining certificates are
+// added to the intermediate pool.
+func verifyX509Chain(chain []*x509.Certificate, options x509.VerifyOptions) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no X.509 certificates provided", ErrX509Chain)
+	}
+	if options.Intermediates == nil {
+		options.Intermediates = x509.NewCertPool()
+	} else {
+		options.Intermediates = options.Intermediates.Clone()
+	}
+	for _, cert := range chain[1:] {
+		options.Intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(options)
+	if err != nil {
+		var invalid x509.CertificateInvalidError
+		if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+			return fmt.Errorf("failed to verify X.509 certificate validity period: %w", errors.Join(ErrX509Expired, err))
+		}
+		return fmt.Errorf("failed to verify X.509 certificate: %w", errors.Join(ErrX509Chain, err))
+	}
+	return nil
+}